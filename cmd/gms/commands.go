@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	_ "crypto/sha256" // for crypto.SHA256
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codingbrain/clix.go/clix"
+	"github.com/codingbrain/gms/gms"
+)
+
+// executable is bound to options of a command and runs it with the
+// remaining args
+type executable interface {
+	Execute(args []string) error
+}
+
+// command is a subcommand and what runs it
+type command struct {
+	def *clix.Command
+	cmd executable
+}
+
+// runCmd is a command without options taking min to max args
+type runCmd struct {
+	min, max int
+	run      func(ctx context.Context, args []string) error
+}
+
+func (cmd *runCmd) Execute(args []string) error {
+	return execute(args, cmd.min, cmd.max, cmd.run)
+}
+
+func arg(name, desc string) *clix.Option {
+	return &clix.Option{Name: name, Desc: desc, Type: "string"}
+}
+
+var commands = []command{
+	{&clix.Command{
+		Name: "add",
+		Desc: "add a git repository or archive, the name is suggested and printed without NAME",
+		Options: []*clix.Option{
+			{Name: "offline", Desc: "detect repository from URL without contacting the remote", Type: "bool"},
+			{Name: "filter", Desc: "partial clone filter of git repository, e.g. blob:none", Type: "string"},
+			{Name: "clone-arg", Desc: "extra argument of git clone", Type: "string", List: true},
+			{Name: "config", Desc: "KEY=VALUE git config of the local clone", Type: "string", List: true},
+			{Name: "archive", Desc: "URL is a tar or zip archive downloaded over HTTP(S)", Type: "bool"},
+			{Name: "strip", Desc: "leading path components removed from entries of --archive", Type: "int"},
+			{Name: "user-agent", Desc: "user agent of --archive requests", Type: "string"},
+			{Name: "header", Desc: "NAME: VALUE header of --archive requests", Type: "string", List: true},
+			{Name: "mirror", Desc: "URL of a mirror tried when the remote fails", Type: "string", List: true},
+		},
+		Arguments: []*clix.Option{arg("name", "name of the repository, optional"), arg("url", "URL of the remote")},
+	}, &addCmd{}},
+	{&clix.Command{
+		Name: "rm",
+		Desc: "remove a repository",
+		Options: []*clix.Option{
+			{Name: "purge", Desc: "delete the local clone", Type: "bool"},
+			{Name: "trash", Desc: "move the local clone to trash", Type: "bool"},
+		},
+		Arguments: []*clix.Option{arg("name", "name of the repository")},
+	}, &removeCmd{}},
+	{&clix.Command{
+		Name: "ls",
+		Desc: "list repositories",
+		Options: []*clix.Option{
+			{Name: "format", Desc: "output format: table, json or yaml", Type: "string", Default: gms.FormatTable},
+		},
+	}, &listCmd{}},
+	{&clix.Command{
+		Name: "sync",
+		Desc: "sync repositories",
+		Options: []*clix.Option{
+			{Name: "all", Desc: "sync all repositories", Type: "bool"},
+			{Name: "verbose", Alias: []string{"v"}, Desc: "print what each sync did", Type: "bool"},
+		},
+		Arguments: []*clix.Option{{Name: "names", Desc: "names of repositories without --all", Type: "string", List: true}},
+	}, &syncCmd{}},
+	{&clix.Command{
+		Name:      "show",
+		Desc:      "show details of a repository",
+		Arguments: []*clix.Option{arg("name", "name of the repository")},
+	}, &runCmd{1, 1, runShow}},
+	{&clix.Command{
+		Name: "walk",
+		Desc: "list files of a repository",
+		Options: []*clix.Option{
+			{Name: "glob", Desc: "only list paths matching the pattern", Type: "string"},
+			{Name: "hash", Desc: "print sha256 of files", Type: "bool"},
+		},
+		Arguments: []*clix.Option{arg("name", "name of the repository")},
+	}, &walkCmd{}},
+	{&clix.Command{
+		Name: "gc",
+		Desc: "purge trashed clones, unreferenced shared objects and blobs",
+		Options: []*clix.Option{
+			{Name: "older", Desc: "only purge clones trashed before this duration, e.g. 24h", Type: "string"},
+		},
+	}, &gcCmd{}},
+	{&clix.Command{
+		Name: "doctor",
+		Desc: "check the cache for problems",
+	}, &runCmd{0, 0, runDoctor}},
+}
+
+// commandDefs returns definitions of commands
+func commandDefs() []*clix.Command {
+	defs := make([]*clix.Command, 0, len(commands))
+	for _, cmd := range commands {
+		defs = append(defs, cmd.def)
+	}
+	return defs
+}
+
+// addCmd adds a git repository or archive
+type addCmd struct {
+	Offline   bool
+	Archive   bool
+	Strip     int
+	Filter    string
+	UserAgent string
+	Mirror    []string
+	CloneArg  []string
+	Config    []string
+	Header    []string
+}
+
+func (cmd *addCmd) Execute(args []string) error {
+	return execute(args, 1, 2, cmd.run)
+}
+
+func (cmd *addCmd) run(ctx context.Context, args []string) error {
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	// without NAME, the suggested name is printed
+	name, url, named := "", args[0], len(args) == 2
+	if named {
+		name, url = args[0], args[1]
+	}
+	if cmd.Archive {
+		repo := &gms.ArchiveRepo{URL: url, Mirrors: cmd.Mirror, StripComponents: cmd.Strip, UserAgent: cmd.UserAgent}
+		for _, header := range cmd.Header {
+			key, value, ok := strings.Cut(header, ":")
+			if !ok || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("%w: --header %q is not NAME: VALUE", errUsage, header)
+			}
+			if repo.Headers == nil {
+				repo.Headers = make(map[string]string)
+			}
+			repo.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		if name == "" {
+			name = c.UniqueName(gms.SuggestRepoName(repo))
+		}
+		return addRepo(c, name, repo, !named)
+	}
+	repo := &gms.GitRepo{URL: url, Client: gms.GitClientWithContext(ctx, gms.DefaultGitClient)}
+	if cmd.Offline {
+		repo.DetectMode = gms.DetectOffline
+	}
+	if dryRun == nil {
+		repo.DetectCache = c.DetectCache()
+	}
+	if err = repo.Detect(); err != nil {
+		return err
+	}
+	repo.Client, repo.DetectCache = nil, nil
+	repo.Mirrors, repo.Filter, repo.CloneArgs = cmd.Mirror, cmd.Filter, cmd.CloneArg
+	for _, config := range cmd.Config {
+		pos := strings.Index(config, "=")
+		if pos <= 0 {
+			return fmt.Errorf("%w: --config %q is not KEY=VALUE", errUsage, config)
+		}
+		if repo.Config == nil {
+			repo.Config = make(map[string]string)
+		}
+		repo.Config[config[:pos]] = config[pos+1:]
+	}
+	if name == "" {
+		name = c.UniqueName(gms.SuggestRepoName(repo))
+	}
+	return addRepo(c, name, repo, !named)
+}
+
+func addRepo(c *gms.RepoCache, name string, repo gms.RemoteRepo, announce bool) error {
+	_, err := c.Add(name, repo)
+	if err == nil && announce {
+		fmt.Println(name)
+	}
+	return err
+}
+
+// removeCmd removes a repository
+type removeCmd struct {
+	Purge bool
+	Trash bool
+}
+
+func (cmd *removeCmd) Execute(args []string) error {
+	return execute(args, 1, 1, cmd.run)
+}
+
+func (cmd *removeCmd) run(ctx context.Context, args []string) error {
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	r, err := findRepo(c, args[0])
+	if err != nil {
+		return err
+	}
+	return c.RemoveWith(r.Name, gms.RemoveOptions{Purge: cmd.Purge, Trash: cmd.Trash})
+}
+
+// listCmd lists repositories
+type listCmd struct {
+	Format string
+}
+
+func (cmd *listCmd) Execute(args []string) error {
+	return execute(args, 0, 0, cmd.run)
+}
+
+func (cmd *listCmd) run(ctx context.Context, args []string) error {
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	return gms.RenderRepoInfos(os.Stdout, c.List(), cmd.Format)
+}
+
+// syncCmd syncs repositories
+type syncCmd struct {
+	All     bool
+	Verbose bool
+}
+
+func (cmd *syncCmd) Execute(args []string) error {
+	return execute(args, 0, -1, cmd.run)
+}
+
+func (cmd *syncCmd) run(ctx context.Context, args []string) error {
+	if cmd.All == (len(args) > 0) {
+		return fmt.Errorf("%w: either --all or NAME", errUsage)
+	}
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	var results []*gms.SyncResult
+	if cmd.All {
+		results, err = c.SyncAllResults(ctx)
+	} else {
+		var errs clix.AggregatedError
+		for _, name := range args {
+			r, err := findRepo(c, name)
+			if errs.Add(err) {
+				continue
+			}
+			res, err := r.SyncWithResult(ctx)
+			results = append(results, res)
+			if err != nil {
+				errs.Add(fmt.Errorf("%s: %w", r.Name, err))
+			}
+		}
+		err = errs.Aggregate()
+	}
+	if rec, ok := c.ConfigStore.(syncRecorder); ok && dryRun == nil {
+		if e := rec.RecordSyncs(results); err == nil {
+			err = e
+		}
+	}
+	if cmd.Verbose {
+		printSyncResults(results)
+	}
+	return err
+}
+
+// syncRecorder is a config store keeping sync history
+type syncRecorder interface {
+	RecordSyncs(results []*gms.SyncResult) error
+}
+
+func printSyncResults(results []*gms.SyncResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, res := range results {
+		if res.Err != nil {
+			fmt.Fprintf(w, "%s\tfailed\t%v\n", res.Repo, res.Err)
+			continue
+		}
+		files := "-"
+		if res.FilesChanged >= 0 {
+			files = fmt.Sprintf("%d files", res.FilesChanged)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d bytes\t%v\n", res.Repo, res.Action,
+			shortVersion(res.NewVersion), files, res.BytesTransferred, res.Duration.Round(time.Millisecond))
+	}
+	w.Flush()
+}
+
+// shortVersion abbreviates commit ids
+func shortVersion(v string) string {
+	if id, err := gms.ParseSHA(v); err == nil {
+		return id.Short()
+	}
+	return v
+}
+
+func runShow(ctx context.Context, args []string) error {
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	r, err := findRepo(c, args[0])
+	if err != nil {
+		return err
+	}
+	stats, err := r.Stats()
+	if err != nil {
+		return err
+	}
+	state, err := r.State()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", r.Name)
+	fmt.Fprintf(w, "Type:\t%s\n", r.Persist().Type)
+	if git, ok := r.Remote.(*gms.GitRepo); ok {
+		fmt.Fprintf(w, "URL:\t%s\n", git.URL)
+	}
+	fmt.Fprintf(w, "Local:\t%s\n", r.LocalDir)
+	if len(r.Meta.Aliases) > 0 {
+		fmt.Fprintf(w, "Aliases:\t%v\n", r.Meta.Aliases)
+	}
+	if len(r.Meta.Tags) > 0 {
+		fmt.Fprintf(w, "Tags:\t%v\n", r.Meta.Tags)
+	}
+	if hooks := r.Meta.Hooks; hooks != nil {
+		for _, command := range hooks.PreSync {
+			fmt.Fprintf(w, "Pre-sync hook:\t%s\n", command)
+		}
+		for _, command := range hooks.PostSync {
+			fmt.Fprintf(w, "Post-sync hook:\t%s\n", command)
+		}
+	}
+	if id := r.Meta.Identity; id != nil {
+		for _, key := range sortedKeys(id.Env) {
+			fmt.Fprintf(w, "Env:\t%s=%s\n", key, id.Env[key])
+		}
+		for _, key := range sortedKeys(id.Secrets) {
+			fmt.Fprintf(w, "Secret env:\t%s=%s\n", key, id.Secrets[key])
+		}
+		if id.Credential != "" {
+			fmt.Fprintf(w, "Credential:\t%s\n", id.Credential)
+		}
+		if id.SSHKey != "" {
+			fmt.Fprintf(w, "SSH key:\t%s\n", id.SSHKey)
+		}
+	}
+	fmt.Fprintf(w, "Version:\t%s\n", state.Version)
+	if !state.LastSync.IsZero() {
+		fmt.Fprintf(w, "Last sync:\t%s\n", state.LastSync.Format(time.RFC3339))
+	}
+	if state.LastError != "" {
+		fmt.Fprintf(w, "Last error:\t%s\n", state.LastError)
+	}
+	if state.Dirty != "" {
+		fmt.Fprintf(w, "Modified locally:\t%s\n", state.Dirty)
+	}
+	fmt.Fprintf(w, "Syncs:\t%d (%d failed)\n", state.Syncs, state.Failures)
+	fmt.Fprintf(w, "Files:\t%d\n", stats.Files)
+	fmt.Fprintf(w, "Disk usage:\t%d\n", stats.DiskUsage)
+	return w.Flush()
+}
+
+// sortedKeys returns keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// walkCmd lists files of a repository
+type walkCmd struct {
+	Glob string
+	Hash bool
+}
+
+func (cmd *walkCmd) Execute(args []string) error {
+	return execute(args, 1, 1, cmd.run)
+}
+
+func (cmd *walkCmd) run(ctx context.Context, args []string) error {
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	r, err := findRepo(c, args[0])
+	if err != nil {
+		return err
+	}
+	if x, err := r.PathIndex(); err == nil {
+		entries := x.Entries
+		if cmd.Glob != "" {
+			entries = x.Glob(cmd.Glob)
+		}
+		for _, entry := range entries {
+			if cmd.Hash {
+				fmt.Printf("%s  %s\n", entry.Hash, entry.Path)
+			} else {
+				fmt.Println(entry.Path)
+			}
+		}
+		return nil
+	}
+	w := &gms.RepoWalker{
+		Sorted: true,
+		Logger: logger,
+		WalkerFn: func(item gms.WalkingItem) error {
+			if item.FileInfo.IsDir() {
+				return nil
+			}
+			if cmd.Hash {
+				fmt.Printf("%s  %s\n", item.Hash, item.RelPath)
+			} else {
+				fmt.Println(item.RelPath)
+			}
+			return nil
+		},
+	}
+	if cmd.Hash {
+		w.Hash = crypto.SHA256
+	}
+	w.Use(gms.FilterVCS())
+	if cmd.Glob != "" {
+		w.Use(gms.FilterGlob(cmd.Glob))
+	}
+	return w.VisitContext(ctx, r.Name, r)
+}
+
+// gcCmd purges unused content
+type gcCmd struct {
+	Older string
+}
+
+func (cmd *gcCmd) Execute(args []string) error {
+	return execute(args, 0, 0, cmd.run)
+}
+
+func (cmd *gcCmd) run(ctx context.Context, args []string) error {
+	older, err := parseDuration("older", cmd.Older)
+	if err != nil {
+		return err
+	}
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	if err = c.PurgeTrash(older); err != nil {
+		return err
+	}
+	if err = c.PruneObjects(); err != nil {
+		return err
+	}
+	return c.PruneCAS()
+}
+
+func runDoctor(ctx context.Context, args []string) error {
+	problems := 0
+	report := func(format string, a ...interface{}) {
+		problems++
+		fmt.Printf(format+"\n", a...)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		report("git: not found in PATH")
+	}
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+	for _, name := range c.RepoNames() {
+		r := c.Find(name)
+		state, err := r.State()
+		if err != nil {
+			report("%s: state: %v", name, err)
+			continue
+		}
+		if state.LastSync.IsZero() {
+			report("%s: never synced", name)
+			continue
+		}
+		if _, err = os.Stat(r.LocalDir); err != nil {
+			report("%s: local clone: %v", name, err)
+			continue
+		}
+		if state.LastError != "" {
+			report("%s: last sync failed: %s", name, state.LastError)
+		}
+		if state.Unhealthy != "" {
+			report("%s: unhealthy: %s", name, state.Unhealthy)
+		}
+		if state.Dirty != "" {
+			report("%s: modified locally: %s", name, state.Dirty)
+		}
+		if err = r.Verify(); err != nil && err != gms.ErrNoManifest {
+			report("%s: %v", name, err)
+		}
+	}
+	for _, s := range c.RemoteHealth().Statuses() {
+		if s.Failures > 0 {
+			report("%s: failed %d time(s), last at %s: %s", s.Remote, s.Failures,
+				s.LastFailure.Format(time.RFC3339), s.LastError)
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	fmt.Println("no problems found")
+	return nil
+}
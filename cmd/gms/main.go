@@ -0,0 +1,322 @@
+// Command gms manages a cache of remote repositories
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codingbrain/clix.go/clix"
+	"github.com/codingbrain/clix.go/exts/bind"
+	"github.com/codingbrain/clix.go/exts/help"
+	"github.com/codingbrain/clix.go/term"
+	"github.com/codingbrain/gms/gms"
+)
+
+// gmsOptions are global options bound to the root command
+type gmsOptions struct {
+	Cache         string
+	ConfigStore   string
+	Layout        string
+	Shared        bool
+	SharedGroup   string
+	Secrets       string
+	Deny          []string
+	Scrub         bool
+	ScrubPattern  []string
+	Quarantine    bool
+	Verbose       bool
+	DryRun        bool
+	Atomic        bool
+	SharedObjects bool
+	DetectChanges bool
+	Index         bool
+	Cas           bool
+	ReadOnly      bool
+	Keep          int
+	Bwlimit       int
+	Budget        int
+	Parallel      int
+	HostConns     int
+	HostRpm       int
+	SshStrict     string
+	SshKnownHosts string
+	SshHostKey    []string
+}
+
+var (
+	opts   gmsOptions
+	logger gms.Logger
+	dryRun *gms.Plan
+
+	errUsage = errors.New("invalid usage")
+)
+
+func defaultCacheDir() string {
+	if dir := os.Getenv("GMS_CACHE"); dir != "" {
+		return dir
+	}
+	// caches created before the XDG default are still used
+	if home, err := os.UserHomeDir(); err == nil {
+		if _, err = os.Stat(filepath.Join(home, ".gms")); err == nil {
+			return filepath.Join(home, ".gms")
+		}
+	}
+	return gms.DefaultCacheDir()
+}
+
+// cliDef defines global options and commands
+func cliDef() *clix.CliDef {
+	return &clix.CliDef{
+		Cli: &clix.Command{
+			Name: "gms",
+			Desc: "manage a cache of remote repositories",
+			Options: []*clix.Option{
+				{Name: "cache", Desc: "cache directory, $GMS_CACHE", Type: "string", Default: defaultCacheDir()},
+				{Name: "config-store", Desc: "store of the repository registry, a directory or SCHEME:LOCATION e.g. env:VAR, the cache directory if empty, $GMS_CONFIG_STORE", Type: "string", Default: os.Getenv("GMS_CONFIG_STORE")},
+				{Name: "layout", Desc: "placement of local clones: flat or host, $GMS_LAYOUT", Type: "string", Default: os.Getenv("GMS_LAYOUT")},
+				{Name: "shared", Desc: "share the cache with users of its group, $GMS_SHARED_GROUP implies it", Type: "bool"},
+				{Name: "shared-group", Desc: "group owning a shared cache, the group of the cache directory if empty, $GMS_SHARED_GROUP", Type: "string", Default: os.Getenv("GMS_SHARED_GROUP")},
+				{Name: "secrets", Desc: "comma-separated providers of secrets referenced by repositories, directories or SCHEME:LOCATION e.g. env:PREFIX, file:DIR or cmd:COMMAND, $GMS_SECRETS", Type: "string", Default: secretsDefault()},
+				{Name: "deny", Desc: "pattern of files never walked, exported or served, e.g. *.key or a path like docs/embargoed, $GMS_DENY separated by commas", Type: "string", List: true},
+				{Name: "scrub", Desc: "remove git hooks, setuid files and symlinks escaping repositories after sync", Type: "bool"},
+				{Name: "scrub-pattern", Desc: "pattern of files removed after sync in addition to --scrub, e.g. *.exe", Type: "string", List: true},
+				{Name: "quarantine", Desc: "move files removed by --scrub into the quarantine of repositories instead", Type: "bool"},
+				{Name: "verbose", Alias: []string{"v"}, Desc: "log diagnostic messages to stderr", Type: "bool"},
+				{Name: "dry-run", Alias: []string{"n"}, Desc: "print actions instead of performing them", Type: "bool"},
+				{Name: "atomic", Desc: "sync into staging copies swapped in when complete", Type: "bool"},
+				{Name: "shared-objects", Desc: "clone git repositories borrowing objects shared by repositories of the same host and org", Type: "bool"},
+				{Name: "detect-changes", Desc: "skip syncing repositories whose remote is unchanged since the last sync", Type: "bool"},
+				{Name: "index", Desc: "index paths of repositories after sync for quick walks", Type: "bool"},
+				{Name: "cas", Desc: "deduplicate files of repositories by hard links to a content-addressable store", Type: "bool"},
+				{Name: "read-only", Desc: "make content of repositories read-only after sync", Type: "bool"},
+				{Name: "keep", Desc: "previous versions to keep with --atomic", Type: "int"},
+				{Name: "bwlimit", Desc: "max download bytes per second of repos downloading themselves", Type: "int"},
+				{Name: "budget", Desc: "max bytes transferred by sync --all", Type: "int"},
+				{Name: "parallel", Desc: "max repositories synced concurrently by sync --all", Type: "int", Default: 1},
+				{Name: "host-conns", Desc: "max concurrent syncs per remote host", Type: "int"},
+				{Name: "host-rpm", Desc: "max syncs started per remote host in a minute", Type: "int"},
+				{Name: "ssh-strict", Desc: "StrictHostKeyChecking of ssh remotes: yes, accept-new or no", Type: "string"},
+				{Name: "ssh-known-hosts", Desc: "known_hosts file of ssh remotes, $GMS_CACHE/known_hosts with --ssh-host-key", Type: "string"},
+				{Name: "ssh-host-key", Desc: "HOST=KEY pinned host key of ssh remotes", Type: "string", List: true},
+			},
+			Commands: commandDefs(),
+		},
+	}
+}
+
+func main() {
+	binder := bind.NewExt().Bind(&opts)
+	for _, cmd := range commands {
+		binder.Bind(cmd.cmd, cmd.def.Name)
+	}
+	cli := cliDef()
+	cli.Normalize()
+	cli.Use(term.NewExt()).
+		Use(binder).
+		Use(help.NewExt()).
+		Parse(os.Args).
+		Exec()
+}
+
+// setup applies global options
+func (o *gmsOptions) setup() error {
+	for _, pattern := range strings.Split(os.Getenv("GMS_DENY"), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			o.Deny = append(o.Deny, pattern)
+		}
+	}
+	if o.Verbose {
+		logger = log.New(os.Stderr, "gms: ", log.Ltime|log.Lmicroseconds)
+		gms.DefaultGitClient.Logger = logger
+	}
+	if o.SshStrict != "" || o.SshKnownHosts != "" || len(o.SshHostKey) > 0 {
+		ssh, err := sshOptions(o.SshStrict, o.SshKnownHosts, o.SshHostKey)
+		if err != nil {
+			return err
+		}
+		gms.DefaultGitClient.SSH = ssh
+	}
+	if o.DryRun {
+		dryRun = &gms.Plan{}
+	}
+	return nil
+}
+
+// execute runs a command after applying global options, errUsage if the
+// number of args is less than min or more than max unless max < 0
+func execute(args []string, min, max int, run func(ctx context.Context, args []string) error) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return errUsage
+	}
+	if err := opts.setup(); err != nil {
+		return err
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	err := run(ctx, args)
+	if dryRun != nil {
+		fmt.Print(dryRun.String())
+	}
+	if err != nil && !errors.Is(err, errUsage) {
+		return errors.New(gms.RedactURL(err.Error()))
+	}
+	return err
+}
+
+func secretsDefault() string {
+	if spec := os.Getenv("GMS_SECRETS"); spec != "" {
+		return spec
+	}
+	return "env:GMS_SECRET_"
+}
+
+// secretProviders opens the providers of --secrets
+func secretProviders() (gms.SecretProvider, error) {
+	var providers gms.SecretProviders
+	for _, spec := range strings.Split(opts.Secrets, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		provider, err := gms.OpenSecretProvider(spec)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// sshOptions builds host key verification of ssh remotes from options
+func sshOptions(strict, knownHosts string, hostKeys []string) (*gms.SSHOptions, error) {
+	checking, err := gms.ParseHostKeyChecking(strict)
+	if err != nil {
+		return nil, err
+	}
+	ssh := &gms.SSHOptions{StrictHostKeyChecking: checking, KnownHostsFile: knownHosts}
+	for _, hostKey := range hostKeys {
+		pos := strings.Index(hostKey, "=")
+		if pos <= 0 {
+			return nil, fmt.Errorf("invalid --ssh-host-key %q", hostKey)
+		}
+		if ssh.HostKeys == nil {
+			ssh.HostKeys = make(map[string][]string)
+		}
+		host := hostKey[:pos]
+		ssh.HostKeys[host] = append(ssh.HostKeys[host], hostKey[pos+1:])
+	}
+	if ssh.KnownHostsFile == "" && len(ssh.HostKeys) > 0 {
+		ssh.KnownHostsFile = filepath.Join(opts.Cache, gms.KnownHostsFile)
+	}
+	return ssh, nil
+}
+
+// openCache loads the cache, an empty cache is created if absent
+func openCache() (*gms.RepoCache, error) {
+	c := &gms.RepoCache{
+		BaseDir:       opts.Cache,
+		Logger:        logger,
+		DryRun:        dryRun,
+		AtomicSync:    opts.Atomic,
+		SharedObjects: opts.SharedObjects,
+		DetectChanges: opts.DetectChanges,
+		IndexPaths:    opts.Index,
+		CAS:           opts.Cas,
+		ReadOnly:      opts.ReadOnly,
+		KeepVersions:  opts.Keep,
+		SyncBudget:    int64(opts.Budget),
+		SyncParallel:  opts.Parallel,
+	}
+	if opts.Shared || opts.SharedGroup != "" {
+		c.Shared = &gms.SharedCache{Group: opts.SharedGroup}
+		gms.DefaultGitClient.Config = gms.SharedGitConfig(opts.Cache)
+	}
+	provider, err := secretProviders()
+	if err != nil {
+		return nil, err
+	}
+	c.Secrets = provider
+	if len(opts.Deny) > 0 {
+		c.ContentPolicy = gms.DenyPatterns(opts.Deny)
+	}
+	if opts.Scrub || len(opts.ScrubPattern) > 0 {
+		scrubber := &gms.Scrubber{Patterns: opts.ScrubPattern, Quarantine: opts.Quarantine}
+		if opts.Scrub {
+			*scrubber = *gms.DefaultScrubber
+			scrubber.Patterns = append(append([]string(nil), scrubber.Patterns...), opts.ScrubPattern...)
+			scrubber.Quarantine = opts.Quarantine
+		}
+		c.Scrubber = scrubber
+	}
+	switch opts.Layout {
+	case "", "flat":
+	case "host":
+		c.Layout = gms.HostLayout
+	default:
+		return nil, fmt.Errorf("invalid layout %q", opts.Layout)
+	}
+	if opts.Bwlimit > 0 {
+		c.RateLimiter = &gms.RateLimiter{BytesPerSecond: int64(opts.Bwlimit)}
+	}
+	if opts.HostConns > 0 || opts.HostRpm > 0 {
+		c.HostLimiter = &gms.HostLimiter{MaxConcurrent: opts.HostConns, RequestsPerMinute: opts.HostRpm}
+	}
+	if key := os.Getenv("GMS_SEAL_KEY"); key != "" {
+		sealKey, err := gms.ParseSealKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("GMS_SEAL_KEY: %w", err)
+		}
+		c.SealKey = sealKey
+	}
+	confDir := opts.Cache
+	if opts.ConfigStore != "" {
+		store, err := gms.OpenConfigStore(opts.ConfigStore)
+		if err != nil {
+			return nil, err
+		}
+		c.ConfigStore = store
+		fs, ok := store.(*gms.FileConfigStore)
+		if !ok {
+			return c, c.Load()
+		}
+		confDir = fs.Dir
+	}
+	if _, err := os.Stat(filepath.Join(confDir, gms.CacheConfFile)); os.IsNotExist(err) && dryRun == nil {
+		if err = os.MkdirAll(confDir, 0755); err != nil {
+			return nil, err
+		}
+		if err = c.Share(); err != nil {
+			return nil, err
+		}
+		if err = c.Save(); err != nil {
+			return nil, err
+		}
+	}
+	return c, c.Load()
+}
+
+// findRepo looks up a repo by name or alias
+func findRepo(c *gms.RepoCache, name string) (*gms.CachedRepo, error) {
+	if r := c.Lookup(name); r != nil {
+		return r, nil
+	}
+	return nil, fmt.Errorf("%s: %w", name, gms.ErrRepoNotFound)
+}
+
+// parseDuration parses an optional duration option
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: --%s: %v", errUsage, name, err)
+	}
+	return d, nil
+}
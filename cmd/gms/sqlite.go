@@ -0,0 +1,10 @@
+//go:build sqlite
+
+package main
+
+// sqlite: config store keeps the repo registry and sync history in a
+// SQLite database, e.g. --config-store sqlite:$HOME/.gms/gms.db
+import (
+	_ "github.com/codingbrain/gms/gms/sqlitestore"
+	_ "github.com/mattn/go-sqlite3"
+)
@@ -0,0 +1,97 @@
+package gms
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// archiveExts are stripped from archive URLs to suggest repo names
+var archiveExts = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// AddFromURL detects the git repository of url and adds it under a name
+// derived from the repository name, see AddFromURLContext
+func (c *RepoCache) AddFromURL(url string) (*CachedRepo, error) {
+	return c.AddFromURLContext(context.Background(), url)
+}
+
+// AddFromURLContext detects the git repository of url and adds it under
+// the name suggested by SuggestRepoName, suffixed to be unique. The repo
+// is synced if SyncOnAdd is set
+func (c *RepoCache) AddFromURLContext(ctx context.Context, url string) (*CachedRepo, error) {
+	repo, err := c.detectGitRepo(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Add(c.UniqueName(SuggestRepoName(repo)), repo)
+	if err != nil || !c.SyncOnAdd {
+		return r, err
+	}
+	return r, r.SyncContext(ctx)
+}
+
+// detectGitRepo detects the git repository of url with the detect cache
+// and the remote policy of the cache
+func (c *RepoCache) detectGitRepo(ctx context.Context, url string) (*GitRepo, error) {
+	repo := &GitRepo{
+		URL:          url,
+		Client:       GitClientWithContext(ctx, DefaultGitClient),
+		RemotePolicy: c.RemotePolicy,
+	}
+	if c.DryRun == nil {
+		repo.DetectCache = c.DetectCache()
+	}
+	if err := repo.Detect(); err != nil {
+		return nil, err
+	}
+	repo.Client, repo.DetectCache, repo.RemotePolicy = nil, nil, nil
+	return repo, nil
+}
+
+// SuggestRepoName derives a name from the last path component of the
+// remote, e.g. "gms" for github.com/codingbrain/gms.git. Characters other
+// than letters, digits, '.', '-' and '_' are replaced by '-'
+func SuggestRepoName(remote RemoteRepo) string {
+	var name string
+	switch r := remote.(type) {
+	case *GitRepo:
+		name = r.RepoName
+		if name == "" {
+			name = r.URL
+		}
+		name = strings.TrimSuffix(path.Base(strings.TrimRight(strings.ReplaceAll(name, "\\", "/"), "/")), ".git")
+	case *ArchiveRepo:
+		if u, err := ParseRepoURL(r.URL); err == nil {
+			name = path.Base(u.Path)
+		}
+		for _, ext := range archiveExts {
+			if strings.HasSuffix(strings.ToLower(name), ext) {
+				name = name[:len(name)-len(ext)]
+				break
+			}
+		}
+	}
+	name = strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '.', c == '-', c == '_':
+			return c
+		}
+		return '-'
+	}, name)
+	if name = strings.Trim(name, ".-"); name == "" {
+		return "repo"
+	}
+	return name
+}
+
+// UniqueName returns name if it's not used by any repo as name or alias,
+// otherwise name suffixed with -2, -3 and so on
+func (c *RepoCache) UniqueName(name string) string {
+	candidate := name
+	for i := 2; c.repos[candidate] != nil || c.FindByAlias(candidate) != nil; i++ {
+		candidate = name + "-" + strconv.Itoa(i)
+	}
+	return candidate
+}
@@ -0,0 +1,205 @@
+package gms
+
+import (
+	"bufio"
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CacheObjectsDir is the name of sub-directory containing git object
+	// stores shared by clones of repos on the same host and org
+	CacheObjectsDir = "objects"
+
+	// objectsRefPrefix namespaces refs of each cached repo in stores
+	objectsRefPrefix = "refs/gms/"
+)
+
+// sharedObjects locates the object store of a cached repo
+type sharedObjects struct {
+	dir  string
+	name string
+}
+
+type sharedObjectsKey struct{}
+
+// withSharedObjects returns ctx telling git clones of the cached repo
+// name to reference object stores in dir
+func withSharedObjects(ctx context.Context, dir, name string) context.Context {
+	return context.WithValue(ctx, sharedObjectsKey{}, &sharedObjects{dir: dir, name: name})
+}
+
+func sharedObjectsFromContext(ctx context.Context) *sharedObjects {
+	s, _ := ctx.Value(sharedObjectsKey{}).(*sharedObjects)
+	return s
+}
+
+// objectsDir returns the directory of shared object stores, or empty
+// if SharedObjects is not set
+func (c *RepoCache) objectsDir() string {
+	if !c.SharedObjects {
+		return ""
+	}
+	return filepath.Join(c.BaseDir, CacheObjectsDir)
+}
+
+// storeDir returns the store shared by repos on the host and org of
+// remote, e.g. objects/github.com/codingbrain.git
+func (s *sharedObjects) storeDir(remote string) (string, error) {
+	u, err := ParseRepoURL(remote)
+	if err != nil {
+		return "", err
+	}
+	host := u.Host
+	if host == "" {
+		host = "local"
+	}
+	org := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)[0]
+	dir, err := SafeJoin(s.dir, url.PathEscape(host)+"/"+url.PathEscape(org)+".git")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(dir)
+}
+
+// fetch creates the store of remote if missing and fetches branches and
+// tags from source, a mirror or remote itself, into the namespace of the
+// cached repo so objects are kept while the repo exists. opts are
+// options of git fetch
+func (s *sharedObjects) fetch(client GitClient, remote, source string, opts []string) (string, error) {
+	store, err := s.storeDir(remote)
+	if err != nil {
+		return "", err
+	}
+	defer lockWorkDir(store)()
+	if _, err = os.Stat(store); os.IsNotExist(err) {
+		if err = os.MkdirAll(filepath.Dir(store), 0755); err != nil {
+			return "", err
+		}
+		if _, err := client.Exec("init", "--bare", "-q", store); err != nil {
+			return "", err
+		}
+		// gc only runs from PruneObjects which knows the references
+		if _, err := client.Exec("--git-dir="+store, "config", "gc.auto", "0"); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	ns := objectsRefPrefix + url.PathEscape(s.name)
+	args := append(append([]string{"--git-dir=" + store, "fetch", "--no-tags"}, opts...), source,
+		"+refs/heads/*:"+ns+"/heads/*", "+refs/tags/*:"+ns+"/tags/*")
+	if _, err := client.Exec(args...); err != nil {
+		return "", err
+	}
+	return store, nil
+}
+
+// PruneObjects deletes shared object stores no longer referenced by any
+// clone, including trashed clones and versions. Namespaces of removed
+// repos are dropped from stores not referenced by trashed clones, then
+// git gc packs and prunes the stores, objects borrowed by clones stay
+// reachable from namespaces of their repos
+func (c *RepoCache) PruneObjects() error {
+	objectsDir := filepath.Join(c.BaseDir, CacheObjectsDir)
+	stores, err := filepath.Glob(filepath.Join(objectsDir, "*", "*.git"))
+	if err != nil || len(stores) == 0 {
+		return err
+	}
+	clones, err := findClones(filepath.Join(c.BaseDir, c.reposDir()))
+	if err != nil {
+		return err
+	}
+	referenced, err := c.referencedStores(clones, filepath.Join(CacheVersionsDir, "*"))
+	if err != nil {
+		return err
+	}
+	trashed, err := c.referencedStores(nil, CacheTrashDir)
+	if err != nil {
+		return err
+	}
+	for _, store := range stores {
+		if store, err = filepath.Abs(store); err != nil {
+			return err
+		}
+		if !referenced[store] && !trashed[store] {
+			if c.DryRun != nil {
+				c.DryRun.record(PlannedAction{Op: PlanDelete, Path: store})
+				continue
+			}
+			logf(c.Logger, "objects: removing unreferenced %s", store)
+			if err = os.RemoveAll(store); err != nil {
+				return err
+			}
+			continue
+		}
+		if c.DryRun != nil {
+			continue
+		}
+		if !trashed[store] {
+			if err = c.pruneNamespaces(store); err != nil {
+				return err
+			}
+		}
+		if _, err := DefaultGitClient.Exec("--git-dir="+store, "gc", "--quiet"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneNamespaces deletes refs of repos no longer in the cache
+func (c *RepoCache) pruneNamespaces(store string) error {
+	out, gerr := DefaultGitClient.Exec("--git-dir="+store, "for-each-ref", "--format=%(refname)", objectsRefPrefix)
+	if gerr != nil {
+		return gerr
+	}
+	for _, ref := range strings.Split(strings.TrimSpace(out), "\n") {
+		ns := strings.SplitN(strings.TrimPrefix(ref, objectsRefPrefix), "/", 2)[0]
+		if name, err := url.PathUnescape(ns); ref == "" || err != nil || c.repos[name] != nil {
+			continue
+		}
+		if _, gerr = DefaultGitClient.Exec("--git-dir="+store, "update-ref", "-d", ref); gerr != nil {
+			return gerr
+		}
+	}
+	return nil
+}
+
+// referencedStores finds stores listed in alternates of clones, and
+// clones in the sub-directories of BaseDir matching patterns
+func (c *RepoCache) referencedStores(clones []string, patterns ...string) (map[string]bool, error) {
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(c.BaseDir, pattern, "*"))
+		if err != nil {
+			return nil, err
+		}
+		clones = append(clones, matches...)
+	}
+	stores := make(map[string]bool)
+	for _, clone := range clones {
+		f, err := os.Open(filepath.Join(clone, ".git", "objects", "info", "alternates"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				if !filepath.IsAbs(line) {
+					line = filepath.Join(clone, ".git", "objects", line)
+				}
+				stores[filepath.Clean(filepath.Dir(line))] = true
+			}
+		}
+		f.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return stores, nil
+}
@@ -0,0 +1,190 @@
+package gms
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// ArchiveFormat is the format of exported archive
+type ArchiveFormat string
+
+// Supported archive formats
+const (
+	ArchiveTar ArchiveFormat = "tar"
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+var (
+	// ErrUnsupportedArchiveFormat indicates the archive format is unknown
+	ErrUnsupportedArchiveFormat = errors.New("unsupported archive format")
+	// ErrArchiveTooLarge indicates an archive exceeds the size or number
+	// of entries allowed to be extracted
+	ErrArchiveTooLarge = errors.New("archive exceeds extraction limits")
+)
+
+// DefaultArchiveModTime is the modification time of all archive entries,
+// the earliest time representable in zip
+var DefaultArchiveModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ArchiveOptions are options of ExportArchive
+type ArchiveOptions struct {
+	// Prefix is prepended to all paths in the archive
+	Prefix string
+	// ModTime overrides DefaultArchiveModTime
+	ModTime time.Time
+	// Filters are additional walker filters selecting content
+	Filters []RepoWalkerFilter
+}
+
+// archiveWriter writes entries of a specific format
+type archiveWriter interface {
+	WriteDir(name string, modTime time.Time) error
+	WriteLink(name, target string, modTime time.Time) error
+	WriteFile(name string, mode os.FileMode, size int64, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+// ExportArchive streams content under BasePath into w as an archive.
+// Entries are ordered by path, timestamps and owners are fixed and
+// permissions normalized so the same content produces the same archive,
+// VCS metadata is not exported
+func ExportArchive(repo Repository, w io.Writer, format ArchiveFormat, opts ArchiveOptions) error {
+	var aw archiveWriter
+	switch format {
+	case ArchiveTar:
+		aw = &tarArchiveWriter{w: tar.NewWriter(w)}
+	case ArchiveZip:
+		aw = &zipArchiveWriter{w: zip.NewWriter(w)}
+	default:
+		return ErrUnsupportedArchiveFormat
+	}
+	modTime := opts.ModTime
+	if modTime.IsZero() {
+		modTime = DefaultArchiveModTime
+	}
+	walker := &RepoWalker{
+		Sorted: true,
+		WalkerFn: func(item WalkingItem) error {
+			name := path.Join(opts.Prefix, item.RelPath)
+			fi := item.FileInfo
+			switch {
+			case fi.IsDir():
+				return aw.WriteDir(name, modTime)
+			case fi.Mode()&os.ModeSymlink != 0:
+				if item.fsys != nil {
+					return nil
+				}
+				target, err := os.Readlink(item.prefix + filepath.Join(item.Path, item.Name))
+				if err != nil {
+					return err
+				}
+				return aw.WriteLink(name, filepath.ToSlash(target), modTime)
+			case fi.Mode().IsRegular():
+				f, err := item.Open()
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				return aw.WriteFile(name, archiveMode(fi.Mode()), fi.Size(), modTime, f)
+			}
+			return nil
+		},
+	}
+	walker.Use(FilterVCS())
+	walker.Use(opts.Filters...)
+	if err := walker.Visit("", repo); err != nil {
+		aw.Close()
+		return err
+	}
+	return aw.Close()
+}
+
+// archiveMode keeps only the executable bit of regular files
+func archiveMode(mode os.FileMode) os.FileMode {
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+type tarArchiveWriter struct {
+	w *tar.Writer
+}
+
+func (a *tarArchiveWriter) WriteDir(name string, modTime time.Time) error {
+	return a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+func (a *tarArchiveWriter) WriteLink(name, target string, modTime time.Time) error {
+	return a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     0777,
+		ModTime:  modTime,
+	})
+}
+
+func (a *tarArchiveWriter) WriteFile(name string, mode os.FileMode, size int64, modTime time.Time, r io.Reader) error {
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode),
+		Size:     size,
+		ModTime:  modTime,
+	})
+	if err == nil {
+		_, err = io.CopyN(a.w, r, size)
+	}
+	return err
+}
+
+func (a *tarArchiveWriter) Close() error {
+	return a.w.Close()
+}
+
+type zipArchiveWriter struct {
+	w *zip.Writer
+}
+
+func (a *zipArchiveWriter) create(name string, mode os.FileMode, method uint16, modTime time.Time) (io.Writer, error) {
+	hdr := &zip.FileHeader{Name: name, Method: method, Modified: modTime}
+	hdr.SetMode(mode)
+	return a.w.CreateHeader(hdr)
+}
+
+func (a *zipArchiveWriter) WriteDir(name string, modTime time.Time) error {
+	_, err := a.create(name+"/", os.ModeDir|0755, zip.Store, modTime)
+	return err
+}
+
+func (a *zipArchiveWriter) WriteLink(name, target string, modTime time.Time) error {
+	w, err := a.create(name, os.ModeSymlink|0777, zip.Store, modTime)
+	if err == nil {
+		_, err = io.WriteString(w, target)
+	}
+	return err
+}
+
+func (a *zipArchiveWriter) WriteFile(name string, mode os.FileMode, size int64, modTime time.Time, r io.Reader) error {
+	w, err := a.create(name, mode, zip.Deflate, modTime)
+	if err == nil {
+		_, err = io.CopyN(w, r, size)
+	}
+	return err
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.w.Close()
+}
@@ -0,0 +1,536 @@
+package gms
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ArchiveRepoType is the type of archive repository
+	ArchiveRepoType = "archive"
+	// ArchiveStateFile records the downloaded archive in the synced dir
+	ArchiveStateFile = ".gms-archive"
+)
+
+var (
+	// DefaultHTTPTransport is used by HTTP based repos without Transport,
+	// it honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	DefaultHTTPTransport http.RoundTripper = NewHTTPTransport(nil)
+	// DefaultUserAgent is sent by HTTP based repos without UserAgent
+	DefaultUserAgent = "gms"
+	// DefaultMaxExtractSize is the bytes extracted from an archive repo
+	// without MaxSize
+	DefaultMaxExtractSize int64 = 16 << 30
+	// DefaultMaxExtractEntries is the entries extracted from an archive
+	// repo without MaxEntries
+	DefaultMaxExtractEntries = 1 << 20
+)
+
+// NewHTTPTransport creates a transport honoring proxy environment
+// variables, tlsConfig is used if not nil, e.g. to trust the CA of a
+// corporate MITM proxy
+func NewHTTPTransport(tlsConfig *tls.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	return t
+}
+
+// HTTPError indicates an unexpected HTTP response status
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+// Error implements error, credentials in URL are redacted
+func (e *HTTPError) Error() string {
+	return RedactURL(e.URL) + ": " + e.Status
+}
+
+// ArchiveRepo is a remote tar (optionally gzipped) or zip archive
+// downloaded over HTTP(S) and extracted on Sync
+type ArchiveRepo struct {
+	// URL is the location of the archive
+	URL string `json:"url"`
+	// Mirrors are URLs of the same archive tried in order when URL fails
+	Mirrors []string `json:"mirrors,omitempty"`
+	// Format is tar or zip, it's zip if URL ends with .zip and tar otherwise
+	// if empty, gzipped tar is detected automatically
+	Format ArchiveFormat `json:"format,omitempty"`
+	// Path is prefix in the extracted content
+	Path string `json:"path,omitempty"`
+	// StripComponents is the number of leading path components removed
+	// from entries, e.g. 1 for archives of GitHub
+	StripComponents int `json:"strip,omitempty"`
+	// UserAgent is sent in requests, DefaultUserAgent if empty
+	UserAgent string `json:"userAgent,omitempty"`
+	// Headers are extra request headers, e.g. for SSO proxies or artifact
+	// gateways, sent to URL and Mirrors. Values are stored in plain text
+	// in the cache config, secrets belong in RepoIdentity
+	Headers map[string]string `json:"headers,omitempty"`
+	// MaxSize limits bytes of extracted files, DefaultMaxExtractSize if 0
+	// and unlimited if negative
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// MaxEntries limits extracted entries, DefaultMaxExtractEntries if 0
+	// and unlimited if negative
+	MaxEntries int `json:"maxEntries,omitempty"`
+
+	// Transport performs HTTP requests, DefaultHTTPTransport is used if nil
+	Transport http.RoundTripper `json:"-"`
+	// RemotePolicy checks URLs before Sync, DefaultRemotePolicy is used if nil
+	RemotePolicy RemotePolicy `json:"-"`
+	// Health orders URL and Mirrors by recent failures, the one from
+	// ctx of SyncContext is used if nil
+	Health *RemoteHealth `json:"-"`
+}
+
+// archiveState records the archive content is extracted from
+type archiveState struct {
+	URL          string `json:"url"`
+	Digest       string `json:"digest"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// BasePath implements Repository
+func (r *ArchiveRepo) BasePath() string {
+	return r.Path
+}
+
+// Persist implements Repository
+func (r *ArchiveRepo) Persist() PersistentHandle {
+	encoded, _ := json.Marshal(r)
+	return PersistentHandle{Type: ArchiveRepoType, Opaque: string(encoded)}
+}
+
+// Sync implements RemoteRepo
+func (r *ArchiveRepo) Sync(dir string) error {
+	return r.SyncContext(context.Background(), dir)
+}
+
+// SyncContext implements ContextRemoteRepo, the archive is downloaded
+// only if changed according to ETag or Last-Modified, and extracted
+// only if its digest changed. Download is throttled by the rate limiter
+// in ctx
+func (r *ArchiveRepo) SyncContext(ctx context.Context, dir string) (err error) {
+	ctx, span := startSpan(ctx, SpanArchiveSync)
+	defer func() { span.End(err) }()
+	remotes := append([]string{r.URL}, r.Mirrors...)
+	for _, remote := range remotes {
+		if err = checkRemote(r.RemotePolicy, remote); err != nil {
+			return
+		}
+	}
+	health := r.Health
+	if health == nil {
+		health = RemoteHealthFromContext(ctx)
+	}
+	state, _ := readArchiveState(dir)
+	err = tryRemotes(ctx, health, nil, remotes, func(remote string) error {
+		return r.syncFrom(ctx, dir, remote, state)
+	})
+	return
+}
+
+// VersionAt implements VersionedRemoteRepo, it returns the digest of
+// the archive
+func (r *ArchiveRepo) VersionAt(dir string) (string, error) {
+	state, err := readArchiveState(dir)
+	if err != nil {
+		return "", err
+	}
+	return state.Digest, nil
+}
+
+// RemoteVersion implements ChangeDetector, it's the ETag, or
+// Last-Modified if absent, of URL by a HEAD request
+func (r *ArchiveRepo) RemoteVersion(ctx context.Context) (string, error) {
+	if err := checkRemote(r.RemotePolicy, r.URL); err != nil {
+		return "", err
+	}
+	req, err := r.newRequest(ctx, http.MethodHead, r.URL)
+	if err != nil {
+		return "", err
+	}
+	r.authorize(ctx, req, r.URL)
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &HTTPError{URL: r.URL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return "etag:" + etag, nil
+	}
+	if modified := resp.Header.Get("Last-Modified"); modified != "" {
+		return "last-modified:" + modified, nil
+	}
+	return "", ErrNoRemoteVersion
+}
+
+func (r *ArchiveRepo) client() *http.Client {
+	transport := r.Transport
+	if transport == nil {
+		transport = DefaultHTTPTransport
+	}
+	return &http.Client{Transport: transport}
+}
+
+// newRequest creates a request with UserAgent and Headers
+func (r *ArchiveRepo) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range r.Headers {
+		req.Header.Set(key, value)
+	}
+	userAgent := r.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// authorize adds the credential of the repo environment in ctx to req
+// for remote, mirrors on other hosts must not see the credential of URL
+func (r *ArchiveRepo) authorize(ctx context.Context, req *http.Request, remote string) {
+	env := RepoEnvFromContext(ctx)
+	if env == nil || env.Password == "" || !sameOrigin(remote, r.URL) {
+		return
+	}
+	if env.Username != "" {
+		req.SetBasicAuth(env.Username, env.Password)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+env.Password)
+	}
+}
+
+func (r *ArchiveRepo) format() ArchiveFormat {
+	if r.Format != "" {
+		return r.Format
+	}
+	if u, err := ParseRepoURL(r.URL); err == nil && strings.HasSuffix(strings.ToLower(u.Path), ".zip") {
+		return ArchiveZip
+	}
+	return ArchiveTar
+}
+
+// limits returns extraction limits of the archive
+func (r *ArchiveRepo) limits() *extractLimits {
+	l := &extractLimits{size: r.MaxSize, entries: r.MaxEntries}
+	if l.size == 0 {
+		l.size = DefaultMaxExtractSize
+	}
+	if l.entries == 0 {
+		l.entries = DefaultMaxExtractEntries
+	}
+	return l
+}
+
+// sameOrigin tells if URLs a and b have the same scheme and host
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// syncFrom downloads the archive from remote and extracts it into dir
+func (r *ArchiveRepo) syncFrom(ctx context.Context, dir, remote string, state *archiveState) error {
+	req, err := r.newRequest(ctx, http.MethodGet, remote)
+	if err != nil {
+		return err
+	}
+	r.authorize(ctx, req, remote)
+	if state != nil && state.URL == remote {
+		if state.ETag != "" {
+			req.Header.Set("If-None-Match", state.ETag)
+		}
+		if state.LastModified != "" {
+			req.Header.Set("If-Modified-Since", state.LastModified)
+		}
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	report := syncReportFromContext(ctx)
+	if resp.StatusCode == http.StatusNotModified {
+		report.setAction(SyncUpToDate)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPError{URL: remote, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	parent := filepath.Dir(dir)
+	if err = os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(parent, ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), transferReader(ctx, resp.Body))
+	if err != nil {
+		return err
+	}
+	report.addBytes(size)
+	newState := &archiveState{
+		URL:          remote,
+		Digest:       "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if state != nil && state.Digest == newState.Digest {
+		report.setAction(SyncUpToDate)
+		return writeArchiveState(dir, newState)
+	}
+
+	staging, err := os.MkdirTemp(parent, ".extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err = extractArchive(f, size, r.format(), staging, r.StripComponents, r.limits()); err != nil {
+		return err
+	}
+	if err = writeArchiveState(staging, newState); err != nil {
+		return err
+	}
+	if err = os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err = os.Rename(staging, dir); err != nil {
+		return err
+	}
+	if state != nil {
+		report.setAction(SyncPulled)
+	} else {
+		report.setAction(SyncCloned)
+	}
+	return nil
+}
+
+func readArchiveState(dir string) (*archiveState, error) {
+	state := &archiveState{}
+	if err := loadJSON(filepath.Join(dir, ArchiveStateFile), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func writeArchiveState(dir string, state *archiveState) error {
+	return saveJSON(filepath.Join(dir, ArchiveStateFile), state)
+}
+
+// extractArchive extracts f of size bytes into dir, the leading strip
+// components of entry paths are removed. Entries escaping dir are rejected
+func extractArchive(f *os.File, size int64, format ArchiveFormat, dir string, strip int, limits *extractLimits) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	switch format {
+	case ArchiveTar:
+		return extractTar(f, dir, strip, limits)
+	case ArchiveZip:
+		return extractZip(f, size, dir, strip, limits)
+	}
+	return ErrUnsupportedArchiveFormat
+}
+
+// extractLimits bounds content extracted from an archive, nil and
+// negative limits are unlimited
+type extractLimits struct {
+	size    int64
+	entries int
+}
+
+// entry counts an extracted entry
+func (l *extractLimits) entry() error {
+	if l == nil || l.entries < 0 {
+		return nil
+	}
+	if l.entries == 0 {
+		return ErrArchiveTooLarge
+	}
+	l.entries--
+	return nil
+}
+
+// copy copies content into w counting its size, content isn't trusted
+// to be of the declared size
+func (l *extractLimits) copy(w io.Writer, content io.Reader) error {
+	if l == nil || l.size < 0 {
+		_, err := io.Copy(w, content)
+		return err
+	}
+	n, err := io.Copy(w, io.LimitReader(content, l.size+1))
+	if err != nil {
+		return err
+	}
+	if l.size -= n; l.size < 0 {
+		return ErrArchiveTooLarge
+	}
+	return nil
+}
+
+func extractTar(r io.Reader, dir string, strip int, limits *extractLimits) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	tr := tar.NewReader(r)
+	links := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = extractEntry(dir, hdr.Name, strip, os.ModeDir, "", nil, limits)
+		case tar.TypeReg:
+			err = extractEntry(dir, hdr.Name, strip, hdr.FileInfo().Mode(), "", tr, limits)
+		case tar.TypeSymlink:
+			links = true
+			err = extractEntry(dir, hdr.Name, strip, os.ModeSymlink, hdr.Linkname, nil, limits)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if links {
+		return CheckArchiveLinks(dir)
+	}
+	return nil
+}
+
+func extractZip(r io.ReaderAt, size int64, dir string, strip int, limits *extractLimits) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	links := false
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		rd, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		var link string
+		if mode&os.ModeSymlink != 0 {
+			links = true
+			var target []byte
+			// link targets are short, a huge one would be read in memory
+			if target, err = io.ReadAll(io.LimitReader(rd, 4096)); err == nil {
+				link = string(target)
+			}
+		}
+		if err == nil {
+			err = extractEntry(dir, zf.Name, strip, mode, link, rd, limits)
+		}
+		rd.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if links {
+		return CheckArchiveLinks(dir)
+	}
+	return nil
+}
+
+// extractEntry creates a directory, regular file or symbolic link named
+// name in dir, sanitized by SanitizeArchivePath and CheckArchiveLink
+func extractEntry(dir, name string, strip int, mode os.FileMode, link string, content io.Reader, limits *extractLimits) error {
+	if err := limits.entry(); err != nil {
+		return err
+	}
+	fn, err := SanitizeArchivePath(dir, name, strip)
+	if err != nil || fn == "" {
+		return err
+	}
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(fn, 0755)
+	case mode&os.ModeSymlink != 0:
+		if err = CheckArchiveLink(dir, fn, link); err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(link, fn)
+	case mode.IsRegular():
+		if err = os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return err
+		}
+		// never write through a symbolic link of a previous entry
+		if info, err := os.Lstat(fn); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if err = os.Remove(fn); err != nil {
+				return err
+			}
+		}
+		w, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+		if err != nil {
+			return err
+		}
+		err = limits.copy(w, content)
+		if e := w.Close(); err == nil {
+			err = e
+		}
+		return err
+	}
+	return nil
+}
+
+// ArchiveRepoFactory is the factory to restore an archive repo
+func ArchiveRepoFactory(h PersistentHandle) (Repository, error) {
+	if h.Type != ArchiveRepoType {
+		return nil, nil
+	}
+	r := &ArchiveRepo{}
+	return r, json.Unmarshal([]byte(h.Opaque), r)
+}
@@ -0,0 +1,141 @@
+package gms_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+type archiveTransport []byte
+
+func (t archiveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(t)),
+		Request:    req,
+	}, nil
+}
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	link     string
+}
+
+func tarArchive(t *testing.T, entries ...tarEntry) archiveTransport {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.content)), Linkname: e.link}
+		if e.typeflag == tar.TypeSymlink {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil && hdr.Size > 0 {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveExtractLimits(t *testing.T) {
+	var entries []tarEntry
+	for i := 0; i < 10; i++ {
+		entries = append(entries, tarEntry{name: "f" + strconv.Itoa(i), typeflag: tar.TypeReg, content: string(make([]byte, 100))})
+	}
+	transport := tarArchive(t, entries...)
+	cases := []struct {
+		maxSize    int64
+		maxEntries int
+		err        error
+	}{
+		{999, 0, gms.ErrArchiveTooLarge},
+		{1000, 0, nil},
+		{0, 9, gms.ErrArchiveTooLarge},
+		{0, 10, nil},
+		{-1, -1, nil},
+	}
+	for i, c := range cases {
+		r := &gms.ArchiveRepo{
+			URL:        "https://example.invalid/x.tar",
+			MaxSize:    c.maxSize,
+			MaxEntries: c.maxEntries,
+			Transport:  transport,
+		}
+		if err := r.Sync(filepath.Join(t.TempDir(), "out")); !errors.Is(err, c.err) {
+			t.Errorf("case %d: got %v, want %v", i, err, c.err)
+		}
+	}
+}
+
+func TestArchiveZipSlip(t *testing.T) {
+	cases := map[string][]tarEntry{
+		"parent":   {{name: "../evil", typeflag: tar.TypeReg, content: "x"}},
+		"absolute": {{name: "/tmp/evil", typeflag: tar.TypeReg, content: "x"}},
+		"symlink":  {{name: "link", typeflag: tar.TypeSymlink, link: "../.."}},
+		"through symlink": {
+			{name: "link", typeflag: tar.TypeSymlink, link: "."},
+			{name: "link/../../evil", typeflag: tar.TypeReg, content: "x"},
+		},
+	}
+	for name, entries := range cases {
+		base := t.TempDir()
+		dir := filepath.Join(base, "out")
+		r := &gms.ArchiveRepo{URL: "https://example.invalid/x.tar", Transport: tarArchive(t, entries...)}
+		if err := r.Sync(dir); !errors.Is(err, gms.ErrPathEscapes) {
+			t.Errorf("%s: got %v, want %v", name, err, gms.ErrPathEscapes)
+		}
+		if _, err := os.Lstat(filepath.Join(base, "evil")); err == nil {
+			t.Errorf("%s: extracted outside", name)
+		}
+	}
+}
+
+type headTransport func(req *http.Request) *http.Response
+
+func (t headTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t(req), nil
+}
+
+func TestArchiveRemoteVersionCredential(t *testing.T) {
+	r := &gms.ArchiveRepo{
+		URL: "https://example.invalid/x.tar",
+		Transport: headTransport(func(req *http.Request) *http.Response {
+			resp := &http.Response{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized", Header: http.Header{}, Body: http.NoBody}
+			if req.Header.Get("Authorization") == "Bearer token" {
+				resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+				resp.Header.Set("ETag", `"v1"`)
+			}
+			return resp
+		}),
+	}
+	if _, err := r.RemoteVersion(context.Background()); err == nil {
+		t.Errorf("no credential: unexpected success")
+	}
+	ctx := gms.WithRepoEnv(context.Background(), &gms.RepoEnv{Password: "token"})
+	version, err := r.RemoteVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != `etag:"v1"` {
+		t.Errorf("got %s, want %s", version, `etag:"v1"`)
+	}
+}
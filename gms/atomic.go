@@ -0,0 +1,207 @@
+package gms
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CacheVersionsDir is the name of sub-directory containing synced
+	// versions of repos using AtomicSync
+	CacheVersionsDir = "versions"
+
+	// stagingPrefix prefixes staging directories in versions dir
+	stagingPrefix = ".staging-"
+)
+
+// localDir resolves LocalDir to the version directory it links to, it's
+// LocalDir itself if not a symbolic link
+func (r *CachedRepo) localDir() string {
+	target, err := os.Readlink(r.LocalDir)
+	if err != nil {
+		return r.LocalDir
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(r.LocalDir), target)
+	}
+	return target
+}
+
+// versionIDs lists numeric names of version directories in ascending order
+func (r *CachedRepo) versionIDs() ([]int, error) {
+	entries, err := os.ReadDir(r.VersionsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, entry := range entries {
+		if id, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (r *CachedRepo) versionDir(id int) string {
+	return filepath.Join(r.VersionsDir, strconv.Itoa(id))
+}
+
+// stage copies current content into a new staging directory to sync
+// into. The staging directory doesn't exist if there is no content yet
+func (r *CachedRepo) stage() (string, error) {
+	if err := os.MkdirAll(r.VersionsDir, 0755); err != nil {
+		return "", err
+	}
+	staging := filepath.Join(r.VersionsDir, stagingPrefix+strconv.FormatInt(time.Now().UnixNano(), 10))
+	current := r.localDir()
+	if _, err := os.Stat(current); os.IsNotExist(err) {
+		return staging, nil
+	}
+	if err := copyTree(current, staging); err != nil {
+		removeAll(staging)
+		return "", err
+	}
+	return staging, nil
+}
+
+// copyTree copies everything under src including VCS metadata to dst,
+// using copy-on-write clones if possible
+func copyTree(src, dst string) error {
+	opts := &ExportOptions{}
+	return filepath.WalkDir(src, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, fn)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return exportFile(fn, filepath.Join(dst, rel), fi, opts)
+	})
+}
+
+// swapIn moves staging into versions and points LocalDir to it. The
+// previous content is kept as a version for readers still using it.
+// If symbolic links are not supported, LocalDir is replaced by renaming
+func (r *CachedRepo) swapIn(staging string) error {
+	ids, err := r.versionIDs()
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+
+	// LocalDir is a directory synced in place or by renaming
+	var legacy string
+	if fi, err := os.Lstat(r.LocalDir); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		legacy = r.versionDir(next)
+		next++
+	}
+	final := r.versionDir(next)
+	if err = os.Rename(staging, final); err != nil {
+		return err
+	}
+
+	if err = r.pointTo(final, legacy); err != nil {
+		return err
+	}
+	logf(r.Logger, "sync %s: switched to version %s", r.Name, filepath.Base(final))
+	return nil
+}
+
+// pointTo flips LocalDir to the version directory dir, the content of
+// LocalDir is moved to legacy if it's a directory. If symbolic links are
+// not supported, dir is renamed to LocalDir
+func (r *CachedRepo) pointTo(dir, legacy string) error {
+	link := r.LocalDir + ".link"
+	os.Remove(link)
+	target, err := filepath.Rel(filepath.Dir(r.LocalDir), dir)
+	if err != nil {
+		target = dir
+	}
+	if err = os.MkdirAll(filepath.Dir(r.LocalDir), 0755); err != nil {
+		return err
+	}
+	// directories moved to another parent must be writable
+	if legacy != "" {
+		if err = unlockContent(r.LocalDir); err != nil {
+			return err
+		}
+	}
+	if os.Symlink(target, link) != nil {
+		// no symbolic links, the current version has no id
+		if legacy != "" {
+			if err = os.Rename(r.LocalDir, legacy); err != nil {
+				return err
+			}
+		}
+		if err = unlockContent(dir); err != nil {
+			return err
+		}
+		return os.Rename(dir, r.LocalDir)
+	}
+	if legacy != "" {
+		if err = os.Rename(r.LocalDir, legacy); err != nil {
+			os.Remove(link)
+			return err
+		}
+	}
+	if err = os.Rename(link, r.LocalDir); err != nil {
+		os.Remove(link)
+		return err
+	}
+	return nil
+}
+
+// pruneVersions removes versions except the current one and the keep
+// latest others, and leftover staging directories
+func (r *CachedRepo) pruneVersions(keep int) error {
+	entries, err := os.ReadDir(r.VersionsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), stagingPrefix) {
+			if err = removeAll(filepath.Join(r.VersionsDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	ids, err := r.versionIDs()
+	if err != nil {
+		return err
+	}
+	current := r.localDir()
+	kept := 0
+	for i := len(ids) - 1; i >= 0; i-- {
+		dir := r.versionDir(ids[i])
+		if dir == current {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if err = removeAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gms_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+	"github.com/codingbrain/gms/gms/gmstest"
+)
+
+func TestAtomicSync(t *testing.T) {
+	c := gmstest.TempCache(t)
+	c.AtomicSync = true
+	c.Validators = []gms.Validator{gms.RequireFiles("required.txt")}
+	remote := gmstest.NewFakeRemoteRepo(t.Name(), map[string]string{"required.txt": "v1"})
+	r, err := c.Add("r", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := r.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.SetFiles(map[string]string{"required.txt": "v2"})
+	if err = r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := r.ReadFile("required.txt"); string(data) != "v2" {
+		t.Errorf("after swap: got %q, want %q", data, "v2")
+	}
+	v2, _ := r.Version()
+	if v2 == v1 {
+		t.Errorf("version not updated after swap")
+	}
+
+	remote.SetFiles(map[string]string{"other.txt": "v3"})
+	err = r.Sync()
+	var verr *gms.ValidationError
+	if !errors.As(err, &verr) || !verr.RolledBack {
+		t.Fatalf("got %v, want rolled back ValidationError", err)
+	}
+	if data, _ := r.ReadFile("required.txt"); string(data) != "v2" {
+		t.Errorf("after rollback: got %q, want %q", data, "v2")
+	}
+	if _, err = r.ReadFile("other.txt"); err == nil {
+		t.Errorf("rejected content is visible")
+	}
+	if version, _ := r.Version(); version != v2 {
+		t.Errorf("after rollback: version %s, want %s", version, v2)
+	}
+}
+
+func TestAtomicSyncFailure(t *testing.T) {
+	c := gmstest.TempCache(t)
+	c.AtomicSync = true
+	remote := gmstest.NewFakeRemoteRepo(t.Name(), map[string]string{"a.txt": "v1"})
+	r, err := c.Add("r", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("remote unavailable")
+	remote.SetFiles(map[string]string{"b.txt": "v2"})
+	remote.SetError(failure)
+	if err = r.Sync(); !errors.Is(err, failure) {
+		t.Fatalf("got %v, want %v", err, failure)
+	}
+	if data, _ := r.ReadFile("a.txt"); string(data) != "v1" {
+		t.Errorf("after failure: got %q, want %q", data, "v1")
+	}
+}
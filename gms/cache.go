@@ -1,13 +1,15 @@
 package gms
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
 	"path/filepath"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/codingbrain/clix.go/clix"
-	"github.com/codingbrain/clix.go/conf"
 )
 
 const (
@@ -15,38 +17,120 @@ const (
 	CacheConfFile = "repos.conf"
 	// CacheReposDir is the name of sub-directory containing cached repos
 	CacheReposDir = "repos"
+	// CacheMetaDir is the name of sub-directory containing per-repo metadata
+	CacheMetaDir = "meta"
 )
 
 var (
 	// ErrRepoAlreadyExists indicates repository with the name already exists
 	ErrRepoAlreadyExists = errors.New("repository already exists")
+	// ErrRepoNotFound indicates no repository is found with the name
+	ErrRepoNotFound = errors.New("repository not found")
+	// ErrAliasConflict indicates the alias is already used by another repo
+	ErrAliasConflict = errors.New("alias already used")
 )
 
 // CacheConfig is the format of cache config file
 type CacheConfig struct {
 	Repos map[string]PersistentHandle
+	// Meta is optional cache-level metadata of repos
+	Meta map[string]*RepoMeta `json:",omitempty"`
 }
 
 // RepoCache is a cache of multiple remote repositories
 type RepoCache struct {
-	// BaseDir is root directory of cache
+	// BaseDir is root directory of cache, see DefaultCacheDir
 	BaseDir string
+	// ReposDir is the name of sub-directory of BaseDir containing local
+	// clones, CacheReposDir if empty
+	ReposDir string
+	// Shared lets users of a group share the cache if not nil
+	Shared *SharedCache
+	// Secrets resolves secrets referenced by identities of repos
+	Secrets SecretProvider
+	// ContentPolicy vetoes serving content of repos if not nil
+	ContentPolicy ContentPolicy
+	// Scrubber removes dangerous files from synced content if not nil,
+	// e.g. DefaultScrubber for untrusted remotes
+	Scrubber *Scrubber
+	// Layout places local clones in ReposDir, FlatLayout if nil. Clones
+	// aren't moved when it changes, they're synced again at new paths
+	Layout RepoLayout
+	// Integrity enables recording content manifest on Sync for Verify
+	Integrity bool
+	// AtomicSync swaps in synced content only after it's complete and
+	// validated, see CachedRepo.AtomicSync
+	AtomicSync bool
+	// KeepVersions is the number of previous versions retained with
+	// AtomicSync, see CachedRepo.KeepVersions
+	KeepVersions int
+	// RateLimiter caps download throughput shared by all repos if not nil
+	RateLimiter *RateLimiter
+	// HostLimiter limits syncs per remote host if not nil
+	HostLimiter *HostLimiter
+	// SyncParallel is the max repos SyncAll syncs concurrently, 1 if not
+	// positive
+	SyncParallel int
+	// SyncBudget is the max bytes SyncAll transfers, measured per repo
+	// by SyncResult.BytesTransferred. Repos are not synced once it's used
+	// up, and downloads relayed by gms stop when a sync exceeds what's
+	// left. Unlimited if not positive
+	SyncBudget int64
+	// SealKey is the AES-256 key encrypting content of sealed repos
+	SealKey []byte
+	// UnsealDir is where sealed repos are decrypted to, preferably in
+	// memory, DefaultUnsealDir in /dev/shm is used if empty
+	UnsealDir string
+	// SharedObjects makes git clones borrow objects from stores shared by
+	// repos on the same host and org, see PruneObjects
+	SharedObjects bool
+	// IndexPaths builds path indexes of repos after Sync, see
+	// CachedRepo.PathIndex
+	IndexPaths bool
+	// DetectChanges skips syncing repos with unchanged remotes, see
+	// CachedRepo.DetectChanges
+	DetectChanges bool
+	// CAS deduplicates files across repos and versions by hard linking
+	// them to read-only blobs in CacheCASDir, see PruneCAS
+	CAS bool
+	// ReadOnly makes content of repos read-only after Sync, see
+	// CachedRepo.ReadOnly
+	ReadOnly bool
+	// SyncOnAdd syncs repos added by AddFromURL
+	SyncOnAdd bool
+	// LockFile is updated after SyncAll succeeds if not empty
+	LockFile string
+	// ConfigStore persists the registry of repos, a FileConfigStore in
+	// BaseDir if nil
+	ConfigStore ConfigStore
+	// Logger receives diagnostic messages of the cache and its repos
+	Logger Logger
+	// Metrics receives measurements of the cache and its repos
+	Metrics Metrics
+	// DryRun records actions of Add, Remove, PurgeTrash and syncs
+	// instead of performing them if not nil
+	DryRun *Plan
+	// RemotePolicy checks remotes on Add, Load and Sync,
+	// DefaultRemotePolicy is used if nil
+	RemotePolicy RemotePolicy
+	// PreSync hooks run before Sync of every repo
+	PreSync []SyncHook
+	// PostSync hooks run after successful Sync of every repo
+	PostSync []SyncHook
+	// Validators check content of every repo after Sync
+	Validators []Validator
 
-	repos map[string]*CachedRepo
+	repos        map[string]*CachedRepo
+	detectCache  *DetectCache
+	remoteHealth *RemoteHealth
 }
 
-// Load loads cached repository from file system
+// Load loads cached repository from the config store
 func (c *RepoCache) Load() error {
-	fs := conf.NewFileStore(filepath.Join(c.BaseDir, CacheConfFile))
-	rd, err := fs.Read()
+	cfg, err := c.configStore().LoadConfig()
 	if err != nil {
 		return err
 	}
-	defer rd.Close()
-	var cfg CacheConfig
-	if err = json.NewDecoder(rd).Decode(&cfg); err != nil {
-		return err
-	}
 
 	if c.repos == nil {
 		c.repos = make(map[string]*CachedRepo)
@@ -68,40 +152,76 @@ func (c *RepoCache) Load() error {
 		}
 		if remote, ok := repo.(RemoteRepo); !ok {
 			continue
+		} else if err = checkRemote(c.RemotePolicy, remoteLocation(remote)); err != nil {
+			errs.Add(fmt.Errorf("%s: %w", name, err))
+			continue
 		} else {
-			c.repos[name] = &CachedRepo{
-				Name:     name,
-				Remote:   remote,
-				LocalDir: filepath.Join(c.BaseDir, CacheReposDir, name),
+			cachedRepo := c.newRepo(name, remote)
+			if meta := cfg.Meta[name]; meta != nil {
+				cachedRepo.Meta = *meta
+				c.configureSealing(cachedRepo)
 			}
+			c.repos[name] = cachedRepo
 		}
 	}
+	logf(c.Logger, "cache %s: loaded %d repos", c.BaseDir, len(c.repos))
+	setMetric(c.Metrics, MetricCacheRepos, float64(len(c.repos)), nil)
 	return errs.Aggregate()
 }
 
-// Save flushes in memory changes to file system
+// Save flushes in memory changes to the config store, the whole config
+// is rewritten
 func (c *RepoCache) Save() error {
 	cfg := &CacheConfig{
 		Repos: make(map[string]PersistentHandle),
+		Meta:  make(map[string]*RepoMeta),
 	}
 	for name, repo := range c.repos {
 		cfg.Repos[name] = repo.Persist()
+		if !repo.Meta.IsEmpty() {
+			meta := repo.Meta
+			cfg.Meta[name] = &meta
+		}
 	}
-	encoded, err := json.Marshal(cfg)
-	if err != nil {
+	if err := c.configStore().SaveConfig(cfg); err != nil {
 		return err
 	}
-	fs := conf.NewFileStore(filepath.Join(c.BaseDir, CacheConfFile))
-	if w, err := fs.Write(); err == nil {
-		defer w.Close()
-		if _, err = w.Write(encoded); err != nil {
-			return err
+	logf(c.Logger, "cache %s: saved %d repos", c.BaseDir, len(c.repos))
+	setMetric(c.Metrics, MetricCacheRepos, float64(len(c.repos)), nil)
+	return nil
+}
+
+// saveRepo saves config of repo name, or its removal, alone
+func (c *RepoCache) saveRepo(name string) error {
+	var (
+		h    *PersistentHandle
+		meta *RepoMeta
+	)
+	if repo := c.repos[name]; repo != nil {
+		persisted := repo.Persist()
+		h = &persisted
+		if !repo.Meta.IsEmpty() {
+			copied := repo.Meta
+			meta = &copied
 		}
-		w.Commit(true)
-	} else {
-		return err
 	}
-	return nil
+	return c.configStore().SaveRepo(name, h, meta)
+}
+
+func (c *RepoCache) configStore() ConfigStore {
+	if c.ConfigStore == nil {
+		c.ConfigStore = &FileConfigStore{Dir: c.BaseDir}
+	}
+	return c.ConfigStore
+}
+
+// planSaveRepo records saving config of repo name to DryRun
+func (c *RepoCache) planSaveRepo(name string) {
+	path := CacheConfFile + ":" + name
+	if fs, ok := c.configStore().(*FileConfigStore); ok {
+		path = fs.fragmentFile(name)
+	}
+	c.DryRun.record(PlannedAction{Op: PlanWrite, Path: path})
 }
 
 // Add adds a remote repo as a new cached repo
@@ -109,27 +229,94 @@ func (c *RepoCache) Add(name string, repo RemoteRepo) (*CachedRepo, error) {
 	if r, exists := c.repos[name]; exists {
 		return r, ErrRepoAlreadyExists
 	}
-	cachedRepo := &CachedRepo{
-		Name:     name,
-		Remote:   repo,
-		LocalDir: filepath.Join(c.BaseDir, CacheReposDir, name),
+	if err := checkRemote(c.RemotePolicy, remoteLocation(repo)); err != nil {
+		return nil, err
+	}
+	if g, ok := repo.(*GitRepo); ok {
+		if err := g.CheckOptions(); err != nil {
+			return nil, err
+		}
+	}
+	cachedRepo := c.newRepo(name, repo)
+	if c.DryRun != nil {
+		c.planSaveRepo(name)
+		c.DryRun.record(PlannedAction{Op: PlanWrite, Path: filepath.Join(cachedRepo.MetaDir, StateFile)})
+		return cachedRepo, nil
 	}
 	c.repos[name] = cachedRepo
-	if err := c.Save(); err != nil {
+	if err := c.saveRepo(name); err != nil {
 		delete(c.repos, name)
 		return nil, err
 	}
-	return cachedRepo, nil
+	logf(c.Logger, "cache %s: added %s", c.BaseDir, name)
+	err := cachedRepo.updateState(func(s *SyncState) {
+		*s = SyncState{Created: time.Now()}
+	})
+	if err == nil && c.Shared != nil {
+		err = cachedRepo.share()
+	}
+	return cachedRepo, err
+}
+
+func (c *RepoCache) newRepo(name string, remote RemoteRepo) *CachedRepo {
+	return &CachedRepo{
+		Name:          name,
+		Remote:        remote,
+		LocalDir:      c.localDir(name, remote),
+		MetaDir:       filepath.Join(c.BaseDir, CacheMetaDir, name),
+		VersionsDir:   filepath.Join(c.BaseDir, CacheVersionsDir, name),
+		WorktreesDir:  filepath.Join(c.BaseDir, CacheWorktreesDir, name),
+		ObjectsDir:    c.objectsDir(),
+		CASDir:        c.casDir(),
+		SealKey:       c.SealKey,
+		AtomicSync:    c.AtomicSync,
+		KeepVersions:  c.KeepVersions,
+		RateLimiter:   c.RateLimiter,
+		RemoteHealth:  c.RemoteHealth(),
+		HostLimiter:   c.HostLimiter,
+		DetectChanges: c.DetectChanges,
+		IndexPaths:    c.IndexPaths,
+		ReadOnly:      c.ReadOnly,
+		Shared:        c.Shared != nil,
+		Secrets:       c.Secrets,
+		ContentPolicy: c.ContentPolicy,
+		Scrubber:      c.Scrubber,
+		Integrity:     c.Integrity,
+		Logger:        c.Logger,
+		Metrics:       c.Metrics,
+		DryRun:        c.DryRun,
+		RemotePolicy:  c.RemotePolicy,
+		PreSync:       append([]SyncHook(nil), c.PreSync...),
+		PostSync:      append([]SyncHook(nil), c.PostSync...),
+		Validators:    append([]Validator(nil), c.Validators...),
+	}
 }
 
-// Remove deletes a cached repo
+// Remove deletes a cached repo, the local clone is left on disk
 func (c *RepoCache) Remove(name string) error {
-	if r, exists := c.repos[name]; exists {
-		delete(c.repos, name)
-		if err := c.Save(); err != nil {
-			c.repos[name] = r
-			return err
-		}
+	return c.RemoveWith(name, RemoveOptions{})
+}
+
+// RemoveWith deletes a cached repo and optionally its local clone
+func (c *RepoCache) RemoveWith(name string, opts RemoveOptions) error {
+	r, exists := c.repos[name]
+	if !exists {
+		return nil
+	}
+	if c.DryRun != nil {
+		return c.planRemove(r, opts)
+	}
+	delete(c.repos, name)
+	if err := c.saveRepo(name); err != nil {
+		c.repos[name] = r
+		return err
+	}
+	logf(c.Logger, "cache %s: removed %s", c.BaseDir, name)
+	switch {
+	case opts.Trash:
+		return c.trash(r)
+	case opts.Purge:
+		return c.purge(r)
 	}
 	return nil
 }
@@ -148,3 +335,87 @@ func (c *RepoCache) RepoNames() []string {
 func (c *RepoCache) Find(name string) *CachedRepo {
 	return c.repos[name]
 }
+
+// SyncAll syncs all cached repos in resolution order, it stops
+// when ctx is cancelled and returns aggregated errors
+func (c *RepoCache) SyncAll(ctx context.Context) error {
+	_, err := c.SyncAllResults(ctx)
+	return err
+}
+
+// SyncAllResults syncs all cached repos like SyncAll and returns results
+// of repos attempted, in resolution order
+func (c *RepoCache) SyncAllResults(ctx context.Context) (results []*SyncResult, err error) {
+	ctx, span := startSpan(ctx, SpanSyncAll)
+	defer func() { span.End(err) }()
+	repos := c.ReposOrdered()
+	span.SetAttribute("gms.repos", len(repos))
+	parallel := c.SyncParallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	var (
+		lock        sync.Mutex
+		errs        clix.AggregatedError
+		transferred int64
+		wg          sync.WaitGroup
+	)
+	slots := make(chan struct{}, parallel)
+	budgeted := c.SyncBudget > 0 && c.DryRun == nil
+	attempted := make([]*SyncResult, len(repos))
+	for i, repo := range repos {
+		slots <- struct{}{}
+		lock.Lock()
+		stop := errs.Add(ctx.Err())
+		exceeded := budgeted && transferred >= c.SyncBudget
+		if exceeded {
+			err := fmt.Errorf("%s: %w", repo.Name, ErrBudgetExceeded)
+			errs.Add(err)
+			attempted[i] = &SyncResult{Repo: repo.Name, Started: time.Now(), FilesChanged: -1, Err: err, Error: err.Error()}
+		}
+		lock.Unlock()
+		if stop {
+			<-slots
+			break
+		}
+		if exceeded {
+			<-slots
+			continue
+		}
+		// concurrent syncs started before the budget is used up may
+		// exceed it
+		repoCtx := ctx
+		if budgeted {
+			lock.Lock()
+			repoCtx = withTransferBudget(ctx, c.SyncBudget-transferred)
+			lock.Unlock()
+		}
+		wg.Add(1)
+		go func(i int, repo *CachedRepo) {
+			defer func() { <-slots; wg.Done() }()
+			res, err := repo.SyncWithResult(repoCtx)
+			lock.Lock()
+			defer lock.Unlock()
+			attempted[i] = res
+			errs.Add(err)
+			if res != nil {
+				transferred += res.BytesTransferred
+			}
+		}(i, repo)
+	}
+	wg.Wait()
+	span.SetAttribute("gms.transferred", transferred)
+	for _, res := range attempted {
+		if res != nil {
+			results = append(results, res)
+		}
+	}
+	if err = errs.Aggregate(); err != nil || c.LockFile == "" {
+		return results, err
+	}
+	if c.DryRun != nil {
+		c.DryRun.record(PlannedAction{Op: PlanWrite, Path: c.LockFile})
+		return results, nil
+	}
+	return results, c.WriteLockFile(c.LockFile)
+}
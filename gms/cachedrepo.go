@@ -1,6 +1,10 @@
 package gms
 
-import "path/filepath"
+import (
+	"context"
+	"path/filepath"
+	"time"
+)
 
 // CachedRepo wraps over RemoteRepo to represent a local accessible repository
 type CachedRepo struct {
@@ -10,11 +14,88 @@ type CachedRepo struct {
 	Remote RemoteRepo
 	// LocalDir is local path to clone of remote repository
 	LocalDir string
+	// MetaDir is local path to metadata of this cached repo
+	MetaDir string
+	// VersionsDir is local path to synced versions if AtomicSync is set
+	VersionsDir string
+	// WorktreesDir is local path to worktrees of refs
+	WorktreesDir string
+	// SealedFile is local path to encrypted content if the repo is
+	// sealed, LocalDir is the decrypted copy then
+	SealedFile string
+	// SealKey decrypts SealedFile
+	SealKey []byte
+	// UnsealRoot is created private to the current user before
+	// unsealing into LocalDir, it's in a directory writable by everyone
+	UnsealRoot string
+	// ObjectsDir is local path to object stores shared with other repos,
+	// git clones reference them if not empty
+	ObjectsDir string
+	// AtomicSync syncs into a staging copy which replaces LocalDir only
+	// after it is synced and validated, LocalDir becomes a symbolic link
+	// to the current version under VersionsDir
+	AtomicSync bool
+	// KeepVersions is the number of previous versions retained with
+	// AtomicSync for Rollback, at least 1 is kept for readers
+	KeepVersions int
+	// RateLimiter caps download throughput if not nil and ctx of
+	// SyncContext has none
+	RateLimiter *RateLimiter
+	// RemoteHealth orders the remote and its mirrors if not nil and ctx
+	// of SyncContext has none
+	RemoteHealth *RemoteHealth
+	// HostLimiter limits syncs per remote host if not nil and ctx of
+	// SyncContext has none
+	HostLimiter *HostLimiter
+	// CASDir is the content-addressable store content files are hard
+	// linked to after Sync if not empty
+	CASDir string
+	// IndexPaths builds the path index after Sync, see PathIndex
+	IndexPaths bool
+	// ReadOnly removes write permission of content after Sync, so
+	// accidental writes fail. VCS metadata stays writable
+	ReadOnly bool
+	// Shared checks the current user can write the repo before Sync and
+	// grants the group access to what Sync created, see SharedCache
+	Shared bool
+	// Secrets resolves secrets referenced by Meta.Identity
+	Secrets SecretProvider
+	// ContentPolicy vetoes serving content on walks, exports and reads if
+	// not nil
+	ContentPolicy ContentPolicy
+	// Scrubber removes dangerous files after Sync before content is
+	// swapped in if not nil
+	Scrubber *Scrubber
+	// DetectChanges skips Sync if the remote is a ChangeDetector and its
+	// remote version is the same as the last sync
+	DetectChanges bool
+	// Integrity enables recording content manifest after Sync
+	Integrity bool
+	// Meta is cache-level metadata persisted in cache config
+	Meta RepoMeta
+	// Logger receives diagnostic messages if not nil
+	Logger Logger
+	// Metrics receives sync measurements if not nil
+	Metrics Metrics
+	// DryRun records actions of Sync instead of performing them if not nil
+	DryRun *Plan
+	// RemotePolicy checks the remote before Sync, DefaultRemotePolicy
+	// is used if nil
+	RemotePolicy RemotePolicy
+	// PreSync hooks run before Sync, initialized from RepoCache.PreSync
+	PreSync []SyncHook
+	// PostSync hooks run after successful Sync, initialized from
+	// RepoCache.PostSync
+	PostSync []SyncHook
+	// Validators check content after Sync, initialized from
+	// RepoCache.Validators
+	Validators []Validator
 }
 
-// BasePath implements Repository
+// BasePath implements Repository, it's resolved to the current version
+// with AtomicSync so readers don't see content switched underneath
 func (r *CachedRepo) BasePath() string {
-	return filepath.Join(r.LocalDir, r.Remote.BasePath())
+	return filepath.Join(r.localDir(), r.Remote.BasePath())
 }
 
 // Persist passthrough to remote repo
@@ -24,5 +105,324 @@ func (r *CachedRepo) Persist() PersistentHandle {
 
 // Sync explicitly updates the local cache
 func (r *CachedRepo) Sync() error {
-	return r.Remote.Sync(r.LocalDir)
+	return r.SyncContext(context.Background())
+}
+
+// SyncContext updates the local cache with cancellation
+func (r *CachedRepo) SyncContext(ctx context.Context) error {
+	if r.DryRun != nil {
+		return r.planSync(ctx)
+	}
+	if r.RateLimiter != nil && RateLimiterFromContext(ctx) == nil {
+		ctx = WithRateLimiter(ctx, r.RateLimiter)
+	}
+	if r.RemoteHealth != nil && RemoteHealthFromContext(ctx) == nil {
+		ctx = WithRemoteHealth(ctx, r.RemoteHealth)
+	}
+	if r.HostLimiter != nil && HostLimiterFromContext(ctx) == nil {
+		ctx = WithHostLimiter(ctx, r.HostLimiter)
+	}
+	if r.ObjectsDir != "" {
+		ctx = withSharedObjects(ctx, r.ObjectsDir, r.Name)
+	}
+	started := time.Now()
+	ctx, span := startSpan(ctx, SpanSync)
+	span.SetAttribute("gms.repo", r.Name)
+	labels := Labels{"repo": r.Name}
+	logf(r.Logger, "sync %s: started", r.Name)
+	addMetric(r.Metrics, MetricSyncAttempts, 1, labels)
+	// bytes received are counted by the report instead of walking the clone
+	rep := syncReportFromContext(ctx)
+	if rep == nil && r.Metrics != nil {
+		rep = &syncReport{}
+		ctx = withSyncReport(ctx, rep)
+	}
+	bytesBefore := rep.transferred()
+	err := r.sync(ctx)
+	if e := r.recordSync(started, err); err == nil {
+		err = e
+	}
+	// after recordSync which may create the state file
+	if r.Shared {
+		if e := r.share(); err == nil {
+			err = e
+		}
+	}
+	observeMetric(r.Metrics, MetricSyncSeconds, time.Since(started).Seconds(), labels)
+	if err != nil {
+		logf(r.Logger, "sync %s: failed in %v: %v", r.Name, time.Since(started), err)
+		addMetric(r.Metrics, MetricSyncFailures, 1, labels)
+	} else {
+		logf(r.Logger, "sync %s: done in %v", r.Name, time.Since(started))
+		if received := rep.transferred() - bytesBefore; received > 0 {
+			addMetric(r.Metrics, MetricSyncBytes, float64(received), labels)
+		}
+	}
+	span.End(err)
+	return err
+}
+
+// SyncWithResult updates the local cache like SyncContext and returns
+// what the sync did, the result is returned with the error if failed
+func (r *CachedRepo) SyncWithResult(ctx context.Context) (*SyncResult, error) {
+	res := &SyncResult{Repo: r.Name, Started: time.Now(), FilesChanged: -1}
+	rep := &syncReport{}
+	err := r.SyncContext(withSyncReport(ctx, rep))
+	res.Duration = time.Since(res.Started)
+	if err != nil {
+		res.Err, res.Error = err, err.Error()
+	}
+	if err != nil || r.DryRun != nil {
+		return res, err
+	}
+	res.OldVersion, res.NewVersion = rep.oldVersion, rep.newVersion
+	res.BytesTransferred = rep.bytes
+	old, cur := res.OldVersion, res.NewVersion
+	switch {
+	case rep.action == SyncRecloned:
+		res.Action = rep.action
+	case old == "" && cur != "":
+		res.Action = SyncCloned
+	case old != "" && old == cur:
+		res.Action = SyncUpToDate
+		res.FilesChanged = 0
+	case old != "" && cur != "":
+		res.Action = SyncPulled
+	default:
+		// versions are unknown, e.g. content removed by hooks
+		res.Action = rep.action
+	}
+	if res.Action != SyncUpToDate && old != "" && cur != "" {
+		if changes, err := r.Changes(old); err == nil {
+			res.FilesChanged = len(changes)
+		}
+	}
+	return res, nil
+}
+
+// planSync records actions of sync to DryRun
+func (r *CachedRepo) planSync(ctx context.Context) error {
+	if err := checkRemote(r.RemotePolicy, remoteLocation(r.Remote)); err != nil {
+		return err
+	}
+	if r.Meta.Hooks != nil {
+		r.planHooks(r.Meta.Hooks.PreSync)
+	}
+	// with AtomicSync, the sync is planned on current content which
+	// is copied to staging directory
+	staging := filepath.Join(r.VersionsDir, stagingPrefix+"*")
+	if r.AtomicSync {
+		r.DryRun.record(PlannedAction{Op: PlanCreate, Path: staging})
+	}
+	if pr, ok := r.Remote.(PlanningRemoteRepo); ok {
+		if err := pr.PlanSync(ctx, r.LocalDir, r.DryRun); err != nil {
+			return err
+		}
+	} else {
+		r.DryRun.record(PlannedAction{Op: PlanSync, Path: r.LocalDir})
+	}
+	if r.AtomicSync {
+		r.DryRun.record(PlannedAction{Op: PlanMove, Path: staging, Target: r.VersionsDir})
+		r.DryRun.record(PlannedAction{Op: PlanWrite, Path: r.LocalDir})
+	}
+	if r.Integrity {
+		r.DryRun.record(PlannedAction{Op: PlanWrite, Path: filepath.Join(r.MetaDir, ManifestFile)})
+	}
+	if _, ok := r.Remote.(ChangeListingRepo); !ok {
+		r.DryRun.record(PlannedAction{Op: PlanWrite, Path: filepath.Join(r.MetaDir, SnapshotFile)})
+	}
+	if r.Meta.Hooks != nil {
+		r.planHooks(r.Meta.Hooks.PostSync)
+	}
+	r.DryRun.record(PlannedAction{Op: PlanWrite, Path: filepath.Join(r.MetaDir, StateFile)})
+	return nil
+}
+
+func (r *CachedRepo) sync(ctx context.Context) error {
+	err := checkRemote(r.RemotePolicy, remoteLocation(r.Remote))
+	if err != nil {
+		return err
+	}
+	if r.Shared {
+		if err = r.checkWritable(); err != nil {
+			return err
+		}
+	}
+	if !r.Meta.Identity.IsEmpty() {
+		env, err := r.repoEnv(ctx)
+		if err != nil {
+			return err
+		}
+		defer env.Close()
+		ctx = WithRepoEnv(ctx, env)
+	}
+	release, err := HostLimiterFromContext(ctx).Acquire(ctx, remoteHost(r.Remote))
+	if err != nil {
+		return err
+	}
+	defer release()
+	report := syncReportFromContext(ctx)
+	remoteVersion, unchanged := r.detectChange(ctx)
+	if unchanged {
+		logf(r.Logger, "sync %s: remote unchanged", r.Name)
+		if state, err := r.State(); err == nil {
+			report.setVersions(state.Version, state.Version)
+		}
+		report.setAction(SyncUpToDate)
+		return nil
+	}
+	if err = r.Unseal(); err != nil {
+		return err
+	}
+	var oldVersion string
+	if r.hasHooks() || len(r.Validators) > 0 || report != nil {
+		oldVersion, _ = r.Version()
+	}
+	var ev *SyncEvent
+	if r.hasHooks() {
+		ev = &SyncEvent{Repo: r.Name, Dir: r.LocalDir, OldVersion: oldVersion}
+		if err = r.runPreSync(ctx, ev); err != nil {
+			return err
+		}
+	}
+	if err = r.updateContent(ctx, oldVersion); err != nil {
+		return err
+	}
+	if report != nil {
+		newVersion, _ := r.Version()
+		report.setVersions(oldVersion, newVersion)
+	}
+	if err = r.Seal(); err != nil {
+		return err
+	}
+	if r.DetectChanges {
+		if err = r.updateState(func(s *SyncState) { s.RemoteVersion = remoteVersion }); err != nil {
+			return err
+		}
+	}
+	if ev != nil {
+		ev.NewVersion, _ = r.Version()
+		return r.runPostSync(ctx, ev)
+	}
+	return nil
+}
+
+// updateContent syncs the remote and records derived metadata. Content
+// updated in place is locked exclusively, readers holding RLock are
+// waited for
+func (r *CachedRepo) updateContent(ctx context.Context, oldVersion string) error {
+	if !r.AtomicSync {
+		lock, err := lockContent(ctx, r.contentLockFile(), true)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+	var err error
+	work := r
+	if r.AtomicSync {
+		staged := *r
+		if staged.LocalDir, err = r.stage(); err != nil {
+			return err
+		}
+		defer removeAll(staged.LocalDir)
+		work = &staged
+	} else if r.Scrubber != nil {
+		staged := *r
+		if staged.LocalDir, err = r.stageScrub(); err != nil {
+			return err
+		}
+		defer removeAll(staged.LocalDir)
+		work = &staged
+	}
+	// content changed in place may fail halfway
+	if work == r {
+		if err = r.removePathIndex(); err != nil {
+			return err
+		}
+	}
+	if err = unlockContent(work.LocalDir); err != nil {
+		return err
+	}
+	if r.ReadOnly {
+		defer func() {
+			if err := setReadOnly(r.localDir()); err != nil {
+				logf(r.Logger, "sync %s: read-only: %v", r.Name, err)
+			}
+		}()
+	}
+	// an empty policy restores content synced sparse before
+	if sparse, ok := r.Remote.(SparseRemoteRepo); ok {
+		err = sparse.SyncSparse(ctx, work.LocalDir, r.Meta.Policy)
+	} else {
+		err = SyncRemoteContext(ctx, r.Remote, work.LocalDir)
+	}
+	if err != nil {
+		return err
+	}
+	if r.Scrubber != nil {
+		if err = work.scrub(); err != nil {
+			return err
+		}
+	}
+	if len(r.Validators) > 0 {
+		if err = work.validate(ctx, oldVersion); err != nil {
+			// the index removed above and usage follow content left in place,
+			// rolled back or not
+			if work == r {
+				if ierr := r.updatePathIndex(); ierr != nil {
+					logf(r.Logger, "sync %s: %v", r.Name, ierr)
+				}
+				if ierr := r.recordUsage(); ierr != nil {
+					logf(r.Logger, "sync %s: %v", r.Name, ierr)
+				}
+			}
+			return err
+		}
+	}
+	if r.AtomicSync {
+		if err = r.swapIn(work.LocalDir); err != nil {
+			return err
+		}
+		r.pruneUnused()
+	} else if work != r {
+		if err = r.replaceScrubbed(work.LocalDir); err != nil {
+			return err
+		}
+	}
+	if err = r.dedupe(); err != nil {
+		return err
+	}
+	if r.Integrity {
+		if err = r.RecordManifest(); err != nil {
+			return err
+		}
+	}
+	if err = r.updatePathIndex(); err != nil {
+		return err
+	}
+	if err = r.recordUsage(); err != nil {
+		return err
+	}
+	if _, ok := r.Remote.(ChangeListingRepo); !ok {
+		return r.recordSnapshot()
+	}
+	return nil
+}
+
+// pruneUnused prunes old versions unless readers hold RLock, which may
+// use any of them. They are pruned by a later sync
+func (r *CachedRepo) pruneUnused() {
+	lock, err := tryLockContent(r.contentLockFile(), true)
+	if err == nil && lock == nil {
+		logf(r.Logger, "sync %s: content in use, old versions not pruned", r.Name)
+		return
+	}
+	if err == nil {
+		defer lock.Unlock()
+		err = r.pruneVersions(r.keepVersions())
+	}
+	if err != nil {
+		logf(r.Logger, "sync %s: prune versions: %v", r.Name, err)
+	}
 }
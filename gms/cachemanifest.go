@@ -0,0 +1,263 @@
+package gms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codingbrain/clix.go/clix"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrInvalidCacheManifest indicates the cache manifest is malformed
+	ErrInvalidCacheManifest = errors.New("invalid cache manifest")
+)
+
+// CacheManifest declares the desired repos of a cache, it's read from
+// YAML or JSON
+type CacheManifest struct {
+	Repos []ManifestRepo `json:"repos" yaml:"repos"`
+}
+
+// ManifestRepo declares a repo in CacheManifest
+type ManifestRepo struct {
+	// Name of the cached repo
+	Name string `json:"name" yaml:"name"`
+	// URL of the remote, a git URL or archive URL depending on Type
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Type is the repo type, git or archive, default is git
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Ref is the branch or tag of git repo, overriding #ref in URL
+	Ref string `json:"ref,omitempty" yaml:"ref,omitempty"`
+	// Filter is the partial clone filter of git repo
+	Filter string `json:"filter,omitempty" yaml:"filter,omitempty"`
+	// CloneArgs are extra arguments of git clone
+	CloneArgs []string `json:"cloneArgs,omitempty" yaml:"cloneArgs,omitempty"`
+	// Config is git config of the local clone of git repo
+	Config map[string]string `json:"config,omitempty" yaml:"config,omitempty"`
+	// Strip is StripComponents of archive repo
+	Strip int `json:"strip,omitempty" yaml:"strip,omitempty"`
+	// UserAgent is UserAgent of archive repo
+	UserAgent string `json:"userAgent,omitempty" yaml:"userAgent,omitempty"`
+	// Headers are extra request headers of archive repo
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Version pins the repo to the commit Id or content digest, the
+	// latest content is synced if empty
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Tags replace tags of the cached repo
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// Absent removes the cached repo and its local clone
+	Absent bool `json:"absent,omitempty" yaml:"absent,omitempty"`
+}
+
+// ReadCacheManifest parses a cache manifest from r
+func ReadCacheManifest(r io.Reader) (*CacheManifest, error) {
+	m := &CacheManifest{}
+	if err := yaml.NewDecoder(r).Decode(m); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCacheManifest, err)
+	}
+	names := make(map[string]bool)
+	for _, spec := range m.Repos {
+		switch {
+		case spec.Name == "":
+			return nil, fmt.Errorf("%w: repo without name", ErrInvalidCacheManifest)
+		case names[spec.Name]:
+			return nil, fmt.Errorf("%w: duplicated repo %s", ErrInvalidCacheManifest, spec.Name)
+		case spec.URL == "" && !spec.Absent:
+			return nil, fmt.Errorf("%w: repo %s without url", ErrInvalidCacheManifest, spec.Name)
+		case spec.Type != "" && spec.Type != GitRepoType && spec.Type != ArchiveRepoType:
+			return nil, fmt.Errorf("%w: repo %s: %v", ErrInvalidCacheManifest, spec.Name, ErrUnsupportedRepoType)
+		}
+		names[spec.Name] = true
+	}
+	return m, nil
+}
+
+// Encode writes the manifest in format json or yaml
+func (m *CacheManifest) Encode(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	case FormatYAML:
+		encoded, err := yaml.Marshal(m)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(encoded)
+		return err
+	}
+	return ErrUnsupportedFormat
+}
+
+// Snapshot builds a manifest pinning every cached repo to the version of
+// its current content, applying it elsewhere reproduces the cache. Repos
+// never synced have no content to pin, they're emitted without version
+// and synced to the latest content when applied. Git config which may
+// carry credentials, e.g. http.extraHeader, is left out, it belongs in
+// RepoIdentity of the target cache
+func (c *RepoCache) Snapshot() (*CacheManifest, error) {
+	m := &CacheManifest{}
+	for _, name := range c.RepoNames() {
+		repo := c.repos[name]
+		spec := ManifestRepo{Name: name, Tags: repo.Meta.Tags}
+		switch r := repo.Remote.(type) {
+		case *GitRepo:
+			spec.URL, spec.Ref, spec.Filter, spec.CloneArgs = r.URL, r.Ref, r.Filter, r.CloneArgs
+			spec.Config = r.shareableConfig()
+		case *ArchiveRepo:
+			spec.URL, spec.Type, spec.Strip = r.URL, ArchiveRepoType, r.StripComponents
+			spec.UserAgent, spec.Headers = r.UserAgent, r.Headers
+		default:
+			return nil, fmt.Errorf("%s: %w", name, ErrUnsupportedRepoType)
+		}
+		if err := repo.Unseal(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if _, err := os.Stat(repo.localDir()); os.IsNotExist(err) {
+			logf(c.Logger, "snapshot %s: never synced, version left out", name)
+			m.Repos = append(m.Repos, spec)
+			continue
+		}
+		version, err := repo.Version()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		spec.Version = version
+		m.Repos = append(m.Repos, spec)
+	}
+	return m, nil
+}
+
+// ApplyManifest reads a cache manifest from r and applies it, see Apply
+func (c *RepoCache) ApplyManifest(r io.Reader) error {
+	m, err := ReadCacheManifest(r)
+	if err != nil {
+		return err
+	}
+	return c.Apply(context.Background(), m)
+}
+
+// Apply brings the cache to the state declared by m: missing repos are
+// added, repos with changed URL, type or ref are recloned, repos marked
+// absent are removed with local clones, then declared repos are synced
+// and checked out at pinned versions. Repos not mentioned in m are left
+// untouched, so applying is idempotent
+func (c *RepoCache) Apply(ctx context.Context, m *CacheManifest) error {
+	var errs clix.AggregatedError
+	var synced []*CachedRepo
+	var versions []string
+	for i := range m.Repos {
+		if errs.Add(ctx.Err()) {
+			return errs.Aggregate()
+		}
+		spec := &m.Repos[i]
+		if spec.Absent {
+			if err := c.RemoveWith(spec.Name, RemoveOptions{Purge: true}); err != nil {
+				errs.Add(fmt.Errorf("%s: %w", spec.Name, err))
+			}
+			continue
+		}
+		repo, err := c.applyRepo(ctx, spec)
+		if err != nil {
+			errs.Add(fmt.Errorf("%s: %w", spec.Name, err))
+			continue
+		}
+		synced = append(synced, repo)
+		versions = append(versions, spec.Version)
+	}
+	for i, repo := range synced {
+		if errs.Add(ctx.Err()) {
+			break
+		}
+		if versions[i] == "" {
+			errs.Add(repo.SyncContext(ctx))
+		} else if err := pinVersion(ctx, repo, versions[i]); err != nil {
+			errs.Add(fmt.Errorf("%s: %w", repo.Name, err))
+		}
+	}
+	return errs.Aggregate()
+}
+
+// applyRepo adds or updates the cached repo declared by spec
+func (c *RepoCache) applyRepo(ctx context.Context, spec *ManifestRepo) (*CachedRepo, error) {
+	repo := c.repos[spec.Name]
+	changed := false
+	if repo == nil || !spec.matches(repo.Remote) {
+		remote, err := c.manifestRemote(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		var meta RepoMeta
+		if repo != nil {
+			meta = repo.Meta
+			if err = c.RemoveWith(spec.Name, RemoveOptions{Purge: true}); err != nil {
+				return nil, err
+			}
+		}
+		if repo, err = c.Add(spec.Name, remote); err != nil {
+			return nil, err
+		}
+		repo.Meta, changed = meta, !meta.IsEmpty()
+		c.configureSealing(repo)
+	}
+	if tags := uniqueStrings(spec.Tags); !equalStrings(tags, repo.Meta.Tags) {
+		repo.Meta.Tags, changed = tags, true
+	}
+	if !changed {
+		return repo, nil
+	}
+	if c.DryRun != nil {
+		c.planSaveRepo(repo.Name)
+		return repo, nil
+	}
+	return repo, c.saveRepo(repo.Name)
+}
+
+// matches checks if remote is what spec declares
+func (spec *ManifestRepo) matches(remote RemoteRepo) bool {
+	switch r := remote.(type) {
+	case *GitRepo:
+		if (spec.Type != "" && spec.Type != GitRepoType) || r.URL != spec.URL ||
+			r.Filter != spec.Filter || !equalStrings(r.CloneArgs, spec.CloneArgs) ||
+			!r.matchesGitConfig(spec.Config) {
+			return false
+		}
+		ref := spec.Ref
+		if ref == "" {
+			if u, err := ParseRepoURL(spec.URL); err == nil {
+				ref = u.Ref
+			}
+		}
+		return r.Ref == ref
+	case *ArchiveRepo:
+		return spec.Type == ArchiveRepoType && r.URL == spec.URL && r.StripComponents == spec.Strip &&
+			r.UserAgent == spec.UserAgent && equalStringMaps(r.Headers, spec.Headers)
+	}
+	return false
+}
+
+// manifestRemote creates the remote repo declared by spec, git repos
+// are detected
+func (c *RepoCache) manifestRemote(ctx context.Context, spec *ManifestRepo) (RemoteRepo, error) {
+	if spec.Type == ArchiveRepoType {
+		return &ArchiveRepo{URL: spec.URL, StripComponents: spec.Strip, UserAgent: spec.UserAgent, Headers: spec.Headers}, nil
+	}
+	repo, err := c.detectGitRepo(ctx, spec.URL)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Ref != "" {
+		repo.Ref = spec.Ref
+	}
+	repo.Filter, repo.CloneArgs, repo.Config = spec.Filter, spec.CloneArgs, spec.Config
+	if err = repo.CheckOptions(); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
@@ -0,0 +1,211 @@
+package gms
+
+import "sort"
+
+// RepoMeta is cache-level metadata attached to a cached repo
+type RepoMeta struct {
+	// Aliases are alternative names to find the repo
+	Aliases []string `json:",omitempty"`
+	// Tags are free-form labels for grouping repos
+	Tags []string `json:",omitempty"`
+	// Priority determines resolution order, higher comes first
+	Priority int `json:",omitempty"`
+	// Policy selects paths to sync and walk
+	Policy *PathPolicy `json:",omitempty"`
+	// Hooks are commands run around Sync
+	Hooks *RepoHooks `json:",omitempty"`
+	// Sealed stores content encrypted at rest, see RepoCache.SetSealed
+	Sealed bool `json:",omitempty"`
+	// Identity scopes environment and credentials to the repo
+	Identity *RepoIdentity `json:",omitempty"`
+}
+
+// IsEmpty returns true if no metadata is set
+func (m *RepoMeta) IsEmpty() bool {
+	return len(m.Aliases) == 0 && len(m.Tags) == 0 && m.Priority == 0 &&
+		m.Policy.IsEmpty() && m.Hooks.IsEmpty() && !m.Sealed && m.Identity.IsEmpty()
+}
+
+// HasAlias checks if alias is assigned
+func (m *RepoMeta) HasAlias(alias string) bool {
+	return containsString(m.Aliases, alias)
+}
+
+// HasTag checks if tag is assigned
+func (m *RepoMeta) HasTag(tag string) bool {
+	return containsString(m.Tags, tag)
+}
+
+// AddAlias assigns an alias to a cached repo
+func (c *RepoCache) AddAlias(name, alias string) error {
+	repo := c.repos[name]
+	if repo == nil {
+		return ErrRepoNotFound
+	}
+	if r := c.FindByAlias(alias); r != nil {
+		if r == repo {
+			return nil
+		}
+		return ErrAliasConflict
+	}
+	if _, exists := c.repos[alias]; exists {
+		return ErrAliasConflict
+	}
+	aliases := repo.Meta.Aliases
+	repo.Meta.Aliases = append(append([]string{}, aliases...), alias)
+	if err := c.saveRepo(repo.Name); err != nil {
+		repo.Meta.Aliases = aliases
+		return err
+	}
+	return nil
+}
+
+// RemoveAlias unassigns an alias
+func (c *RepoCache) RemoveAlias(alias string) error {
+	repo := c.FindByAlias(alias)
+	if repo == nil {
+		return nil
+	}
+	aliases := repo.Meta.Aliases
+	repo.Meta.Aliases = removeString(aliases, alias)
+	if err := c.saveRepo(repo.Name); err != nil {
+		repo.Meta.Aliases = aliases
+		return err
+	}
+	return nil
+}
+
+// SetTags replaces tags of a cached repo
+func (c *RepoCache) SetTags(name string, tags ...string) error {
+	repo := c.repos[name]
+	if repo == nil {
+		return ErrRepoNotFound
+	}
+	oldTags := repo.Meta.Tags
+	repo.Meta.Tags = uniqueStrings(tags)
+	if err := c.saveRepo(name); err != nil {
+		repo.Meta.Tags = oldTags
+		return err
+	}
+	return nil
+}
+
+// SetPriority updates priority of a cached repo
+func (c *RepoCache) SetPriority(name string, priority int) error {
+	repo := c.repos[name]
+	if repo == nil {
+		return ErrRepoNotFound
+	}
+	oldPriority := repo.Meta.Priority
+	repo.Meta.Priority = priority
+	if err := c.saveRepo(name); err != nil {
+		repo.Meta.Priority = oldPriority
+		return err
+	}
+	return nil
+}
+
+// ReposOrdered returns cached repos in resolution order:
+// higher priority first, and by name for equal priorities
+func (c *RepoCache) ReposOrdered() []*CachedRepo {
+	repos := make([]*CachedRepo, 0, len(c.repos))
+	for _, repo := range c.repos {
+		repos = append(repos, repo)
+	}
+	sort.Sort(reposByPriority(repos))
+	return repos
+}
+
+// FindByAlias returns a cached repo by alias
+func (c *RepoCache) FindByAlias(alias string) *CachedRepo {
+	for _, repo := range c.repos {
+		if repo.Meta.HasAlias(alias) {
+			return repo
+		}
+	}
+	return nil
+}
+
+// Lookup returns a cached repo by name or alias
+func (c *RepoCache) Lookup(nameOrAlias string) *CachedRepo {
+	if repo := c.repos[nameOrAlias]; repo != nil {
+		return repo
+	}
+	return c.FindByAlias(nameOrAlias)
+}
+
+// ReposWithTag returns names of cached repos with the tag
+func (c *RepoCache) ReposWithTag(tag string) []string {
+	var names []string
+	for name, repo := range c.repos {
+		if repo.Meta.HasTag(tag) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type reposByPriority []*CachedRepo
+
+func (s reposByPriority) Len() int      { return len(s) }
+func (s reposByPriority) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s reposByPriority) Less(i, j int) bool {
+	if s[i].Meta.Priority != s[j].Meta.Priority {
+		return s[i].Meta.Priority > s[j].Meta.Priority
+	}
+	return s[i].Name < s[j].Name
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, str string) []string {
+	result := make([]string, 0, len(list))
+	for _, s := range list {
+		if s != str {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func uniqueStrings(list []string) []string {
+	var result []string
+	for _, s := range list {
+		if !containsString(result, s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStringMaps(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package gms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// CacheCASDir is the name of sub-directory containing file contents
+	// by hash, shared by content of all repos and versions as hard links
+	CacheCASDir = "cas"
+
+	// casExecSuffix distinguishes executable blobs, links share the mode
+	casExecSuffix = ".x"
+)
+
+// casDir returns the directory of the content-addressable store, or
+// empty if CAS is not set
+func (c *RepoCache) casDir() string {
+	if !c.CAS {
+		return ""
+	}
+	return filepath.Join(c.BaseDir, CacheCASDir)
+}
+
+// dedupe replaces regular files of content with hard links to blobs in
+// CASDir, blobs are read-only so content is not modified in place.
+// Files failing to link, e.g. on another file system, are left alone
+func (r *CachedRepo) dedupe() error {
+	if r.CASDir == "" || r.SealedFile != "" {
+		return nil
+	}
+	var linked, failed int
+	err := filepath.WalkDir(r.localDir(), func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if isVCSName(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// linked already, by an earlier sync or exported from a version
+		if linkCount(fi) > 1 {
+			return nil
+		}
+		if err = casLink(r.CASDir, fn, fi); err != nil {
+			failed++
+			logf(r.Logger, "cas %s: %v", r.Name, err)
+			return nil
+		}
+		linked++
+		return nil
+	})
+	logf(r.Logger, "cas %s: linked %d files, %d failed", r.Name, linked, failed)
+	return err
+}
+
+// casLink stores fn as a blob and replaces it with a hard link to the
+// blob if the blob exists
+func casLink(dir, fn string, fi os.FileInfo) error {
+	hash, err := hashFile(fn)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0444)
+	blob := filepath.Join(dir, hash[:2], hash)
+	if fi.Mode().Perm()&0111 != 0 {
+		perm, blob = 0555, blob+casExecSuffix
+	}
+	if err = os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return err
+	}
+	if err = os.Chmod(fn, perm); err != nil {
+		return err
+	}
+	if err = os.Link(fn, blob); err == nil || !os.IsExist(err) {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(fn), ".cas-"+hash)
+	if err = os.Link(blob, tmp); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, fn); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func hashFile(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PruneCAS removes blobs no longer linked from content of any repo or
+// version. It requires link counts, blobs are kept where they are
+// unavailable, e.g. on windows
+func (c *RepoCache) PruneCAS() error {
+	blobs, err := filepath.Glob(filepath.Join(c.BaseDir, CacheCASDir, "*", "*"))
+	if err != nil {
+		return err
+	}
+	var pruned int
+	for _, blob := range blobs {
+		fi, err := os.Lstat(blob)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || linkCount(fi) != 1 {
+			continue
+		}
+		if c.DryRun != nil {
+			c.DryRun.record(PlannedAction{Op: PlanDelete, Path: blob})
+			continue
+		}
+		if err = os.Remove(blob); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		logf(c.Logger, "cas: pruned %d blobs", pruned)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package gms
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkCount returns the number of hard links of fi, 0 if unknown
+func linkCount(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 0
+}
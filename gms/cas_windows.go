@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package gms
+
+import "os"
+
+// linkCount returns 0 as the number of hard links is unknown
+func linkCount(fi os.FileInfo) uint64 {
+	return 0
+}
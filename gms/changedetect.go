@@ -0,0 +1,93 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+var (
+	// ErrNoRemoteVersion indicates the remote can't tell its version
+	// without syncing
+	ErrNoRemoteVersion = errors.New("remote version unavailable")
+)
+
+// ChangeDetector is a remote repository able to tell the version of
+// remote content cheaply, without syncing. The version only needs to
+// change when content changes, it's not comparable with VersionAt.
+// Credentials of the repo environment in ctx are used like by sync
+type ChangeDetector interface {
+	RemoteRepo
+	RemoteVersion(ctx context.Context) (string, error)
+}
+
+// Changed tells if the remote may have changed since the last sync, by
+// comparing its remote version with the one recorded by the last sync.
+// It's true if the remote isn't a ChangeDetector, or content is missing
+// or modified since
+func (r *CachedRepo) Changed() (bool, error) {
+	return r.ChangedContext(context.Background())
+}
+
+// ChangedContext is Changed with ctx, the identity of the repo is used
+// to ask the remote
+func (r *CachedRepo) ChangedContext(ctx context.Context) (bool, error) {
+	detector, ok := r.Remote.(ChangeDetector)
+	if !ok {
+		return true, nil
+	}
+	if err := checkRemote(r.RemotePolicy, remoteLocation(r.Remote)); err != nil {
+		return true, err
+	}
+	if !r.Meta.Identity.IsEmpty() {
+		env, err := r.repoEnv(ctx)
+		if err != nil {
+			return true, err
+		}
+		defer env.Close()
+		ctx = WithRepoEnv(ctx, env)
+	}
+	version, err := detector.RemoteVersion(ctx)
+	if err != nil {
+		return true, err
+	}
+	return r.changedFrom(version)
+}
+
+// changedFrom compares remoteVersion with the one recorded by the last
+// sync
+func (r *CachedRepo) changedFrom(remoteVersion string) (bool, error) {
+	state, err := r.State()
+	if err != nil {
+		return true, err
+	}
+	if state.RemoteVersion == "" || state.RemoteVersion != remoteVersion || state.Unhealthy != "" || state.Dirty != "" {
+		return true, nil
+	}
+	if r.SealedFile != "" {
+		// content is verified by decryption when it's unsealed
+		_, err = os.Stat(r.SealedFile)
+		return err != nil, nil
+	}
+	if _, err = os.Stat(r.localDir()); err != nil {
+		return true, nil
+	}
+	version, err := r.Version()
+	return err != nil || version != state.Version, nil
+}
+
+// detectChange returns the remote version if DetectChanges is enabled
+// and the remote supports it, and tells if the sync can be skipped
+func (r *CachedRepo) detectChange(ctx context.Context) (string, bool) {
+	detector, ok := r.Remote.(ChangeDetector)
+	if !r.DetectChanges || !ok {
+		return "", false
+	}
+	version, err := detector.RemoteVersion(ctx)
+	if err != nil {
+		logf(r.Logger, "sync %s: remote version: %v", r.Name, err)
+		return "", false
+	}
+	changed, err := r.changedFrom(version)
+	return version, err == nil && !changed
+}
@@ -0,0 +1,122 @@
+package gms
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SnapshotFile is the filename of content snapshot of current version
+	SnapshotFile = "snapshot.json"
+	// PrevSnapshotFile is the filename of content snapshot of previous version
+	PrevSnapshotFile = "snapshot.prev.json"
+)
+
+var (
+	// ErrUnknownVersion indicates changes since the version can't be determined
+	ErrUnknownVersion = errors.New("unknown version")
+)
+
+// ChangeKind is the type of a change
+type ChangeKind string
+
+// Kinds of changes
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+)
+
+// Change is a changed path between two versions
+type Change struct {
+	// Path is slash-separated path relative to BasePath
+	Path string
+	// Kind is the type of the change
+	Kind ChangeKind
+}
+
+type changesByPath []Change
+
+func (s changesByPath) Len() int           { return len(s) }
+func (s changesByPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s changesByPath) Less(i, j int) bool { return s[i].Path < s[j].Path }
+
+// Changes lists changed paths since sinceVersion till current synced
+// version. Git repos use git diff, other repos compare content snapshots
+// which are only kept for the previous and current synced version
+func (r *CachedRepo) Changes(sinceVersion string) ([]Change, error) {
+	if cl, ok := r.Remote.(ChangeListingRepo); ok {
+		return cl.ChangesAt(r.LocalDir, sinceVersion)
+	}
+	var current, since ContentManifest
+	if err := loadJSON(filepath.Join(r.MetaDir, SnapshotFile), &current); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUnknownVersion
+		}
+		return nil, err
+	}
+	if current.Version == sinceVersion {
+		return nil, nil
+	}
+	if err := loadJSON(filepath.Join(r.MetaDir, PrevSnapshotFile), &since); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUnknownVersion
+		}
+		return nil, err
+	}
+	if since.Version != sinceVersion {
+		return nil, ErrUnknownVersion
+	}
+	prefix := filepath.ToSlash(r.Remote.BasePath())
+	var changes []Change
+	for _, c := range since.Diff(&current) {
+		if rel, ok := relPathUnder(prefix, c.Path); ok {
+			c.Path = rel
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// recordSnapshot saves content snapshot of the current version, the
+// existing snapshot becomes previous one if the version changed
+func (r *CachedRepo) recordSnapshot() error {
+	version, err := r.Version()
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(r.MetaDir, SnapshotFile)
+	var current ContentManifest
+	if err = loadJSON(fn, &current); err == nil {
+		if current.Version == version {
+			return nil
+		}
+		if err = os.Rename(fn, filepath.Join(r.MetaDir, PrevSnapshotFile)); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	m, err := BuildContentManifest(r.localDir())
+	if err != nil {
+		return err
+	}
+	m.Version = version
+	return saveJSON(fn, m)
+}
+
+// relPathUnder converts slash-separated rel into path relative to prefix,
+// returns false if rel is not under prefix
+func relPathUnder(prefix, rel string) (string, bool) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return rel, true
+	}
+	if !strings.HasPrefix(rel, prefix+"/") {
+		return "", false
+	}
+	return path.Clean(strings.TrimPrefix(rel, prefix+"/")), true
+}
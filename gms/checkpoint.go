@@ -0,0 +1,157 @@
+package gms
+
+import (
+	"strings"
+	"sync"
+)
+
+// WalkCheckpoint records walk progress per repo so interrupted walks
+// can be resumed, it is safe for concurrent use and can be persisted
+// using Save and LoadWalkCheckpoint
+type WalkCheckpoint struct {
+	mu   sync.Mutex
+	last map[string]string
+	done map[string]bool
+	// pruned is set if the last item is a directory skipped by SkipDir
+	pruned map[string]bool
+}
+
+// walkCheckpointData is the persisted format of WalkCheckpoint
+type walkCheckpointData struct {
+	Last   map[string]string `json:"last"`
+	Done   []string          `json:"done,omitempty"`
+	Pruned []string          `json:"pruned,omitempty"`
+}
+
+// LoadWalkCheckpoint loads a checkpoint saved by Save
+func LoadWalkCheckpoint(fn string) (*WalkCheckpoint, error) {
+	var data walkCheckpointData
+	if err := loadJSON(fn, &data); err != nil {
+		return nil, err
+	}
+	cp := &WalkCheckpoint{last: data.Last, done: make(map[string]bool), pruned: make(map[string]bool)}
+	for _, name := range data.Done {
+		cp.done[name] = true
+	}
+	for _, name := range data.Pruned {
+		cp.pruned[name] = true
+	}
+	return cp, nil
+}
+
+// Save persists the checkpoint into file
+func (cp *WalkCheckpoint) Save(fn string) error {
+	cp.mu.Lock()
+	data := walkCheckpointData{Last: make(map[string]string)}
+	for name, rel := range cp.last {
+		data.Last[name] = rel
+	}
+	for name := range cp.done {
+		data.Done = append(data.Done, name)
+	}
+	for name := range cp.pruned {
+		data.Pruned = append(data.Pruned, name)
+	}
+	cp.mu.Unlock()
+	return saveJSON(fn, &data)
+}
+
+// Last returns RelPath of the last item visited in the repo
+func (cp *WalkCheckpoint) Last(name string) string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.last[name]
+}
+
+// lastPruned tells if the last item visited is a directory pruned by
+// SkipDir, its content must not be visited on resume
+func (cp *WalkCheckpoint) lastPruned(name string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.pruned[name]
+}
+
+// IsDone checks if the walk of the repo has completed
+func (cp *WalkCheckpoint) IsDone(name string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.done[name]
+}
+
+// Reset clears progress of the repo, or all repos if name is empty
+func (cp *WalkCheckpoint) Reset(name string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if name == "" {
+		cp.last, cp.done, cp.pruned = nil, nil, nil
+	} else {
+		delete(cp.last, name)
+		delete(cp.done, name)
+		delete(cp.pruned, name)
+	}
+}
+
+func (cp *WalkCheckpoint) record(name, rel string, pruned bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.last == nil {
+		cp.last = make(map[string]string)
+	}
+	cp.last[name] = rel
+	if pruned {
+		if cp.pruned == nil {
+			cp.pruned = make(map[string]bool)
+		}
+		cp.pruned[name] = true
+	} else {
+		delete(cp.pruned, name)
+	}
+}
+
+func (cp *WalkCheckpoint) markDone(name string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.done == nil {
+		cp.done = make(map[string]bool)
+	}
+	cp.done[name] = true
+}
+
+// Actions when resuming from a checkpoint
+const (
+	resumeNone = iota
+	resumePrune
+	resumeDescend
+)
+
+// resumeAction decides how to handle an item before the resume point
+func (w *walk) resumeAction(rel string, isDir bool) int {
+	if w.resumeFrom == "" {
+		return resumeNone
+	}
+	c := compareWalkOrder(rel, w.resumeFrom)
+	if c > 0 {
+		w.resumeFrom = ""
+		return resumeNone
+	}
+	if c == 0 && w.resumePruned {
+		w.resumeFrom = ""
+		return resumePrune
+	}
+	if isDir && (c == 0 || strings.HasPrefix(w.resumeFrom, rel+"/")) {
+		return resumeDescend
+	}
+	return resumePrune
+}
+
+// compareWalkOrder compares slash-separated paths in sorted depth-first
+// walk order
+func compareWalkOrder(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
@@ -0,0 +1,53 @@
+package gms
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ShortCommitIDLen is the length of abbreviated commit Ids
+const ShortCommitIDLen = 7
+
+var (
+	// ErrInvalidSHA indicates a string is not a full commit Id
+	ErrInvalidSHA = errors.New("invalid sha")
+)
+
+// CommitID is a full git commit Id in lower case hex, 40 digits for
+// SHA-1 and 64 for SHA-256 repositories
+type CommitID string
+
+// ParseSHA validates s as a full commit Id, surrounding whitespace,
+// e.g. the newline of git output, is trimmed and upper case is lowered
+func ParseSHA(s string) (CommitID, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) != 40 && len(s) != 64 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSHA, s)
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", fmt.Errorf("%w: %q", ErrInvalidSHA, s)
+		}
+	}
+	return CommitID(s), nil
+}
+
+// String implements fmt.Stringer
+func (id CommitID) String() string {
+	return string(id)
+}
+
+// Short returns the abbreviated commit Id
+func (id CommitID) Short() string {
+	if len(id) > ShortCommitIDLen {
+		return string(id[:ShortCommitIDLen])
+	}
+	return string(id)
+}
+
+// Equal tells if id and other are the same commit, ignoring case and
+// surrounding whitespace of Ids not from ParseSHA
+func (id CommitID) Equal(other CommitID) bool {
+	return id != "" && strings.EqualFold(strings.TrimSpace(string(id)), strings.TrimSpace(string(other)))
+}
@@ -0,0 +1,93 @@
+package gms
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Diff lists paths differing from content of a repository to another,
+// paths are slash-separated relative to BasePath and sorted
+type Diff struct {
+	// Added are files only in the other repository
+	Added []string `json:"added,omitempty"`
+	// Removed are files only in the repository
+	Removed []string `json:"removed,omitempty"`
+	// Changed are files whose content, type or executable bit differ
+	Changed []string `json:"changed,omitempty"`
+}
+
+// IsEmpty tells if the repositories have the same content
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Changes lists the differences as changes ordered by path
+func (d *Diff) Changes() []Change {
+	changes := make([]Change, 0, len(d.Added)+len(d.Removed)+len(d.Changed))
+	for _, fn := range d.Added {
+		changes = append(changes, Change{Path: fn, Kind: ChangeAdded})
+	}
+	for _, fn := range d.Removed {
+		changes = append(changes, Change{Path: fn, Kind: ChangeDeleted})
+	}
+	for _, fn := range d.Changed {
+		changes = append(changes, Change{Path: fn, Kind: ChangeModified})
+	}
+	sort.Sort(changesByPath(changes))
+	return changes
+}
+
+// CompareRepos compares content of a with b by hash, e.g. a local
+// working copy with the cached upstream. VCS metadata is excluded and
+// permissions other than the executable bit are ignored. Path indexes
+// of cached repos are used if available
+func CompareRepos(a, b Repository) (Diff, error) {
+	var d Diff
+	from, err := compareKeys(a)
+	if err != nil {
+		return d, err
+	}
+	to, err := compareKeys(b)
+	if err != nil {
+		return d, err
+	}
+	for fn, key := range to {
+		if expected, ok := from[fn]; !ok {
+			d.Added = append(d.Added, fn)
+		} else if expected != key {
+			d.Changed = append(d.Changed, fn)
+		}
+	}
+	for fn := range from {
+		if _, ok := to[fn]; !ok {
+			d.Removed = append(d.Removed, fn)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d, nil
+}
+
+// compareKeys maps paths of repo to keys of hash, type and executable bit
+func compareKeys(repo Repository) (map[string]string, error) {
+	var m *Manifest
+	if cr, ok := repo.(*CachedRepo); ok {
+		if x, err := cr.PathIndex(); err == nil {
+			m = &x.Manifest
+		}
+	}
+	if m == nil {
+		var err error
+		if m, err = BuildManifest(repo, crypto.SHA256); err != nil {
+			return nil, err
+		}
+	}
+	keys := make(map[string]string, len(m.Entries))
+	for _, entry := range m.Entries {
+		keys[entry.Path] = fmt.Sprintf("%o:%s", uint32(entry.Mode&(os.ModeType|0100)), entry.Hash)
+	}
+	return keys, nil
+}
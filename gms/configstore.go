@@ -0,0 +1,289 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codingbrain/clix.go/conf"
+)
+
+const (
+	// CacheFragmentsDir is the name of sub-directory containing configs of
+	// single repos changed since the cache config was last written
+	CacheFragmentsDir = "repos.d"
+	// CacheConfLockFile is the filename of the lock serializing writers of
+	// cache config and fragments
+	CacheConfLockFile = "repos.conf.lock"
+
+	fragmentExt = ".json"
+)
+
+var (
+	// ErrConfigReadOnly indicates the config store can't be written
+	ErrConfigReadOnly = errors.New("cache config is read-only")
+	// ErrUnsupportedConfigStore indicates no factory for the scheme of a
+	// config store location
+	ErrUnsupportedConfigStore = errors.New("unsupported config store")
+)
+
+// ConfigStore persists the cache config, the registry of repos, which
+// may live elsewhere than the local clones, e.g. in a database shared by
+// a fleet of machines
+type ConfigStore interface {
+	// LoadConfig reads the whole config
+	LoadConfig() (*CacheConfig, error)
+	// SaveConfig replaces the whole config
+	SaveConfig(cfg *CacheConfig) error
+	// SaveRepo updates config of a single repo, it's removed if h is nil
+	SaveRepo(name string, h *PersistentHandle, meta *RepoMeta) error
+}
+
+// ConfigStoreFactories creates config stores by the scheme of location
+// in OpenConfigStore
+var ConfigStoreFactories = map[string]func(location string) (ConfigStore, error){
+	"file": func(location string) (ConfigStore, error) {
+		return &FileConfigStore{Dir: location}, nil
+	},
+	"env": func(location string) (ConfigStore, error) {
+		return &EnvConfigStore{Var: location}, nil
+	},
+}
+
+// OpenConfigStore creates a config store from spec in the form of
+// SCHEME:LOCATION, e.g. env:GMS_REPOS, or a directory of FileConfigStore
+func OpenConfigStore(spec string) (ConfigStore, error) {
+	pos := strings.Index(spec, ":")
+	// a single letter is a drive on windows
+	if pos <= 1 {
+		return &FileConfigStore{Dir: spec}, nil
+	}
+	f := ConfigStoreFactories[spec[:pos]]
+	if f == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedConfigStore, spec[:pos])
+	}
+	return f(spec[pos+1:])
+}
+
+// FileConfigStore keeps the config in CacheConfFile of Dir. SaveRepo
+// writes fragments of single repos in CacheFragmentsDir, which are
+// compacted into the config once there are many of them
+type FileConfigStore struct {
+	// Dir is the directory of config files
+	Dir string
+
+	fragments int
+}
+
+// fragmentsCompactThreshold is the number of fragments written before
+// they are compacted into the cache config
+var fragmentsCompactThreshold = 128
+
+// repoFragment is the config of a single repo, it overrides the repo in
+// cache config until compacted
+type repoFragment struct {
+	Name string
+	// Repo is nil if the repo is removed
+	Repo *PersistentHandle `json:",omitempty"`
+	Meta *RepoMeta         `json:",omitempty"`
+}
+
+// fragmentOf returns the fragment of repo name in cfg
+func fragmentOf(cfg *CacheConfig, name string) *repoFragment {
+	f := &repoFragment{Name: name}
+	if h, ok := cfg.Repos[name]; ok {
+		f.Repo, f.Meta = &h, cfg.Meta[name]
+	}
+	return f
+}
+
+func (s *FileConfigStore) confFile() string {
+	return filepath.Join(s.Dir, CacheConfFile)
+}
+
+func (s *FileConfigStore) fragmentsDir() string {
+	return filepath.Join(s.Dir, CacheFragmentsDir)
+}
+
+func (s *FileConfigStore) fragmentFile(name string) string {
+	return filepath.Join(s.fragmentsDir(), url.QueryEscape(name)+fragmentExt)
+}
+
+func (s *FileConfigStore) lock() (*RepoLock, error) {
+	return lockContent(context.Background(), filepath.Join(s.Dir, CacheConfLockFile), true)
+}
+
+// LoadConfig implements ConfigStore
+func (s *FileConfigStore) LoadConfig() (*CacheConfig, error) {
+	rd, err := conf.NewFileStore(s.confFile()).Read()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	cfg := &CacheConfig{}
+	if err = json.NewDecoder(rd).Decode(cfg); err != nil {
+		return nil, err
+	}
+	fragments, err := s.loadFragments()
+	if err != nil {
+		return nil, err
+	}
+	s.fragments = len(fragments)
+	for _, f := range fragments {
+		f.applyTo(cfg)
+	}
+	return cfg, nil
+}
+
+// SaveConfig implements ConfigStore, fragments same as cfg are removed,
+// others are written since cfg was loaded and kept
+func (s *FileConfigStore) SaveConfig(cfg *CacheConfig) error {
+	lock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if err = saveJSON(s.confFile(), cfg); err != nil {
+		return err
+	}
+	fragments, err := s.loadFragments()
+	if err != nil {
+		return err
+	}
+	s.fragments = 0
+	for fn, f := range fragments {
+		current, err := json.Marshal(fragmentOf(cfg, f.Name))
+		if err != nil {
+			return err
+		}
+		saved, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, saved) {
+			s.fragments++
+		} else if err = os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveRepo implements ConfigStore
+func (s *FileConfigStore) SaveRepo(name string, h *PersistentHandle, meta *RepoMeta) error {
+	encoded, err := json.Marshal(&repoFragment{Name: name, Repo: h, Meta: meta})
+	if err != nil {
+		return err
+	}
+	lock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if err = saveFile(s.fragmentFile(name), encoded); err != nil {
+		return err
+	}
+	if s.fragments++; s.fragments >= fragmentsCompactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact merges all fragments into the config, the lock must be held
+func (s *FileConfigStore) compact() error {
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		return err
+	}
+	fragments, err := s.loadFragments()
+	if err != nil {
+		return err
+	}
+	if err = saveJSON(s.confFile(), cfg); err != nil {
+		return err
+	}
+	for fn := range fragments {
+		if err = os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	s.fragments = 0
+	return nil
+}
+
+// loadFragments reads fragments by filename
+func (s *FileConfigStore) loadFragments() (map[string]*repoFragment, error) {
+	entries, err := os.ReadDir(s.fragmentsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	fragments := make(map[string]*repoFragment)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fragmentExt) {
+			continue
+		}
+		fn := filepath.Join(s.fragmentsDir(), entry.Name())
+		f := &repoFragment{}
+		if err = loadJSON(fn, f); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
+		fragments[fn] = f
+	}
+	return fragments, nil
+}
+
+// applyTo overrides the repo in cfg
+func (f *repoFragment) applyTo(cfg *CacheConfig) {
+	if cfg.Repos == nil {
+		cfg.Repos = make(map[string]PersistentHandle)
+	}
+	if cfg.Meta == nil {
+		cfg.Meta = make(map[string]*RepoMeta)
+	}
+	if f.Repo == nil {
+		delete(cfg.Repos, f.Name)
+	} else {
+		cfg.Repos[f.Name] = *f.Repo
+	}
+	if f.Meta == nil {
+		delete(cfg.Meta, f.Name)
+	} else {
+		cfg.Meta[f.Name] = f.Meta
+	}
+}
+
+// EnvConfigStore reads the config as JSON from an environment variable,
+// e.g. provided by a fleet manager. It's read-only
+type EnvConfigStore struct {
+	// Var is the name of the environment variable
+	Var string
+}
+
+// LoadConfig implements ConfigStore, the config is empty if Var is unset
+func (s *EnvConfigStore) LoadConfig() (*CacheConfig, error) {
+	cfg := &CacheConfig{}
+	if value := os.Getenv(s.Var); value != "" {
+		if err := json.Unmarshal([]byte(value), cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Var, err)
+		}
+	}
+	return cfg, nil
+}
+
+// SaveConfig implements ConfigStore
+func (s *EnvConfigStore) SaveConfig(cfg *CacheConfig) error {
+	return ErrConfigReadOnly
+}
+
+// SaveRepo implements ConfigStore
+func (s *EnvConfigStore) SaveRepo(name string, h *PersistentHandle, meta *RepoMeta) error {
+	return ErrConfigReadOnly
+}
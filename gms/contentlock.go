@@ -0,0 +1,99 @@
+package gms
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// ContentLockFile is the filename of the lock coordinating readers of
+	// content and Sync in meta dir
+	ContentLockFile = "content.lock"
+)
+
+// lockPollInterval is the interval of retrying a held lock
+var lockPollInterval = 50 * time.Millisecond
+
+// RepoLock is a shared or exclusive lock on content of a cached repo,
+// it's effective across processes
+type RepoLock struct {
+	f *os.File
+}
+
+// Unlock releases the lock
+func (l *RepoLock) Unlock() error {
+	err := unlockFile(l.f)
+	if e := l.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// lockContent acquires the lock file fn, waiting until ctx is done
+func lockContent(ctx context.Context, fn string, exclusive bool) (*RepoLock, error) {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return nil, err
+	}
+	// lock files created by other users of a shared cache may only be
+	// readable, which is enough to lock them
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0644)
+	if os.IsPermission(err) {
+		f, err = os.Open(fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for {
+		locked, err := tryLockFile(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			return &RepoLock{f: f}, nil
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// tryLockContent acquires the lock file fn if not held by others
+func tryLockContent(fn string, exclusive bool) (*RepoLock, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lock, err := lockContent(ctx, fn, exclusive)
+	if err == context.Canceled {
+		return nil, nil
+	}
+	return lock, err
+}
+
+func (r *CachedRepo) contentLockFile() string {
+	return filepath.Join(r.MetaDir, ContentLockFile)
+}
+
+// RLock acquires a shared lock on content, Sync waits until it's
+// released before changing content in place or pruning old versions
+func (r *CachedRepo) RLock(ctx context.Context) (*RepoLock, error) {
+	return lockContent(ctx, r.contentLockFile(), false)
+}
+
+// WithContent runs fn with BasePath under a shared lock, the content in
+// dir doesn't change while fn runs
+func (r *CachedRepo) WithContent(ctx context.Context, fn func(dir string) error) error {
+	lock, err := r.RLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if err = r.Unseal(); err != nil {
+		return err
+	}
+	return fn(r.BasePath())
+}
@@ -0,0 +1,226 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrContentDenied indicates a path is vetoed by ContentPolicy
+	ErrContentDenied = errors.New("content denied by policy")
+)
+
+// ContentPolicy vetoes serving files and directories of repos, e.g. keys
+// or embargoed directories. It's enforced centrally on walks, exports and
+// reads of repos carrying one, see RepoCache.ContentPolicy, a denied
+// directory is pruned with everything inside
+type ContentPolicy interface {
+	// Allow decides if rel, slash-separated path relative to BasePath
+	// of repo, may be served. info is nil if rel isn't in current
+	// content, e.g. checked before exporting another version
+	Allow(ctx context.Context, repo, rel string, info fs.FileInfo) (bool, error)
+}
+
+// ContentPolicyFunc adapts a function to ContentPolicy, e.g. to call
+// a policy engine like OPA
+type ContentPolicyFunc func(ctx context.Context, repo, rel string, info fs.FileInfo) (bool, error)
+
+// Allow implements ContentPolicy
+func (f ContentPolicyFunc) Allow(ctx context.Context, repo, rel string, info fs.FileInfo) (bool, error) {
+	return f(ctx, repo, rel, info)
+}
+
+// ContentPolicies allows content allowed by every policy
+type ContentPolicies []ContentPolicy
+
+// Allow implements ContentPolicy
+func (p ContentPolicies) Allow(ctx context.Context, repo, rel string, info fs.FileInfo) (bool, error) {
+	for _, policy := range p {
+		if allowed, err := policy.Allow(ctx, repo, rel, info); err != nil || !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// DenyPatterns denies paths matching any of the patterns in path.Match
+// syntax. Patterns without / match names at any depth, e.g. *.key, others
+// match paths relative to BasePath, e.g. docs/embargoed
+type DenyPatterns []string
+
+// Allow implements ContentPolicy
+func (p DenyPatterns) Allow(ctx context.Context, repo, rel string, info fs.FileInfo) (bool, error) {
+	for _, pattern := range p {
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(rel)
+		}
+		// a malformed pattern denies everything rather than nothing
+		if ok, err := path.Match(pattern, target); ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// contentPolicyRepo is a repository whose content is vetoed by a policy
+type contentPolicyRepo interface {
+	// contentPolicy returns the policy and name of the repo given to it
+	contentPolicy() (ContentPolicy, string)
+}
+
+// contentPolicy implements contentPolicyRepo
+func (r *CachedRepo) contentPolicy() (ContentPolicy, string) {
+	return r.ContentPolicy, r.Name
+}
+
+// contentPolicy implements contentPolicyRepo, the policy of Base applies
+func (r *OverlayRepo) contentPolicy() (ContentPolicy, string) {
+	if pr, ok := r.Base.(contentPolicyRepo); ok {
+		return pr.contentPolicy()
+	}
+	return nil, ""
+}
+
+// contentPolicyFilter creates a walker filter applying policy
+func contentPolicyFilter(policy ContentPolicy, repo string) RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		return policy.Allow(item.Context(), repo, item.RelPath, item.FileInfo)
+	}
+}
+
+// CheckContent checks ContentPolicy allows rel relative to BasePath and
+// its parent directories, ErrContentDenied if not
+func (r *CachedRepo) CheckContent(ctx context.Context, rel string) error {
+	if r.ContentPolicy == nil {
+		return nil
+	}
+	base := r.BasePath()
+	fn, err := SafeJoin(base, rel)
+	if err != nil || fn == base {
+		return err
+	}
+	if rel, err = filepath.Rel(base, fn); err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		info, err := os.Lstat(filepath.Join(base, filepath.FromSlash(prefix)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		allowed, err := r.ContentPolicy.Allow(ctx, r.Name, prefix, info)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return fmt.Errorf("%s: %w", rel, ErrContentDenied)
+		}
+	}
+	return nil
+}
+
+// ContentFilter creates a walker filter applying ContentPolicy to walks
+// of the subtree at rel relative to BasePath, nil if there's no policy
+func (r *CachedRepo) ContentFilter(rel string) RepoWalkerFilter {
+	if r.ContentPolicy == nil {
+		return nil
+	}
+	prefix := strings.Trim(filepath.ToSlash(rel), "/")
+	return func(item *WalkingItem) (bool, error) {
+		return r.ContentPolicy.Allow(item.Context(), r.Name, path.Join(prefix, item.RelPath), item.FileInfo)
+	}
+}
+
+// pruneDenied removes content exported into dir from rel relative to
+// BasePath which is denied by ContentPolicy
+func (r *CachedRepo) pruneDenied(ctx context.Context, dir, rel string) error {
+	return filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil || fn == dir {
+			return err
+		}
+		sub, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		allowed, err := r.ContentPolicy.Allow(ctx, r.Name, path.Join(filepath.ToSlash(rel), filepath.ToSlash(sub)), info)
+		if err != nil || allowed {
+			return err
+		}
+		if err = removeAll(fn); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// allowedEntries filters entries of the path index by ContentPolicy, the
+// index may be built under another policy. A denied directory denies all
+// entries inside
+func (r *CachedRepo) allowedEntries(ctx context.Context, entries []ManifestEntry) ([]ManifestEntry, error) {
+	base := r.BasePath()
+	dirs := map[string]bool{".": true}
+	var allowDir func(dir string) (bool, error)
+	allowDir = func(dir string) (bool, error) {
+		if allowed, ok := dirs[dir]; ok {
+			return allowed, nil
+		}
+		allowed, err := allowDir(path.Dir(dir))
+		if err != nil {
+			return false, err
+		}
+		if allowed {
+			var info fs.FileInfo
+			if fi, err := os.Lstat(filepath.Join(base, filepath.FromSlash(dir))); err == nil {
+				info = fi
+			}
+			if allowed, err = r.ContentPolicy.Allow(ctx, r.Name, dir, info); err != nil {
+				return false, err
+			}
+		}
+		dirs[dir] = allowed
+		return allowed, nil
+	}
+	allowed := make([]ManifestEntry, 0, len(entries))
+	for i := range entries {
+		ok, err := allowDir(path.Dir(entries[i].Path))
+		if err == nil && ok {
+			ok, err = r.ContentPolicy.Allow(ctx, r.Name, entries[i].Path, &entryInfo{entry: &entries[i]})
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			allowed = append(allowed, entries[i])
+		}
+	}
+	return allowed, nil
+}
+
+// entryInfo is FileInfo of a path index entry
+type entryInfo struct {
+	entry *ManifestEntry
+}
+
+func (fi *entryInfo) Name() string       { return path.Base(fi.entry.Path) }
+func (fi *entryInfo) Size() int64        { return fi.entry.Size }
+func (fi *entryInfo) Mode() os.FileMode  { return fi.entry.Mode }
+func (fi *entryInfo) ModTime() time.Time { return time.Time{} }
+func (fi *entryInfo) IsDir() bool        { return false }
+func (fi *entryInfo) Sys() interface{}   { return nil }
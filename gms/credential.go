@@ -0,0 +1,155 @@
+package gms
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrNoCredential indicates credential helpers provided no password
+	ErrNoCredential = errors.New("no credential")
+)
+
+// Redacted replaces secrets in redacted text
+const Redacted = "xxxxx"
+
+// GitCredential is a credential in git credential helper protocol
+type GitCredential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// CredentialFor builds the credential query for a remote URL
+func CredentialFor(remote string) (*GitCredential, error) {
+	u, err := ParseRepoURL(remote)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port != "" {
+		host += ":" + u.Port
+	}
+	return &GitCredential{
+		Protocol: u.Protocol,
+		Host:     host,
+		Path:     strings.TrimPrefix(u.Path, "/"),
+		Username: u.User,
+		Password: u.Password,
+	}, nil
+}
+
+// encode formats the credential as helper input
+func (c *GitCredential) encode() []byte {
+	var b bytes.Buffer
+	for _, attr := range [][2]string{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+	} {
+		if attr[1] != "" {
+			b.WriteString(attr[0] + "=" + attr[1] + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// decode parses helper output
+func (c *GitCredential) decode(out []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		}
+	}
+}
+
+// FillCredential asks configured credential helpers for the credential
+// of remote without prompting the terminal
+func (g *GitCmd) FillCredential(ctx context.Context, remote string) (*GitCredential, error) {
+	cred, err := CredentialFor(remote)
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.credential(ctx, "fill", cred)
+	if err != nil {
+		return nil, err
+	}
+	cred.decode(out)
+	if cred.Password == "" {
+		return nil, ErrNoCredential
+	}
+	return cred, nil
+}
+
+// ApproveCredential tells credential helpers to store cred
+func (g *GitCmd) ApproveCredential(ctx context.Context, cred *GitCredential) error {
+	_, err := g.credential(ctx, "approve", cred)
+	return err
+}
+
+// RejectCredential tells credential helpers to erase cred
+func (g *GitCmd) RejectCredential(ctx context.Context, cred *GitCredential) error {
+	_, err := g.credential(ctx, "reject", cred)
+	return err
+}
+
+// credential runs git credential action, stdout is returned
+func (g *GitCmd) credential(ctx context.Context, action string, cred *GitCredential) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, g.Program, "credential", action)
+	cmd.Env = append(append([]string{}, os.Environ()...), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = bytes.NewReader(cred.encode())
+	var errout bytes.Buffer
+	cmd.Stderr = &errout
+	out, err := cmd.Output()
+	if err != nil {
+		logf(g.Logger, "%s credential %s: failed: %v", g.Program, action, err)
+		return nil, &GitError{Output: errout.String(), Err: err}
+	}
+	logf(g.Logger, "%s credential %s: done", g.Program, action)
+	return out, nil
+}
+
+// urlUserinfoRe matches scheme://userinfo@ in text
+var urlUserinfoRe = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*)://([^/@\s:]*)(:[^/@\s]*)?@`)
+
+// RedactURL replaces secrets of URLs in s: passwords are replaced and
+// usernames without password are replaced unless the scheme is ssh or
+// git, as http tokens are often given as username
+func RedactURL(s string) string {
+	return urlUserinfoRe.ReplaceAllStringFunc(s, func(m string) string {
+		parts := urlUserinfoRe.FindStringSubmatch(m)
+		scheme, user, password := parts[1], parts[2], parts[3]
+		switch {
+		case password != "":
+			return scheme + "://" + user + ":" + Redacted + "@"
+		case strings.EqualFold(scheme, "ssh"), strings.EqualFold(scheme, "git"), strings.HasSuffix(strings.ToLower(scheme), "+ssh"):
+			return m
+		}
+		return scheme + "://" + Redacted + "@"
+	})
+}
@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"errors"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// Client is a client of Daemon
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the daemon of the cache in baseDir
+func Dial(baseDir string) (*Client, error) {
+	return DialAddr("unix", SocketPath(baseDir))
+}
+
+// DialAddr connects to the daemon listening on address
+func DialAddr(network, address string) (*Client, error) {
+	c, err := jsonrpc.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{rpc: c}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+// knownErrors are restored from error messages of the server
+var knownErrors = []error{
+	gms.ErrRepoNotFound,
+	gms.ErrRepoAlreadyExists,
+	gms.ErrUnsupportedRepoType,
+	gms.ErrHashUnavailable,
+	ErrNotRemote,
+	ErrNoWalk,
+}
+
+func (c *Client) call(method string, args interface{}, reply interface{}) error {
+	err := c.rpc.Call(ServiceName+"."+method, args, reply)
+	if serverErr, ok := err.(rpc.ServerError); ok {
+		for _, known := range knownErrors {
+			if string(serverErr) == known.Error() {
+				return known
+			}
+		}
+	}
+	return err
+}
+
+// Add adds a remote repo to the cache
+func (c *Client) Add(name string, repo gms.RemoteRepo) (*Repo, error) {
+	var reply Repo
+	if err := c.call("Add", AddArgs{Name: name, Handle: repo.Persist()}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Remove removes a repo from the cache
+func (c *Client) Remove(name string) error {
+	return c.call("Remove", NameArgs{Name: name}, &Empty{})
+}
+
+// List lists all cached repos ordered by name
+func (c *Client) List() ([]Repo, error) {
+	var reply []Repo
+	err := c.call("List", Empty{}, &reply)
+	return reply, err
+}
+
+// Find finds a repo by name or alias
+func (c *Client) Find(name string) (*Repo, error) {
+	var reply Repo
+	if err := c.call("Find", NameArgs{Name: name}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Sync syncs the named repos, or all repos if none is given
+func (c *Client) Sync(names ...string) error {
+	return c.call("Sync", SyncArgs{Names: names}, &Empty{})
+}
+
+// Walk walks a repo on the daemon and calls fn with each item as
+// batches arrive, returning an error from fn stops the walk
+func (c *Client) Walk(args WalkArgs, fn func(WalkItem) error) error {
+	var id string
+	if err := c.call("Walk", args, &id); err != nil {
+		return err
+	}
+	for {
+		var batch WalkBatch
+		if err := c.call("WalkNext", WalkNextArgs{ID: id}, &batch); err != nil {
+			return err
+		}
+		for _, item := range batch.Items {
+			if err := fn(item); err != nil {
+				if !batch.Done {
+					c.call("WalkClose", WalkNextArgs{ID: id}, &Empty{})
+				}
+				if errors.Is(err, gms.SkipAll) {
+					return nil
+				}
+				return err
+			}
+		}
+		if batch.Done {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Package daemon shares a gms.RepoCache between processes on a host
+// using JSON-RPC over a unix socket
+package daemon
+
+import (
+	"context"
+	"crypto"
+	_ "crypto/sha1"   // for crypto.SHA1
+	_ "crypto/sha256" // for crypto.SHA256
+	_ "crypto/sha512" // for crypto.SHA512
+	"errors"
+	"iter"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codingbrain/clix.go/clix"
+	"github.com/codingbrain/gms/gms"
+)
+
+const (
+	// SocketFile is the filename of daemon socket in cache base dir
+	SocketFile = "gms.sock"
+	// ServiceName is the name of the JSON-RPC service
+	ServiceName = "Cache"
+	// DefaultWalkBatch is the number of items returned by WalkNext
+	DefaultWalkBatch = 256
+	// DefaultWalkTTL is how long a walk cursor is kept without WalkNext
+	DefaultWalkTTL = 5 * time.Minute
+)
+
+var (
+	// ErrNotRemote indicates the handle is not a remote repository
+	ErrNotRemote = errors.New("not a remote repository")
+	// ErrNoWalk indicates the walk cursor doesn't exist
+	ErrNoWalk = errors.New("walk not found")
+)
+
+// SocketPath returns the default socket path of the cache
+func SocketPath(baseDir string) string {
+	return filepath.Join(baseDir, SocketFile)
+}
+
+// Daemon serves a loaded RepoCache
+type Daemon struct {
+	// Cache is the loaded cache to serve
+	Cache *gms.RepoCache
+	// WalkTTL releases walk cursors idle for longer, e.g. of clients
+	// gone away, DefaultWalkTTL if zero
+	WalkTTL time.Duration
+}
+
+// ListenAndServe serves on the unix socket in cache base dir,
+// a stale socket file is removed. The socket is only accessible by the
+// owner
+func (d *Daemon) ListenAndServe() error {
+	fn := SocketPath(d.Cache.BaseDir)
+	if conn, err := net.Dial("unix", fn); err == nil {
+		conn.Close()
+		return &net.OpError{Op: "listen", Net: "unix", Err: os.ErrExist}
+	}
+	os.Remove(fn)
+	l, err := net.Listen("unix", fn)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if err = os.Chmod(fn, 0600); err != nil {
+		return err
+	}
+	return d.Serve(l)
+}
+
+// Serve accepts connections on l until it is closed
+func (d *Daemon) Serve(l net.Listener) error {
+	server := rpc.NewServer()
+	ttl := d.WalkTTL
+	if ttl <= 0 {
+		ttl = DefaultWalkTTL
+	}
+	if err := server.RegisterName(ServiceName, &Service{cache: d.Cache, walks: make(map[string]*walkCursor), walkTTL: ttl}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Repo describes a cached repo
+type Repo struct {
+	Name     string
+	Handle   gms.PersistentHandle
+	LocalDir string
+	Meta     gms.RepoMeta
+}
+
+// AddArgs are arguments of Add
+type AddArgs struct {
+	Name   string
+	Handle gms.PersistentHandle
+}
+
+// NameArgs are arguments of calls on a single repo
+type NameArgs struct {
+	Name string
+}
+
+// SyncArgs are arguments of Sync
+type SyncArgs struct {
+	// Names are repos to sync, all repos if empty
+	Names []string
+}
+
+// WalkArgs are arguments of Walk
+type WalkArgs struct {
+	Name string
+	// Globs selects items using gms.FilterGlob if not empty
+	Globs []string
+	// Hash is the name of hash algorithm for WalkItem.Hash, e.g. sha256
+	Hash string
+}
+
+// WalkNextArgs are arguments of WalkNext
+type WalkNextArgs struct {
+	ID string
+	// Max is the maximum number of items, DefaultWalkBatch if zero
+	Max int
+}
+
+// WalkItem is an item of a walk
+type WalkItem struct {
+	RelPath string
+	Dir     bool
+	Size    int64
+	Mode    os.FileMode
+	Hash    string `json:",omitempty"`
+}
+
+// WalkBatch is a batch of walk items
+type WalkBatch struct {
+	Items []WalkItem
+	// Done is set when the walk finished and the cursor is released
+	Done bool
+}
+
+// Empty is the placeholder of empty arguments or reply
+type Empty struct{}
+
+// Service is the JSON-RPC service, methods are called as Cache.<Method>
+type Service struct {
+	cache *gms.RepoCache
+	// lock guards the cache and repos while they are synced, syncLock
+	// serializes syncs so reads are only blocked by the repo syncing
+	lock     sync.Mutex
+	syncLock sync.Mutex
+
+	walkLock sync.Mutex
+	walks    map[string]*walkCursor
+	walkSeq  int
+	walkTTL  time.Duration
+}
+
+type walkCursor struct {
+	lock  sync.Mutex
+	next  func() (gms.WalkingItem, error, bool)
+	stop  func()
+	timer *time.Timer
+	// err is returned by WalkNext after the items before it
+	err    error
+	closed bool
+}
+
+// close stops the walk, the cursor must be locked
+func (c *walkCursor) close() {
+	if !c.closed {
+		c.closed = true
+		c.timer.Stop()
+		c.stop()
+	}
+}
+
+// hashAlgos are hash algorithms accepted by Walk
+var hashAlgos = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha256": crypto.SHA256,
+	"sha512": crypto.SHA512,
+}
+
+func repoOf(r *gms.CachedRepo) Repo {
+	return Repo{Name: r.Name, Handle: r.Persist(), LocalDir: r.LocalDir, Meta: r.Meta}
+}
+
+// Add adds a remote repo to the cache
+func (s *Service) Add(args AddArgs, reply *Repo) error {
+	f := gms.RepoFactories[args.Handle.Type]
+	if f == nil {
+		return gms.ErrUnsupportedRepoType
+	}
+	repo, err := f(args.Handle)
+	if err != nil {
+		return err
+	}
+	remote, ok := repo.(gms.RemoteRepo)
+	if !ok {
+		return ErrNotRemote
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, err := s.cache.Add(args.Name, remote)
+	if err != nil {
+		return err
+	}
+	*reply = repoOf(r)
+	return nil
+}
+
+// Remove removes a repo from the cache
+func (s *Service) Remove(args NameArgs, reply *Empty) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cache.Remove(args.Name)
+}
+
+// List lists all cached repos ordered by name
+func (s *Service) List(args Empty, reply *[]Repo) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	repos := []Repo{}
+	for _, name := range s.cache.RepoNames() {
+		repos = append(repos, repoOf(s.cache.Find(name)))
+	}
+	*reply = repos
+	return nil
+}
+
+// Find finds a repo by name or alias
+func (s *Service) Find(args NameArgs, reply *Repo) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := s.cache.Lookup(args.Name)
+	if r == nil {
+		return gms.ErrRepoNotFound
+	}
+	*reply = repoOf(r)
+	return nil
+}
+
+// Sync syncs repos and returns aggregated errors
+func (s *Service) Sync(args SyncArgs, reply *Empty) error {
+	s.syncLock.Lock()
+	defer s.syncLock.Unlock()
+	if len(args.Names) == 0 {
+		s.lock.Lock()
+		repos := s.cache.ReposOrdered()
+		s.lock.Unlock()
+		for _, r := range repos {
+			args.Names = append(args.Names, r.Name)
+		}
+	}
+	var errs clix.AggregatedError
+	for _, name := range args.Names {
+		errs.Add(s.syncRepo(name))
+	}
+	return errs.Aggregate()
+}
+
+// syncRepo syncs a repo under lock, it may be removed otherwise
+func (s *Service) syncRepo(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r := s.cache.Lookup(name)
+	if r == nil {
+		return gms.ErrRepoNotFound
+	}
+	return r.Sync()
+}
+
+// Walk starts a walk of a repo and returns the cursor ID for WalkNext
+func (s *Service) Walk(args WalkArgs, reply *string) error {
+	s.lock.Lock()
+	r := s.cache.Lookup(args.Name)
+	s.lock.Unlock()
+	if r == nil {
+		return gms.ErrRepoNotFound
+	}
+	w := &gms.RepoWalker{Sorted: true}
+	w.Use(gms.FilterVCS())
+	if len(args.Globs) > 0 {
+		w.Use(gms.FilterGlob(args.Globs...))
+	}
+	if args.Hash != "" {
+		algo, ok := hashAlgos[args.Hash]
+		if !ok {
+			return gms.ErrHashUnavailable
+		}
+		w.Hash = algo
+	}
+	cursor := &walkCursor{}
+	cursor.next, cursor.stop = iter.Pull2(w.Items(context.Background(), r.Name, r))
+
+	s.walkLock.Lock()
+	defer s.walkLock.Unlock()
+	s.walkSeq++
+	id := strconv.Itoa(s.walkSeq)
+	cursor.timer = time.AfterFunc(s.walkTTL, func() { s.closeWalk(id) })
+	s.walks[id] = cursor
+	*reply = id
+	return nil
+}
+
+// WalkNext returns the next batch of walk items, an error of the walk
+// is returned by the call after the items before it
+func (s *Service) WalkNext(args WalkNextArgs, reply *WalkBatch) error {
+	s.walkLock.Lock()
+	cursor := s.walks[args.ID]
+	s.walkLock.Unlock()
+	if cursor == nil {
+		return ErrNoWalk
+	}
+	max := args.Max
+	if max <= 0 {
+		max = DefaultWalkBatch
+	}
+	cursor.lock.Lock()
+	defer cursor.lock.Unlock()
+	// released by WalkClose or expired meanwhile
+	if cursor.closed {
+		return ErrNoWalk
+	}
+	// idle time doesn't include hashing the batch
+	cursor.timer.Stop()
+	if cursor.err != nil {
+		s.releaseWalk(args.ID, cursor)
+		reply.Done = true
+		return cursor.err
+	}
+	for len(reply.Items) < max {
+		item, err, ok := cursor.next()
+		if err != nil && len(reply.Items) > 0 {
+			cursor.err = err
+			break
+		}
+		if !ok || err != nil {
+			s.releaseWalk(args.ID, cursor)
+			reply.Done = true
+			return err
+		}
+		reply.Items = append(reply.Items, WalkItem{
+			RelPath: item.RelPath,
+			Dir:     item.FileInfo.IsDir(),
+			Size:    item.FileInfo.Size(),
+			Mode:    item.FileInfo.Mode(),
+			Hash:    item.Hash,
+		})
+	}
+	cursor.timer.Reset(s.walkTTL)
+	return nil
+}
+
+// WalkClose releases a walk cursor before it is done
+func (s *Service) WalkClose(args WalkNextArgs, reply *Empty) error {
+	s.closeWalk(args.ID)
+	return nil
+}
+
+// closeWalk releases a walk cursor unless it's gone
+func (s *Service) closeWalk(id string) {
+	s.walkLock.Lock()
+	cursor := s.walks[id]
+	s.walkLock.Unlock()
+	if cursor != nil {
+		cursor.lock.Lock()
+		defer cursor.lock.Unlock()
+		s.releaseWalk(id, cursor)
+	}
+}
+
+// releaseWalk forgets and closes a locked cursor
+func (s *Service) releaseWalk(id string, cursor *walkCursor) {
+	s.walkLock.Lock()
+	delete(s.walks, id)
+	s.walkLock.Unlock()
+	cursor.close()
+}
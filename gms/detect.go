@@ -0,0 +1,164 @@
+package gms
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DetectMode controls how GitRepo.Detect finds the repository in URL
+type DetectMode int
+
+const (
+	// DetectProbe probes the remote with ls-remote
+	DetectProbe DetectMode = iota
+	// DetectPreferKnown matches KnownRepoPatterns first and probes
+	// the remote only if none matches
+	DetectPreferKnown
+	// DetectOffline never contacts the remote: KnownRepoPatterns are
+	// matched, local paths are checked on file system, otherwise the whole
+	// path is taken as repository. Invalid URLs fail on first Sync
+	DetectOffline
+)
+
+// DetectPolicy picks the repository among valid prefixes of URL path
+type DetectPolicy int
+
+const (
+	// DetectShortest picks the shortest valid prefix
+	DetectShortest DetectPolicy = iota
+	// DetectLongest picks the longest valid prefix, e.g. for nested
+	// repositories served under another repository's path
+	DetectLongest
+)
+
+// DefaultDetectParallel is the default max concurrent ls-remote probes
+var DefaultDetectParallel = 4
+
+// KnownRepoPatterns are host/path patterns of repositories on well-known
+// hosts, each path segment is matched using path.Match
+var KnownRepoPatterns = []string{
+	"github.com/*/*",
+	"gitlab.com/*/*",
+	"bitbucket.org/*/*",
+}
+
+// matchKnownRepo splits path into repository and sub-path using
+// KnownRepoPatterns, path is relative or absolute slash-separated
+func matchKnownRepo(host, repoPath string) (repo, sub string, ok bool) {
+	leading := ""
+	if strings.HasPrefix(repoPath, "/") {
+		leading, repoPath = "/", repoPath[1:]
+	}
+	segs := strings.Split(repoPath, "/")
+	for _, pattern := range KnownRepoPatterns {
+		patSegs := strings.Split(pattern, "/")
+		if len(patSegs) < 2 || len(segs) < len(patSegs)-1 {
+			continue
+		}
+		if matched, _ := path.Match(patSegs[0], host); !matched {
+			continue
+		}
+		n := len(patSegs) - 1
+		i := 0
+		for ; i < n; i++ {
+			if matched, _ := path.Match(patSegs[i+1], segs[i]); !matched {
+				break
+			}
+		}
+		if i < n {
+			continue
+		}
+		repo = leading + strings.Join(segs[:n], "/")
+		if n < len(segs) {
+			sub = "/" + strings.Join(segs[n:], "/")
+		}
+		return repo, sub, true
+	}
+	return "", "", false
+}
+
+// detectKnown uses KnownRepoPatterns, it defaults to https for URLs
+// without scheme
+func (r *GitRepo) detectKnown(u *RepoURL) bool {
+	if u.Protocol == "file" {
+		return false
+	}
+	repo, sub, ok := matchKnownRepo(u.Host, u.Path)
+	if !ok {
+		return false
+	}
+	if u.Protocol == "" {
+		u = u.WithProtocol("https")
+	}
+	r.setDetected(u, repo, sub)
+	return true
+}
+
+// detectOffline finds the repository without contacting the remote
+func (r *GitRepo) detectOffline(u *RepoURL) error {
+	if r.detectKnown(u) {
+		return nil
+	}
+	if u.Protocol == "file" {
+		if repo, sub, ok := findLocalGitDir(u); ok {
+			r.setDetected(u, repo, sub)
+			return nil
+		}
+	}
+	if u.Protocol == "" {
+		u = u.WithProtocol("https")
+	}
+	r.setDetected(u, u.Path, "")
+	return nil
+}
+
+// findLocalGitDir finds the first directory along the path being a
+// repository with .git or a bare repository
+func findLocalGitDir(u *RepoURL) (repo, sub string, ok bool) {
+	if u.Host != "" {
+		return "", "", false
+	}
+	base := ""
+	rest := u.Path
+	for rest != "" {
+		pos := strings.Index(rest, "/")
+		if pos == 0 {
+			base, rest = base+"/", rest[1:]
+			continue
+		} else if pos > 0 {
+			base, rest = base+rest[:pos], rest[pos:]
+		} else {
+			base, rest = base+rest, ""
+		}
+		dir := filepath.FromSlash(base)
+		if strings.HasPrefix(base, "/") && len(base) > 2 && base[2] == ':' {
+			// /C:/path
+			dir = filepath.FromSlash(base[1:])
+		}
+		if isLocalGitDir(dir) {
+			return base, rest, true
+		}
+	}
+	return "", "", false
+}
+
+func isLocalGitDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true
+	}
+	if info, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil && !info.IsDir() {
+		_, err = os.Stat(filepath.Join(dir, "objects"))
+		return err == nil
+	}
+	return false
+}
+
+// setDetected fills fields calculated from URL
+func (r *GitRepo) setDetected(u *RepoURL, repo, sub string) {
+	r.Protocol = u.Protocol
+	r.RepoName = repo
+	r.Path = sub
+	r.Remote = u.Prefix() + repo
+}
@@ -0,0 +1,212 @@
+package gms
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DetectCacheFile is the filename of detect cache in cache dir
+	DetectCacheFile = "detect.json"
+)
+
+// DefaultDetectTTL is used if DetectCache.TTL is zero
+var DefaultDetectTTL = 24 * time.Hour
+
+// DefaultDetectFailedTTL is used if DetectCache.FailedTTL is zero
+var DefaultDetectFailedTTL = time.Minute
+
+// DetectCache persists Detect results keyed by URL, DetectMode and
+// DetectPolicy, and ls-remote probes keyed by remote. It's safe for
+// concurrent use
+type DetectCache struct {
+	// Path is the file persisting the cache, nothing is persisted if empty
+	Path string
+	// TTL is how long entries are valid
+	TTL time.Duration
+	// FailedTTL is how long failed probes are valid, short so a remote
+	// created or reachable again is found soon
+	FailedTTL time.Duration
+
+	lock   sync.Mutex
+	loaded bool
+	data   detectCacheData
+}
+
+type detectCacheData struct {
+	Repos  map[string]*detectedRepo `json:"repos,omitempty"`
+	Probes map[string]*probeResult  `json:"probes,omitempty"`
+}
+
+// detectedRepo is the result of Detect
+type detectedRepo struct {
+	URL      string    `json:"url"`
+	Protocol string    `json:"protocol"`
+	RepoName string    `json:"name"`
+	Remote   string    `json:"remote"`
+	Path     string    `json:"path"`
+	Branch   string    `json:"branch,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// probeResult tells if ls-remote succeeded on a remote
+type probeResult struct {
+	OK   bool      `json:"ok"`
+	Time time.Time `json:"time"`
+}
+
+// DetectCache returns the detect cache persisted in BaseDir
+func (c *RepoCache) DetectCache() *DetectCache {
+	if c.detectCache == nil {
+		c.detectCache = &DetectCache{Path: filepath.Join(c.BaseDir, DetectCacheFile)}
+	}
+	return c.detectCache
+}
+
+func (c *DetectCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return DefaultDetectTTL
+}
+
+func (c *DetectCache) expired(t time.Time) bool {
+	return time.Since(t) > c.ttl()
+}
+
+// probeExpired tells if p is no longer valid, failures expire after
+// FailedTTL
+func (c *DetectCache) probeExpired(p *probeResult) bool {
+	if p.OK {
+		return c.expired(p.Time)
+	}
+	ttl := c.FailedTTL
+	if ttl <= 0 {
+		ttl = DefaultDetectFailedTTL
+	}
+	return time.Since(p.Time) > ttl
+}
+
+// detectKey is the key of Detect result of r, which differs by mode and
+// policy for the same URL
+func detectKey(r *GitRepo) string {
+	return fmt.Sprintf("%d/%d/%s", r.DetectMode, r.DetectPolicy, r.URL)
+}
+
+// load reads the persisted cache once, must be called with lock held
+func (c *DetectCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	if c.Path != "" {
+		if err := loadJSON(c.Path, &c.data); err != nil && !os.IsNotExist(err) {
+			c.data = detectCacheData{}
+		}
+	}
+	if c.data.Repos == nil {
+		c.data.Repos = make(map[string]*detectedRepo)
+	}
+	if c.data.Probes == nil {
+		c.data.Probes = make(map[string]*probeResult)
+	}
+}
+
+// save persists unexpired entries, must be called with lock held
+func (c *DetectCache) save() error {
+	for key, d := range c.data.Repos {
+		if c.expired(d.Time) {
+			delete(c.data.Repos, key)
+		}
+	}
+	for remote, p := range c.data.Probes {
+		if c.probeExpired(p) {
+			delete(c.data.Probes, remote)
+		}
+	}
+	if c.Path == "" {
+		return nil
+	}
+	return saveJSON(c.Path, &c.data)
+}
+
+// lookup fills r with cached Detect result of r.URL
+func (c *DetectCache) lookup(r *GitRepo) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.load()
+	d := c.data.Repos[detectKey(r)]
+	if d == nil || c.expired(d.Time) {
+		return false
+	}
+	r.Protocol, r.RepoName, r.Remote, r.Path = d.Protocol, d.RepoName, d.Remote, d.Path
+	if r.Ref == "" {
+		r.Branch = d.Branch
+	}
+	return true
+}
+
+// store saves Detect result of r
+func (c *DetectCache) store(r *GitRepo) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.load()
+	c.data.Repos[detectKey(r)] = &detectedRepo{
+		URL:      r.URL,
+		Protocol: r.Protocol,
+		RepoName: r.RepoName,
+		Remote:   r.Remote,
+		Path:     r.Path,
+		Branch:   r.Branch,
+		Time:     time.Now(),
+	}
+	return c.save()
+}
+
+// probe returns memoized result of ls-remote on remote
+func (c *DetectCache) probe(remote string) (ok, found bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.load()
+	p := c.data.Probes[remote]
+	if p == nil || c.probeExpired(p) {
+		return false, false
+	}
+	return p.OK, true
+}
+
+// storeProbe memoizes result of ls-remote in memory, it's persisted
+// with the next store
+func (c *DetectCache) storeProbe(remote string, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.load()
+	c.data.Probes[remote] = &probeResult{OK: ok, Time: time.Now()}
+}
+
+// Invalidate removes cached results of url and failed probes, or all
+// results if url is empty
+func (c *DetectCache) Invalidate(url string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.load()
+	if url == "" {
+		c.data.Repos = make(map[string]*detectedRepo)
+		c.data.Probes = make(map[string]*probeResult)
+	} else {
+		for key, d := range c.data.Repos {
+			if d.URL == url {
+				delete(c.data.Repos, key)
+			}
+		}
+		for remote, p := range c.data.Probes {
+			if !p.OK {
+				delete(c.data.Probes, remote)
+			}
+		}
+	}
+	return c.save()
+}
@@ -0,0 +1,130 @@
+package gms
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Kinds of planned actions
+const (
+	PlanExec   = "exec"
+	PlanCreate = "create"
+	PlanWrite  = "write"
+	PlanMove   = "move"
+	PlanDelete = "delete"
+	PlanSync   = "sync"
+)
+
+// PlannedAction is an action a dry run would perform
+type PlannedAction struct {
+	// Op is one of exec, create, write, move, delete or sync
+	Op string `json:"op"`
+	// Path is the affected path
+	Path string `json:"path,omitempty"`
+	// Target is the destination of move
+	Target string `json:"target,omitempty"`
+	// Args is the command line of exec
+	Args []string `json:"args,omitempty"`
+}
+
+// String formats the action with credentials in URLs redacted
+func (a PlannedAction) String() string {
+	switch a.Op {
+	case PlanExec:
+		return RedactURL(a.Op + " " + strings.Join(a.Args, " "))
+	case PlanMove:
+		return a.Op + " " + a.Path + " -> " + a.Target
+	}
+	return a.Op + " " + a.Path
+}
+
+// Plan collects actions of dry runs, it's safe for concurrent use
+type Plan struct {
+	lock    sync.Mutex
+	actions []PlannedAction
+}
+
+// Actions returns recorded actions in order
+func (p *Plan) Actions() []PlannedAction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]PlannedAction(nil), p.actions...)
+}
+
+// String formats one action per line
+func (p *Plan) String() string {
+	var lines []string
+	for _, a := range p.Actions() {
+		lines = append(lines, a.String()+"\n")
+	}
+	return strings.Join(lines, "")
+}
+
+func (p *Plan) record(a PlannedAction) {
+	p.lock.Lock()
+	p.actions = append(p.actions, a)
+	p.lock.Unlock()
+}
+
+// PlanningRemoteRepo is a remote repository able to plan Sync, it
+// records mutating actions to plan and only runs read-only queries
+type PlanningRemoteRepo interface {
+	RemoteRepo
+	PlanSync(ctx context.Context, dir string, plan *Plan) error
+}
+
+// planGitClient runs read-only git commands and records others
+type planGitClient struct {
+	client GitClient
+	plan   *Plan
+}
+
+func (c *planGitClient) Exec(args ...string) (string, *GitError) {
+	return c.ExecContext(context.Background(), args...)
+}
+
+func (c *planGitClient) ExecContext(ctx context.Context, args ...string) (string, *GitError) {
+	if isReadOnlyGitCommand(args) {
+		return GitClientWithContext(ctx, c.client).Exec(args...)
+	}
+	c.plan.record(PlannedAction{Op: PlanExec, Args: append([]string{"git"}, args...)})
+	return "", nil
+}
+
+// readOnlyGitCommands never modify the working tree or repository
+var readOnlyGitCommands = map[string]bool{
+	"log":          true,
+	"rev-parse":    true,
+	"ls-remote":    true,
+	"ls-files":     true,
+	"status":       true,
+	"diff":         true,
+	"show":         true,
+	"symbolic-ref": true,
+	"cat-file":     true,
+}
+
+// isReadOnlyGitCommand skips global options and checks the subcommand
+func isReadOnlyGitCommand(args []string) bool {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-C" || arg == "-c":
+			i++
+		case strings.HasPrefix(arg, "-"):
+		default:
+			rest := args[i+1:]
+			switch arg {
+			case "branch":
+				return containsString(rest, "--show-current")
+			case "tag":
+				return len(rest) == 0 || containsString(rest, "-l") || containsString(rest, "--list")
+			case "config":
+				return containsString(rest, "--get") || containsString(rest, "--get-all") || containsString(rest, "--list")
+			}
+			return readOnlyGitCommands[arg]
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package gms
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PathExportingRepo is a remote repository able to extract a subtree of
+// any version from content synced into dir without checking it out
+type PathExportingRepo interface {
+	RemoteRepo
+	ExportPathAt(dir, ref, subpath, dst string) error
+}
+
+// ExportOptions controls how repository content is materialized
+type ExportOptions struct {
+	// NoReflink disables copy-on-write clones of files
+	NoReflink bool
+	// Hardlink allows hard links when copy-on-write is unavailable,
+	// exported files share content with the cache and must not be
+	// modified in place
+	Hardlink bool
+	// Filters are additional walker filters selecting content
+	Filters []RepoWalkerFilter
+}
+
+// ExportTo materializes content under BasePath into dir,
+// VCS metadata is not exported. Files are copied unless opts.Hardlink,
+// with CASDir hard links share read-only blobs of the store
+func (r *CachedRepo) ExportTo(dir string, opts ExportOptions) error {
+	if err := r.Unseal(); err != nil {
+		return err
+	}
+	return ExportRepo(r, dir, opts)
+}
+
+// ExportPath extracts subpath relative to BasePath at ref into dst from
+// the local clone without checking it out, a directory is extracted as
+// dst itself and a file is extracted into dst. Current content is
+// exported if ref is empty and the remote can't export paths.
+// ContentPolicy applies to subpath and exported content
+func (r *CachedRepo) ExportPath(ref, subpath, dst string) error {
+	if err := r.Unseal(); err != nil {
+		return err
+	}
+	if err := r.CheckContent(context.Background(), subpath); err != nil {
+		return err
+	}
+	if pr, ok := r.Remote.(PathExportingRepo); ok {
+		if err := pr.ExportPathAt(r.localDir(), ref, subpath, dst); err != nil || r.ContentPolicy == nil {
+			return err
+		}
+		return r.pruneDenied(context.Background(), dst, subpath)
+	}
+	if ref != "" {
+		return ErrNotPinnable
+	}
+	src, err := SafeJoin(r.BasePath(), subpath)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if err = os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		return exportFile(src, filepath.Join(dst, fi.Name()), fi, &ExportOptions{})
+	}
+	opts := ExportOptions{}
+	if filter := r.ContentFilter(subpath); filter != nil {
+		opts.Filters = append(opts.Filters, filter)
+	}
+	return ExportRepo(&LocalRepo{BaseDir: src}, dst, opts)
+}
+
+// ExportRepo materializes content of a repository into dir
+func ExportRepo(repo Repository, dir string, opts ExportOptions) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	w := &RepoWalker{
+		WalkerFn: func(item WalkingItem) error {
+			src := filepath.Join(item.Path, item.Name)
+			dst := filepath.Join(dir, filepath.FromSlash(item.RelPath))
+			return exportFile(src, dst, item.FileInfo, &opts)
+		},
+	}
+	w.Use(FilterVCS())
+	w.Use(opts.Filters...)
+	return w.Visit("", repo)
+}
+
+func exportFile(src, dst string, fi os.FileInfo, opts *ExportOptions) error {
+	switch {
+	case fi.IsDir():
+		// copies of read-only content stay writable to be populated
+		return os.MkdirAll(dst, fi.Mode().Perm()|0200)
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case !fi.Mode().IsRegular():
+		return nil
+	}
+	if !opts.NoReflink && reflinkFile(src, dst, fi.Mode().Perm()) == nil {
+		return nil
+	}
+	if opts.Hardlink && os.Link(src, dst) == nil {
+		return nil
+	}
+	return copyFile(src, dst, fi.Mode().Perm())
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
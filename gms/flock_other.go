@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly,!windows
+
+package gms
+
+import (
+	"os"
+)
+
+// tryLockFile doesn't lock on this platform
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+// unlockFile doesn't lock on this platform
+func unlockFile(f *os.File) error {
+	return nil
+}
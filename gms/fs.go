@@ -0,0 +1,82 @@
+package gms
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// FSRepoType is the type name of fs.FS based repo, which can't be restored
+	FSRepoType = "fs"
+)
+
+// FSRepository is a repository whose content is accessed using fs.FS
+// instead of local file system, BasePath is a path inside the fs.FS
+type FSRepository interface {
+	Repository
+	FS() fs.FS
+}
+
+// FSRepo adapts a fs.FS (e.g. embed.FS, fstest.MapFS) as a repository
+type FSRepo struct {
+	// Root is the file system of the repository
+	Root fs.FS
+	// Path is slash-separated path inside Root
+	Path string
+}
+
+// BasePath implements Repository
+func (r *FSRepo) BasePath() string {
+	return r.Path
+}
+
+// Persist implements Repository, the handle can't be used to restore
+func (r *FSRepo) Persist() PersistentHandle {
+	return PersistentHandle{Type: FSRepoType}
+}
+
+// FS implements FSRepository
+func (r *FSRepo) FS() fs.FS {
+	return r.Root
+}
+
+// FS returns a file system rooted at BasePath of the repository,
+// the result also implements fs.ReadDirFS, fs.ReadFileFS and fs.StatFS
+func FS(repo Repository) fs.FS {
+	if fr, ok := repo.(FSRepository); ok {
+		root := strings.Trim(path.Clean("/"+repo.BasePath()), "/")
+		if root == "" {
+			return &repoFS{fsys: fr.FS()}
+		}
+		// root is cleaned so fs.Sub never fails
+		sub, _ := fs.Sub(fr.FS(), root)
+		return &repoFS{fsys: sub}
+	}
+	return &repoFS{fsys: os.DirFS(repo.BasePath())}
+}
+
+type repoFS struct {
+	fsys fs.FS
+}
+
+// Open implements fs.FS
+func (f *repoFS) Open(name string) (fs.File, error) {
+	return f.fsys.Open(name)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (f *repoFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.fsys, name)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (f *repoFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, name)
+}
+
+// Stat implements fs.StatFS
+func (f *repoFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, name)
+}
@@ -0,0 +1,65 @@
+// Package fsnotifygms implements gms.FileWatcher using fsnotify
+package fsnotifygms
+
+import (
+	"github.com/codingbrain/gms/gms"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher implements gms.FileWatcher using a fsnotify.Watcher
+type Watcher struct {
+	w      *fsnotify.Watcher
+	events chan gms.FileEvent
+	done   chan struct{}
+}
+
+// Install makes gms.CachedRepo.WatchLocal use fsnotify
+func Install() {
+	gms.NewFileWatcher = func() (gms.FileWatcher, error) {
+		return New()
+	}
+}
+
+// New creates a watcher
+func New() (*Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	watcher := &Watcher{w: w, events: make(chan gms.FileEvent), done: make(chan struct{})}
+	go watcher.forward()
+	return watcher, nil
+}
+
+// forward converts events until the fsnotify watcher is closed
+func (w *Watcher) forward() {
+	defer close(w.events)
+	for event := range w.w.Events {
+		select {
+		case w.events <- gms.FileEvent{Path: event.Name, Op: event.Op.String()}:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Add implements gms.FileWatcher
+func (w *Watcher) Add(dir string) error {
+	return w.w.Add(dir)
+}
+
+// Events implements gms.FileWatcher
+func (w *Watcher) Events() <-chan gms.FileEvent {
+	return w.events
+}
+
+// Errors implements gms.FileWatcher
+func (w *Watcher) Errors() <-chan error {
+	return w.w.Errors
+}
+
+// Close implements gms.FileWatcher
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.w.Close()
+}
@@ -2,11 +2,20 @@ package gms
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -22,6 +31,10 @@ var (
 
 	// ErrInvalidGitURL indicates no git respository is detected with the URL
 	ErrInvalidGitURL = errors.New("invalid git url")
+	// ErrNothingToCommit indicates no changes are staged for commit
+	ErrNothingToCommit = errors.New("nothing to commit")
+	// ErrNoHistory indicates no commit is found for the path
+	ErrNoHistory = errors.New("no history")
 )
 
 // GitError represents the error of git client
@@ -32,8 +45,14 @@ type GitError struct {
 	Err error
 }
 
+// Error implements error, credentials in URLs are redacted
 func (e *GitError) Error() string {
-	return e.Err.Error() + ":\n" + e.Output
+	return RedactURL(e.Err.Error() + ":\n" + e.Output)
+}
+
+// Unwrap returns Err, e.g. ErrBudgetExceeded of a transfer
+func (e *GitError) Unwrap() error {
+	return e.Err
 }
 
 // GitClient is abstaction of functions from git
@@ -41,69 +60,488 @@ type GitClient interface {
 	Exec(args ...string) (string, *GitError)
 }
 
+// ContextGitClient is a GitClient supporting cancellation
+type ContextGitClient interface {
+	GitClient
+	ExecContext(ctx context.Context, args ...string) (string, *GitError)
+}
+
+// StreamingGitClient is a GitClient able to write stdout to a writer
+// instead of buffering it in memory
+type StreamingGitClient interface {
+	GitClient
+	ExecStream(ctx context.Context, w io.Writer, args ...string) *GitError
+}
+
+// ExecStream runs git with stdout written to w, the output is buffered
+// if client doesn't implement StreamingGitClient
+func ExecStream(ctx context.Context, client GitClient, w io.Writer, args ...string) error {
+	if sc, ok := client.(StreamingGitClient); ok {
+		return gitErr(sc.ExecStream(ctx, w, args...))
+	}
+	out, err := GitClientWithContext(ctx, client).Exec(args...)
+	if err != nil {
+		return err
+	}
+	_, werr := io.WriteString(w, out)
+	return werr
+}
+
+// ExecReader runs git in background and returns its stdout, the error
+// of git is returned by Read. Close stops reading and waits for git to
+// exit, returning its error
+func ExecReader(ctx context.Context, client GitClient, args ...string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	r := &execReader{PipeReader: pr, done: make(chan error, 1)}
+	go func() {
+		err := ExecStream(ctx, client, pw, args...)
+		pw.CloseWithError(err)
+		r.done <- err
+	}()
+	return r
+}
+
+type execReader struct {
+	*io.PipeReader
+	done chan error
+}
+
+func (r *execReader) Close() error {
+	r.PipeReader.Close()
+	return <-r.done
+}
+
+// GitClientWithContext binds ctx to client, commands are killed on
+// cancellation if client implements ContextGitClient, otherwise
+// cancellation is only checked before commands start
+func GitClientWithContext(ctx context.Context, client GitClient) GitClient {
+	return &ctxGitClient{ctx: ctx, client: client}
+}
+
+type ctxGitClient struct {
+	ctx    context.Context
+	client GitClient
+}
+
+// ExecStream implements StreamingGitClient, the command is killed when
+// either the bound ctx or ctx is done
+func (c *ctxGitClient) ExecStream(ctx context.Context, w io.Writer, args ...string) *GitError {
+	if err := c.ctx.Err(); err != nil {
+		return &GitError{Err: err}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := ExecStream(ctx, c.client, w, args...); err != nil {
+		if gerr, ok := err.(*GitError); ok {
+			return gerr
+		}
+		return &GitError{Err: err}
+	}
+	return nil
+}
+
+func (c *ctxGitClient) Exec(args ...string) (string, *GitError) {
+	if err := c.ctx.Err(); err != nil {
+		return "", &GitError{Err: err}
+	}
+	if cc, ok := c.client.(ContextGitClient); ok {
+		return cc.ExecContext(c.ctx, args...)
+	}
+	return c.client.Exec(args...)
+}
+
+// gitErr converts *GitError to error without producing non-nil
+// interface holding nil pointer
+func gitErr(err *GitError) error {
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
 // GitCmd implements GitClient using git command
 type GitCmd struct {
 	// Program is path to git command, default is "git"
 	Program string
+	// Logger receives command lines and durations if not nil
+	Logger Logger
+	// SSH controls host key verification of ssh remotes if not nil
+	SSH *SSHOptions
+	// Config is git config of every command like git -c, e.g.
+	// SharedGitConfig
+	Config map[string]string
+}
+
+// env returns environment variables of git commands, http(s) transfers
+// go through proxy if not nil
+func (g *GitCmd) env(ctx context.Context, proxy *transferProxy) ([]string, error) {
+	sshEnv, err := g.SSH.env()
+	if err != nil {
+		return nil, err
+	}
+	env := append(append([]string{}, os.Environ()...), sshEnv...)
+	repoEnv := RepoEnvFromContext(ctx)
+	env = append(env, repoEnv.environ()...)
+	if repoEnv != nil && repoEnv.SSHKeyFile != "" {
+		sshCommand := os.Getenv("GIT_SSH_COMMAND")
+		if g.SSH != nil {
+			sshCommand = g.SSH.SSHCommand()
+		}
+		env = append(env, "GIT_SSH_COMMAND="+repoEnv.sshCommand(sshCommand))
+	}
+	var config []string
+	for _, key := range sortedKeys(g.Config) {
+		config = append(config, key+"="+g.Config[key])
+	}
+	if repoEnv != nil {
+		config = append(config, repoEnv.GitConfig...)
+	}
+	if proxy != nil {
+		config = append(config, "http.proxy="+proxy.URL())
+	}
+	if len(config) == 0 {
+		return env, nil
+	}
+	// appended to config of GIT_CONFIG_COUNT in the environment if any
+	count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	for _, kv := range config {
+		key, value, _ := strings.Cut(kv, "=")
+		env = append(env,
+			"GIT_CONFIG_KEY_"+strconv.Itoa(count)+"="+key,
+			"GIT_CONFIG_VALUE_"+strconv.Itoa(count)+"="+value)
+		count++
+	}
+	return append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(count)), nil
 }
 
 // Exec implements GitClient
 func (g *GitCmd) Exec(args ...string) (string, *GitError) {
-	cmd := exec.Command(g.Program, args...)
-	cmd.Env = append([]string{}, os.Environ()...)
+	return g.ExecContext(context.Background(), args...)
+}
+
+// ExecContext implements ContextGitClient
+func (g *GitCmd) ExecContext(ctx context.Context, args ...string) (string, *GitError) {
+	proxy, err := startTransferProxy(ctx)
+	if err != nil {
+		return "", &GitError{Err: err}
+	}
+	if proxy != nil {
+		defer proxy.Close()
+	}
+	env, err := g.env(ctx, proxy)
+	if err != nil {
+		return "", &GitError{Err: err}
+	}
+	cmd := exec.CommandContext(ctx, g.Program, args...)
+	cmd.Env = env
 	var errout bytes.Buffer
 	cmd.Stderr = &errout
+	started := time.Now()
 	out, err := cmd.Output()
+	stderr := collapseProgress(errout.String())
+	syncReportFromContext(ctx).addBytes(parseTransferred(stderr))
 	if err != nil {
-		return string(out), &GitError{Output: errout.String(), Err: err}
+		logf(g.Logger, "%s %s: failed in %v: %v", g.Program, strings.Join(args, " "), time.Since(started), err)
+		return string(out), &GitError{Output: stderr, Err: proxy.cause(err)}
 	}
+	logf(g.Logger, "%s %s: done in %v", g.Program, strings.Join(args, " "), time.Since(started))
 	return string(out), nil
 }
 
-// GitWorkTree wraps GitClient with working tree and git dir
+// ExecStream implements StreamingGitClient
+func (g *GitCmd) ExecStream(ctx context.Context, w io.Writer, args ...string) *GitError {
+	proxy, err := startTransferProxy(ctx)
+	if err != nil {
+		return &GitError{Err: err}
+	}
+	if proxy != nil {
+		defer proxy.Close()
+	}
+	env, err := g.env(ctx, proxy)
+	if err != nil {
+		return &GitError{Err: err}
+	}
+	cmd := exec.CommandContext(ctx, g.Program, args...)
+	cmd.Env = env
+	var errout bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &errout
+	started := time.Now()
+	err = cmd.Run()
+	stderr := collapseProgress(errout.String())
+	syncReportFromContext(ctx).addBytes(parseTransferred(stderr))
+	if err != nil {
+		logf(g.Logger, "%s %s: failed in %v: %v", g.Program, strings.Join(args, " "), time.Since(started), err)
+		return &GitError{Output: stderr, Err: proxy.cause(err)}
+	}
+	logf(g.Logger, "%s %s: done in %v", g.Program, strings.Join(args, " "), time.Since(started))
+	return nil
+}
+
+// GitWorkTree wraps GitClient with working tree and git dir, it's safe
+// for concurrent use: commands changing the working tree or repository
+// are serialized on the same WorkDir within the process
 type GitWorkTree struct {
 	Client  GitClient
 	WorkDir string
 	GitDir  string
+
+	// locked is set when the caller holds the lock of WorkDir
+	locked bool
+}
+
+// workDirLocks are mutexes keyed by absolute WorkDir
+var workDirLocks sync.Map
+
+// lockWorkDir locks dir within the process and returns the unlock func
+func lockWorkDir(dir string) func() {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	m, _ := workDirLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lock locks WorkDir unless the caller holds the lock already
+func (g *GitWorkTree) lock() func() {
+	if g.locked {
+		return func() {}
+	}
+	return lockWorkDir(g.WorkDir)
+}
+
+// execLocked runs a command changing the working tree with WorkDir locked
+func (g *GitWorkTree) execLocked(args ...string) error {
+	defer g.lock()()
+	_, err := g.Exec(args...)
+	return gitErr(err)
 }
 
 // Exec implements GitClient
 func (g *GitWorkTree) Exec(args ...string) (string, *GitError) {
+	return g.ExecContext(context.Background(), args...)
+}
+
+// ExecContext implements ContextGitClient
+func (g *GitWorkTree) ExecContext(ctx context.Context, args ...string) (string, *GitError) {
+	return GitClientWithContext(ctx, g.Client).Exec(append(g.argv(), args...)...)
+}
+
+// ExecStream implements StreamingGitClient
+func (g *GitWorkTree) ExecStream(ctx context.Context, w io.Writer, args ...string) *GitError {
+	if err := ExecStream(ctx, g.Client, w, append(g.argv(), args...)...); err != nil {
+		if gerr, ok := err.(*GitError); ok {
+			return gerr
+		}
+		return &GitError{Err: err}
+	}
+	return nil
+}
+
+// argv returns arguments selecting WorkDir and GitDir
+func (g *GitWorkTree) argv() []string {
 	if g.WorkDir == "" {
 		panic("WorkDir is required")
 	}
-	argv := []string{}
 	if g.GitDir != "" {
-		argv = append(argv, "--work-tree="+g.WorkDir, "--git-dir="+g.GitDir)
-	} else {
-		argv = append(argv, "-C", g.WorkDir)
+		return []string{"--work-tree=" + filepath.Clean(g.WorkDir), "--git-dir=" + filepath.Clean(g.GitDir)}
 	}
-	return g.Client.Exec(append(argv, args...)...)
+	return []string{"-C", filepath.Clean(g.WorkDir)}
 }
 
 // LatestCommit gets the latest commit Id in the working tree
-func (g *GitWorkTree) LatestCommit() (string, error) {
-	return g.Exec("log", "-1", "--format=%H")
+func (g *GitWorkTree) LatestCommit() (CommitID, error) {
+	out, err := g.Exec("log", "-1", "--format=%H")
+	if err != nil {
+		return "", err
+	}
+	return ParseSHA(out)
 }
 
-// Pull fetches changes from remote and apply to current working tree
-func (g *GitWorkTree) Pull() error {
-	_, err := g.Exec("pull")
-	return err
+// ResolveRef gets the commit Id of ref, e.g. a branch, tag or
+// abbreviated commit Id
+func (g *GitWorkTree) ResolveRef(ref string) (CommitID, error) {
+	out, err := g.Exec("rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return ParseSHA(out)
+}
+
+// Pull fetches changes from remote and apply to current working tree,
+// args are options of git pull optionally followed by remote and refs
+func (g *GitWorkTree) Pull(args ...string) error {
+	return g.execLocked(append([]string{"pull"}, args...)...)
+}
+
+// PullFrom fetches refs from remote and merges into current branch
+func (g *GitWorkTree) PullFrom(remote string, refs ...string) error {
+	return g.Pull(append([]string{remote}, refs...)...)
+}
+
+// SetRemoteURL changes the URL of the named remote
+func (g *GitWorkTree) SetRemoteURL(name, url string) error {
+	return g.execLocked("remote", "set-url", name, url)
 }
 
 // PullAndVerify first pulls and verify by querying latest commit
-func (g *GitWorkTree) PullAndVerify() (string, error) {
+func (g *GitWorkTree) PullAndVerify() (CommitID, error) {
 	if err := g.Pull(); err != nil {
 		return "", err
 	}
 	return g.LatestCommit()
 }
 
-// Clone clones a remote repository
+// ExcludeAt implements ExcludingRepo, tracked files among paths are
+// marked skip-worktree so they're neither reported deleted nor block
+// pulls
+func (r *GitRepo) ExcludeAt(dir string, paths []string) error {
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	args := []string{"ls-files", "-z", "--"}
+	for _, p := range paths {
+		args = append(args, ":(literal)"+p)
+	}
+	out, err := git.Exec(args...)
+	if err != nil {
+		return gitErr(err)
+	}
+	if out == "" {
+		return nil
+	}
+	tracked := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	return git.execLocked(append([]string{"update-index", "--skip-worktree", "--"}, tracked...)...)
+}
+
+// Clone clones a remote repository, it runs outside of WorkDir
+// as WorkDir doesn't exist before clone
 func (g *GitWorkTree) Clone(remote string, args ...string) error {
-	_, err := g.Exec("clone", remote, g.WorkDir)
-	return err
+	defer g.lock()()
+	argv := append(append([]string{"clone"}, args...), remote, g.WorkDir)
+	_, err := g.Client.Exec(argv...)
+	return gitErr(err)
+}
+
+// CurrentBranch returns the branch checked out, or "HEAD" if detached
+func (g *GitWorkTree) CurrentBranch() (string, error) {
+	out, err := g.Exec("rev-parse", "--abbrev-ref", "HEAD")
+	return strings.TrimSpace(out), gitErr(err)
+}
+
+// Checkout updates working tree to ref
+func (g *GitWorkTree) Checkout(ref string, args ...string) error {
+	return g.execLocked(append(append([]string{"checkout"}, args...), ref)...)
+}
+
+// Tags lists all tags in the repository
+func (g *GitWorkTree) Tags() ([]string, error) {
+	out, err := g.Exec("tag", "-l")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// SparseCheckout restricts working tree to the patterns (non-cone mode)
+func (g *GitWorkTree) SparseCheckout(patterns ...string) error {
+	return g.execLocked(append([]string{"sparse-checkout", "set", "--no-cone"}, patterns...)...)
+}
+
+// DisableSparseCheckout restores the full working tree if sparse
+// checkout is enabled
+func (g *GitWorkTree) DisableSparseCheckout() error {
+	if out, err := g.Exec("config", "--bool", "core.sparseCheckout"); err != nil || strings.TrimSpace(out) != "true" {
+		return nil
+	}
+	return g.execLocked("sparse-checkout", "disable")
+}
+
+// CommitInfo describes a commit
+type CommitInfo struct {
+	// ID is the commit Id
+	ID CommitID `json:"id"`
+	// Author is the name of author
+	Author string `json:"author"`
+	// Email is the email of author
+	Email string `json:"email"`
+	// Date is the author date
+	Date time.Time `json:"date"`
+	// Subject is the first line of commit message
+	Subject string `json:"subject"`
+}
+
+// LastChanged returns the latest commit touching path relative to
+// WorkDir, ErrNoHistory is returned if the path is never committed
+func (g *GitWorkTree) LastChanged(path string) (*CommitInfo, error) {
+	out, err := g.Exec("log", "-1", "--format=%H%x00%an%x00%ae%x00%aI%x00%s", "--", path)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(strings.TrimRight(out, "\n"), "\x00", 5)
+	if len(fields) < 5 {
+		return nil, ErrNoHistory
+	}
+	id, perr := ParseSHA(fields[0])
+	if perr != nil {
+		return nil, perr
+	}
+	date, perr := time.Parse(time.RFC3339, fields[3])
+	if perr != nil {
+		return nil, perr
+	}
+	return &CommitInfo{ID: id, Author: fields[1], Email: fields[2], Date: date, Subject: fields[4]}, nil
+}
+
+// Add stages paths, or all changes including untracked files if no
+// path is given
+func (g *GitWorkTree) Add(paths ...string) error {
+	args := []string{"add", "-A"}
+	if len(paths) > 0 {
+		args = append(args, "--")
+		args = append(args, paths...)
+	}
+	return g.execLocked(args...)
+}
+
+// Commit records staged changes with message, author is in the form
+// "Name <email>" and also used as committer if not empty, otherwise
+// the identity is from git config. ErrNothingToCommit is returned if
+// nothing is staged
+func (g *GitWorkTree) Commit(message, author string) error {
+	defer g.lock()()
+	if _, err := g.Exec("diff", "--cached", "--quiet"); err == nil {
+		return ErrNothingToCommit
+	}
+	var args []string
+	if author != "" {
+		name, email := author, ""
+		if pos := strings.Index(author, "<"); pos >= 0 {
+			name = strings.TrimSpace(author[:pos])
+			email = strings.TrimSuffix(strings.TrimSpace(author[pos+1:]), ">")
+		}
+		args = append(args, "-c", "user.name="+name, "-c", "user.email="+email)
+	}
+	args = append(args, "commit", "-q", "-m", message)
+	if author != "" {
+		args = append(args, "--author="+author)
+	}
+	_, err := g.Exec(args...)
+	return gitErr(err)
+}
+
+// Push updates ref of remote with local commits, ref can be a refspec
+// like "HEAD:refs/heads/main"
+func (g *GitWorkTree) Push(remote, ref string) error {
+	_, err := g.Exec("push", remote, ref)
+	return gitErr(err)
 }
 
 // GitRepo is a remote git repository
@@ -121,9 +559,53 @@ type GitRepo struct {
 	Remote string `json:"remote"`
 	// Path is prefix in the repository
 	Path string `json:"path"`
+	// Ref is the branch or tag to clone, from #ref in URL
+	Ref string `json:"ref,omitempty"`
+	// Filter is the partial clone filter, e.g. "blob:none" to fetch
+	// blobs on demand for very large repositories
+	Filter string `json:"filter,omitempty"`
+	// CloneArgs are extra arguments of git clone, e.g. --single-branch
+	// or --config=core.longpaths=true, limited to AllowedCloneArgs
+	CloneArgs []string `json:"cloneArgs,omitempty"`
+	// Config is git config set in the local clone at clone time and
+	// restored on every Sync, e.g. core.longpaths=true or
+	// http.extraHeader for proxies authenticating by header. Keys are
+	// limited to AllowedGitConfig. Values are stored in plain text in the
+	// cache config and left out of Snapshot if they may carry credentials
+	Config map[string]string `json:"config,omitempty"`
+	// Mirrors are URLs of the same repository tried in order when
+	// Remote fails, the local clone always tracks Remote
+	Mirrors []string `json:"mirrors,omitempty"`
+	// Branch is the default branch of Remote tracked when Ref is empty,
+	// Sync follows it when upstream renames the default branch
+	Branch string `json:"branch,omitempty"`
 
-	// Client is git client
+	// Client is git client, DefaultGitClient is used if nil
 	Client GitClient `json:"-"`
+	// DetectMode controls whether Detect probes the remote
+	DetectMode DetectMode `json:"-"`
+	// DetectCache memoizes Detect results and probes if not nil
+	DetectCache *DetectCache `json:"-"`
+	// DetectPolicy picks the repository when several prefixes are valid
+	DetectPolicy DetectPolicy `json:"-"`
+	// DetectParallel is max concurrent probes, DefaultDetectParallel
+	// is used if not positive
+	DetectParallel int `json:"-"`
+	// RemotePolicy checks URLs before Detect and Sync,
+	// DefaultRemotePolicy is used if nil
+	RemotePolicy RemotePolicy `json:"-"`
+	// Health orders Remote and Mirrors by recent failures, the one
+	// from ctx of SyncContext is used if nil
+	Health *RemoteHealth `json:"-"`
+	// Logger receives fallbacks to mirrors if not nil
+	Logger Logger `json:"-"`
+}
+
+func (r *GitRepo) client() GitClient {
+	if r.Client == nil {
+		return DefaultGitClient
+	}
+	return r.Client
 }
 
 // Detect parse the URL and find out the right information about the repository
@@ -131,47 +613,77 @@ func (r *GitRepo) Detect() (err error) {
 	if r.URL == "" {
 		panic("URL is required")
 	}
+	_, span := startSpan(context.Background(), SpanGitDetect)
+	span.SetAttribute("gms.url", RedactURL(r.URL))
+	defer func() {
+		span.SetAttribute("gms.protocol", r.Protocol)
+		span.End(err)
+	}()
 
-	slashPos := strings.Index(r.URL, "/")
-	colonPos := strings.Index(r.URL, ":")
-	atPos := strings.Index(r.URL, "@")
-
-	// user@host:repo/path
-	if atPos > 0 && atPos < colonPos && (slashPos < 0 || colonPos < slashPos) {
-		r.Protocol = "ssh"
-		return r.detectPrefixed(r.URL[0:colonPos+1], r.URL[colonPos+1:])
+	u, err := ParseRepoURL(r.URL)
+	if err != nil {
+		return err
+	}
+	r.Ref = u.Ref
+	if u.Protocol != "" {
+		if err = r.checkRemote(u); err != nil {
+			return err
+		}
 	}
 
-	// protocol://host/repo/path
-	if colonPos > 0 && colonPos < slashPos &&
-		strings.HasPrefix(r.URL[colonPos+1:], "//") {
-		r.Protocol = r.URL[0:colonPos]
-		return r.detectPrefixed(r.URL[0:colonPos+3], r.URL[colonPos+3:])
+	if r.DetectCache != nil && r.DetectMode != DetectOffline {
+		if r.DetectCache.lookup(r) {
+			span.SetAttribute("gms.detect.cached", true)
+			return checkRemote(r.RemotePolicy, r.Remote)
+		}
+		defer func() {
+			if err == nil {
+				err = r.DetectCache.store(r)
+			}
+		}()
 	}
 
-	// ./path, ../path, /path
-	if strings.HasPrefix(r.URL, "./") ||
-		strings.HasPrefix(r.URL, "../") ||
-		strings.HasPrefix(r.URL, "/") {
-		r.Protocol = "file"
-		return r.detectPrefixed(r.Protocol+"://", r.URL)
+	switch r.DetectMode {
+	case DetectOffline:
+		if err = r.detectOffline(u); err == nil {
+			err = checkRemote(r.RemotePolicy, r.Remote)
+		}
+		return err
+	case DetectPreferKnown:
+		if r.detectKnown(u) {
+			return checkRemote(r.RemotePolicy, r.Remote)
+		}
 	}
 
 	// host/repo/path
-	if err := r.detectPrefixed("http://", r.URL); err == nil {
-		r.Protocol = "http"
-	} else if err := r.detectPrefixed("https://", r.URL); err == nil {
-		r.Protocol = "https"
-	} else if err := r.detectPrefixed("file://", r.URL); err == nil {
-		r.Protocol = "file"
-	} else {
+	if u.Protocol == "" {
+		var denied error
+		for _, protocol := range []string{"http", "https", "file"} {
+			candidate := u.WithProtocol(protocol)
+			if err := r.checkRemote(candidate); err != nil {
+				denied = err
+				continue
+			}
+			denied = nil
+			if r.detectPrefixed(candidate.Prefix(), u.Path) == nil {
+				r.Protocol = protocol
+				return nil
+			}
+		}
+		if denied != nil {
+			return denied
+		}
 		return ErrInvalidGitURL
 	}
 
-	return nil
+	r.Protocol = u.Protocol
+	return r.detectPrefixed(u.Prefix(), u.Path)
 }
 
+// detectPrefixed probes bases of path concurrently and picks the valid
+// one according to DetectPolicy
 func (r *GitRepo) detectPrefixed(prefix, path string) error {
+	var bases, subs []string
 	base := ""
 	for path != "" {
 		pos := strings.Index(path, "/")
@@ -186,15 +698,153 @@ func (r *GitRepo) detectPrefixed(prefix, path string) error {
 			base += path
 			path = ""
 		}
-		_, err := r.Client.Exec("ls-remote", prefix+base)
-		if err == nil {
-			r.RepoName = base
-			r.Path = path
-			r.Remote = prefix + base
-			return nil
+		bases = append(bases, base)
+		subs = append(subs, path)
+	}
+	if r.DetectPolicy == DetectLongest {
+		for i, j := 0, len(bases)-1; i < j; i, j = i+1, j-1 {
+			bases[i], bases[j] = bases[j], bases[i]
+			subs[i], subs[j] = subs[j], subs[i]
+		}
+	}
+
+	found := probeInOrder(len(bases), r.detectParallel(), func(i int) bool {
+		return r.probe(prefix + bases[i])
+	})
+	if found < 0 {
+		return ErrInvalidGitURL
+	}
+	r.RepoName = bases[found]
+	r.Path = subs[found]
+	r.Remote = prefix + bases[found]
+	if r.Ref == "" {
+		// not fatal, the default branch is checked out anyway
+		r.Branch, _ = defaultBranch(r.client(), r.Remote)
+	}
+	return nil
+}
+
+// DefaultBranch queries the branch HEAD of Remote points to, e.g. main
+func (r *GitRepo) DefaultBranch() (string, error) {
+	if err := checkRemote(r.RemotePolicy, r.Remote); err != nil {
+		return "", err
+	}
+	return defaultBranch(r.client(), r.Remote)
+}
+
+func defaultBranch(client GitClient, remote string) (string, error) {
+	refs, err := lsRemote(client, remote, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if branch := refs.Head(); branch != "" {
+		return branch, nil
+	}
+	return "", fmt.Errorf("%w: HEAD", ErrRefNotFound)
+}
+
+func (r *GitRepo) detectParallel() int {
+	if r.DetectParallel > 0 {
+		return r.DetectParallel
+	}
+	return DefaultDetectParallel
+}
+
+// probeInOrder runs probe on 0..n-1 using at most parallel goroutines
+// and returns the first index in order which succeeds, or -1. No more
+// probes are started once the result is decided
+func probeInOrder(n, parallel int, probe func(int) bool) int {
+	type probed struct {
+		index int
+		ok    bool
+	}
+	indices := make(chan int)
+	results := make(chan probed)
+	stop := make(chan struct{})
+	go func() {
+		defer close(indices)
+		for i := 0; i < n; i++ {
+			select {
+			case indices <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < parallel && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results <- probed{index: i, ok: probe(i)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// states are 0 if unknown, 1 if valid and -1 if invalid
+	states := make([]int, n)
+	found := -1
+	for p := range results {
+		states[p.index] = -1
+		if p.ok {
+			states[p.index] = 1
+		}
+		if found >= 0 {
+			continue
+		}
+		for i, state := range states {
+			if state == 0 {
+				break
+			}
+			if state > 0 {
+				found = i
+				close(stop)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// checkRemote checks u with RemotePolicy or DefaultRemotePolicy
+func (r *GitRepo) checkRemote(u *RepoURL) error {
+	policy := r.RemotePolicy
+	if policy == nil {
+		policy = DefaultRemotePolicy
+	}
+	if policy == nil {
+		return nil
+	}
+	return policy.CheckRemote(u)
+}
+
+// probe checks if remote is a repository using ls-remote
+func (r *GitRepo) probe(remote string) bool {
+	if r.DetectCache != nil {
+		if ok, found := r.DetectCache.probe(remote); found {
+			return ok
 		}
 	}
-	return ErrInvalidGitURL
+	out, err := r.client().Exec("ls-remote", remote)
+	// failures are memoized only when git explains them, not when
+	// interrupted or failed to start
+	if err != nil && err.Output == "" {
+		return false
+	}
+	ok := err == nil
+	if ok {
+		_, perr := ParseLsRemote(out)
+		ok = perr == nil
+	}
+	if r.DetectCache != nil {
+		r.DetectCache.storeProbe(remote, ok)
+	}
+	return ok
 }
 
 // BasePath implements Repository
@@ -209,17 +859,363 @@ func (r *GitRepo) Persist() PersistentHandle {
 }
 
 // Sync implements RemoteRepo
-func (r *GitRepo) Sync(dir string) (err error) {
-	git := &GitWorkTree{Client: r.Client, WorkDir: dir}
-	_, err = git.LatestCommit()
+func (r *GitRepo) Sync(dir string) error {
+	return r.SyncContext(context.Background(), dir)
+}
+
+// SyncContext implements ContextRemoteRepo
+func (r *GitRepo) SyncContext(ctx context.Context, dir string) error {
+	return r.syncWith(ctx, dir, nil)
+}
+
+// PlanSync implements PlanningRemoteRepo
+func (r *GitRepo) PlanSync(ctx context.Context, dir string, plan *Plan) error {
+	return r.syncWith(ctx, dir, plan)
+}
+
+// syncWith pulls, or reclones if pull fails, mutating actions are
+// recorded instead of executed if plan is not nil. Mirrors are tried
+// in order of health when Remote fails
+func (r *GitRepo) syncWith(ctx context.Context, dir string, plan *Plan) (err error) {
+	ctx, span := startSpan(ctx, SpanGitSync)
+	defer func() { span.End(err) }()
+	remotes := append([]string{r.Remote}, r.Mirrors...)
+	for _, remote := range remotes {
+		if err = checkRemote(r.RemotePolicy, remote); err != nil {
+			return
+		}
+	}
+	// options may be persisted before they were checked
+	if err = r.CheckOptions(); err != nil {
+		return
+	}
+	health := r.Health
+	if health == nil {
+		health = RemoteHealthFromContext(ctx)
+	}
+	client := r.client()
+	if plan != nil {
+		client = &planGitClient{client: client, plan: plan}
+	}
+	// pull and reclone are one operation on dir
+	defer lockWorkDir(dir)()
+	git := &GitWorkTree{Client: GitClientWithContext(ctx, client), WorkDir: dir, locked: true}
+	report := syncReportFromContext(ctx)
+	local, err := git.LatestCommit()
+	cloned := err == nil
+	if err == nil {
+		err = r.applyConfig(git)
+	}
+	if err == nil {
+		span.SetAttribute("gms.sync.mode", "pull")
+		var unchanged bool
+		err = tryRemotes(ctx, health, r.Logger, remotes, func(remote string) error {
+			if unchanged = r.unchanged(git, remote, local); unchanged {
+				return nil
+			}
+			return r.pull(git, remote, progressArgs(ctx))
+		})
+		if err == nil && unchanged {
+			span.SetAttribute("gms.sync.mode", "unchanged")
+			report.setAction(SyncUpToDate)
+		} else if err == nil {
+			report.setAction(SyncPulled)
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		span.SetAttribute("gms.sync.mode", "clone")
+		err = tryRemotes(ctx, health, r.Logger, remotes, func(remote string) error {
+			return r.clone(ctx, git, remote, plan)
+		})
+		if err == nil && cloned {
+			report.setAction(SyncRecloned)
+		} else if err == nil {
+			report.setAction(SyncCloned)
+		}
+	}
+	return
+}
+
+// unchanged tells if the ref tracked from remote is still at local, so
+// the pull can be skipped. Ref is resolved by ls-remote which is much
+// cheaper than fetching, if it fails the pull decides
+func (r *GitRepo) unchanged(git *GitWorkTree, remote string, local CommitID) bool {
+	id, err := r.remoteCommit(git.Client, remote)
+	if err != nil {
+		logf(r.Logger, "git %s: remote commit: %v", RedactURL(remote), err)
+		return false
+	}
+	return id.Equal(local)
+}
+
+// remoteCommit resolves Ref, or HEAD if empty, on remote
+func (r *GitRepo) remoteCommit(client GitClient, remote string) (CommitID, error) {
+	ref := r.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if id, err := ParseSHA(ref); err == nil {
+		return id, nil
+	}
+	refs, err := lsRemote(client, remote, ref)
+	if err != nil {
+		return "", err
+	}
+	return refs.Resolve(ref)
+}
+
+// RemoteVersion implements ChangeDetector, it's the commit Ref is at on
+// Remote by ls-remote
+func (r *GitRepo) RemoteVersion(ctx context.Context) (string, error) {
+	if err := checkRemote(r.RemotePolicy, r.Remote); err != nil {
+		return "", err
+	}
+	id, err := r.remoteCommit(GitClientWithContext(ctx, r.client()), r.Remote)
+	return id.String(), err
+}
+
+// pull pulls from remote into the local clone
+func (r *GitRepo) pull(git *GitWorkTree, remote string, opts []string) error {
+	args := opts
+	if remote != r.Remote {
+		args = append(append([]string{}, opts...), remote)
+		if r.Ref != "" {
+			args = append(args, r.Ref)
+		}
+	}
+	err := git.Pull(args...)
+	if err != nil && r.Ref == "" && remote == r.Remote {
+		if followed, ferr := r.followDefaultBranch(git); ferr != nil {
+			logf(r.Logger, "git %s: default branch: %v", RedactURL(r.Remote), ferr)
+		} else if followed {
+			err = nil
+		}
+	}
 	if err == nil {
-		_, err = git.PullAndVerify()
+		_, err = git.LatestCommit()
 	}
+	return err
+}
+
+// followDefaultBranch switches the local clone to the default branch
+// of Remote if it's renamed, e.g. from master to main. It tells if the
+// branch is switched
+func (r *GitRepo) followDefaultBranch(git *GitWorkTree) (bool, error) {
+	branch, err := defaultBranch(git.Client, r.Remote)
 	if err != nil {
+		return false, err
+	}
+	current, err := git.CurrentBranch()
+	if err != nil || current == branch {
+		return false, err
+	}
+	tracking := "refs/remotes/origin/" + branch
+	if err = git.execLocked("fetch", "origin", "+"+RefHeadsPrefix+branch+":"+tracking); err != nil {
+		return false, err
+	}
+	if err = git.execLocked("checkout", "-B", branch, "--track", "origin/"+branch); err != nil {
+		return false, err
+	}
+	if err = git.execLocked("remote", "set-head", "origin", branch); err != nil {
+		return false, err
+	}
+	logf(r.Logger, "git %s: default branch changed from %s to %s", RedactURL(r.Remote), current, branch)
+	r.Branch = branch
+	return true, nil
+}
+
+// configKeys returns keys of Config in order
+func (r *GitRepo) configKeys() []string {
+	keys := make([]string, 0, len(r.Config))
+	for key := range r.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyConfig sets Config in the local clone where it's changed, all
+// existing values of a key are replaced
+func (r *GitRepo) applyConfig(git *GitWorkTree) error {
+	for _, key := range r.configKeys() {
+		if out, err := git.Exec("config", "--get-all", key); err == nil && out == r.Config[key]+"\n" {
+			continue
+		}
+		if err := git.execLocked("config", "--replace-all", key, r.Config[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clone reclones from remote, origin is pointed back to Remote if
+// cloned from a mirror. Objects are borrowed from the shared store in
+// ctx if any, except for partial clones
+func (r *GitRepo) clone(ctx context.Context, git *GitWorkTree, remote string, plan *Plan) error {
+	if plan != nil {
+		plan.record(PlannedAction{Op: PlanDelete, Path: git.WorkDir})
+	} else {
 		os.RemoveAll(git.WorkDir)
-		err = git.Clone(r.Remote)
 	}
-	return
+	var args []string
+	if r.Ref != "" {
+		args = append(args, "--branch", r.Ref)
+	}
+	if r.Filter != "" {
+		args = append(args, "--filter="+r.Filter)
+	}
+	if shared := sharedObjectsFromContext(ctx); shared != nil && plan == nil && r.Filter == "" {
+		// cloning without the store is slower but still works
+		if store, err := shared.fetch(git.Client, r.Remote, remote, progressArgs(ctx)); err != nil {
+			logf(r.Logger, "git %s: shared objects: %v", RedactURL(r.Remote), err)
+		} else {
+			args = append(args, "--reference", store)
+		}
+	}
+	for _, key := range r.configKeys() {
+		args = append(args, "--config", key+"="+r.Config[key])
+	}
+	args = append(args, progressArgs(ctx)...)
+	args = append(args, r.CloneArgs...)
+	if err := git.Clone(remote, args...); err != nil {
+		return err
+	}
+	if r.Ref == "" && plan == nil {
+		if branch, err := git.CurrentBranch(); err == nil && branch != "HEAD" {
+			r.Branch = branch
+		}
+	}
+	if remote != r.Remote {
+		logf(r.Logger, "git %s: cloned from mirror %s", RedactURL(r.Remote), RedactURL(remote))
+		return git.SetRemoteURL("origin", r.Remote)
+	}
+	return nil
+}
+
+// VersionAt implements VersionedRemoteRepo, it returns the commit Id
+func (r *GitRepo) VersionAt(dir string) (string, error) {
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	id, err := git.LatestCommit()
+	return id.String(), err
+}
+
+// RefAt implements RefRepo, it returns the branch checked out, or Ref
+// if HEAD is detached at a tag or commit
+func (r *GitRepo) RefAt(dir string) (string, error) {
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	branch, err := git.CurrentBranch()
+	if err != nil || branch != "HEAD" {
+		return branch, err
+	}
+	return r.Ref, nil
+}
+
+// Checkout implements PinnableRepo, the branch checked out is reset to
+// version so later pulls still fast-forward it. HEAD is only detached
+// if it's detached already, e.g. at a tag
+func (r *GitRepo) Checkout(dir, version string) error {
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	branch, err := git.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	if branch == "HEAD" {
+		return git.Checkout(version, "--detach")
+	}
+	return git.Checkout(version, "-B", branch)
+}
+
+// ChangesAt implements ChangeListingRepo using git diff, paths are
+// relative to BasePath
+func (r *GitRepo) ChangesAt(dir, sinceVersion string) ([]Change, error) {
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	args := []string{"diff", "--name-status", "--no-renames", "-z", sinceVersion, "HEAD"}
+	prefix := strings.Trim(r.Path, "/")
+	if prefix != "" {
+		args = append(args, "--", prefix)
+		prefix += "/"
+	}
+	out, err := git.Exec(args...)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	fields := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		var kind ChangeKind
+		switch fields[i] {
+		case "A":
+			kind = ChangeAdded
+		case "D":
+			kind = ChangeDeleted
+		default:
+			kind = ChangeModified
+		}
+		changes = append(changes, Change{Path: strings.TrimPrefix(fields[i+1], prefix), Kind: kind})
+	}
+	return changes, nil
+}
+
+// ExportPathAt implements PathExportingRepo using git archive, subpath
+// is relative to BasePath and ref is HEAD if empty
+func (r *GitRepo) ExportPathAt(dir, ref, subpath, dst string) error {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	full := strings.Trim(path.Join(strings.Trim(r.Path, "/"), filepath.ToSlash(subpath)), "/")
+	if full == "." {
+		full = ""
+	}
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	strip := 0
+	args := []string{"archive", "--format=tar", ref}
+	if full != "" {
+		kind, err := git.Exec("cat-file", "-t", ref+":"+full)
+		if err != nil {
+			return err
+		}
+		strip = len(strings.Split(full, "/"))
+		if strings.TrimSpace(kind) != "tree" {
+			strip--
+		}
+		args = append(args, "--", full)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	out := ExecReader(context.Background(), git, args...)
+	err := extractTar(out, dst, strip, nil)
+	if err == nil {
+		// consume padding after the end of archive
+		_, err = io.Copy(io.Discard, out)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// LastChangedAt implements HistoryRepo, relpath is relative to BasePath
+func (r *GitRepo) LastChangedAt(dir, relpath string) (*CommitInfo, error) {
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	full := path.Join(strings.Trim(r.Path, "/"), filepath.ToSlash(relpath))
+	if full == "" {
+		full = "."
+	}
+	return git.LastChanged(full)
+}
+
+// SyncSparse implements SparseRemoteRepo, sparse checkout is disabled
+// if policy is empty
+func (r *GitRepo) SyncSparse(ctx context.Context, dir string, policy *PathPolicy) error {
+	if err := r.SyncContext(ctx, dir); err != nil {
+		return err
+	}
+	git := &GitWorkTree{Client: GitClientWithContext(ctx, r.client()), WorkDir: dir}
+	if policy.IsEmpty() {
+		return git.DisableSparseCheckout()
+	}
+	return git.SparseCheckout(policy.SparsePatterns(r.Path)...)
 }
 
 // GitRepoFactory is the factory to restore a git repo
@@ -0,0 +1,191 @@
+package gms
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrUnsafeGitConfig indicates a git config key not allowed in
+	// GitRepo.Config
+	ErrUnsafeGitConfig = errors.New("git config not allowed")
+	// ErrUnsafeCloneArg indicates an argument not allowed in
+	// GitRepo.CloneArgs
+	ErrUnsafeCloneArg = errors.New("git clone argument not allowed")
+)
+
+// AllowedCloneArgs are the options GitRepo.CloneArgs may pass to git
+// clone, true if the option takes a value as --opt=VALUE or --opt VALUE.
+// They only shape what's fetched, others like --upload-pack or
+// --template run commands. --config and -c are checked by CheckGitConfig
+var AllowedCloneArgs = map[string]bool{
+	"--also-filter-submodules": false,
+	"--depth":                  true,
+	"--filter":                 true,
+	"--jobs":                   true,
+	"--no-shallow-submodules":  false,
+	"--no-single-branch":       false,
+	"--no-tags":                false,
+	"--quiet":                  false,
+	"--recurse-submodules":     false,
+	"--shallow-exclude":        true,
+	"--shallow-since":          true,
+	"--shallow-submodules":     false,
+	"--single-branch":          false,
+	"--sparse":                 false,
+	"-j":                       true,
+	"-q":                       false,
+}
+
+// AllowedGitConfig are the keys GitRepo.Config may set, in lower case.
+// Others can run commands, e.g. core.sshCommand or core.fsmonitor, or
+// redirect remotes around RemotePolicy, e.g. url.*.insteadOf. Keys of
+// http are allowed with a URL subsection as well
+var AllowedGitConfig = map[string]bool{
+	"core.autocrlf":          true,
+	"core.bigfilethreshold":  true,
+	"core.checkstat":         true,
+	"core.compression":       true,
+	"core.eol":               true,
+	"core.filemode":          true,
+	"core.ignorecase":        true,
+	"core.longpaths":         true,
+	"core.precomposeunicode": true,
+	"core.protecthfs":        true,
+	"core.protectntfs":       true,
+	"core.quotepath":         true,
+	"core.safecrlf":          true,
+	"core.symlinks":          true,
+	"core.trustctime":        true,
+	"checkout.workers":       true,
+	"fetch.parallel":         true,
+	"fetch.writecommitgraph": true,
+	"gc.auto":                true,
+	"http.extraheader":       true,
+	"http.lowspeedlimit":     true,
+	"http.lowspeedtime":      true,
+	"http.postbuffer":        true,
+	"http.proxy":             true,
+	"http.sslbackend":        true,
+	"http.sslcainfo":         true,
+	"http.sslcapath":         true,
+	"http.sslverify":         true,
+	"http.sslversion":        true,
+	"http.version":           true,
+	"index.threads":          true,
+	"pack.threads":           true,
+	"pack.windowmemory":      true,
+	"protocol.version":       true,
+}
+
+// credentialGitConfig are keys in AllowedGitConfig whose values may
+// carry credentials
+var credentialGitConfig = map[string]bool{
+	"http.extraheader": true,
+	"http.proxy":       true,
+}
+
+// gitConfigName returns the name of key in AllowedGitConfig, the section
+// and name of git config are case insensitive unlike a subsection
+func gitConfigName(key string) string {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return ""
+	}
+	section := strings.ToLower(key[:first])
+	if first != last && section != "http" {
+		return ""
+	}
+	return section + "." + strings.ToLower(key[last+1:])
+}
+
+// CheckGitConfig checks key may be set in GitRepo.Config,
+// ErrUnsafeGitConfig if not
+func CheckGitConfig(key string) error {
+	if !AllowedGitConfig[gitConfigName(key)] {
+		return fmt.Errorf("%s: %w", key, ErrUnsafeGitConfig)
+	}
+	return nil
+}
+
+// CheckCloneArgs checks args only pass options in AllowedCloneArgs and
+// config allowed by CheckGitConfig, ErrUnsafeCloneArg if not
+func CheckCloneArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		takesValue, allowed := AllowedCloneArgs[name]
+		switch {
+		case name == "--config" || name == "-c":
+			if !hasValue {
+				if i++; i == len(args) || strings.HasPrefix(args[i], "-") {
+					return fmt.Errorf("%s: %w", name, ErrUnsafeCloneArg)
+				}
+				value = args[i]
+			}
+			key, _, _ := strings.Cut(value, "=")
+			if err := CheckGitConfig(key); err != nil {
+				return err
+			}
+		// --recurse-submodules takes an optional pathspec
+		case allowed && hasValue && (takesValue || name == "--recurse-submodules"):
+		case allowed && !hasValue && takesValue:
+			// the value must not be mistaken for another option
+			if i++; i == len(args) || strings.HasPrefix(args[i], "-") {
+				return fmt.Errorf("%s: %w", name, ErrUnsafeCloneArg)
+			}
+		case allowed && !hasValue:
+		default:
+			return fmt.Errorf("%s: %w", args[i], ErrUnsafeCloneArg)
+		}
+	}
+	return nil
+}
+
+// CheckOptions checks CloneArgs by CheckCloneArgs and Config only sets
+// keys allowed by CheckGitConfig, the repo may come from an untrusted
+// manifest
+func (r *GitRepo) CheckOptions() error {
+	if err := CheckCloneArgs(r.CloneArgs); err != nil {
+		return err
+	}
+	for _, key := range r.configKeys() {
+		if err := CheckGitConfig(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shareableConfig returns Config without values which may carry
+// credentials, e.g. http.extraHeader
+func (r *GitRepo) shareableConfig() map[string]string {
+	var config map[string]string
+	for key, value := range r.Config {
+		if credentialGitConfig[gitConfigName(key)] {
+			continue
+		}
+		if config == nil {
+			config = make(map[string]string)
+		}
+		config[key] = value
+	}
+	return config
+}
+
+// matchesGitConfig compares Config with config of a manifest, values
+// which may carry credentials are left out of snapshots so they only
+// count if in config
+func (r *GitRepo) matchesGitConfig(config map[string]string) bool {
+	for key, value := range config {
+		if current, ok := r.Config[key]; !ok || current != value {
+			return false
+		}
+	}
+	for key := range r.Config {
+		if _, ok := config[key]; !ok && !credentialGitConfig[gitConfigName(key)] {
+			return false
+		}
+	}
+	return true
+}
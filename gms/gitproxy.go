@@ -0,0 +1,200 @@
+package gms
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// transferProxy is an HTTP proxy on localhost git commands of http(s)
+// remotes are pointed to by http.proxy, it relays their downloads
+// through transferReader as git has no rate limit of its own. Remotes
+// are connected through proxies of the environment
+type transferProxy struct {
+	ctx      context.Context
+	listener net.Listener
+	server   *http.Server
+
+	lock  sync.Mutex
+	conns map[net.Conn]bool
+	err   error
+}
+
+// startTransferProxy starts a proxy for a git command if transfers in
+// ctx are limited, nil otherwise
+func startTransferProxy(ctx context.Context) (*transferProxy, error) {
+	if !transferLimited(ctx) {
+		return nil, nil
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &transferProxy{ctx: ctx, listener: l, conns: make(map[net.Conn]bool)}
+	p.server = &http.Server{Handler: p}
+	go p.server.Serve(l)
+	return p, nil
+}
+
+// URL returns the value of http.proxy
+func (p *transferProxy) URL() string {
+	return "http://" + p.listener.Addr().String()
+}
+
+// cause returns the first error of transfers, e.g. ErrBudgetExceeded,
+// as the cause of git failing with err
+func (p *transferProxy) cause(err error) error {
+	if p == nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return err
+}
+
+// Close stops the proxy including tunnels still open
+func (p *transferProxy) Close() error {
+	err := p.server.Close()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// fail records err if it's why the transfer stopped, other errors are
+// reported by git
+func (p *transferProxy) fail(err error) {
+	if !errors.Is(err, ErrBudgetExceeded) {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// track adds conn to be closed by Close, or removes it
+func (p *transferProxy) track(conn net.Conn, add bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if add {
+		p.conns[conn] = true
+	} else {
+		delete(p.conns, conn)
+	}
+}
+
+// ServeHTTP tunnels CONNECT requests of https remotes and forwards
+// requests of http remotes
+func (p *transferProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		p.forward(w, req)
+		return
+	}
+	upstream, err := dialTunnel(req.Context(), req.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	p.track(upstream, true)
+	defer p.track(upstream, false)
+	defer upstream.Close()
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	p.track(conn, true)
+	defer p.track(conn, false)
+	defer conn.Close()
+	if _, err = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	go func() {
+		io.Copy(upstream, rw)
+		upstream.Close()
+	}()
+	if _, err = io.Copy(conn, transferReader(p.ctx, upstream)); err != nil {
+		p.fail(err)
+	}
+}
+
+// forward sends a request of an http remote by DefaultHTTPTransport
+func (p *transferProxy) forward(w http.ResponseWriter, req *http.Request) {
+	out := req.Clone(req.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+	resp, err := DefaultHTTPTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err = io.Copy(w, transferReader(p.ctx, resp.Body)); err != nil {
+		p.fail(err)
+	}
+}
+
+// dialTunnel connects to host directly or by CONNECT through the https
+// proxy of the environment
+func dialTunnel(ctx context.Context, host string) (net.Conn, error) {
+	var d net.Dialer
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: host}})
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return d.DialContext(ctx, "tcp", host)
+	}
+	addr := proxy.Host
+	if proxy.Port() == "" {
+		addr = net.JoinHostPort(proxy.Hostname(), "80")
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	connect := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: host}, Host: host, Header: make(http.Header)}
+	if user := proxy.User; user != nil {
+		password, _ := user.Password()
+		connect.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err = connect.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// nothing is sent before the tunnel is used, the reader buffers none
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %s", RedactURL(proxy.String()), resp.Status)
+	}
+	return conn, nil
+}
@@ -0,0 +1,44 @@
+package gms
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob matches slash-separated path against pattern using path.Match
+// syntax per segment, plus "**" matching zero or more segments
+func MatchGlob(pattern, name string) bool {
+	return matchSegments(splitSegments(pattern), splitSegments(name))
+}
+
+func splitSegments(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func matchSegments(pats, segs []string) bool {
+	for len(pats) > 0 {
+		if pats[0] == "**" {
+			if len(pats) == 1 {
+				return true
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pats[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pats[0], segs[0]); !matched {
+			return false
+		}
+		pats, segs = pats[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,71 @@
+package gmstest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// WriteFiles writes files keyed by slash-separated relative path under dir
+func WriteFiles(dir string, files map[string]string) error {
+	for name, content := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TempRepo creates a LocalRepo in a temporary directory with files
+func TempRepo(t testing.TB, files map[string]string) *gms.LocalRepo {
+	t.Helper()
+	dir := t.TempDir()
+	if err := WriteFiles(dir, files); err != nil {
+		t.Fatal(err)
+	}
+	return &gms.LocalRepo{BaseDir: dir}
+}
+
+// TempGitRepo creates a git repository in a temporary directory with
+// files committed and returns its path, the test is skipped without git
+func TempGitRepo(t testing.TB, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath(gms.DefaultGitCmd); err != nil {
+		t.Skip("git not available")
+	}
+	dir := TempRepo(t, files).BaseDir
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command(gms.DefaultGitCmd, append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=gmstest", "GIT_AUTHOR_EMAIL=gmstest@example.com",
+			"GIT_COMMITTER_NAME=gmstest", "GIT_COMMITTER_EMAIL=gmstest@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+	return dir
+}
+
+// TempCache creates an empty RepoCache in a temporary directory
+func TempCache(t testing.TB) *gms.RepoCache {
+	t.Helper()
+	c := &gms.RepoCache{BaseDir: t.TempDir()}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
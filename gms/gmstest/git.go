@@ -0,0 +1,130 @@
+// Package gmstest provides fakes and fixtures for testing code using gms
+// without a git binary or network
+package gmstest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// Any matches any single argument in FakeGitClient expectations
+const Any = "*"
+
+var (
+	// ErrUnexpectedCall indicates no expectation matches the command
+	ErrUnexpectedCall = errors.New("unexpected git command")
+)
+
+// ExitError is the error of a failed fake command
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Expectation is a canned response of FakeGitClient
+type Expectation struct {
+	args   []string
+	stdout string
+	stderr string
+	code   int
+	times  int
+	calls  int
+}
+
+// Return sets stdout of a successful command
+func (e *Expectation) Return(stdout string) *Expectation {
+	e.stdout = stdout
+	return e
+}
+
+// Fail makes the command fail with stderr and exit code
+func (e *Expectation) Fail(stderr string, code int) *Expectation {
+	e.stderr, e.code = stderr, code
+	return e
+}
+
+// Times limits how many calls the expectation matches, unlimited if 0
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) matches(args []string) bool {
+	if len(args) != len(e.args) || (e.times > 0 && e.calls >= e.times) {
+		return false
+	}
+	for i, arg := range e.args {
+		if arg != Any && arg != args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FakeGitClient implements gms.GitClient with canned responses,
+// the first matching expectation in order of Expect answers a command
+type FakeGitClient struct {
+	lock         sync.Mutex
+	expectations []*Expectation
+	calls        [][]string
+}
+
+// Expect adds an expectation of command line args, Any matches any arg
+func (c *FakeGitClient) Expect(args ...string) *Expectation {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e := &Expectation{args: args}
+	c.expectations = append(c.expectations, e)
+	return e
+}
+
+// Exec implements gms.GitClient
+func (c *FakeGitClient) Exec(args ...string) (string, *gms.GitError) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls = append(c.calls, append([]string(nil), args...))
+	for _, e := range c.expectations {
+		if !e.matches(args) {
+			continue
+		}
+		e.calls++
+		if e.code != 0 {
+			return e.stdout, &gms.GitError{Output: e.stderr, Err: &ExitError{Code: e.code}}
+		}
+		return e.stdout, nil
+	}
+	return "", &gms.GitError{
+		Output: "git " + strings.Join(args, " "),
+		Err:    ErrUnexpectedCall,
+	}
+}
+
+// Calls returns command lines of all calls in order
+func (c *FakeGitClient) Calls() [][]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([][]string(nil), c.calls...)
+}
+
+// Verify checks all expectations have been called
+func (c *FakeGitClient) Verify() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var missing []string
+	for _, e := range c.expectations {
+		if e.calls == 0 || (e.times > 0 && e.calls < e.times) {
+			missing = append(missing, strings.Join(e.args, " "))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("expected git commands not called: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package gmstest
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// RecordEnv enables recording in RecordOrReplay when set to non-empty
+const RecordEnv = "GMSTEST_RECORD"
+
+// Interaction is a recorded git command and its result
+type Interaction struct {
+	Args     []string `json:"args"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exit,omitempty"`
+	// Error is the message of errors other than non-zero exit
+	Error string `json:"error,omitempty"`
+}
+
+// Vars map placeholder names to values varying between runs, e.g.
+// temporary directories. Values are replaced by ${name} in recordings
+// and placeholders are expanded on replay
+type Vars map[string]string
+
+// substitute replaces values with placeholders, longer values first
+func (v Vars) substitute(s string) string {
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(v[names[i]]) > len(v[names[j]]) })
+	for _, name := range names {
+		if value := v[name]; value != "" {
+			s = strings.ReplaceAll(s, value, "${"+name+"}")
+		}
+	}
+	return s
+}
+
+// expand replaces placeholders with values
+func (v Vars) expand(s string) string {
+	for name, value := range v {
+		s = strings.ReplaceAll(s, "${"+name+"}", value)
+	}
+	return s
+}
+
+func (v Vars) substituteAll(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = v.substitute(arg)
+	}
+	return out
+}
+
+// Recorder is a gms.GitClient recording commands run by Client
+type Recorder struct {
+	// Client runs the commands
+	Client gms.GitClient
+	// Vars are substituted in recorded interactions
+	Vars Vars
+
+	lock         sync.Mutex
+	interactions []Interaction
+}
+
+// Exec implements gms.GitClient
+func (r *Recorder) Exec(args ...string) (string, *gms.GitError) {
+	out, gitErr := r.Client.Exec(args...)
+	recorded := Interaction{Args: r.Vars.substituteAll(args), Stdout: r.Vars.substitute(out)}
+	if gitErr != nil {
+		recorded.Stderr = r.Vars.substitute(gitErr.Output)
+		var exitErr *exec.ExitError
+		var fakeErr *ExitError
+		switch {
+		case errors.As(gitErr.Err, &exitErr):
+			recorded.ExitCode = exitErr.ExitCode()
+		case errors.As(gitErr.Err, &fakeErr):
+			recorded.ExitCode = fakeErr.Code
+		default:
+			recorded.Error = r.Vars.substitute(gitErr.Err.Error())
+		}
+	}
+	r.lock.Lock()
+	r.interactions = append(r.interactions, recorded)
+	r.lock.Unlock()
+	return out, gitErr
+}
+
+// Save writes recorded interactions to fn as JSON
+func (r *Recorder) Save(fn string) error {
+	r.lock.Lock()
+	encoded, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fn, append(encoded, '\n'), 0644)
+}
+
+// Replayer is a gms.GitClient answering commands from recorded
+// interactions, each interaction answers once in recorded order
+type Replayer struct {
+	// Vars are expanded in recorded interactions
+	Vars Vars
+
+	lock         sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// LoadReplayer loads interactions saved by Recorder
+func LoadReplayer(fn string, vars Vars) (*Replayer, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replayer{Vars: vars}
+	if err = json.Unmarshal(data, &r.interactions); err != nil {
+		return nil, err
+	}
+	r.used = make([]bool, len(r.interactions))
+	return r, nil
+}
+
+// Exec implements gms.GitClient
+func (r *Replayer) Exec(args ...string) (string, *gms.GitError) {
+	key := strings.Join(r.Vars.substituteAll(args), "\x00")
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, recorded := range r.interactions {
+		if r.used[i] || strings.Join(recorded.Args, "\x00") != key {
+			continue
+		}
+		r.used[i] = true
+		out := r.Vars.expand(recorded.Stdout)
+		switch {
+		case recorded.ExitCode != 0:
+			return out, &gms.GitError{Output: r.Vars.expand(recorded.Stderr), Err: &ExitError{Code: recorded.ExitCode}}
+		case recorded.Error != "":
+			return out, &gms.GitError{Output: r.Vars.expand(recorded.Stderr), Err: errors.New(r.Vars.expand(recorded.Error))}
+		}
+		return out, nil
+	}
+	return "", &gms.GitError{Output: "git " + strings.Join(args, " "), Err: ErrUnexpectedCall}
+}
+
+// Remaining returns the number of interactions not replayed yet
+func (r *Replayer) Remaining() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n := 0
+	for _, used := range r.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// RecordOrReplay returns a client recording real git commands into
+// fixture fn when RecordEnv is set, saved when the test finishes,
+// otherwise a client replaying fn
+func RecordOrReplay(t testing.TB, fn string, vars Vars) gms.GitClient {
+	t.Helper()
+	if os.Getenv(RecordEnv) != "" {
+		r := &Recorder{Client: &gms.GitCmd{Program: gms.DefaultGitCmd}, Vars: vars}
+		t.Cleanup(func() {
+			if err := r.Save(fn); err != nil {
+				t.Errorf("save %s: %v", fn, err)
+			}
+		})
+		return r
+	}
+	r, err := LoadReplayer(fn, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
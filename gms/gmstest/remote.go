@@ -0,0 +1,170 @@
+package gmstest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// FakeRepoType is the type of FakeRemoteRepo in persistent handles
+const FakeRepoType = "gmstest.fake"
+
+// FakeRemoteRepo is an in-memory remote repository, Sync replaces
+// content of dir with Files
+type FakeRemoteRepo struct {
+	// ID identifies the repo in persistent handle
+	ID string `json:"id"`
+	// Path is the prefix in the repository
+	Path string `json:"path"`
+
+	lock  sync.Mutex
+	files map[string]string
+	err   error
+	syncs int
+}
+
+var (
+	fakeRepos     = make(map[string]*FakeRemoteRepo)
+	fakeReposLock sync.Mutex
+)
+
+// NewFakeRemoteRepo creates a fake remote repo with files, it's
+// registered by id so a RepoCache can restore it after Load
+func NewFakeRemoteRepo(id string, files map[string]string) *FakeRemoteRepo {
+	r := &FakeRemoteRepo{ID: id}
+	r.SetFiles(files)
+	fakeReposLock.Lock()
+	fakeRepos[id] = r
+	fakeReposLock.Unlock()
+	return r
+}
+
+func init() {
+	gms.RepoFactories[FakeRepoType] = FakeRepoFactory
+}
+
+// FakeRepoFactory restores FakeRemoteRepo created by NewFakeRemoteRepo
+func FakeRepoFactory(h gms.PersistentHandle) (gms.Repository, error) {
+	if h.Type != FakeRepoType {
+		return nil, nil
+	}
+	var persisted FakeRemoteRepo
+	if err := json.Unmarshal([]byte(h.Opaque), &persisted); err != nil {
+		return nil, err
+	}
+	fakeReposLock.Lock()
+	defer fakeReposLock.Unlock()
+	if r := fakeRepos[persisted.ID]; r != nil {
+		return r, nil
+	}
+	return nil, gms.ErrRepoNotFound
+}
+
+// SetFiles replaces the remote content
+func (r *FakeRemoteRepo) SetFiles(files map[string]string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.files = make(map[string]string, len(files))
+	for name, content := range files {
+		r.files[name] = content
+	}
+}
+
+// SetError makes following syncs fail with err, nil to succeed again
+func (r *FakeRemoteRepo) SetError(err error) {
+	r.lock.Lock()
+	r.err = err
+	r.lock.Unlock()
+}
+
+// Syncs returns the number of Sync calls
+func (r *FakeRemoteRepo) Syncs() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.syncs
+}
+
+// BasePath implements gms.Repository
+func (r *FakeRemoteRepo) BasePath() string {
+	return r.Path
+}
+
+// Persist implements gms.Repository
+func (r *FakeRemoteRepo) Persist() gms.PersistentHandle {
+	encoded, _ := json.Marshal(r)
+	return gms.PersistentHandle{Type: FakeRepoType, Opaque: string(encoded)}
+}
+
+// Sync implements gms.RemoteRepo
+func (r *FakeRemoteRepo) Sync(dir string) error {
+	return r.SyncContext(context.Background(), dir)
+}
+
+// SyncContext implements gms.ContextRemoteRepo
+func (r *FakeRemoteRepo) SyncContext(ctx context.Context, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.syncs++
+	if r.err != nil {
+		return r.err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return WriteFiles(dir, r.files)
+}
+
+// VersionAt implements gms.VersionedRemoteRepo, the version is the
+// digest of content in dir so it follows dir when moved, e.g. by
+// AtomicSync
+func (r *FakeRemoteRepo) VersionAt(dir string) (string, error) {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	files := make(map[string]string)
+	err = filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(fn)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return digest(files), nil
+}
+
+// digest hashes files keyed by slash-separated relative path
+func digest(files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(filepath.ToSlash(name) + "\x00" + files[name] + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
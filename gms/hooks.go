@@ -0,0 +1,145 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// SyncEvent describes a sync passed to hooks
+type SyncEvent struct {
+	// Repo is the name of cached repo
+	Repo string
+	// Dir is the local clone
+	Dir string
+	// OldVersion is the version before sync, empty if not synced before
+	OldVersion string
+	// NewVersion is the version after sync, empty for pre-sync hooks
+	NewVersion string
+}
+
+// SyncHook is a callback around Sync, an error from pre-sync hook
+// aborts the sync and an error from post-sync hook fails the sync
+type SyncHook func(ctx context.Context, ev *SyncEvent) error
+
+// RepoHooks are shell commands run around Sync of a repo, they receive
+// the event in environment variables GMS_REPO, GMS_DIR, GMS_OLD_VERSION
+// and GMS_NEW_VERSION and run inside the local clone if it exists
+type RepoHooks struct {
+	PreSync  []string `json:",omitempty"`
+	PostSync []string `json:",omitempty"`
+}
+
+// IsEmpty returns true if no hook is set
+func (h *RepoHooks) IsEmpty() bool {
+	return h == nil || (len(h.PreSync) == 0 && len(h.PostSync) == 0)
+}
+
+// HookError is the failure of a hook command
+type HookError struct {
+	// Command is the hook command line
+	Command string
+	// Output is combined stdout and stderr of the command
+	Output string
+	Err    error
+}
+
+func (e *HookError) Error() string {
+	return "hook " + e.Command + ": " + e.Err.Error() + ":\n" + e.Output
+}
+
+// Unwrap returns the underlying error
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// SetHooks updates hook commands of a cached repo
+func (c *RepoCache) SetHooks(name string, hooks *RepoHooks) error {
+	repo := c.repos[name]
+	if repo == nil {
+		return ErrRepoNotFound
+	}
+	if hooks.IsEmpty() {
+		hooks = nil
+	}
+	oldHooks := repo.Meta.Hooks
+	repo.Meta.Hooks = hooks
+	if err := c.saveRepo(name); err != nil {
+		repo.Meta.Hooks = oldHooks
+		return err
+	}
+	return nil
+}
+
+// hasHooks checks if any hook needs to run around sync
+func (r *CachedRepo) hasHooks() bool {
+	return len(r.PreSync) > 0 || len(r.PostSync) > 0 || !r.Meta.Hooks.IsEmpty()
+}
+
+// runPreSync runs callbacks and then commands
+func (r *CachedRepo) runPreSync(ctx context.Context, ev *SyncEvent) error {
+	for _, hook := range r.PreSync {
+		if err := hook(ctx, ev); err != nil {
+			return err
+		}
+	}
+	if r.Meta.Hooks != nil {
+		return r.runHookCommands(ctx, r.Meta.Hooks.PreSync, ev)
+	}
+	return nil
+}
+
+// runPostSync runs commands and then callbacks
+func (r *CachedRepo) runPostSync(ctx context.Context, ev *SyncEvent) error {
+	if r.Meta.Hooks != nil {
+		if err := r.runHookCommands(ctx, r.Meta.Hooks.PostSync, ev); err != nil {
+			return err
+		}
+	}
+	for _, hook := range r.PostSync {
+		if err := hook(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *CachedRepo) runHookCommands(ctx context.Context, commands []string, ev *SyncEvent) error {
+	for _, command := range commands {
+		cmd := hookCommand(ctx, command)
+		cmd.Env = append(append([]string{}, os.Environ()...),
+			"GMS_REPO="+ev.Repo,
+			"GMS_DIR="+ev.Dir,
+			"GMS_OLD_VERSION="+ev.OldVersion,
+			"GMS_NEW_VERSION="+ev.NewVersion)
+		cmd.Env = append(cmd.Env, RepoEnvFromContext(ctx).environ()...)
+		if info, err := os.Stat(ev.Dir); err == nil && info.IsDir() {
+			cmd.Dir = ev.Dir
+		}
+		var out bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &out, &out
+		if err := cmd.Run(); err != nil {
+			logf(r.Logger, "sync %s: hook %s: failed: %v", r.Name, command, err)
+			return &HookError{Command: command, Output: out.String(), Err: err}
+		}
+		logf(r.Logger, "sync %s: hook %s: done", r.Name, command)
+	}
+	return nil
+}
+
+// hookCommand runs command using the shell of the platform
+func hookCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+// planHooks records hook commands to DryRun
+func (r *CachedRepo) planHooks(commands []string) {
+	for _, command := range commands {
+		r.DryRun.record(PlannedAction{Op: PlanExec, Args: hookCommand(context.Background(), command).Args})
+	}
+}
@@ -0,0 +1,114 @@
+package gms
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HostLimiter caps concurrent syncs and the rate of syncs per remote
+// host, so caches with many repos from one host don't trip its rate
+// limits. It's safe for concurrent use
+type HostLimiter struct {
+	// MaxConcurrent is the max syncs in progress per host, unlimited if
+	// not positive
+	MaxConcurrent int
+	// RequestsPerMinute is the max syncs started per host in a minute,
+	// they are spread evenly. Unlimited if not positive
+	RequestsPerMinute int
+
+	lock  sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState tracks syncs of one host
+type hostState struct {
+	slots chan struct{}
+	next  time.Time
+}
+
+func (l *HostLimiter) state(host string) *hostState {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.hosts == nil {
+		l.hosts = make(map[string]*hostState)
+	}
+	s := l.hosts[host]
+	if s == nil {
+		s = &hostState{}
+		if l.MaxConcurrent > 0 {
+			s.slots = make(chan struct{}, l.MaxConcurrent)
+		}
+		l.hosts[host] = s
+	}
+	return s
+}
+
+// Acquire blocks until a sync with host is allowed or ctx is done, the
+// returned func must be called when the sync finishes. Empty host,
+// e.g. of local repos, is not limited
+func (l *HostLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	if l == nil || host == "" {
+		return func() {}, nil
+	}
+	s := l.state(host)
+	release := func() {}
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+			release = func() { <-s.slots }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if delay := l.reserve(s); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// reserve schedules a request of s and returns how long to wait for it
+func (l *HostLimiter) reserve(s *hostState) time.Duration {
+	if l.RequestsPerMinute <= 0 {
+		return 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+	s.next = at.Add(time.Minute / time.Duration(l.RequestsPerMinute))
+	return at.Sub(now)
+}
+
+// remoteHost returns the host of remote, empty for local repos
+func remoteHost(remote RemoteRepo) string {
+	u, err := ParseRepoURL(remoteLocation(remote))
+	if err != nil || u.Protocol == "file" {
+		return ""
+	}
+	return u.Host
+}
+
+type hostLimiterKey struct{}
+
+// WithHostLimiter returns ctx carrying l, syncs of cached repos are
+// limited per host with it
+func WithHostLimiter(ctx context.Context, l *HostLimiter) context.Context {
+	return context.WithValue(ctx, hostLimiterKey{}, l)
+}
+
+// HostLimiterFromContext returns the host limiter in ctx, or nil
+func HostLimiterFromContext(ctx context.Context) *HostLimiter {
+	l, _ := ctx.Value(hostLimiterKey{}).(*HostLimiter)
+	return l
+}
@@ -0,0 +1,211 @@
+package gms
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gitCredentialHelper answers git credential requests from the
+// environment of the repo, a token is sent with the username of the
+// remote URL or "token". Requests of other hosts than the remote, e.g.
+// of submodules, are not answered
+const gitCredentialHelper = `!f() { test "$1" = get || return 0; u="$GMS_GIT_USERNAME"; h=; ` +
+	`while read -r l && test -n "$l"; do case "$l" in username=*) test -n "$u" || u="${l#username=}";; host=*) h="${l#host=}";; esac; done; ` +
+	`test "$(printf %s "${h%:*}" | tr A-Z a-z)" = "$GMS_GIT_HOST" || return 0; ` +
+	`echo "username=${u:-token}"; echo "password=$GMS_GIT_PASSWORD"; }; f`
+
+// RepoIdentity scopes environment and credentials to a cached repo, so
+// repos of a cache can use different identities
+type RepoIdentity struct {
+	// Env are environment variables of git commands and hooks
+	Env map[string]string `json:",omitempty"`
+	// Secrets maps environment variables to names of secrets resolved
+	// by SecretProvider at sync time
+	Secrets map[string]string `json:",omitempty"`
+	// Credential names the secret authenticating to the remote, either
+	// USER:PASSWORD or a token. Git uses it instead of configured
+	// credential helpers, archives send it as basic or bearer auth. It
+	// is only sent to the host of the remote URL
+	Credential string `json:",omitempty"`
+	// SSHKey names the secret of a private key ssh remotes are accessed
+	// with, it's written to a temporary file during the sync
+	SSHKey string `json:",omitempty"`
+}
+
+// IsEmpty returns true if nothing is scoped
+func (id *RepoIdentity) IsEmpty() bool {
+	return id == nil || (len(id.Env) == 0 && len(id.Secrets) == 0 && id.Credential == "" && id.SSHKey == "")
+}
+
+// RepoEnv is the resolved environment of a repo carried by the context
+// of its sync
+type RepoEnv struct {
+	// Env are KEY=VALUE environment variables
+	Env []string
+	// GitConfig are KEY=VALUE git config in order, like git -c
+	GitConfig []string
+	// Username and Password authenticate to the remote if Password is
+	// not empty, a token has no Username
+	Username string
+	Password string
+	// SSHKeyFile is the private key git uses for ssh remotes if not empty
+	SSHKeyFile string
+}
+
+type repoEnvKey struct{}
+
+// WithRepoEnv returns ctx carrying env, git commands and hooks run with it
+func WithRepoEnv(ctx context.Context, env *RepoEnv) context.Context {
+	return context.WithValue(ctx, repoEnvKey{}, env)
+}
+
+// RepoEnvFromContext returns the repo environment in ctx, or nil
+func RepoEnvFromContext(ctx context.Context) *RepoEnv {
+	env, _ := ctx.Value(repoEnvKey{}).(*RepoEnv)
+	return env
+}
+
+// environ returns the environment variables of env, nil safe
+func (env *RepoEnv) environ() []string {
+	if env == nil {
+		return nil
+	}
+	return env.Env
+}
+
+// Close removes the temporary key file, nil safe
+func (env *RepoEnv) Close() error {
+	if env == nil || env.SSHKeyFile == "" {
+		return nil
+	}
+	return os.Remove(env.SSHKeyFile)
+}
+
+// sshCommand adds SSHKeyFile to the ssh command of git
+func (env *RepoEnv) sshCommand(cmd string) string {
+	if cmd == "" {
+		cmd = "ssh"
+	}
+	return cmd + " -i " + shellQuote(filepath.ToSlash(env.SSHKeyFile)) + " -o IdentitiesOnly=yes"
+}
+
+// SetIdentity scopes environment and credentials to a repo, nil clears
+func (c *RepoCache) SetIdentity(name string, id *RepoIdentity) error {
+	repo := c.repos[name]
+	if repo == nil {
+		return ErrRepoNotFound
+	}
+	if id.IsEmpty() {
+		id = nil
+	}
+	oldIdentity := repo.Meta.Identity
+	repo.Meta.Identity = id
+	if err := c.saveRepo(name); err != nil {
+		repo.Meta.Identity = oldIdentity
+		return err
+	}
+	return nil
+}
+
+// repoEnv resolves the identity of the repo, nil if none. It must be
+// closed after use
+func (r *CachedRepo) repoEnv(ctx context.Context) (*RepoEnv, error) {
+	id := r.Meta.Identity
+	if id.IsEmpty() {
+		return nil, nil
+	}
+	env := &RepoEnv{}
+	if err := env.resolve(ctx, r, id); err != nil {
+		env.Close()
+		return nil, err
+	}
+	return env, nil
+}
+
+// resolve fills env with the identity of r
+func (env *RepoEnv) resolve(ctx context.Context, r *CachedRepo, id *RepoIdentity) error {
+	for _, key := range sortedKeys(id.Env) {
+		env.Env = append(env.Env, key+"="+id.Env[key])
+	}
+	for _, key := range sortedKeys(id.Secrets) {
+		value, err := r.secret(ctx, id.Secrets[key])
+		if err != nil {
+			return err
+		}
+		env.Env = append(env.Env, key+"="+value)
+	}
+	if id.Credential != "" {
+		value, err := r.secret(ctx, id.Credential)
+		if err != nil {
+			return err
+		}
+		if user, password, ok := strings.Cut(value, ":"); ok {
+			env.Username, env.Password = user, password
+		} else {
+			env.Password = value
+		}
+		env.Env = append(env.Env, "GMS_GIT_USERNAME="+env.Username, "GMS_GIT_PASSWORD="+env.Password,
+			"GMS_GIT_HOST="+remoteHost(r.Remote))
+		// an empty helper drops helpers configured outside
+		env.GitConfig = append(env.GitConfig, "credential.helper=", "credential.helper="+gitCredentialHelper)
+	}
+	if id.SSHKey != "" {
+		key, err := r.secret(ctx, id.SSHKey)
+		if err != nil {
+			return err
+		}
+		if env.SSHKeyFile, err = writeKeyFile(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKeyFile writes a private key into a temporary file only readable
+// by the user
+func writeKeyFile(key string) (string, error) {
+	f, err := os.CreateTemp("", "gms-key-")
+	if err != nil {
+		return "", err
+	}
+	// ssh rejects keys without the final newline
+	if !strings.HasSuffix(key, "\n") {
+		key += "\n"
+	}
+	if _, err = f.WriteString(key); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// secret resolves a secret using Secrets
+func (r *CachedRepo) secret(ctx context.Context, name string) (string, error) {
+	if r.Secrets == nil {
+		return "", fmt.Errorf("%s: %w", name, ErrNoSecretProvider)
+	}
+	value, err := r.Secrets.Secret(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// sortedKeys returns keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,133 @@
+package gms
+
+import (
+	"crypto"
+	_ "crypto/sha256" // for crypto.SHA256
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ManifestFile is the filename of content manifest in meta dir
+	ManifestFile = "manifest.json"
+)
+
+var (
+	// ErrNoManifest indicates no content manifest has been recorded
+	ErrNoManifest = errors.New("no content manifest recorded")
+)
+
+// ContentManifest records digests of all files in a local clone
+type ContentManifest struct {
+	// Version is the content version when manifest is built
+	Version string `json:"version,omitempty"`
+	// Files maps slash-separated relative path to digest
+	Files map[string]string `json:"files"`
+}
+
+// IntegrityError lists the paths failing verification
+type IntegrityError struct {
+	// Modified are files whose content changed
+	Modified []string
+	// Added are files not present in the manifest
+	Added []string
+	// Removed are files missing from the local clone
+	Removed []string
+}
+
+func (e *IntegrityError) Error() string {
+	var parts []string
+	if len(e.Modified) > 0 {
+		parts = append(parts, "modified: "+strings.Join(e.Modified, ", "))
+	}
+	if len(e.Added) > 0 {
+		parts = append(parts, "added: "+strings.Join(e.Added, ", "))
+	}
+	if len(e.Removed) > 0 {
+		parts = append(parts, "removed: "+strings.Join(e.Removed, ", "))
+	}
+	return "content verification failed:\n" + strings.Join(parts, "\n")
+}
+
+// BuildContentManifest computes digests of all files under dir,
+// excluding VCS metadata
+func BuildContentManifest(dir string) (*ContentManifest, error) {
+	m, err := BuildManifest(&LocalRepo{BaseDir: dir}, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return &ContentManifest{Files: m.Files()}, nil
+}
+
+// Diff lists changes from m to other ordered by path
+func (m *ContentManifest) Diff(other *ContentManifest) []Change {
+	return diffFiles(m.Files, other.Files)
+}
+
+// Compare checks actual against m and returns IntegrityError on mismatch
+func (m *ContentManifest) Compare(actual *ContentManifest) error {
+	changes := m.Diff(actual)
+	if len(changes) == 0 {
+		return nil
+	}
+	e := &IntegrityError{}
+	for _, c := range changes {
+		switch c.Kind {
+		case ChangeAdded:
+			e.Added = append(e.Added, c.Path)
+		case ChangeModified:
+			e.Modified = append(e.Modified, c.Path)
+		case ChangeDeleted:
+			e.Removed = append(e.Removed, c.Path)
+		}
+	}
+	return e
+}
+
+// isVCSName checks if a file or directory of name is VCS metadata. A
+// .git directory or the .git file of a submodule or worktree is at any
+// depth, nested repositories are not content either
+func isVCSName(name string) bool {
+	return name == ".git"
+}
+
+// isVCSPath checks if dir is VCS metadata inside base, see isVCSName
+func isVCSPath(base, dir string) bool {
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if isVCSName(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordManifest computes and saves the content manifest of local clone
+func (r *CachedRepo) RecordManifest() error {
+	m, err := BuildContentManifest(r.localDir())
+	if err != nil {
+		return err
+	}
+	return saveJSON(filepath.Join(r.MetaDir, ManifestFile), m)
+}
+
+// Verify checks the local clone against recorded content manifest
+func (r *CachedRepo) Verify() error {
+	var expected ContentManifest
+	err := loadJSON(filepath.Join(r.MetaDir, ManifestFile), &expected)
+	if os.IsNotExist(err) {
+		return ErrNoManifest
+	} else if err != nil {
+		return err
+	}
+	actual, err := BuildContentManifest(r.localDir())
+	if err != nil {
+		return err
+	}
+	return expected.Compare(actual)
+}
@@ -0,0 +1,58 @@
+package gms_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+	"github.com/codingbrain/gms/gms/gmstest"
+)
+
+func TestVerify(t *testing.T) {
+	c := gmstest.TempCache(t)
+	c.Integrity = true
+	remote := gmstest.NewFakeRemoteRepo(t.Name(), map[string]string{
+		"a.txt":     "a",
+		"dir/b.txt": "b",
+		"c.txt":     "c",
+	})
+	r, err := c.Add("r", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Verify(); !errors.Is(err, gms.ErrNoManifest) {
+		t.Fatalf("before sync: got %v, want %v", err, gms.ErrNoManifest)
+	}
+	if err = r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	dir := r.BasePath()
+	// VCS metadata at any depth isn't content
+	if err = gmstest.WriteFiles(dir, map[string]string{".git/HEAD": "x", "dir/sub/.git": "gitdir: x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Verify(); err != nil {
+		t.Fatalf("VCS metadata: %v", err)
+	}
+	if err = gmstest.WriteFiles(dir, map[string]string{"a.txt": "tampered", "new.txt": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Verify()
+	var ierr *gms.IntegrityError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("got %v, want IntegrityError", err)
+	}
+	want := &gms.IntegrityError{Modified: []string{"a.txt"}, Added: []string{"new.txt"}, Removed: []string{"c.txt"}}
+	if !reflect.DeepEqual(ierr, want) {
+		t.Errorf("got %+v, want %+v", ierr, want)
+	}
+}
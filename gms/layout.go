@@ -0,0 +1,84 @@
+package gms
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultCacheDir returns the conventional cache directory of the user,
+// gms under XDG_CACHE_HOME or ~/.cache on Unix, ~/Library/Caches on
+// macOS and %LocalAppData% on Windows, or .gms if none is known
+func DefaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "gms")
+	}
+	return ".gms"
+}
+
+// RepoLayout maps a repo to the slash-separated path of its local clone
+// relative to the repos directory of the cache
+type RepoLayout func(name string, remote RemoteRepo) string
+
+// FlatLayout places clones directly in the repos directory by name
+func FlatLayout(name string, remote RemoteRepo) string {
+	return name
+}
+
+// HostLayout nests clones by remote host, e.g. github.com/NAME, clones of
+// local or unknown remotes are placed by name
+func HostLayout(name string, remote RemoteRepo) string {
+	if host := remoteHost(remote); host != "" {
+		return path.Join(strings.ReplaceAll(host, ":", "_"), name)
+	}
+	return name
+}
+
+// reposDir returns the sub-directory of BaseDir containing clones
+func (c *RepoCache) reposDir() string {
+	if c.ReposDir != "" {
+		return c.ReposDir
+	}
+	return CacheReposDir
+}
+
+// localDir returns the local clone of a repo according to Layout, a
+// layout path escaping the repos directory falls back to name
+func (c *RepoCache) localDir(name string, remote RemoteRepo) string {
+	base := filepath.Join(c.BaseDir, c.reposDir())
+	if c.Layout != nil {
+		rel := c.Layout(name, remote)
+		if dir, err := SafeJoin(base, rel); err == nil && dir != base {
+			return dir
+		}
+		logf(c.Logger, "cache %s: invalid layout path %q of %s", c.BaseDir, rel, name)
+	}
+	return filepath.Join(base, name)
+}
+
+// findClones lists directories under dir containing .git, a clone's
+// sub-directories are not searched
+func findClones(dir string) ([]string, error) {
+	var clones []string
+	err := filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fn == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if fn == dir || (!d.IsDir() && d.Type()&fs.ModeSymlink == 0) {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(fn, ".git")); err == nil {
+			clones = append(clones, fn)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	return clones, err
+}
@@ -0,0 +1,113 @@
+package gms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats of RenderRepoInfos
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+var (
+	// ErrUnsupportedFormat indicates the output format is unknown
+	ErrUnsupportedFormat = errors.New("unsupported output format")
+)
+
+// RepoInfo describes a cached repo for listings
+type RepoInfo struct {
+	Name     string    `json:"name" yaml:"name"`
+	Type     string    `json:"type" yaml:"type"`
+	URL      string    `json:"url,omitempty" yaml:"url,omitempty"`
+	Ref      string    `json:"ref,omitempty" yaml:"ref,omitempty"`
+	Version  string    `json:"version,omitempty" yaml:"version,omitempty"`
+	LocalDir string    `json:"localDir" yaml:"localDir"`
+	LastSync time.Time `json:"lastSync" yaml:"lastSync,omitempty"`
+	// Size is the disk usage in bytes of the local clone
+	Size int64 `json:"size" yaml:"size"`
+	// Error is set if the info is incomplete
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Info collects listing information of the cached repo
+func (r *CachedRepo) Info() RepoInfo {
+	info := RepoInfo{
+		Name:     r.Name,
+		Type:     r.Persist().Type,
+		URL:      repoURL(r.Remote),
+		LocalDir: r.LocalDir,
+	}
+	stats, err := r.Stats()
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.LastSync = stats.LastSync
+	info.Size = stats.DiskUsage
+	if stats.LastSync.IsZero() {
+		return info
+	}
+	if state, err := r.State(); err == nil {
+		info.Version = state.Version
+	}
+	if refRepo, ok := r.Remote.(RefRepo); ok {
+		info.Ref, _ = refRepo.RefAt(r.LocalDir)
+	}
+	return info
+}
+
+// List collects listing information of all cached repos ordered by name
+func (c *RepoCache) List() []RepoInfo {
+	infos := make([]RepoInfo, 0, len(c.repos))
+	for _, name := range c.RepoNames() {
+		infos = append(infos, c.repos[name].Info())
+	}
+	return infos
+}
+
+// repoURL returns the location of remote repo if known
+func repoURL(remote RemoteRepo) string {
+	if git, ok := remote.(*GitRepo); ok {
+		return git.URL
+	}
+	return ""
+}
+
+// RenderRepoInfos writes infos to w in format table, json or yaml
+func RenderRepoInfos(w io.Writer, infos []RepoInfo, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	case FormatYAML:
+		encoded, err := yaml.Marshal(infos)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(encoded)
+		return err
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tTYPE\tREF\tLAST SYNC\tSIZE\tURL")
+		for _, info := range infos {
+			lastSync := "never"
+			if !info.LastSync.IsZero() {
+				lastSync = info.LastSync.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n",
+				info.Name, info.Type, info.Ref, lastSync, info.Size, info.URL)
+		}
+		return tw.Flush()
+	}
+	return ErrUnsupportedFormat
+}
@@ -20,7 +20,7 @@ type LocalRepo struct {
 
 // BasePath implements Repository
 func (r *LocalRepo) BasePath() string {
-	return filepath.Join(r.BaseDir, r.Path)
+	return filepath.Join(r.BaseDir, filepath.FromSlash(r.Path))
 }
 
 // Persist implements Repository
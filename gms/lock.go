@@ -0,0 +1,195 @@
+package gms
+
+import (
+	"context"
+	"errors"
+
+	"github.com/codingbrain/clix.go/clix"
+)
+
+const (
+	// LockFile is the default filename of lock file
+	LockFile = "gms.lock"
+)
+
+var (
+	// ErrNotPinnable indicates the repo can't be restored to a version
+	ErrNotPinnable = errors.New("repository can't be pinned to a version")
+)
+
+// PinnableRepo is a remote repository which can restore synced content
+// to a specific version
+type PinnableRepo interface {
+	RemoteRepo
+	Checkout(dir, version string) error
+}
+
+// RefRepo is a remote repository able to report the ref synced into dir
+type RefRepo interface {
+	RemoteRepo
+	RefAt(dir string) (string, error)
+}
+
+// LockEntry records the resolved state of a cached repo
+type LockEntry struct {
+	// Name of the cached repo
+	Name string `json:"name"`
+	// Remote is the persistent handle of the remote repo
+	Remote PersistentHandle `json:"remote"`
+	// Version is the commit Id or content digest
+	Version string `json:"version"`
+}
+
+// Lock records resolved versions of cached repos
+type Lock struct {
+	// Repos are lock entries ordered by name
+	Repos []LockEntry `json:"repos"`
+}
+
+// Lock builds a lock from current content of all cached repos
+func (c *RepoCache) Lock() (*Lock, error) {
+	lock := &Lock{}
+	for _, name := range c.RepoNames() {
+		repo := c.repos[name]
+		version, err := repo.Version()
+		if err != nil {
+			return nil, err
+		}
+		lock.Repos = append(lock.Repos, LockEntry{Name: name, Remote: repo.Persist(), Version: version})
+	}
+	return lock, nil
+}
+
+// WriteLockFile saves lock of all cached repos into file
+func (c *RepoCache) WriteLockFile(fn string) error {
+	lock, err := c.Lock()
+	if err != nil {
+		return err
+	}
+	return saveJSON(fn, lock)
+}
+
+// ReadLockFile loads a lock from file
+func ReadLockFile(fn string) (*Lock, error) {
+	lock := &Lock{}
+	if err := loadJSON(fn, lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// SyncFromLock adds repos missing from the cache, syncs all repos in
+// the lock and restores the exact locked versions
+func (c *RepoCache) SyncFromLock(ctx context.Context, lock *Lock) error {
+	var errs clix.AggregatedError
+	for _, entry := range lock.Repos {
+		if errs.Add(ctx.Err()) {
+			break
+		}
+		errs.Add(c.syncLockEntry(ctx, &entry))
+	}
+	return errs.Aggregate()
+}
+
+func (c *RepoCache) syncLockEntry(ctx context.Context, entry *LockEntry) error {
+	repo := c.repos[entry.Name]
+	if repo == nil {
+		f := RepoFactories[entry.Remote.Type]
+		if f == nil {
+			return ErrUnsupportedRepoType
+		}
+		r, err := f(entry.Remote)
+		if err != nil {
+			return err
+		}
+		remote, ok := r.(RemoteRepo)
+		if !ok {
+			return ErrUnsupportedRepoType
+		}
+		if repo, err = c.Add(entry.Name, remote); err != nil {
+			return err
+		}
+	}
+	return pinVersion(ctx, repo, entry.Version)
+}
+
+// pinVersion syncs repo unless it's at version, and checks out version
+// if the synced content is not at it
+func pinVersion(ctx context.Context, repo *CachedRepo, version string) error {
+	if current, err := repo.Version(); err == nil && current == version {
+		return nil
+	}
+	if err := repo.SyncContext(ctx); err != nil {
+		return err
+	}
+	if current, err := repo.Version(); err == nil && current == version {
+		return nil
+	}
+	pr, ok := repo.Remote.(PinnableRepo)
+	if !ok {
+		return ErrNotPinnable
+	}
+	return repo.checkoutVersion(ctx, pr, version)
+}
+
+// checkoutVersion restores content to version and records derived
+// metadata like updateContent. Content changed in place is locked
+// exclusively, with AtomicSync a staged copy is checked out and swapped in
+func (r *CachedRepo) checkoutVersion(ctx context.Context, pr PinnableRepo, version string) error {
+	if !r.AtomicSync {
+		lock, err := lockContent(ctx, r.contentLockFile(), true)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+	var err error
+	work := r.LocalDir
+	if r.AtomicSync {
+		if work, err = r.stage(); err != nil {
+			return err
+		}
+		defer removeAll(work)
+	} else if err = r.removePathIndex(); err != nil {
+		return err
+	}
+	if err = unlockContent(work); err != nil {
+		return err
+	}
+	if r.ReadOnly {
+		defer func() {
+			if err := setReadOnly(r.localDir()); err != nil {
+				logf(r.Logger, "sync %s: read-only: %v", r.Name, err)
+			}
+		}()
+	}
+	if err = pr.Checkout(work, version); err != nil {
+		return err
+	}
+	if r.AtomicSync {
+		if err = r.swapIn(work); err != nil {
+			return err
+		}
+		r.pruneUnused()
+	}
+	if err = r.dedupe(); err != nil {
+		return err
+	}
+	if r.Integrity {
+		if err = r.RecordManifest(); err != nil {
+			return err
+		}
+	}
+	if err = r.updatePathIndex(); err != nil {
+		return err
+	}
+	if err = r.recordUsage(); err != nil {
+		return err
+	}
+	if _, ok := r.Remote.(ChangeListingRepo); !ok {
+		if err = r.recordSnapshot(); err != nil {
+			return err
+		}
+	}
+	return r.updateState(func(s *SyncState) { s.Version = version })
+}
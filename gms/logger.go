@@ -0,0 +1,17 @@
+package gms
+
+import (
+	"fmt"
+)
+
+// Logger receives diagnostic messages, *log.Logger implements it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf writes to l if it is not nil, credentials in URLs are redacted
+func logf(l Logger, format string, v ...interface{}) {
+	if l != nil {
+		l.Printf("%s", RedactURL(fmt.Sprintf(format, v...)))
+	}
+}
@@ -0,0 +1,157 @@
+package gms
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// RefHeadsPrefix is the prefix of branch refs
+	RefHeadsPrefix = "refs/heads/"
+	// RefTagsPrefix is the prefix of tag refs
+	RefTagsPrefix = "refs/tags/"
+	// peeledSuffix marks the commit an annotated tag points to
+	peeledSuffix = "^{}"
+)
+
+var (
+	// ErrInvalidLsRemote indicates ls-remote output is malformed
+	ErrInvalidLsRemote = errors.New("invalid ls-remote output")
+	// ErrRefNotFound indicates the remote doesn't have the ref
+	ErrRefNotFound = errors.New("ref not found")
+)
+
+// RemoteRef is a ref advertised by a remote
+type RemoteRef struct {
+	// Name is the full ref name, e.g. refs/heads/main or HEAD
+	Name string `json:"name"`
+	// ID is the object the ref points to, the tag object of annotated tags
+	ID CommitID `json:"id"`
+	// Peeled is the commit an annotated tag points to
+	Peeled CommitID `json:"peeled,omitempty"`
+	// Target is the ref a symbolic ref points to, e.g. refs/heads/main
+	// for HEAD, only reported with ls-remote --symref
+	Target string `json:"target,omitempty"`
+}
+
+// Commit returns the commit of the ref, peeled for annotated tags
+func (r *RemoteRef) Commit() CommitID {
+	if r.Peeled != "" {
+		return r.Peeled
+	}
+	return r.ID
+}
+
+// RemoteRefs are refs advertised by a remote in the order of ls-remote
+type RemoteRefs []RemoteRef
+
+// ParseLsRemote parses the output of git ls-remote, with or without
+// --symref. Peeled lines of annotated tags are folded into the tags
+func ParseLsRemote(out string) (RemoteRefs, error) {
+	var refs RemoteRefs
+	index := make(map[string]int)
+	targets := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimRight(line, "\r"); line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || fields[1] == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidLsRemote, line)
+		}
+		if target := strings.TrimPrefix(fields[0], "ref: "); target != fields[0] {
+			targets[fields[1]] = target
+			continue
+		}
+		id, err := ParseSHA(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLsRemote, err)
+		}
+		if name := strings.TrimSuffix(fields[1], peeledSuffix); name != fields[1] {
+			if i, ok := index[name]; ok {
+				refs[i].Peeled = id
+			}
+			continue
+		}
+		index[fields[1]] = len(refs)
+		refs = append(refs, RemoteRef{Name: fields[1], ID: id})
+	}
+	for name, target := range targets {
+		if i, ok := index[name]; ok {
+			refs[i].Target = target
+		} else {
+			// symref to an unborn branch
+			refs = append(refs, RemoteRef{Name: name, Target: target})
+		}
+	}
+	return refs, nil
+}
+
+// Find returns the ref of full name, or nil
+func (refs RemoteRefs) Find(name string) *RemoteRef {
+	for i := range refs {
+		if refs[i].Name == name {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+// Resolve finds the commit of name, which is a full ref name, a branch
+// or a tag, in the order git resolves ambiguous names
+func (refs RemoteRefs) Resolve(name string) (CommitID, error) {
+	for _, candidate := range []string{name, RefTagsPrefix + name, RefHeadsPrefix + name} {
+		if ref := refs.Find(candidate); ref != nil && ref.ID != "" {
+			return ref.Commit(), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrRefNotFound, name)
+}
+
+// Head returns the branch HEAD points to, e.g. main, or empty if the
+// remote doesn't report it, which requires ls-remote --symref
+func (refs RemoteRefs) Head() string {
+	if ref := refs.Find("HEAD"); ref != nil {
+		return strings.TrimPrefix(ref.Target, RefHeadsPrefix)
+	}
+	return ""
+}
+
+// Branches lists names of branches without refs/heads/
+func (refs RemoteRefs) Branches() []string {
+	return refs.names(RefHeadsPrefix)
+}
+
+// Tags lists names of tags without refs/tags/
+func (refs RemoteRefs) Tags() []string {
+	return refs.names(RefTagsPrefix)
+}
+
+func (refs RemoteRefs) names(prefix string) []string {
+	var names []string
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, prefix) {
+			names = append(names, ref.Name[len(prefix):])
+		}
+	}
+	return names
+}
+
+// lsRemote lists refs of remote including symbolic refs, patterns
+// limit the refs like git ls-remote does
+func lsRemote(client GitClient, remote string, patterns ...string) (RemoteRefs, error) {
+	out, err := client.Exec(append([]string{"ls-remote", "--symref", remote}, patterns...)...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseLsRemote(out)
+}
+
+// ListRefs lists refs advertised by Remote, the remote policy is checked
+func (r *GitRepo) ListRefs(patterns ...string) (RemoteRefs, error) {
+	if err := checkRemote(r.RemotePolicy, r.Remote); err != nil {
+		return nil, err
+	}
+	return lsRemote(r.client(), r.Remote, patterns...)
+}
@@ -0,0 +1,131 @@
+package gms
+
+import (
+	"crypto"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestEntry describes a single file in Manifest
+type ManifestEntry struct {
+	// Path is slash-separated path relative to BasePath
+	Path string `json:"path"`
+	// Size is the size of the file, or length of link target
+	Size int64 `json:"size"`
+	// Mode is the file mode including type bits
+	Mode os.FileMode `json:"mode"`
+	// Hash is hex digest of file content, symbolic links are hashed
+	// as "link:" followed by link target
+	Hash string `json:"hash"`
+}
+
+// Manifest is a deterministic listing of repository content
+type Manifest struct {
+	// Algorithm is the name of hash algorithm
+	Algorithm string `json:"algorithm"`
+	// Entries are files ordered by path, directories are not included
+	Entries []ManifestEntry `json:"entries"`
+	// Digest is the tree digest computed from all entries
+	Digest string `json:"digest"`
+}
+
+// BuildManifest walks the repository and computes digests of all files
+// using hashAlgo, VCS metadata is excluded
+func BuildManifest(repo Repository, hashAlgo crypto.Hash) (*Manifest, error) {
+	if !hashAlgo.Available() {
+		return nil, ErrHashUnavailable
+	}
+	m := &Manifest{Algorithm: hashAlgo.String()}
+	w := &RepoWalker{
+		Hash: hashAlgo,
+		WalkerFn: func(item WalkingItem) error {
+			if item.FileInfo.IsDir() {
+				return nil
+			}
+			entry := ManifestEntry{
+				Path: item.RelPath,
+				Size: item.FileInfo.Size(),
+				Mode: item.FileInfo.Mode(),
+				Hash: item.Hash,
+			}
+			if entry.Mode&os.ModeSymlink != 0 && item.fsys == nil {
+				target, err := os.Readlink(item.prefix + filepath.Join(item.Path, item.Name))
+				if err != nil {
+					return err
+				}
+				h := hashAlgo.New()
+				io.WriteString(h, "link:"+target)
+				entry.Hash = hex.EncodeToString(h.Sum(nil))
+				entry.Size = int64(len(target))
+			}
+			m.Entries = append(m.Entries, entry)
+			return nil
+		},
+	}
+	w.Use(FilterVCS())
+	if err := w.Visit("", repo); err != nil {
+		return nil, err
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+	m.Digest = m.treeDigest(hashAlgo)
+	return m, nil
+}
+
+// treeDigest hashes all entries in order
+func (m *Manifest) treeDigest(hashAlgo crypto.Hash) string {
+	h := hashAlgo.New()
+	for _, entry := range m.Entries {
+		fmt.Fprintf(h, "%s %o %d %s\x00", entry.Hash, uint32(entry.Mode), entry.Size, entry.Path)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Files maps path to hash of all entries
+func (m *Manifest) Files() map[string]string {
+	files := make(map[string]string, len(m.Entries))
+	for _, entry := range m.Entries {
+		files[entry.Path] = entry.Hash
+	}
+	return files
+}
+
+// Diff lists changes from m to other ordered by path, an entry is
+// modified if hash or mode differs
+func (m *Manifest) Diff(other *Manifest) []Change {
+	if m.Digest != "" && m.Digest == other.Digest && m.Algorithm == other.Algorithm {
+		return nil
+	}
+	return diffFiles(m.keyed(), other.keyed())
+}
+
+func (m *Manifest) keyed() map[string]string {
+	files := make(map[string]string, len(m.Entries))
+	for _, entry := range m.Entries {
+		files[entry.Path] = fmt.Sprintf("%o:%s", uint32(entry.Mode), entry.Hash)
+	}
+	return files
+}
+
+// diffFiles compares maps from path to digest and lists changes
+// ordered by path
+func diffFiles(from, to map[string]string) []Change {
+	var changes []Change
+	for fn, digest := range to {
+		if expected, ok := from[fn]; !ok {
+			changes = append(changes, Change{Path: fn, Kind: ChangeAdded})
+		} else if expected != digest {
+			changes = append(changes, Change{Path: fn, Kind: ChangeModified})
+		}
+	}
+	for fn := range from {
+		if _, ok := to[fn]; !ok {
+			changes = append(changes, Change{Path: fn, Kind: ChangeDeleted})
+		}
+	}
+	sort.Sort(changesByPath(changes))
+	return changes
+}
@@ -0,0 +1,54 @@
+package gms
+
+// Names of metrics reported to Metrics
+const (
+	// MetricSyncAttempts counts syncs started, labeled by repo
+	MetricSyncAttempts = "gms_sync_attempts_total"
+	// MetricSyncFailures counts failed syncs, labeled by repo
+	MetricSyncFailures = "gms_sync_failures_total"
+	// MetricSyncSeconds observes sync durations, labeled by repo
+	MetricSyncSeconds = "gms_sync_duration_seconds"
+	// MetricSyncBytes counts bytes received by syncs, as reported by git
+	// progress or downloaded archives, labeled by repo
+	MetricSyncBytes = "gms_sync_bytes_total"
+	// MetricWalkSeconds observes walk durations, labeled by repo
+	MetricWalkSeconds = "gms_walk_duration_seconds"
+	// MetricCacheRepos is the number of cached repos
+	MetricCacheRepos = "gms_cache_repos"
+	// MetricCacheBytes is the disk usage of all local clones
+	MetricCacheBytes = "gms_cache_bytes"
+	// MetricScrubbedFiles counts files removed or quarantined by
+	// Scrubber, labeled by repo and reason
+	MetricScrubbedFiles = "gms_scrubbed_files_total"
+)
+
+// Labels are metric labels
+type Labels map[string]string
+
+// Metrics receives counters, gauges and observations
+type Metrics interface {
+	// Add increases a counter
+	Add(name string, delta float64, labels Labels)
+	// Set sets a gauge
+	Set(name string, value float64, labels Labels)
+	// Observe records a sample, durations are in seconds
+	Observe(name string, value float64, labels Labels)
+}
+
+func addMetric(m Metrics, name string, delta float64, labels Labels) {
+	if m != nil {
+		m.Add(name, delta, labels)
+	}
+}
+
+func setMetric(m Metrics, name string, value float64, labels Labels) {
+	if m != nil {
+		m.Set(name, value, labels)
+	}
+}
+
+func observeMetric(m Metrics, name string, value float64, labels Labels) {
+	if m != nil {
+		m.Observe(name, value, labels)
+	}
+}
@@ -0,0 +1,196 @@
+package gms
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingbrain/clix.go/clix"
+)
+
+const (
+	// RemoteHealthFile is the filename of remote health records in cache dir
+	RemoteHealthFile = "health.json"
+)
+
+// DefaultRemoteCooldown is used if RemoteHealth.Cooldown is zero
+var DefaultRemoteCooldown = 10 * time.Minute
+
+// RemoteHealth tracks failures of remotes, a remote failed within
+// Cooldown is tried after the healthy ones so a dead primary doesn't
+// add latency to every sync. It's safe for concurrent use
+type RemoteHealth struct {
+	// Path is the file persisting the records, nothing is persisted if empty
+	Path string
+	// Cooldown is how long a failed remote is deprioritized
+	Cooldown time.Duration
+
+	lock    sync.Mutex
+	loaded  bool
+	remotes map[string]*RemoteStatus
+}
+
+// RemoteStatus is the health record of a remote
+type RemoteStatus struct {
+	// Remote is the URL with credentials redacted
+	Remote string `json:"remote"`
+	// Failures is the number of consecutive failures
+	Failures int `json:"failures"`
+	// LastError is the error of last failure
+	LastError string `json:"error,omitempty"`
+	// LastFailure is the time of last failure
+	LastFailure time.Time `json:"failed"`
+	// LastSuccess is the time of last success
+	LastSuccess time.Time `json:"succeeded"`
+}
+
+// RemoteHealth returns the remote health records persisted in BaseDir
+func (c *RepoCache) RemoteHealth() *RemoteHealth {
+	if c.remoteHealth == nil {
+		c.remoteHealth = &RemoteHealth{Path: filepath.Join(c.BaseDir, RemoteHealthFile)}
+	}
+	return c.remoteHealth
+}
+
+func (h *RemoteHealth) cooldown() time.Duration {
+	if h.Cooldown > 0 {
+		return h.Cooldown
+	}
+	return DefaultRemoteCooldown
+}
+
+// load reads the persisted records once, must be called with lock held
+func (h *RemoteHealth) load() {
+	if h.loaded {
+		return
+	}
+	h.loaded = true
+	h.remotes = make(map[string]*RemoteStatus)
+	if h.Path != "" {
+		if err := loadJSON(h.Path, &h.remotes); err != nil && !os.IsNotExist(err) {
+			h.remotes = make(map[string]*RemoteStatus)
+		}
+	}
+}
+
+// save persists the records, must be called with lock held
+func (h *RemoteHealth) save() error {
+	if h.Path == "" {
+		return nil
+	}
+	return saveJSON(h.Path, h.remotes)
+}
+
+// Healthy tells if remote has not failed within Cooldown
+func (h *RemoteHealth) Healthy(remote string) bool {
+	if h == nil {
+		return true
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.load()
+	s := h.remotes[RedactURL(remote)]
+	return s == nil || s.Failures == 0 || time.Since(s.LastFailure) > h.cooldown()
+}
+
+// Report records the result of talking to remote
+func (h *RemoteHealth) Report(remote string, err error) error {
+	if h == nil {
+		return nil
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.load()
+	key := RedactURL(remote)
+	s := h.remotes[key]
+	if s == nil {
+		if err == nil {
+			return nil
+		}
+		s = &RemoteStatus{Remote: key}
+		h.remotes[key] = s
+	}
+	if err != nil {
+		s.Failures++
+		s.LastError = RedactURL(strings.TrimSpace(err.Error()))
+		s.LastFailure = time.Now()
+	} else {
+		if s.Failures == 0 {
+			return nil
+		}
+		s.Failures, s.LastError = 0, ""
+		s.LastSuccess = time.Now()
+	}
+	return h.save()
+}
+
+// Statuses lists the health records of remotes which have failed
+func (h *RemoteHealth) Statuses() []RemoteStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.load()
+	var statuses []RemoteStatus
+	for _, s := range h.remotes {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Remote < statuses[j].Remote })
+	return statuses
+}
+
+// Order returns remotes with healthy ones first, the order is
+// preserved within healthy and unhealthy ones
+func (h *RemoteHealth) Order(remotes []string) []string {
+	ordered := make([]string, 0, len(remotes))
+	var failed []string
+	for _, remote := range remotes {
+		if h.Healthy(remote) {
+			ordered = append(ordered, remote)
+		} else {
+			failed = append(failed, remote)
+		}
+	}
+	return append(ordered, failed...)
+}
+
+type remoteHealthKey struct{}
+
+// WithRemoteHealth returns ctx carrying h, remote repos with mirrors
+// order and report remotes with it
+func WithRemoteHealth(ctx context.Context, h *RemoteHealth) context.Context {
+	return context.WithValue(ctx, remoteHealthKey{}, h)
+}
+
+// RemoteHealthFromContext returns the remote health in ctx, or nil
+func RemoteHealthFromContext(ctx context.Context) *RemoteHealth {
+	h, _ := ctx.Value(remoteHealthKey{}).(*RemoteHealth)
+	return h
+}
+
+// tryRemotes calls fn with remotes ordered by health until one succeeds,
+// the results are reported to health. It stops when ctx is done
+func tryRemotes(ctx context.Context, h *RemoteHealth, logger Logger, remotes []string, fn func(remote string) error) error {
+	if len(remotes) == 1 {
+		return fn(remotes[0])
+	}
+	var errs clix.AggregatedError
+	for _, remote := range h.Order(remotes) {
+		err := fn(remote)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e := h.Report(remote, err); e != nil {
+			logf(logger, "remote health: %v", e)
+		}
+		if err == nil {
+			return nil
+		}
+		logf(logger, "remote %s: %v", RedactURL(remote), err)
+		errs.Add(fmt.Errorf("%s: %w", RedactURL(remote), err))
+	}
+	return errs.Aggregate()
+}
@@ -0,0 +1,213 @@
+package gms
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// DefaultModuleManifests are default file names of module manifests
+	DefaultModuleManifests = []string{"module.yml", "module.yaml", "module.json"}
+
+	// DefaultModuleParsers are default parsers by manifest file extension
+	DefaultModuleParsers = map[string]ModuleParser{
+		".json": ParseJSONModule,
+		".yml":  ParseYAMLModule,
+		".yaml": ParseYAMLModule,
+	}
+)
+
+// Module is a module discovered from a manifest file in a repository
+type Module struct {
+	// Name is the module name declared in manifest
+	Name string `json:"name" yaml:"name"`
+	// Version is the module version declared in manifest
+	Version string `json:"version" yaml:"version"`
+
+	// RepoName is the name of repository containing the module
+	RepoName string `json:"-" yaml:"-"`
+	// Repo is the repository containing the module
+	Repo Repository `json:"-" yaml:"-"`
+	// Path is slash-separated directory of the module relative to BasePath
+	Path string `json:"-" yaml:"-"`
+	// Manifest is the file name of the manifest
+	Manifest string `json:"-" yaml:"-"`
+	// Spec is the full content of the manifest
+	Spec map[string]interface{} `json:"-" yaml:"-"`
+}
+
+// ModuleParser parses manifest content into module
+type ModuleParser func(data []byte, m *Module) error
+
+// ParseJSONModule parses JSON module manifest
+func ParseJSONModule(data []byte, m *Module) error {
+	if err := json.Unmarshal(data, m); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &m.Spec)
+}
+
+// ParseYAMLModule parses YAML module manifest
+func ParseYAMLModule(data []byte, m *Module) error {
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, &m.Spec)
+}
+
+// ModuleIndex discovers modules across repositories. When the same
+// module name and version is found in multiple repositories, the one
+// from the repository added first takes precedence
+type ModuleIndex struct {
+	// ManifestNames are file names of manifests, DefaultModuleManifests if empty
+	ManifestNames []string
+	// Parsers are manifest parsers by file extension, DefaultModuleParsers if nil
+	Parsers map[string]ModuleParser
+
+	modules []*Module
+	byName  map[string][]*Module
+}
+
+// Build indexes all repos in the cache in resolution order
+func (x *ModuleIndex) Build(cache *RepoCache) error {
+	x.Reset()
+	for _, repo := range cache.ReposOrdered() {
+		if err := x.AddRepo(repo.Name, repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset clears the index
+func (x *ModuleIndex) Reset() {
+	x.modules = nil
+	x.byName = nil
+}
+
+// AddRepo walks a repository and indexes modules found, with lower
+// precedence than modules already indexed
+func (x *ModuleIndex) AddRepo(name string, repo Repository) error {
+	w := &RepoWalker{
+		WalkerFn: func(item WalkingItem) error {
+			if item.FileInfo.IsDir() || !x.isManifest(item.Name) {
+				return nil
+			}
+			fn := filepath.Join(item.Path, item.Name)
+			m := &Module{
+				RepoName: name,
+				Repo:     repo,
+				Path:     path.Dir(item.RelPath),
+				Manifest: item.Name,
+			}
+			data, err := ReadRepoFile(repo, filepath.FromSlash(item.RelPath))
+			if err != nil {
+				return err
+			}
+			if err = x.parser(fn)(data, m); err != nil {
+				return err
+			}
+			if m.Name == "" {
+				m.Name = defaultModuleName(name, m.Path)
+			}
+			x.add(m)
+			return nil
+		},
+	}
+	w.Use(FilterVCS())
+	return w.Visit(name, repo)
+}
+
+// defaultModuleName derives module name from directory, skipping
+// version directory like "foo/1.2.0"
+func defaultModuleName(repoName, dir string) string {
+	if _, err := ParseSemVer(path.Base(dir)); err == nil {
+		dir = path.Dir(dir)
+	}
+	if dir == "." {
+		return repoName
+	}
+	return path.Base(dir)
+}
+
+func (x *ModuleIndex) isManifest(fn string) bool {
+	names := x.ManifestNames
+	if len(names) == 0 {
+		names = DefaultModuleManifests
+	}
+	return containsString(names, fn)
+}
+
+func (x *ModuleIndex) parser(fn string) ModuleParser {
+	parsers := x.Parsers
+	if parsers == nil {
+		parsers = DefaultModuleParsers
+	}
+	if p := parsers[filepath.Ext(fn)]; p != nil {
+		return p
+	}
+	return ParseYAMLModule
+}
+
+func (x *ModuleIndex) add(m *Module) {
+	if x.byName == nil {
+		x.byName = make(map[string][]*Module)
+	}
+	x.modules = append(x.modules, m)
+	x.byName[m.Name] = append(x.byName[m.Name], m)
+}
+
+// Modules returns all indexed modules in precedence order
+func (x *ModuleIndex) Modules() []*Module {
+	return x.modules
+}
+
+// Names returns sorted names of all indexed modules
+func (x *ModuleIndex) Names() []string {
+	names := make([]string, 0, len(x.byName))
+	for name := range x.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Find returns the module with the name of highest precedence
+func (x *ModuleIndex) Find(name string) *Module {
+	if found := x.byName[name]; len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// FindVersion returns the module with the name and version of
+// highest precedence
+func (x *ModuleIndex) FindVersion(name, version string) *Module {
+	for _, m := range x.byName[name] {
+		if m.Version == version {
+			return m
+		}
+	}
+	return nil
+}
+
+// Versions returns all modules with the name in precedence order,
+// including the same version from different repositories
+func (x *ModuleIndex) Versions(name string) []*Module {
+	return x.byName[name]
+}
+
+// FindByPath returns the module at the directory inside a repository
+func (x *ModuleIndex) FindByPath(repoName, dir string) *Module {
+	dir = path.Clean(filepath.ToSlash(dir))
+	for _, m := range x.modules {
+		if m.RepoName == repoName && m.Path == dir {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Package otelgms traces gms operations using OpenTelemetry
+package otelgms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codingbrain/gms/gms"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of tracer requested from TracerProvider
+const InstrumentationName = "github.com/codingbrain/gms/gms"
+
+// Tracer implements gms.Tracer using an OpenTelemetry TracerProvider
+type Tracer struct {
+	// Provider creates spans, the global TracerProvider is used if nil
+	Provider trace.TracerProvider
+}
+
+// Install traces gms operations using the global TracerProvider,
+// spans are recorded once a TracerProvider is configured
+func Install() {
+	gms.DefaultTracer = &Tracer{}
+}
+
+// Start implements gms.Tracer
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, gms.Span) {
+	provider := t.Provider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	ctx, span := provider.Tracer(InstrumentationName).Start(ctx, name)
+	return ctx, &otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetAttribute(key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+func (s *otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
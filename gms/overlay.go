@@ -0,0 +1,341 @@
+package gms
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	// OverlayRepoType is the type name of overlay repo, which can't be restored
+	OverlayRepoType = "overlay"
+
+	// whiteoutPrefix prefixes a marker in overlay dir hiding the named
+	// entry of base and its children
+	whiteoutPrefix = ".wh."
+)
+
+// OverlayRepo is a writable view of a repository, reads fall through to
+// Base unless the path is written or deleted in the overlay directory, so
+// cached content is never modified. Deletions are recorded as whiteout
+// files prefixed by ".wh.", such names can't be written. CompareRepos
+// with Base lists local changes
+type OverlayRepo struct {
+	// Base is the read-only repository
+	Base Repository
+	// Dir is the local directory capturing writes and deletions
+	Dir string
+}
+
+// WritableOverlay creates an overlay repo capturing writes to base in dir,
+// dir is created on the first write
+func WritableOverlay(base Repository, dir string) *OverlayRepo {
+	return &OverlayRepo{Base: base, Dir: dir}
+}
+
+// BasePath implements Repository, it's empty as content merged from Base
+// and Dir has no local path and is only accessible using FS
+func (r *OverlayRepo) BasePath() string {
+	return ""
+}
+
+// Persist implements Repository, the handle can't be used to restore
+func (r *OverlayRepo) Persist() PersistentHandle {
+	return PersistentHandle{Type: OverlayRepoType, Opaque: r.Dir}
+}
+
+// FS implements FSRepository, it merges overlay dir over content of Base
+func (r *OverlayRepo) FS() fs.FS {
+	return &overlayFS{r: r, base: FS(r.Base)}
+}
+
+// OpenFile opens slash-separated name relative to content for writing
+// like os.OpenFile, the file is copied from Base first unless truncated
+func (r *OverlayRepo) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	if err := checkOverlayName("open", name); err != nil {
+		return nil, err
+	}
+	fsys := r.FS()
+	info, err := fs.Stat(fsys, name)
+	exists := err == nil
+	switch {
+	case exists && info.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case exists && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !exists && !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	if err = r.mkdirUpper(fsys, path.Dir(name)); err != nil {
+		return nil, err
+	}
+	if exists && flag&os.O_TRUNC == 0 {
+		if err = r.copyUp(name, info); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(r.upper(name), flag, perm)
+}
+
+// Create creates or truncates name like os.Create
+func (r *OverlayRepo) Create(name string) (*os.File, error) {
+	return r.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// WriteFile writes data to name like os.WriteFile
+func (r *OverlayRepo) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := r.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// MkdirAll creates directory name and missing parents like os.MkdirAll
+func (r *OverlayRepo) MkdirAll(name string, perm fs.FileMode) error {
+	if err := checkOverlayName("mkdir", name); err != nil {
+		return err
+	}
+	fsys := r.FS()
+	dir := "."
+	for _, elem := range strings.Split(name, "/") {
+		dir = path.Join(dir, elem)
+		if info, err := fs.Stat(fsys, dir); err == nil && !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.MkdirAll(r.upper(name), perm)
+}
+
+// Remove deletes name and its children from the view, Base is untouched
+func (r *OverlayRepo) Remove(name string) error {
+	if err := checkOverlayName("remove", name); err != nil {
+		return err
+	}
+	fsys := r.FS()
+	if _, err := fs.Stat(fsys, name); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(r.upper(name)); err != nil {
+		return err
+	}
+	if r.hidden(name) {
+		return nil
+	}
+	if _, err := fs.Stat(FS(r.Base), name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	dir := path.Dir(name)
+	if err := r.mkdirUpper(fsys, dir); err != nil {
+		return err
+	}
+	return os.WriteFile(r.upper(path.Join(dir, whiteoutPrefix+path.Base(name))), nil, 0644)
+}
+
+// Reset discards all writes and deletions
+func (r *OverlayRepo) Reset() error {
+	return os.RemoveAll(r.Dir)
+}
+
+// upper returns the local path of name in overlay dir
+func (r *OverlayRepo) upper(name string) string {
+	return filepath.Join(r.Dir, filepath.FromSlash(name))
+}
+
+// hidden tells if name of Base is hidden by a whiteout of itself or a parent
+func (r *OverlayRepo) hidden(name string) bool {
+	if name == "." {
+		return false
+	}
+	dir := "."
+	for _, elem := range strings.Split(name, "/") {
+		if _, err := os.Lstat(r.upper(path.Join(dir, whiteoutPrefix+elem))); err == nil {
+			return true
+		}
+		dir = path.Join(dir, elem)
+	}
+	return false
+}
+
+// mkdirUpper creates dir in overlay dir, it must be a directory in the view
+func (r *OverlayRepo) mkdirUpper(fsys fs.FS, dir string) error {
+	info, err := fs.Stat(fsys, dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+	}
+	return os.MkdirAll(r.upper(dir), 0755)
+}
+
+// copyUp copies file name from Base into overlay dir if absent
+func (r *OverlayRepo) copyUp(name string, info fs.FileInfo) error {
+	fn := r.upper(name)
+	if _, err := os.Lstat(fn); err == nil {
+		return nil
+	}
+	src, err := FS(r.Base).Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm()|0200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fn)
+	}
+	return err
+}
+
+// checkOverlayName validates a writable name
+func checkOverlayName(op, name string) error {
+	if !fs.ValidPath(name) || name == "." || strings.HasPrefix(path.Base(name), whiteoutPrefix) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// overlayFS is the merged view of an overlay repo
+type overlayFS struct {
+	r    *OverlayRepo
+	base fs.FS
+}
+
+// Open implements fs.FS
+func (f *overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || strings.HasPrefix(path.Base(name), whiteoutPrefix) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	info, err := os.Stat(f.r.upper(name))
+	if err == nil {
+		if !info.IsDir() {
+			return os.Open(f.r.upper(name))
+		}
+		return f.openDir(name, info)
+	}
+	// a missing overlay dir or a file replacing a parent leaves the path
+	// to Base
+	if !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+		return nil, err
+	}
+	if f.r.hidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if info, err = fs.Stat(f.base, name); err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return f.base.Open(name)
+	}
+	return f.openDir(name, info)
+}
+
+// ReadDir implements fs.ReadDirFS, entries of overlay dir replace those
+// of Base with the same name
+func (f *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	dir, ok := file.(*overlayDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	return dir.entries, nil
+}
+
+// openDir merges entries of dir
+func (f *overlayFS) openDir(name string, info fs.FileInfo) (*overlayDir, error) {
+	merged := make(map[string]fs.DirEntry)
+	whiteouts := make(map[string]bool)
+	if entries, err := os.ReadDir(f.r.upper(name)); err == nil {
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), whiteoutPrefix) {
+				whiteouts[strings.TrimPrefix(entry.Name(), whiteoutPrefix)] = true
+			} else {
+				merged[entry.Name()] = entry
+			}
+		}
+	} else if !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+		return nil, err
+	}
+	if !f.r.hidden(name) {
+		entries, err := fs.ReadDir(f.base, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, exists := merged[entry.Name()]; !exists && !whiteouts[entry.Name()] {
+				merged[entry.Name()] = entry
+			}
+		}
+	}
+	dir := &overlayDir{info: info, entries: make([]fs.DirEntry, 0, len(merged))}
+	for _, entry := range merged {
+		dir.entries = append(dir.entries, entry)
+	}
+	sort.Slice(dir.entries, func(i, j int) bool { return dir.entries[i].Name() < dir.entries[j].Name() })
+	return dir, nil
+}
+
+// overlayDir is an opened directory of overlayFS
+type overlayDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	pos     int
+}
+
+// Stat implements fs.File
+func (d *overlayDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read implements fs.File
+func (d *overlayDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: syscall.EISDIR}
+}
+
+// Close implements fs.File
+func (d *overlayDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.pos:]
+	if n <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.pos += n
+	return rest[:n], nil
+}
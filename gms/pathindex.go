@@ -0,0 +1,146 @@
+package gms
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// PathIndexFile is the filename of path index in meta dir
+	PathIndexFile = "paths.json"
+)
+
+var (
+	// ErrNoPathIndex indicates the path index is absent or doesn't match
+	// current content
+	ErrNoPathIndex = errors.New("path index unavailable")
+)
+
+// PathIndex lists files of a cached repo at a version, so lookups don't
+// walk the disk
+type PathIndex struct {
+	// Version is the content version indexed
+	Version string `json:"version,omitempty"`
+	// Manifest lists files relative to BasePath ordered by path
+	Manifest
+}
+
+// Lookup finds the entry of slash-separated path relative to BasePath
+func (x *PathIndex) Lookup(rel string) (*ManifestEntry, bool) {
+	rel = path.Clean(strings.Trim(rel, "/"))
+	i := sort.Search(len(x.Entries), func(i int) bool { return x.Entries[i].Path >= rel })
+	if i < len(x.Entries) && x.Entries[i].Path == rel {
+		return &x.Entries[i], true
+	}
+	return nil, false
+}
+
+// Glob lists entries whose path matches pattern, see FilterGlob
+func (x *PathIndex) Glob(pattern string) []ManifestEntry {
+	var entries []ManifestEntry
+	for _, entry := range x.Entries {
+		if matchPathOrBase(pattern, entry.Path) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// matchPathOrBase matches rel against pattern, or its base name if
+// pattern contains no "/"
+func matchPathOrBase(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(rel))
+		return matched
+	}
+	return MatchGlob(pattern, rel)
+}
+
+// buildPathIndex indexes current content into meta dir
+func (r *CachedRepo) buildPathIndex() error {
+	version, err := r.Version()
+	if err != nil {
+		return err
+	}
+	m, err := BuildManifest(r, crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	return saveJSON(filepath.Join(r.MetaDir, PathIndexFile), &PathIndex{Version: version, Manifest: *m})
+}
+
+// updatePathIndex rebuilds the path index after content changed with
+// IndexPaths, otherwise the index is removed as it's stale. Staleness is
+// only detected by version for VersionedRemoteRepo
+func (r *CachedRepo) updatePathIndex() error {
+	if r.IndexPaths {
+		return r.buildPathIndex()
+	}
+	return r.removePathIndex()
+}
+
+// removePathIndex removes the path index before content changes
+func (r *CachedRepo) removePathIndex() error {
+	if err := os.Remove(filepath.Join(r.MetaDir, PathIndexFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PathIndex loads the path index built by Sync with IndexPaths,
+// ErrNoPathIndex is returned if it's absent or stale. Entries denied by
+// ContentPolicy are left out, Digest still covers all of them
+func (r *CachedRepo) PathIndex() (*PathIndex, error) {
+	if err := r.Unseal(); err != nil {
+		return nil, err
+	}
+	x := &PathIndex{}
+	if err := loadJSON(filepath.Join(r.MetaDir, PathIndexFile), x); err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoPathIndex
+		}
+		return nil, err
+	}
+	// content versions of other remotes are computed by walking
+	if vr, ok := r.Remote.(VersionedRemoteRepo); ok {
+		if version, err := vr.VersionAt(r.LocalDir); err != nil || version != x.Version {
+			return nil, ErrNoPathIndex
+		}
+	}
+	if r.ContentPolicy != nil {
+		entries, err := r.allowedEntries(context.Background(), x.Entries)
+		if err != nil {
+			return nil, err
+		}
+		x.Entries = entries
+	}
+	return x, nil
+}
+
+// FileExists tells if slash-separated rel exists in content, from the
+// path index if available
+func (r *CachedRepo) FileExists(rel string) (bool, error) {
+	if err := r.Unseal(); err != nil {
+		return false, err
+	}
+	if x, err := r.PathIndex(); err == nil {
+		_, found := x.Lookup(rel)
+		return found, nil
+	}
+	fn, err := SafeJoin(r.BasePath(), rel)
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Lstat(fn); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,138 @@
+package gms
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathPolicy selects paths inside a repository using include and exclude
+// patterns. Patterns use path.Match syntax against slash-separated paths
+// relative to repository base path, and a pattern matching a directory
+// applies to everything inside it
+type PathPolicy struct {
+	// Include patterns, everything is included if empty
+	Include []string `json:",omitempty"`
+	// Exclude patterns, take precedence over Include
+	Exclude []string `json:",omitempty"`
+}
+
+// PolicyRepo is a repository carrying a path policy
+type PolicyRepo interface {
+	Repository
+	PathPolicy() *PathPolicy
+}
+
+// IsEmpty returns true if the policy selects everything
+func (p *PathPolicy) IsEmpty() bool {
+	return p == nil || (len(p.Include) == 0 && len(p.Exclude) == 0)
+}
+
+// Allows checks if relative path is selected by the policy
+func (p *PathPolicy) Allows(rel string, isDir bool) bool {
+	if p.IsEmpty() {
+		return true
+	}
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	for _, pattern := range p.Exclude {
+		if matchPathPattern(pattern, rel) {
+			return false
+		}
+	}
+	if len(p.Include) == 0 {
+		return true
+	}
+	for _, pattern := range p.Include {
+		if matchPathPattern(pattern, rel) ||
+			(isDir && matchPathPrefix(pattern, rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter creates a walker filter applying the policy to repo-relative paths
+func (p *PathPolicy) Filter() RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		return p.Allows(item.RelPath, item.FileInfo.IsDir()), nil
+	}
+}
+
+// PolicyFilter creates a walker filter applying the path policy to walks
+// of the subtree at rel relative to BasePath, nil if there's no policy
+func (r *CachedRepo) PolicyFilter(rel string) RepoWalkerFilter {
+	policy := r.Meta.Policy
+	if policy.IsEmpty() {
+		return nil
+	}
+	prefix := strings.Trim(filepath.ToSlash(rel), "/")
+	return func(item *WalkingItem) (bool, error) {
+		return policy.Allows(path.Join(prefix, item.RelPath), item.FileInfo.IsDir()), nil
+	}
+}
+
+// SparsePatterns converts the policy into git sparse-checkout patterns
+// (non-cone mode) with prefix as the path inside git repository
+func (p *PathPolicy) SparsePatterns(prefix string) []string {
+	prefix = "/" + strings.Trim(filepath.ToSlash(prefix), "/")
+	var patterns []string
+	for _, pattern := range p.Include {
+		patterns = append(patterns, path.Join(prefix, pattern))
+	}
+	if len(patterns) == 0 {
+		patterns = append(patterns, prefix)
+	}
+	for _, pattern := range p.Exclude {
+		patterns = append(patterns, "!"+path.Join(prefix, pattern))
+	}
+	return patterns
+}
+
+// matchPathPattern matches the path or any of its parent directories
+func matchPathPattern(pattern, rel string) bool {
+	for rel != "" && rel != "." {
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+		rel = path.Dir(rel)
+	}
+	return false
+}
+
+// matchPathPrefix checks if directory rel may contain paths matching pattern
+func matchPathPrefix(pattern, rel string) bool {
+	patternSegs := strings.Split(strings.Trim(pattern, "/"), "/")
+	relSegs := strings.Split(rel, "/")
+	if len(relSegs) >= len(patternSegs) {
+		return false
+	}
+	for i, seg := range relSegs {
+		if matched, _ := path.Match(patternSegs[i], seg); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// PathPolicy implements PolicyRepo
+func (r *CachedRepo) PathPolicy() *PathPolicy {
+	return r.Meta.Policy
+}
+
+// SetPathPolicy updates path policy of a cached repo
+func (c *RepoCache) SetPathPolicy(name string, policy *PathPolicy) error {
+	repo := c.repos[name]
+	if repo == nil {
+		return ErrRepoNotFound
+	}
+	if policy.IsEmpty() {
+		policy = nil
+	}
+	oldPolicy := repo.Meta.Policy
+	repo.Meta.Policy = policy
+	if err := c.saveRepo(name); err != nil {
+		repo.Meta.Policy = oldPolicy
+		return err
+	}
+	return nil
+}
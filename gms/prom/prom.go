@@ -0,0 +1,126 @@
+// Package prom collects gms.Metrics and exposes them in Prometheus
+// text format without depending on the Prometheus client library
+package prom
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// metric types in exposition format
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+	typeSummary = "summary"
+)
+
+// Registry implements gms.Metrics and serves collected metrics as
+// http.Handler, observations are exposed as summaries with sum and count
+type Registry struct {
+	lock   sync.Mutex
+	types  map[string]string
+	values map[string]map[string]float64
+	counts map[string]map[string]uint64
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		types:  make(map[string]string),
+		values: make(map[string]map[string]float64),
+		counts: make(map[string]map[string]uint64),
+	}
+}
+
+// Add implements gms.Metrics
+func (r *Registry) Add(name string, delta float64, labels gms.Labels) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.series(name, typeCounter)[formatLabels(labels)] += delta
+}
+
+// Set implements gms.Metrics
+func (r *Registry) Set(name string, value float64, labels gms.Labels) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.series(name, typeGauge)[formatLabels(labels)] = value
+}
+
+// Observe implements gms.Metrics
+func (r *Registry) Observe(name string, value float64, labels gms.Labels) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := formatLabels(labels)
+	r.series(name, typeSummary)[key] += value
+	if r.counts[name] == nil {
+		r.counts[name] = make(map[string]uint64)
+	}
+	r.counts[name][key]++
+}
+
+// series returns values of a metric, the type of first use is kept
+func (r *Registry) series(name, typ string) map[string]float64 {
+	if _, ok := r.types[name]; !ok {
+		r.types[name] = typ
+		r.values[name] = make(map[string]float64)
+	}
+	return r.values[name]
+}
+
+// ServeHTTP implements http.Handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	names := make([]string, 0, len(r.types))
+	for name := range r.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		typ := r.types[name]
+		fmt.Fprintf(out, "# TYPE %s %s\n", name, typ)
+		keys := make([]string, 0, len(r.values[name]))
+		for key := range r.values[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := strconv.FormatFloat(r.values[name][key], 'g', -1, 64)
+			if typ == typeSummary {
+				fmt.Fprintf(out, "%s_sum%s %s\n", name, key, value)
+				fmt.Fprintf(out, "%s_count%s %d\n", name, key, r.counts[name][key])
+			} else {
+				fmt.Fprintf(out, "%s%s %s\n", name, key, value)
+			}
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats labels ordered by name as {name="value",...}
+func formatLabels(labels gms.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + `="` + labelEscaper.Replace(labels[name]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,208 @@
+package gms
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CacheTrashDir is the name of sub-directory containing removed clones
+	CacheTrashDir = "trash"
+)
+
+var (
+	// ErrUnsafePath indicates a path outside the cache is about to be deleted
+	ErrUnsafePath = errors.New("path is outside of cache directory")
+)
+
+// RemoveOptions controls what happens to local data on Remove
+type RemoveOptions struct {
+	// Purge deletes local clone and metadata
+	Purge bool
+	// Trash moves local clone into trash directory for delayed purge,
+	// takes precedence over Purge
+	Trash bool
+}
+
+// purge deletes local clone and metadata of a removed repo
+func (c *RepoCache) purge(r *CachedRepo) error {
+	content, subdir := c.contentPath(r)
+	if err := c.checkSafePath(content, subdir); err != nil {
+		return err
+	}
+	if err := removeAll(content); err != nil {
+		return err
+	}
+	if err := r.Evict(); err != nil {
+		return err
+	}
+	if err := c.removeVersions(r); err != nil {
+		return err
+	}
+	if err := c.checkSafePath(r.MetaDir, CacheMetaDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(r.MetaDir)
+}
+
+// removeVersions deletes versions kept by AtomicSync and worktrees
+func (c *RepoCache) removeVersions(r *CachedRepo) error {
+	if r.VersionsDir != "" {
+		if err := c.checkSafePath(r.VersionsDir, CacheVersionsDir); err != nil {
+			return err
+		}
+		if err := removeAll(r.VersionsDir); err != nil {
+			return err
+		}
+	}
+	if r.WorktreesDir != "" {
+		if err := c.checkSafePath(r.WorktreesDir, CacheWorktreesDir); err != nil {
+			return err
+		}
+		return removeAll(r.WorktreesDir)
+	}
+	return nil
+}
+
+// trash moves local clone into trash directory and deletes metadata
+func (c *RepoCache) trash(r *CachedRepo) error {
+	content, subdir := c.contentPath(r)
+	if err := c.checkSafePath(content, subdir); err != nil {
+		return err
+	}
+	trashDir := filepath.Join(c.BaseDir, CacheTrashDir)
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return err
+	}
+	if c.Shared != nil {
+		if err := shareDirs(trashDir, 0); err != nil {
+			return err
+		}
+	}
+	dest := filepath.Join(trashDir, r.Name+"."+strconv.FormatInt(time.Now().Unix(), 10))
+	// the current version is trashed instead of the link to it, the
+	// encrypted content of sealed repos is trashed
+	if err := unlockContent(r.localDir()); err != nil {
+		return err
+	}
+	if content != r.LocalDir {
+		if err := os.Rename(content, dest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := r.Evict(); err != nil {
+			return err
+		}
+	} else if current := r.localDir(); current != r.LocalDir {
+		if err := os.Rename(current, dest); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(r.LocalDir); err != nil {
+			return err
+		}
+	} else if err := os.Rename(r.LocalDir, dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := c.removeVersions(r); err != nil {
+		return err
+	}
+	if err := c.checkSafePath(r.MetaDir, CacheMetaDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(r.MetaDir)
+}
+
+// planRemove records actions of RemoveWith to DryRun
+func (c *RepoCache) planRemove(r *CachedRepo, opts RemoveOptions) error {
+	c.planSaveRepo(r.Name)
+	if !opts.Trash && !opts.Purge {
+		return nil
+	}
+	content, subdir := c.contentPath(r)
+	if err := c.checkSafePath(content, subdir); err != nil {
+		return err
+	}
+	if err := c.checkSafePath(r.MetaDir, CacheMetaDir); err != nil {
+		return err
+	}
+	if opts.Trash {
+		dest := filepath.Join(c.BaseDir, CacheTrashDir, r.Name+"."+strconv.FormatInt(time.Now().Unix(), 10))
+		c.DryRun.record(PlannedAction{Op: PlanMove, Path: content, Target: dest})
+	} else {
+		c.DryRun.record(PlannedAction{Op: PlanDelete, Path: content})
+	}
+	if _, err := os.Stat(r.VersionsDir); err == nil {
+		c.DryRun.record(PlannedAction{Op: PlanDelete, Path: r.VersionsDir})
+	}
+	if _, err := os.Stat(r.WorktreesDir); err == nil {
+		c.DryRun.record(PlannedAction{Op: PlanDelete, Path: r.WorktreesDir})
+	}
+	c.DryRun.record(PlannedAction{Op: PlanDelete, Path: r.MetaDir})
+	return nil
+}
+
+// contentPath returns the path of content removed with r and the
+// sub-directory of BaseDir containing it, the encrypted content is
+// removed for sealed repos
+func (c *RepoCache) contentPath(r *CachedRepo) (string, string) {
+	if r.SealedFile != "" {
+		return r.SealedFile, CacheSealedDir
+	}
+	return r.LocalDir, c.reposDir()
+}
+
+// PurgeTrash permanently deletes clones trashed longer than olderThan ago
+func (c *RepoCache) PurgeTrash(olderThan time.Duration) error {
+	trashDir := filepath.Join(c.BaseDir, CacheTrashDir)
+	f, err := os.Open(trashDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(-olderThan)
+	for _, name := range names {
+		pos := strings.LastIndex(name, ".")
+		if pos < 0 {
+			continue
+		}
+		ts, err := strconv.ParseInt(name[pos+1:], 10, 64)
+		if err != nil || time.Unix(ts, 0).After(deadline) {
+			continue
+		}
+		if c.DryRun != nil {
+			c.DryRun.record(PlannedAction{Op: PlanDelete, Path: filepath.Join(trashDir, name)})
+			continue
+		}
+		if err = removeAll(filepath.Join(trashDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSafePath ensures path is strictly inside BaseDir/subdir
+func (c *RepoCache) checkSafePath(path, subdir string) error {
+	base, err := filepath.Abs(filepath.Join(c.BaseDir, subdir))
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(base, abs)
+	if err != nil || rel == "." || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ErrUnsafePath
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBudgetExceeded indicates SyncAll stopped syncing as the transfer
+	// budget is used up
+	ErrBudgetExceeded = errors.New("transfer budget exceeded")
+)
+
+// RateLimiter caps the throughput of readers sharing it, it's a token
+// bucket refilled at BytesPerSecond and holding at most one second of
+// tokens. It's safe for concurrent use
+type RateLimiter struct {
+	// BytesPerSecond is the cap, unlimited if not positive
+	BytesPerSecond int64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// WaitN blocks until n bytes are allowed or ctx is done
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || l.BytesPerSecond <= 0 {
+		return nil
+	}
+	for {
+		delay := l.reserve(n)
+		if delay <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// reserve takes n tokens if available, otherwise returns how long to
+// wait for them. Requests larger than the bucket are served when it's full
+func (l *RateLimiter) reserve(n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	rate := float64(l.BytesPerSecond)
+	now := time.Now()
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+		if l.tokens > rate {
+			l.tokens = rate
+		}
+	}
+	l.last = now
+	need := float64(n)
+	if need > rate {
+		need = rate
+	}
+	if l.tokens >= need {
+		l.tokens -= float64(n)
+		return 0
+	}
+	return time.Duration((need - l.tokens) / rate * float64(time.Second))
+}
+
+// Reader throttles r, it's r itself if l is nil
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil || l.BytesPerSecond <= 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: l}
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// read in chunks of at most 1/10 second to keep the flow smooth
+	if max := int(t.limiter.BytesPerSecond / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.WaitN(t.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+type rateLimiterKey struct{}
+
+// WithRateLimiter returns ctx carrying l, remote repos downloading data
+// themselves throttle with it. Git has no rate limit, its transfers of
+// http(s) remotes are relayed through a local proxy throttling them
+func WithRateLimiter(ctx context.Context, l *RateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, l)
+}
+
+// RateLimiterFromContext returns the rate limiter in ctx, or nil
+func RateLimiterFromContext(ctx context.Context) *RateLimiter {
+	l, _ := ctx.Value(rateLimiterKey{}).(*RateLimiter)
+	return l
+}
+
+// transferBudget is the number of bytes a sync may still transfer
+type transferBudget struct {
+	remaining atomic.Int64
+}
+
+// take accounts n bytes, ErrBudgetExceeded if they're not left
+func (b *transferBudget) take(n int) error {
+	if b.remaining.Add(-int64(n)) < 0 {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+type transferBudgetKey struct{}
+
+// withTransferBudget returns ctx limiting transfers relayed by gms to n
+// bytes
+func withTransferBudget(ctx context.Context, n int64) context.Context {
+	b := &transferBudget{}
+	b.remaining.Store(n)
+	return context.WithValue(ctx, transferBudgetKey{}, b)
+}
+
+func transferBudgetFromContext(ctx context.Context) *transferBudget {
+	b, _ := ctx.Value(transferBudgetKey{}).(*transferBudget)
+	return b
+}
+
+// transferLimited tells if transfers in ctx are throttled or budgeted
+func transferLimited(ctx context.Context) bool {
+	l := RateLimiterFromContext(ctx)
+	return (l != nil && l.BytesPerSecond > 0) || transferBudgetFromContext(ctx) != nil
+}
+
+// transferReader throttles r by the RateLimiter in ctx and fails with
+// ErrBudgetExceeded once the transfer budget in ctx is used up
+func transferReader(ctx context.Context, r io.Reader) io.Reader {
+	r = RateLimiterFromContext(ctx).Reader(ctx, r)
+	if b := transferBudgetFromContext(ctx); b != nil {
+		r = &budgetReader{r: r, budget: b}
+	}
+	return r
+}
+
+type budgetReader struct {
+	r      io.Reader
+	budget *transferBudget
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if n > 0 {
+		if berr := b.budget.take(n); berr != nil {
+			return n, berr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,78 @@
+package gms
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// setReadOnly removes write permission of files and directories of
+// content in dir, VCS metadata is left writable for git
+func setReadOnly(dir string) error {
+	return filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && isVCSPath(dir, fn) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if mode := info.Mode().Perm(); mode&0222 != 0 {
+			return os.Chmod(fn, mode&^0222)
+		}
+		return nil
+	})
+}
+
+// setWritable restores owner write permission of directories in dir made
+// read-only by setReadOnly, so files can be replaced or removed. Files
+// stay read-only as syncs replace rather than modify them, and they may
+// be shared blobs of CAS
+func setWritable(dir string) error {
+	return filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isVCSPath(dir, fn) {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if mode := info.Mode().Perm(); mode&0200 == 0 {
+			return os.Chmod(fn, mode|0200)
+		}
+		return nil
+	})
+}
+
+// unlockContent makes content in dir writable if it was made read-only,
+// which is tracked by permission of dir itself
+func unlockContent(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm()&0200 != 0 {
+		return nil
+	}
+	return setWritable(dir)
+}
+
+// removeAll is os.RemoveAll of a tree which may contain read-only content
+func removeAll(dir string) error {
+	if err := os.RemoveAll(dir); err == nil || !os.IsPermission(err) {
+		return err
+	}
+	if err := setWritable(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package gms
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is FICLONE ioctl request
+const ficlone = 0x40049409
+
+// reflinkFile creates a copy-on-write clone of src
+func reflinkFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+	out.Close()
+	if errno != 0 {
+		os.Remove(dst)
+		return errno
+	}
+	return nil
+}
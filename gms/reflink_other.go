@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package gms
+
+import (
+	"errors"
+	"os"
+)
+
+// reflinkFile is not supported on this platform
+func reflinkFile(src, dst string, perm os.FileMode) error {
+	return errors.New("reflink not supported")
+}
@@ -0,0 +1,99 @@
+package gms
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrRemoteDenied indicates a remote URL is rejected by RemotePolicy
+	ErrRemoteDenied = errors.New("remote denied by policy")
+)
+
+// RemotePolicy decides whether a remote may be used, it's consulted by
+// Detect, Sync and when cached repos are added or loaded
+type RemotePolicy interface {
+	// CheckRemote returns an error wrapping ErrRemoteDenied to reject u
+	CheckRemote(u *RepoURL) error
+}
+
+// DefaultRemotePolicy is used where no policy is set, everything is
+// allowed if nil
+var DefaultRemotePolicy RemotePolicy
+
+// HostPolicy allows or denies remotes by protocol and host patterns.
+// Patterns use path.Match syntax against the host, or against host and
+// path if the pattern contains "/", e.g. "*.corp.example.com" or
+// "github.com/myorg/*"
+type HostPolicy struct {
+	// Protocols allowed, e.g. https and ssh, all if empty
+	Protocols []string `json:",omitempty"`
+	// Allow patterns, all remotes are allowed if empty
+	Allow []string `json:",omitempty"`
+	// Deny patterns, take precedence over Allow
+	Deny []string `json:",omitempty"`
+}
+
+// CheckRemote implements RemotePolicy
+func (p *HostPolicy) CheckRemote(u *RepoURL) error {
+	if len(p.Protocols) > 0 && !containsString(p.Protocols, u.Protocol) {
+		return fmt.Errorf("%w: protocol %q not allowed", ErrRemoteDenied, u.Protocol)
+	}
+	for _, pattern := range p.Deny {
+		if matchRemotePattern(pattern, u) {
+			return fmt.Errorf("%w: %s matches %q", ErrRemoteDenied, u.Host, pattern)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if matchRemotePattern(pattern, u) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s not allowed", ErrRemoteDenied, u.Host)
+}
+
+// matchRemotePattern matches host, or host/path and its parents if
+// pattern contains "/"
+func matchRemotePattern(pattern string, u *RepoURL) bool {
+	pattern = strings.ToLower(pattern)
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, u.Host)
+		return matched
+	}
+	return matchPathPattern(pattern, strings.TrimSuffix(u.Key(), "/"))
+}
+
+// checkRemote parses raw URL and checks it with policy, or
+// DefaultRemotePolicy if policy is nil
+func checkRemote(policy RemotePolicy, raw string) error {
+	if policy == nil {
+		policy = DefaultRemotePolicy
+	}
+	if policy == nil || raw == "" {
+		return nil
+	}
+	u, err := ParseRepoURL(raw)
+	if err != nil {
+		return err
+	}
+	return policy.CheckRemote(u)
+}
+
+// remoteLocation returns the URL a remote repo talks to if known
+func remoteLocation(remote RemoteRepo) string {
+	if git, ok := remote.(*GitRepo); ok {
+		if git.Remote != "" {
+			return git.Remote
+		}
+		return git.URL
+	}
+	if archive, ok := remote.(*ArchiveRepo); ok {
+		return archive.URL
+	}
+	return ""
+}
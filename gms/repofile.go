@@ -0,0 +1,190 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxLinkDepth limits symbolic links followed resolving a path
+const maxLinkDepth = 255
+
+var (
+	// ErrPathEscapes indicates a relative path resolves outside of base path
+	ErrPathEscapes = errors.New("path escapes repository")
+	// ErrNoLocalPath indicates content of FSRepository is opened as a
+	// local file
+	ErrNoLocalPath = errors.New("repository has no local path")
+)
+
+// SafeJoin joins relpath to base and ensures the result stays inside base,
+// both lexically and after resolving symbolic links. relpath may use
+// slash or native separators
+func SafeJoin(base, relpath string) (string, error) {
+	relpath = filepath.FromSlash(relpath)
+	if filepath.IsAbs(relpath) || filepath.VolumeName(relpath) != "" {
+		return "", ErrPathEscapes
+	}
+	joined := filepath.Join(base, relpath)
+	if !isUnder(base, joined) {
+		return "", ErrPathEscapes
+	}
+	// missing components are resolved lexically after the existing
+	// prefix, which may itself be a link leaving base
+	resolved, err := resolvePath(joined)
+	if err != nil {
+		return "", err
+	}
+	resolvedBase, err := resolvePath(base)
+	if err != nil {
+		return "", err
+	}
+	if !isUnder(resolvedBase, resolved) {
+		return "", ErrPathEscapes
+	}
+	return joined, nil
+}
+
+// isUnder checks if path is base or inside base
+func isUnder(base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	return err == nil && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvePath resolves symbolic links in fn like filepath.EvalSymlinks,
+// except that components which don't exist are resolved lexically
+func resolvePath(fn string) (string, error) {
+	if !filepath.IsAbs(fn) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		fn = wd + string(filepath.Separator) + fn
+	}
+	vol := filepath.VolumeName(fn)
+	resolved := vol + string(filepath.Separator)
+	parts := strings.Split(filepath.ToSlash(fn[len(vol):]), "/")
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(next)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxLinkDepth {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", fn)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+			vol = filepath.VolumeName(target)
+			resolved = vol + string(filepath.Separator)
+			target = target[len(vol):]
+		}
+		parts = append(strings.Split(filepath.ToSlash(target), "/"), parts...)
+	}
+	return resolved, nil
+}
+
+// realPath resolves path to absolute path without symbolic links
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// OpenRepoFile opens a file by path relative to BasePath of the repo,
+// ErrNoLocalPath for FSRepository which must be opened by OpenRepoContent
+func OpenRepoFile(repo Repository, relpath string) (*os.File, error) {
+	if _, ok := repo.(FSRepository); ok {
+		return nil, &fs.PathError{Op: "open", Path: relpath, Err: ErrNoLocalPath}
+	}
+	fn, err := SafeJoin(repo.BasePath(), relpath)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fn)
+}
+
+// OpenRepoContent opens a file by path relative to BasePath of the repo
+// for reading, FSRepository is read using its fs.FS
+func OpenRepoContent(repo Repository, relpath string) (fs.File, error) {
+	if _, ok := repo.(FSRepository); ok {
+		name := filepath.ToSlash(relpath)
+		if !fs.ValidPath(name) {
+			return nil, ErrPathEscapes
+		}
+		return FS(repo).Open(name)
+	}
+	return OpenRepoFile(repo, relpath)
+}
+
+// ReadRepoFile reads a file by path relative to BasePath of the repo,
+// FSRepository is read using its fs.FS
+func ReadRepoFile(repo Repository, relpath string) ([]byte, error) {
+	if _, ok := repo.(FSRepository); ok {
+		name := filepath.ToSlash(relpath)
+		if !fs.ValidPath(name) {
+			return nil, ErrPathEscapes
+		}
+		return fs.ReadFile(FS(repo), name)
+	}
+	fn, err := SafeJoin(repo.BasePath(), relpath)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(fn)
+}
+
+// OpenFile opens a file inside the repo
+func (r *LocalRepo) OpenFile(relpath string) (*os.File, error) {
+	return OpenRepoFile(r, relpath)
+}
+
+// ReadFile reads a file inside the repo
+func (r *LocalRepo) ReadFile(relpath string) ([]byte, error) {
+	return ReadRepoFile(r, relpath)
+}
+
+// OpenFile opens a file inside the local clone, ContentPolicy applies
+func (r *CachedRepo) OpenFile(relpath string) (*os.File, error) {
+	if err := r.Unseal(); err != nil {
+		return nil, err
+	}
+	if err := r.CheckContent(context.Background(), relpath); err != nil {
+		return nil, err
+	}
+	return OpenRepoFile(r, relpath)
+}
+
+// ReadFile reads a file inside the local clone, ContentPolicy applies
+func (r *CachedRepo) ReadFile(relpath string) ([]byte, error) {
+	if err := r.Unseal(); err != nil {
+		return nil, err
+	}
+	if err := r.CheckContent(context.Background(), relpath); err != nil {
+		return nil, err
+	}
+	return ReadRepoFile(r, relpath)
+}
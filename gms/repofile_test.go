@@ -0,0 +1,44 @@
+package gms_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+	"github.com/codingbrain/gms/gms/gmstest"
+)
+
+func TestSafeJoin(t *testing.T) {
+	base := gmstest.TempRepo(t, map[string]string{"dir/file": "content"}).BaseDir
+	for _, name := range []string{"dir/file", "dir/../dir/file", "missing/file", "."} {
+		if _, err := gms.SafeJoin(base, name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"..", "../outside", "dir/../../outside", "/etc/passwd"} {
+		if _, err := gms.SafeJoin(base, name); !errors.Is(err, gms.ErrPathEscapes) {
+			t.Errorf("%s: got %v, want %v", name, err, gms.ErrPathEscapes)
+		}
+	}
+}
+
+func TestSafeJoinSymlink(t *testing.T) {
+	base := gmstest.TempRepo(t, map[string]string{"dir/file": "content"}).BaseDir
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(base, "escape")); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+	if err := os.Symlink("dir", filepath.Join(base, "inside")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gms.SafeJoin(base, "inside/file"); err != nil {
+		t.Errorf("inside/file: %v", err)
+	}
+	for _, name := range []string{"escape", "escape/file", "escape/missing/file"} {
+		if _, err := gms.SafeJoin(base, name); !errors.Is(err, gms.ErrPathEscapes) {
+			t.Errorf("%s: got %v, want %v", name, err, gms.ErrPathEscapes)
+		}
+	}
+}
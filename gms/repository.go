@@ -1,5 +1,15 @@
 package gms
 
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrUnsupportedRepoType indicates no factory or wrong kind for repo type
+	ErrUnsupportedRepoType = errors.New("unsupported repository type")
+)
+
 // PersistentHandle is opaque data which is used to persist/restore an object
 type PersistentHandle struct {
 	// Type indicate the object type
@@ -21,13 +31,65 @@ type RemoteRepo interface {
 	Sync(dir string) error
 }
 
+// VersionedRepo is a repository able to identify the version of its content
+type VersionedRepo interface {
+	Repository
+	Version() (string, error)
+}
+
+// VersionedRemoteRepo is a remote repository able to identify the version
+// of content synced into dir
+type VersionedRemoteRepo interface {
+	RemoteRepo
+	VersionAt(dir string) (string, error)
+}
+
+// ChangeListingRepo is a remote repository able to list changes between
+// versions of content synced into dir
+type ChangeListingRepo interface {
+	RemoteRepo
+	ChangesAt(dir, sinceVersion string) ([]Change, error)
+}
+
+// HistoryRepo is a remote repository able to tell the commit which last
+// changed a path in content synced into dir
+type HistoryRepo interface {
+	RemoteRepo
+	LastChangedAt(dir, relpath string) (*CommitInfo, error)
+}
+
+// ContextRemoteRepo is a remote repository supporting cancellation of Sync
+type ContextRemoteRepo interface {
+	RemoteRepo
+	SyncContext(ctx context.Context, dir string) error
+}
+
+// SparseRemoteRepo is a remote repository able to sync only selected paths
+type SparseRemoteRepo interface {
+	RemoteRepo
+	SyncSparse(ctx context.Context, dir string, policy *PathPolicy) error
+}
+
+// SyncRemoteContext syncs a remote repo with cancellation, for legacy
+// implementations without SyncContext, ctx is only checked before Sync
+func SyncRemoteContext(ctx context.Context, repo RemoteRepo, dir string) error {
+	if cr, ok := repo.(ContextRemoteRepo); ok {
+		return cr.SyncContext(ctx, dir)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return repo.Sync(dir)
+}
+
 // RepoFactory is used to restore a repository from persistent handle
 type RepoFactory func(PersistentHandle) (Repository, error)
 
 var (
 	// RepoFactories is the registry of repo factories
 	RepoFactories = map[string]RepoFactory{
-		GitRepoType:   GitRepoFactory,
-		LocalRepoType: LocalRepoFactory,
+		ArchiveRepoType: ArchiveRepoFactory,
+		GitRepoType:     GitRepoFactory,
+		LocalRepoType:   LocalRepoFactory,
 	}
 )
@@ -0,0 +1,176 @@
+package gms
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RepoURL is a parsed repository URL
+type RepoURL struct {
+	// Protocol is the scheme, "ssh" for scp-like URLs, "file" for local
+	// paths and empty for host/path without scheme
+	Protocol string
+	// User and Password are userinfo of the URL
+	User     string
+	Password string
+	// Host is the lower-cased host name without port
+	Host string
+	// Port is empty if not specified
+	Port string
+	// Path is the slash-separated path of repository followed by the
+	// sub-path inside, which is only told apart by probing the remote.
+	// It's relative for scp-like URLs
+	Path string
+	// Ref is the branch or tag from URL fragment or ref query parameter
+	Ref string
+	// SCP indicates the scp-like form user@host:path
+	SCP bool
+}
+
+// ParseRepoURL parses URL forms accepted by GitRepo:
+// scheme://[user[:password]@]host[:port]/path, user@host:path,
+// local paths (/path, ./path, C:\path, \\server\share) and host/path.
+// A ref can be given as #ref or ?ref=ref
+func ParseRepoURL(raw string) (*RepoURL, error) {
+	if raw == "" {
+		return nil, ErrInvalidGitURL
+	}
+	u := &RepoURL{}
+	raw = u.extractRef(raw)
+
+	if isWindowsPath(raw) {
+		u.Protocol = "file"
+		prefix, path := windowsFileURL(raw)
+		if prefix == "file:" {
+			// UNC path //server/share/path
+			path = strings.TrimPrefix(path, "//")
+			if pos := strings.Index(path, "/"); pos >= 0 {
+				u.Host, u.Path = path[:pos], path[pos:]
+			} else {
+				u.Host = path
+			}
+		} else if prefix == "file:///" {
+			u.Path = "/" + path
+		} else {
+			u.Path = path
+		}
+		return u, nil
+	}
+
+	if strings.Contains(raw, "://") {
+		parsed, err := url.Parse(raw)
+		if err != nil {
+			return nil, ErrInvalidGitURL
+		}
+		u.Protocol = strings.ToLower(parsed.Scheme)
+		if parsed.User != nil {
+			u.User = parsed.User.Username()
+			u.Password, _ = parsed.User.Password()
+		}
+		u.Host = strings.ToLower(parsed.Hostname())
+		u.Port = parsed.Port()
+		u.Path = parsed.Path
+		if u.Protocol == "file" && strings.HasPrefix(u.Path, "/.") {
+			// file://./path
+			u.Host, u.Path = "", strings.TrimPrefix(u.Path, "/")
+		}
+		return u, nil
+	}
+
+	if strings.HasPrefix(raw, "/") || strings.HasPrefix(raw, "./") || strings.HasPrefix(raw, "../") {
+		u.Protocol = "file"
+		u.Path = raw
+		return u, nil
+	}
+
+	slashPos := strings.Index(raw, "/")
+	colonPos := strings.Index(raw, ":")
+	atPos := strings.Index(raw, "@")
+	if atPos > 0 && atPos < colonPos && (slashPos < 0 || colonPos < slashPos) {
+		u.Protocol, u.SCP = "ssh", true
+		u.User = raw[:atPos]
+		u.Host = strings.ToLower(raw[atPos+1 : colonPos])
+		u.Path = raw[colonPos+1:]
+		return u, nil
+	}
+
+	// host[:port]/path without scheme
+	hostPort, path := raw, ""
+	if slashPos >= 0 {
+		hostPort, path = raw[:slashPos], raw[slashPos:]
+	}
+	if pos := strings.LastIndex(hostPort, ":"); pos >= 0 {
+		u.Host, u.Port = hostPort[:pos], hostPort[pos+1:]
+	} else {
+		u.Host = hostPort
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = path
+	if u.Host == "" {
+		return nil, ErrInvalidGitURL
+	}
+	return u, nil
+}
+
+// extractRef removes #ref or ref query parameter from raw
+func (u *RepoURL) extractRef(raw string) string {
+	if pos := strings.LastIndex(raw, "#"); pos >= 0 {
+		u.Ref = raw[pos+1:]
+		raw = raw[:pos]
+	}
+	if pos := strings.Index(raw, "?"); pos >= 0 {
+		if query, err := url.ParseQuery(raw[pos+1:]); err == nil && query.Get("ref") != "" {
+			if u.Ref == "" {
+				u.Ref = query.Get("ref")
+			}
+			raw = raw[:pos]
+		}
+	}
+	return raw
+}
+
+// WithProtocol returns a copy with protocol set, for URLs without scheme
+func (u *RepoURL) WithProtocol(protocol string) *RepoURL {
+	c := *u
+	c.Protocol = protocol
+	return &c
+}
+
+// Prefix formats the URL before Path, Path appended to it forms the URL
+func (u *RepoURL) Prefix() string {
+	if u.SCP {
+		return u.User + "@" + u.Host + ":"
+	}
+	if u.Protocol == "file" {
+		return "file://" + u.Host
+	}
+	var b strings.Builder
+	if u.Protocol != "" {
+		b.WriteString(u.Protocol + "://")
+	}
+	if u.Password != "" {
+		b.WriteString(url.UserPassword(u.User, u.Password).String() + "@")
+	} else if u.User != "" {
+		b.WriteString(url.User(u.User).String() + "@")
+	}
+	b.WriteString(u.Host)
+	if u.Port != "" {
+		b.WriteString(":" + u.Port)
+	}
+	return b.String()
+}
+
+// String formats the normalized URL without ref
+func (u *RepoURL) String() string {
+	return u.Prefix() + u.Path
+}
+
+// Key identifies the repository regardless of protocol, userinfo, port
+// and .git suffix, e.g. for matching webhook payloads
+func (u *RepoURL) Key() string {
+	path := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	if u.SCP && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.ToLower(u.Host + path)
+}
@@ -0,0 +1,148 @@
+package gms
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrNoMatchingVersion indicates no version satisfies the constraint
+	ErrNoMatchingVersion = errors.New("no matching version")
+)
+
+// ModuleVersion is an available version of a module
+type ModuleVersion struct {
+	// Name is the module name
+	Name string
+	// Version is the semantic version
+	Version SemVer
+	// RepoName is the name of cached repo providing the version
+	RepoName string
+	// Path is slash-separated module directory relative to BasePath
+	Path string
+	// Ref is the git ref to checkout for the version,
+	// empty if it is available in current content
+	Ref string
+}
+
+// ModuleResolver lists and resolves module versions from module
+// manifests in current content and from git tags of cached repos.
+// A manifest without version uses its directory name as version, e.g.
+// "foo/1.2.0/module.yml" declares version 1.2.0 of module foo.
+// Tags can be "<module>/vX.Y.Z" for a single module or "vX.Y.Z" for
+// all modules in the repository
+type ModuleResolver struct {
+	// Cache provides repositories
+	Cache *RepoCache
+	// Index is the module index built from Cache
+	Index *ModuleIndex
+}
+
+// Versions lists all available versions of a module, from highest
+// version to lowest, and by repo precedence for equal versions
+func (res *ModuleResolver) Versions(name string) ([]ModuleVersion, error) {
+	var versions []ModuleVersion
+	for _, m := range res.Index.Versions(name) {
+		vstr := m.Version
+		if vstr == "" {
+			vstr = path.Base(m.Path)
+		}
+		if v, err := ParseSemVer(vstr); err == nil {
+			versions = append(versions, ModuleVersion{
+				Name:     name,
+				Version:  v,
+				RepoName: m.RepoName,
+				Path:     m.Path,
+			})
+		}
+	}
+	for _, repo := range res.Cache.ReposOrdered() {
+		tagged, err := res.taggedVersions(name, repo)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, tagged...)
+	}
+	sort.Stable(versionsDesc(versions))
+	return versions, nil
+}
+
+func (res *ModuleResolver) taggedVersions(name string, repo *CachedRepo) ([]ModuleVersion, error) {
+	gitRepo, ok := repo.Remote.(*GitRepo)
+	if !ok {
+		return nil, nil
+	}
+	git := &GitWorkTree{Client: gitRepo.client(), WorkDir: repo.LocalDir}
+	tags, err := git.Tags()
+	if err != nil {
+		return nil, err
+	}
+	modPath := name
+	var inRepo *Module
+	for _, m := range res.Index.Versions(name) {
+		if m.RepoName == repo.Name {
+			inRepo = m
+			modPath = m.Path
+			break
+		}
+	}
+	var versions []ModuleVersion
+	for _, tag := range tags {
+		vstr := tag
+		if strings.HasPrefix(tag, name+"/") {
+			vstr = tag[len(name)+1:]
+		} else if strings.Contains(tag, "/") || inRepo == nil {
+			continue
+		}
+		v, err := ParseSemVer(vstr)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, ModuleVersion{
+			Name:     name,
+			Version:  v,
+			RepoName: repo.Name,
+			Path:     path.Clean(modPath),
+			Ref:      "refs/tags/" + tag,
+		})
+	}
+	return versions, nil
+}
+
+// Resolve finds the highest version of the module satisfying constraint,
+// pre-release versions are only considered if no release matches
+func (res *ModuleResolver) Resolve(name, constraint string) (*ModuleVersion, error) {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := res.Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	var pre *ModuleVersion
+	for i := range versions {
+		v := &versions[i]
+		if !c.Match(v.Version) {
+			continue
+		}
+		if v.Version.Pre == "" {
+			return v, nil
+		}
+		if pre == nil {
+			pre = v
+		}
+	}
+	if pre != nil {
+		return pre, nil
+	}
+	return nil, ErrNoMatchingVersion
+}
+
+type versionsDesc []ModuleVersion
+
+func (s versionsDesc) Len() int           { return len(s) }
+func (s versionsDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s versionsDesc) Less(i, j int) bool { return s[i].Version.Compare(s[j].Version) > 0 }
@@ -0,0 +1,156 @@
+package gms
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrVersionNotFound indicates the requested version is not retained
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+// VersionInfo describes a version retained by AtomicSync
+type VersionInfo struct {
+	// ID is the sequence number of the version directory, the current
+	// version has ID 0 if symbolic links are not supported
+	ID int `json:"id"`
+	// Version is the content version, e.g. commit Id
+	Version string `json:"version,omitempty"`
+	// Synced is when the version was swapped in
+	Synced time.Time `json:"synced"`
+	// Current is true if LocalDir points to the version
+	Current bool `json:"current,omitempty"`
+}
+
+// keepVersions returns KeepVersions, at least 1
+func (r *CachedRepo) keepVersions() int {
+	if r.KeepVersions > 0 {
+		return r.KeepVersions
+	}
+	return 1
+}
+
+// Versions lists retained versions in ascending order of ID
+func (r *CachedRepo) Versions() ([]VersionInfo, error) {
+	ids, err := r.versionIDs()
+	if err != nil {
+		return nil, err
+	}
+	current := r.localDir()
+	var infos []VersionInfo
+	if current == r.LocalDir {
+		if info, err := r.versionInfo(0, r.LocalDir); err == nil {
+			info.Current = true
+			infos = append(infos, info)
+		}
+	}
+	for _, id := range ids {
+		dir := r.versionDir(id)
+		info, err := r.versionInfo(id, dir)
+		if err != nil {
+			return nil, err
+		}
+		info.Current = dir == current
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (r *CachedRepo) versionInfo(id int, dir string) (VersionInfo, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return VersionInfo{}, err
+	}
+	info := VersionInfo{ID: id, Synced: fi.ModTime()}
+	if vr, ok := r.Remote.(VersionedRemoteRepo); ok {
+		info.Version, _ = vr.VersionAt(dir)
+	} else {
+		info.Version, _ = mtimeVersion(&LocalRepo{BaseDir: filepath.Join(dir, r.Remote.BasePath())})
+	}
+	return info, nil
+}
+
+// UseVersion switches LocalDir to a retained version identified by ID or
+// content version (a unique prefix is accepted). The switch lasts until
+// the next Sync
+func (r *CachedRepo) UseVersion(id string) error {
+	versions, err := r.Versions()
+	if err != nil {
+		return err
+	}
+	var found *VersionInfo
+	for i := range versions {
+		v := &versions[i]
+		if strconv.Itoa(v.ID) == id || (v.Version != "" && strings.HasPrefix(v.Version, id)) {
+			if found != nil && found.ID != v.ID {
+				return ErrVersionNotFound
+			}
+			found = v
+		}
+	}
+	if found == nil {
+		return ErrVersionNotFound
+	}
+	return r.switchTo(found)
+}
+
+// Rollback switches LocalDir to the latest version before the current one
+func (r *CachedRepo) Rollback() error {
+	versions, err := r.Versions()
+	if err != nil {
+		return err
+	}
+	var current *VersionInfo
+	for i := range versions {
+		if versions[i].Current {
+			current = &versions[i]
+		}
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := &versions[i]
+		if !v.Current && (current == nil || current.ID == 0 || v.ID < current.ID) {
+			return r.switchTo(v)
+		}
+	}
+	return ErrVersionNotFound
+}
+
+// switchTo points LocalDir to version v and updates the state
+func (r *CachedRepo) switchTo(v *VersionInfo) error {
+	if v.Current {
+		return nil
+	}
+	if r.DryRun != nil {
+		r.DryRun.record(PlannedAction{Op: PlanWrite, Path: r.LocalDir})
+		return nil
+	}
+	var legacy string
+	if fi, err := os.Lstat(r.LocalDir); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		ids, err := r.versionIDs()
+		if err != nil {
+			return err
+		}
+		legacy = r.versionDir(ids[len(ids)-1] + 1)
+	}
+	if err := r.pointTo(r.versionDir(v.ID), legacy); err != nil {
+		return err
+	}
+	logf(r.Logger, "sync %s: switched to version %d", r.Name, v.ID)
+	if r.Integrity {
+		if err := r.RecordManifest(); err != nil {
+			return err
+		}
+	}
+	if err := r.updatePathIndex(); err != nil {
+		return err
+	}
+	if err := r.recordUsage(); err != nil {
+		return err
+	}
+	return r.updateState(func(s *SyncState) { s.Version = v.Version })
+}
@@ -0,0 +1,90 @@
+package gms
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// SanitizeArchivePath returns the path an archive entry of name is
+// extracted to in dir after stripping strip leading components, empty if
+// nothing is left. Absolute names and .. components are rejected with
+// ErrPathEscapes rather than cleaned, so are names resolving outside of
+// dir through symbolic links extracted before
+func SanitizeArchivePath(dir, name string, strip int) (string, error) {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return "", fmt.Errorf("%s: %w", name, ErrPathEscapes)
+	}
+	// backslashes separate paths on windows
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", fmt.Errorf("%s: %w", name, ErrPathEscapes)
+		}
+	}
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) <= strip {
+		return "", nil
+	}
+	fn, err := SafeJoin(dir, strings.Join(parts[strip:], "/"))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return fn, nil
+}
+
+// CheckArchiveLink checks a symbolic link to target extracted at fn in
+// dir points inside of dir, following symbolic links extracted before.
+// Absolute targets are rejected with ErrPathEscapes
+func CheckArchiveLink(dir, fn, target string) error {
+	target = filepath.FromSlash(target)
+	if target == "" || filepath.IsAbs(target) || filepath.VolumeName(target) != "" ||
+		strings.HasPrefix(target, string(filepath.Separator)) {
+		return fmt.Errorf("%s -> %s: %w", fn, target, ErrPathEscapes)
+	}
+	// joined without cleaning, .. may follow a symbolic link
+	if err := checkResolvesUnder(dir, filepath.Dir(fn)+string(filepath.Separator)+target); err != nil {
+		return fmt.Errorf("%s -> %s: %w", fn, target, err)
+	}
+	return nil
+}
+
+// CheckArchiveLinks checks every symbolic link in dir points inside of
+// dir after extraction, links extracted later may redirect ones before
+func CheckArchiveLinks(dir string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		if escapesRoot(root, fn) {
+			return fmt.Errorf("%s: %w", fn, ErrPathEscapes)
+		}
+		return nil
+	})
+}
+
+// checkResolvesUnder checks fn resolves inside of dir, ErrPathEscapes
+// if not
+func checkResolvesUnder(dir, fn string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolvePath(fn)
+	if err != nil {
+		return err
+	}
+	if !isUnder(root, resolved) {
+		return ErrPathEscapes
+	}
+	return nil
+}
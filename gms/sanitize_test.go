@@ -0,0 +1,88 @@
+package gms_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+func TestSanitizeArchivePath(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		name  string
+		strip int
+		want  string
+	}{
+		{"a/b", 0, "a/b"},
+		{"./a/b", 0, "a/b"},
+		{"top/a/b", 1, "a/b"},
+		{"top/", 1, ""},
+	}
+	for _, c := range cases {
+		fn, err := gms.SanitizeArchivePath(dir, c.name, c.strip)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		want := ""
+		if c.want != "" {
+			want = filepath.Join(dir, filepath.FromSlash(c.want))
+		}
+		if fn != want {
+			t.Errorf("%s: got %q, want %q", c.name, fn, want)
+		}
+	}
+	for _, name := range []string{"../evil", "a/../../evil", "/etc/passwd", `\evil`, `a\..\..\evil`, "top/../evil"} {
+		if _, err := gms.SanitizeArchivePath(dir, name, 1); !errors.Is(err, gms.ErrPathEscapes) {
+			t.Errorf("%s: got %v, want %v", name, err, gms.ErrPathEscapes)
+		}
+	}
+}
+
+func TestSanitizeArchivePathThroughLink(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "link")); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+	if _, err := gms.SanitizeArchivePath(dir, "link/evil", 0); !errors.Is(err, gms.ErrPathEscapes) {
+		t.Errorf("got %v, want %v", err, gms.ErrPathEscapes)
+	}
+}
+
+func TestCheckArchiveLink(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "a", "b", "link")
+	for _, target := range []string{"file", "../file", "../../file"} {
+		if err := gms.CheckArchiveLink(dir, fn, target); err != nil {
+			t.Errorf("%s: %v", target, err)
+		}
+	}
+	for _, target := range []string{"", "/etc/passwd", "../../../file", "../../../../etc/passwd"} {
+		if err := gms.CheckArchiveLink(dir, fn, target); !errors.Is(err, gms.ErrPathEscapes) {
+			t.Errorf("%q: got %v, want %v", target, err, gms.ErrPathEscapes)
+		}
+	}
+}
+
+func TestCheckArchiveLinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Symlink("a", filepath.Join(dir, "inside")); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+	if err := gms.CheckArchiveLinks(dir); err != nil {
+		t.Errorf("inside: %v", err)
+	}
+	// a link extracted later redirects an earlier one
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gms.CheckArchiveLinks(dir); !errors.Is(err, gms.ErrPathEscapes) {
+		t.Errorf("got %v, want %v", err, gms.ErrPathEscapes)
+	}
+}
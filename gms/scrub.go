@@ -0,0 +1,223 @@
+package gms
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// QuarantineDir is the sub-directory of MetaDir scrubbed files are
+	// moved into with Scrubber.Quarantine
+	QuarantineDir = "quarantine"
+)
+
+// Reasons of ScrubbedFile
+const (
+	// ScrubPattern is a file matching Scrubber.Patterns
+	ScrubPattern = "pattern"
+	// ScrubSetuid is a setuid or setgid file
+	ScrubSetuid = "setuid"
+	// ScrubSymlink is a symbolic link escaping the local clone
+	ScrubSymlink = "escaping symlink"
+)
+
+// ExcludingRepo is a remote repository able to keep files removed from
+// the local clone, so they're not reported as local changes
+type ExcludingRepo interface {
+	RemoteRepo
+	ExcludeAt(dir string, paths []string) error
+}
+
+// Scrubber removes dangerous files from synced content before it's
+// live, hardening caches of untrusted remotes. Content is scrubbed in a
+// staging copy even without AtomicSync
+type Scrubber struct {
+	// Patterns match dangerous paths relative to the local clone like
+	// DenyPatterns, e.g. .git/hooks/* or *.exe
+	Patterns []string
+	// Setuid matches setuid and setgid files
+	Setuid bool
+	// EscapingSymlinks matches symbolic links pointing outside of the
+	// local clone, including absolute ones
+	EscapingSymlinks bool
+	// Quarantine moves scrubbed files into QuarantineDir of MetaDir
+	// instead of removing them
+	Quarantine bool
+}
+
+// DefaultScrubber removes git hooks, setuid files and escaping links
+var DefaultScrubber = &Scrubber{
+	Patterns:         []string{".git/hooks/*"},
+	Setuid:           true,
+	EscapingSymlinks: true,
+}
+
+// ScrubbedFile is a file removed or quarantined by Scrubber
+type ScrubbedFile struct {
+	// Path is slash-separated path relative to the local clone
+	Path string
+	// Reason is ScrubPattern, ScrubSetuid or ScrubSymlink
+	Reason string
+}
+
+// Scrub removes or moves into quarantine dangerous files in dir. Inside
+// of .git only hooks are looked at
+func (s *Scrubber) Scrub(dir, quarantine string) ([]ScrubbedFile, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	var scrubbed []ScrubbedFile
+	err = filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil || fn == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, ".git/") && rel != ".git/hooks" && !strings.HasPrefix(rel, ".git/hooks/") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		reason, err := s.match(root, fn, rel, info)
+		if err != nil || reason == "" {
+			return err
+		}
+		if s.Quarantine {
+			err = moveToQuarantine(fn, filepath.Join(quarantine, filepath.FromSlash(rel)))
+		} else {
+			err = removeAll(fn)
+		}
+		if err != nil {
+			return err
+		}
+		scrubbed = append(scrubbed, ScrubbedFile{Path: rel, Reason: reason})
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return scrubbed, err
+}
+
+// match returns why fn is dangerous, empty if it isn't
+func (s *Scrubber) match(root, fn, rel string, info fs.FileInfo) (string, error) {
+	if len(s.Patterns) > 0 {
+		allowed, err := DenyPatterns(s.Patterns).Allow(context.Background(), "", rel, info)
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return ScrubPattern, nil
+		}
+	}
+	mode := info.Mode()
+	if s.Setuid && mode.IsRegular() && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		return ScrubSetuid, nil
+	}
+	if s.EscapingSymlinks && mode&os.ModeSymlink != 0 && escapesRoot(root, fn) {
+		return ScrubSymlink, nil
+	}
+	return "", nil
+}
+
+// escapesRoot checks if the link fn points outside of root, missing
+// components of broken links are resolved lexically
+func escapesRoot(root, fn string) bool {
+	target, err := os.Readlink(fn)
+	if err != nil {
+		return true
+	}
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return true
+	}
+	resolved, err := resolvePath(fn)
+	return err != nil || !isUnder(root, resolved)
+}
+
+// moveToQuarantine moves fn to dst, replacing an older one. Quarantined
+// files lose setuid and setgid
+func moveToQuarantine(fn, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := removeAll(dst); err != nil {
+		return err
+	}
+	if err := os.Rename(fn, dst); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dst); err != nil || !info.Mode().IsRegular() {
+		return err
+	} else if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		return os.Chmod(dst, info.Mode().Perm())
+	}
+	return nil
+}
+
+// scrub runs Scrubber on the local clone, scrubbed files are excluded
+// from the clone if the remote supports it
+func (r *CachedRepo) scrub() error {
+	quarantine := filepath.Join(r.MetaDir, QuarantineDir, time.Now().UTC().Format("20060102T150405.000000000Z"))
+	scrubbed, err := r.Scrubber.Scrub(r.LocalDir, quarantine)
+	var paths []string
+	for _, file := range scrubbed {
+		logf(r.Logger, "sync %s: scrubbed %s: %s", r.Name, file.Path, file.Reason)
+		addMetric(r.Metrics, MetricScrubbedFiles, 1, Labels{"repo": r.Name, "reason": file.Reason})
+		if !strings.HasPrefix(file.Path, ".git/") {
+			paths = append(paths, file.Path)
+		}
+	}
+	if er, ok := r.Remote.(ExcludingRepo); ok && len(paths) > 0 {
+		if xerr := er.ExcludeAt(r.LocalDir, paths); err == nil {
+			err = xerr
+		}
+	}
+	return err
+}
+
+// stageScrub copies LocalDir next to it to sync and scrub without
+// AtomicSync, so dangerous files are never live. It's kept on the same
+// file system, e.g. in memory for sealed repos
+func (r *CachedRepo) stageScrub() (string, error) {
+	staging := filepath.Join(filepath.Dir(r.LocalDir), stagingPrefix+filepath.Base(r.LocalDir))
+	if err := removeAll(staging); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(r.LocalDir); os.IsNotExist(err) {
+		return staging, nil
+	}
+	if err := copyTree(r.LocalDir, staging); err != nil {
+		removeAll(staging)
+		return "", err
+	}
+	return staging, nil
+}
+
+// replaceScrubbed replaces LocalDir with the staging copy scrubbed, the
+// content must be locked exclusively
+func (r *CachedRepo) replaceScrubbed(staging string) error {
+	old := staging + ".old"
+	if err := removeAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(r.LocalDir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(staging, r.LocalDir); err != nil {
+		return err
+	}
+	return removeAll(old)
+}
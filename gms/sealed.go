@@ -0,0 +1,461 @@
+package gms
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CacheSealedDir is the name of sub-directory containing encrypted
+	// content of sealed repos
+	CacheSealedDir = "sealed"
+	// SealKeySize is the size of SealKey, an AES-256 key
+	SealKeySize = 32
+
+	// sealMagic starts sealed files, the version of the format included
+	sealMagic = "GMSSEAL1"
+	// sealChunkSize is the plaintext size of each encrypted chunk
+	sealChunkSize = 64 * 1024
+)
+
+var (
+	// ErrNoSealKey indicates a sealed repo is accessed without key
+	ErrNoSealKey = errors.New("seal key required")
+	// ErrInvalidSealKey indicates the seal key is malformed
+	ErrInvalidSealKey = errors.New("invalid seal key")
+	// ErrUnsafeUnsealDir indicates the directory sealed repos are
+	// decrypted into is missing or may be accessed by other users
+	ErrUnsafeUnsealDir = errors.New("unseal directory missing or not private")
+	// ErrSealCorrupted indicates sealed content is tampered, truncated
+	// or encrypted with another key
+	ErrSealCorrupted = errors.New("sealed content corrupted or wrong key")
+)
+
+// ParseSealKey decodes a seal key in hex or base64
+func ParseSealKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil || len(key) != SealKeySize {
+		return nil, ErrInvalidSealKey
+	}
+	return key, nil
+}
+
+// DefaultUnsealDir returns the directory sealed repos of the cache in
+// baseDir are decrypted into, under a directory of the current user in
+// /dev/shm so plaintext stays in memory. There's no fallback on disk,
+// RepoCache.UnsealDir must be set where /dev/shm is not available
+func DefaultUnsealDir(baseDir string) string {
+	if abs, err := filepath.Abs(baseDir); err == nil {
+		baseDir = abs
+	}
+	sum := sha256.Sum256([]byte(baseDir))
+	return filepath.Join(defaultUnsealRoot(), hex.EncodeToString(sum[:6]))
+}
+
+// defaultUnsealRoot returns the directory of the current user in
+// /dev/shm containing DefaultUnsealDir of all caches
+func defaultUnsealRoot() string {
+	return filepath.Join("/dev/shm", fmt.Sprintf("gms-%d", os.Getuid()))
+}
+
+// unsealDir returns UnsealDir or the default
+func (c *RepoCache) unsealDir() string {
+	if c.UnsealDir != "" {
+		return c.UnsealDir
+	}
+	return DefaultUnsealDir(c.BaseDir)
+}
+
+// configureSealing points LocalDir of a sealed repo to its decrypted
+// copy, AtomicSync and worktrees are disabled to keep plaintext off disk
+func (c *RepoCache) configureSealing(r *CachedRepo) {
+	if !r.Meta.Sealed {
+		return
+	}
+	r.SealedFile = filepath.Join(c.BaseDir, CacheSealedDir, r.Name)
+	r.LocalDir = filepath.Join(c.unsealDir(), r.Name)
+	if c.UnsealDir == "" {
+		r.UnsealRoot = defaultUnsealRoot()
+	}
+	r.AtomicSync, r.WorktreesDir = false, ""
+}
+
+// SetSealed seals content of a cached repo encrypted with SealKey, or
+// restores it as plain content
+func (c *RepoCache) SetSealed(name string, sealed bool) error {
+	repo := c.repos[name]
+	if repo == nil {
+		return ErrRepoNotFound
+	}
+	if repo.Meta.Sealed == sealed {
+		return nil
+	}
+	if len(c.SealKey) == 0 {
+		return ErrNoSealKey
+	}
+	if c.DryRun != nil {
+		c.planSaveRepo(name)
+		return nil
+	}
+	converted := *repo
+	converted.Meta.Sealed = sealed
+	if sealed {
+		c.configureSealing(&converted)
+		if err := converted.makeUnsealDir(); err != nil {
+			return err
+		}
+	} else {
+		converted.SealedFile, converted.UnsealRoot = "", ""
+		converted.LocalDir = c.localDir(name, repo.Remote)
+		converted.AtomicSync = c.AtomicSync
+		converted.WorktreesDir = filepath.Join(c.BaseDir, CacheWorktreesDir, name)
+		if err := repo.Unseal(); err != nil {
+			return err
+		}
+	}
+	if err := removeAll(converted.LocalDir); err != nil {
+		return err
+	}
+	if _, err := os.Stat(repo.localDir()); err == nil {
+		if err = copyTree(repo.localDir(), converted.LocalDir); err != nil {
+			return err
+		}
+		if err = converted.Seal(); err != nil {
+			return err
+		}
+	}
+	c.repos[name] = &converted
+	if err := c.saveRepo(name); err != nil {
+		c.repos[name] = repo
+		return err
+	}
+	if sealed {
+		return c.purgePlain(repo)
+	}
+	if err := repo.Evict(); err != nil {
+		return err
+	}
+	if err := os.Remove(repo.SealedFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// purgePlain deletes plain content of a repo after it's sealed
+func (c *RepoCache) purgePlain(r *CachedRepo) error {
+	if err := c.checkSafePath(r.LocalDir, c.reposDir()); err != nil {
+		return err
+	}
+	if err := removeAll(r.LocalDir); err != nil {
+		return err
+	}
+	return c.removeVersions(r)
+}
+
+// Unseal decrypts content into LocalDir unless it's there already, it
+// does nothing if the repo is not sealed or never synced. Content is
+// unsealed on access after it's evicted
+func (r *CachedRepo) Unseal() error {
+	if r.SealedFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(r.LocalDir); err == nil {
+		return nil
+	}
+	f, err := os.Open(r.SealedFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd, err := newSealReader(f, r.SealKey)
+	if err != nil {
+		return err
+	}
+	if err = r.makeUnsealDir(); err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(r.LocalDir), ".unseal-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if err = extractTar(rd, staging, 0, nil); err != nil {
+		return err
+	}
+	if err = os.Chmod(staging, 0700); err != nil {
+		return err
+	}
+	if err = os.Rename(staging, r.LocalDir); err != nil {
+		// unsealed concurrently
+		if _, serr := os.Stat(r.LocalDir); serr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// makeUnsealDir creates the parent of LocalDir private to the current
+// user, UnsealRoot is checked as anyone may have created it first
+func (r *CachedRepo) makeUnsealDir() error {
+	if r.UnsealRoot != "" {
+		if err := makePrivateDir(r.UnsealRoot); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(filepath.Dir(r.LocalDir), 0700)
+}
+
+// sealedRepo is implemented by repos whose content is decrypted on
+// access
+type sealedRepo interface {
+	Unseal() error
+}
+
+// unsealRepo unseals content of repo if it's a sealedRepo
+func unsealRepo(repo Repository) error {
+	if sr, ok := repo.(sealedRepo); ok {
+		return sr.Unseal()
+	}
+	return nil
+}
+
+// Seal encrypts content of LocalDir into SealedFile
+func (r *CachedRepo) Seal() error {
+	if r.SealedFile == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.SealedFile), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(r.SealedFile), ".seal-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	w, err := newSealWriter(f, r.SealKey)
+	if err != nil {
+		return err
+	}
+	if err = tarTree(w, r.LocalDir); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), r.SealedFile)
+}
+
+// Evict removes the decrypted content of a sealed repo
+func (r *CachedRepo) Evict() error {
+	if r.SealedFile == "" {
+		return nil
+	}
+	return removeAll(r.LocalDir)
+}
+
+// tarTree writes everything under dir as tar into w, including .git
+func tarTree(w io.Writer, dir string) error {
+	aw := &tarArchiveWriter{w: tar.NewWriter(w)}
+	err := filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil || fn == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fn)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			return aw.WriteDir(name, fi.ModTime())
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(fn)
+			if err != nil {
+				return err
+			}
+			return aw.WriteLink(name, filepath.ToSlash(target), fi.ModTime())
+		case fi.Mode().IsRegular():
+			f, err := os.Open(fn)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return aw.WriteFile(name, archiveMode(fi.Mode()), fi.Size(), fi.ModTime(), f)
+		}
+		return nil
+	})
+	if err != nil {
+		aw.Close()
+		return err
+	}
+	return aw.Close()
+}
+
+// sealWriter encrypts a stream with AES-GCM in chunks of sealChunkSize,
+// the nonce of each chunk is derived from the random file nonce and
+// the chunk index, and the last chunk is marked in additional data so
+// truncation is detected
+type sealWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	index uint64
+	buf   []byte
+}
+
+func newSealAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, ErrNoSealKey
+	}
+	if len(key) != SealKeySize {
+		return nil, ErrInvalidSealKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newSealWriter(w io.Writer, key []byte) (*sealWriter, error) {
+	aead, err := newSealAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sw := &sealWriter{w: w, aead: aead, nonce: make([]byte, aead.NonceSize())}
+	if _, err = rand.Read(sw.nonce); err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(w, sealMagic); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(sw.nonce); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// chunkNonce returns the nonce of chunk index
+func chunkNonce(nonce []byte, index uint64) []byte {
+	n := append([]byte{}, nonce...)
+	tail := n[len(n)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^index)
+	return n
+}
+
+func chunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func (w *sealWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// a full chunk is flushed only when more data follows, so the
+		// final chunk is written by Close
+		if len(w.buf) == sealChunkSize {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		n := sealChunkSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *sealWriter) flush(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.nonce, w.index), w.buf, chunkAD(final))
+	w.index++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(sealed)
+	return err
+}
+
+// Close writes the final chunk, the underlying writer is not closed
+func (w *sealWriter) Close() error {
+	return w.flush(true)
+}
+
+// sealReader decrypts the stream of sealWriter
+type sealReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	index uint64
+	buf   []byte
+	final bool
+}
+
+func newSealReader(r io.Reader, key []byte) (*sealReader, error) {
+	aead, err := newSealAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(sealMagic)+aead.NonceSize())
+	if _, err = io.ReadFull(r, header); err != nil || string(header[:len(sealMagic)]) != sealMagic {
+		return nil, ErrSealCorrupted
+	}
+	return &sealReader{r: bufio.NewReader(r), aead: aead, nonce: header[len(sealMagic):]}, nil
+}
+
+func (r *sealReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.final {
+			return 0, io.EOF
+		}
+		chunk := make([]byte, sealChunkSize+r.aead.Overhead())
+		n, err := io.ReadFull(r.r, chunk)
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			r.final = true
+		} else if err != nil {
+			return 0, err
+		} else if _, err = r.r.Peek(1); err == io.EOF {
+			r.final = true
+		}
+		plain, err := r.aead.Open(chunk[:0], chunkNonce(r.nonce, r.index), chunk[:n], chunkAD(r.final))
+		if err != nil {
+			return 0, ErrSealCorrupted
+		}
+		r.index++
+		r.buf = plain
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package gms
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// makePrivateDir creates dir unless it exists and checks it's a
+// directory owned by the current user and accessible by nobody else,
+// another user may have created it first
+func makePrivateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("%s: %w", dir, ErrUnsafeUnsealDir)
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("%s: %w", dir, ErrUnsafeUnsealDir)
+	}
+	return nil
+}
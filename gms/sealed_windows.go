@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package gms
+
+import "fmt"
+
+// makePrivateDir fails as there's no /dev/shm, RepoCache.UnsealDir
+// must be set
+func makePrivateDir(dir string) error {
+	return fmt.Errorf("%s: %w", dir, ErrUnsafeUnsealDir)
+}
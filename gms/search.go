@@ -0,0 +1,188 @@
+package gms
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DefaultSearchConcurrency is the default number of repos searched concurrently
+const DefaultSearchConcurrency = 4
+
+// SearchQuery specifies files to find across cached repos,
+// all specified conditions must match
+type SearchQuery struct {
+	// Glob matches repo-relative slash-separated path, or the base name
+	// if the pattern contains no "/"
+	Glob string
+	// Regexp matches repo-relative slash-separated path
+	Regexp *regexp.Regexp
+	// Content matches lines of file content, each matching line is
+	// reported as a result
+	Content *regexp.Regexp
+	// Repos limits search to the named repos, all repos if empty
+	Repos []string
+	// Concurrency is max number of repos searched concurrently
+	Concurrency int
+}
+
+// SearchResult is a file found by Search
+type SearchResult struct {
+	// RepoName is the name of cached repo
+	RepoName string
+	// Path is slash-separated path relative to BasePath
+	Path string
+	// Line is the 1-based line number if Content is specified
+	Line int
+	// Text is the matching line if Content is specified
+	Text string
+	// Err reports failure searching the repo, other fields except
+	// RepoName are empty
+	Err error
+}
+
+// Search finds files across cached repos, results are streamed to the
+// returned channel which is closed when search completes or ctx is done
+func (c *RepoCache) Search(ctx context.Context, q SearchQuery) <-chan SearchResult {
+	names := q.Repos
+	if len(names) == 0 {
+		names = c.RepoNames()
+	}
+	concurrency := q.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultSearchConcurrency
+	}
+	results := make(chan SearchResult)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		repo := c.repos[name]
+		if repo == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(repo *CachedRepo) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			if err := q.searchRepo(ctx, repo, results); err != nil && ctx.Err() == nil {
+				select {
+				case results <- SearchResult{RepoName: repo.Name, Err: err}:
+				case <-ctx.Done():
+				}
+			}
+		}(repo)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func (q *SearchQuery) searchRepo(ctx context.Context, repo *CachedRepo, results chan<- SearchResult) error {
+	if err := repo.Unseal(); err != nil {
+		return err
+	}
+	emit := func(r SearchResult) error {
+		select {
+		case results <- r:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	match := func(ctx context.Context, rel string) error {
+		if !q.matchPath(rel) {
+			return nil
+		}
+		// the index may be built under another policy
+		if err := repo.CheckContent(ctx, rel); errors.Is(err, ErrContentDenied) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if q.Content == nil {
+			return emit(SearchResult{RepoName: repo.Name, Path: rel})
+		}
+		return q.grep(ctx, repo, rel, func(line int, text string) error {
+			return emit(SearchResult{RepoName: repo.Name, Path: rel, Line: line, Text: text})
+		})
+	}
+	if x, err := repo.PathIndex(); err == nil {
+		for _, entry := range x.Entries {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			if err = match(ctx, entry.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := &RepoWalker{
+		WalkerFn: func(item WalkingItem) error {
+			if item.FileInfo.IsDir() {
+				return nil
+			}
+			return match(item.Context(), item.RelPath)
+		},
+	}
+	w.Use(FilterVCS())
+	return w.VisitContext(ctx, repo.Name, repo)
+}
+
+func (q *SearchQuery) matchPath(rel string) bool {
+	if q.Glob != "" {
+		target := rel
+		if !strings.Contains(q.Glob, "/") {
+			target = path.Base(rel)
+		}
+		if matched, _ := path.Match(q.Glob, target); !matched {
+			return false
+		}
+	}
+	return q.Regexp == nil || q.Regexp.MatchString(rel)
+}
+
+// grep matches lines of rel against Content, only regular files are
+// read and dangling symbolic links or ones resolving outside of the
+// repo are skipped
+func (q *SearchQuery) grep(ctx context.Context, repo Repository, rel string, fn func(int, string) error) error {
+	f, err := OpenRepoContent(repo, rel)
+	if errors.Is(err, ErrPathEscapes) || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if !info.Mode().IsRegular() {
+		return nil
+	}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if text := scanner.Text(); q.Content.MatchString(text) {
+			if err = fn(line, text); err != nil {
+				return err
+			}
+		}
+	}
+	if err = scanner.Err(); err == bufio.ErrTooLong {
+		return nil
+	}
+	return err
+}
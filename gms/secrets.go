@@ -0,0 +1,152 @@
+package gms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNoSecretProvider indicates a repo references secrets but no
+	// SecretProvider is set
+	ErrNoSecretProvider = errors.New("no secret provider")
+	// ErrSecretNotFound indicates the provider has no secret of the name
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrUnsupportedSecretProvider indicates no factory for the scheme of
+	// a secret provider spec
+	ErrUnsupportedSecretProvider = errors.New("unsupported secret provider")
+)
+
+// SecretProvider resolves tokens and keys by name when a sync needs
+// them, so only names are persisted. Implementations for Vault or OS
+// keychains are registered in SecretProviderFactories
+type SecretProvider interface {
+	// Secret returns the secret of name, ErrSecretNotFound if none
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// SecretProviderFactories creates secret providers by the scheme of
+// location in OpenSecretProvider
+var SecretProviderFactories = map[string]func(location string) (SecretProvider, error){
+	"env": func(location string) (SecretProvider, error) {
+		return &EnvSecrets{Prefix: location}, nil
+	},
+	"file": func(location string) (SecretProvider, error) {
+		return &FileSecrets{Dir: location}, nil
+	},
+	"cmd": func(location string) (SecretProvider, error) {
+		return &CommandSecrets{Command: location}, nil
+	},
+}
+
+// OpenSecretProvider creates a secret provider from spec in the form of
+// SCHEME:LOCATION, e.g. env:GMS_SECRET_, or a directory of FileSecrets
+func OpenSecretProvider(spec string) (SecretProvider, error) {
+	pos := strings.Index(spec, ":")
+	// a single letter is a drive on windows
+	if pos <= 1 {
+		return &FileSecrets{Dir: spec}, nil
+	}
+	f := SecretProviderFactories[spec[:pos]]
+	if f == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSecretProvider, spec[:pos])
+	}
+	return f(spec[pos+1:])
+}
+
+// SecretProviders resolves secrets from the first provider having them
+type SecretProviders []SecretProvider
+
+// Secret implements SecretProvider
+func (p SecretProviders) Secret(ctx context.Context, name string) (string, error) {
+	for _, provider := range p {
+		value, err := provider.Secret(ctx, name)
+		if !errors.Is(err, ErrSecretNotFound) {
+			return value, err
+		}
+	}
+	return "", ErrSecretNotFound
+}
+
+// EnvSecrets resolves secrets from environment variables named Prefix
+// followed by the name in upper case with other characters than letters
+// and digits replaced by _, e.g. GMS_SECRET_GITHUB_TOKEN of github-token
+type EnvSecrets struct {
+	Prefix string
+}
+
+// Secret implements SecretProvider
+func (p *EnvSecrets) Secret(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(p.Prefix + envSecretName(name))
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// envSecretName converts name into an environment variable name
+func envSecretName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// FileSecrets resolves secrets from files of the names in Dir, e.g.
+// mounted Kubernetes or Docker secrets. A trailing newline is removed
+type FileSecrets struct {
+	Dir string
+}
+
+// Secret implements SecretProvider
+func (p *FileSecrets) Secret(ctx context.Context, name string) (string, error) {
+	fn, err := SafeJoin(p.Dir, name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return "", ErrSecretNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
+
+// CommandSecrets resolves secrets by the output of a shell command with
+// the name in $GMS_SECRET, e.g. `secret-tool lookup gms "$GMS_SECRET"`
+// for a keychain. Empty output, or failing silently, is ErrSecretNotFound
+type CommandSecrets struct {
+	Command string
+}
+
+// Secret implements SecretProvider
+func (p *CommandSecrets) Secret(ctx context.Context, name string) (string, error) {
+	cmd := hookCommand(ctx, p.Command)
+	cmd.Env = append(append([]string{}, os.Environ()...), "GMS_SECRET="+name)
+	var errout bytes.Buffer
+	cmd.Stderr = &errout
+	out, err := cmd.Output()
+	value := strings.TrimRight(string(out), "\r\n")
+	if err != nil {
+		if msg := strings.TrimSpace(errout.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", p.Command, err, msg)
+		}
+		if value != "" {
+			return "", fmt.Errorf("%s: %w", p.Command, err)
+		}
+	}
+	if value == "" {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
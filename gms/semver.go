@@ -0,0 +1,197 @@
+package gms
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidVersion indicates a version string is not semantic version
+	ErrInvalidVersion = errors.New("invalid semantic version")
+	// ErrInvalidConstraint indicates a malformed version constraint
+	ErrInvalidConstraint = errors.New("invalid version constraint")
+)
+
+// SemVer is a semantic version
+type SemVer struct {
+	Major int
+	Minor int
+	Patch int
+	// Pre is the pre-release part without leading "-"
+	Pre string
+}
+
+// ParseSemVer parses version like "v1.2.3-rc.1", minor and patch are optional
+// and build metadata is ignored
+func ParseSemVer(s string) (v SemVer, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if pos := strings.Index(s, "+"); pos >= 0 {
+		s = s[:pos]
+	}
+	if pos := strings.Index(s, "-"); pos >= 0 {
+		v.Pre = s[pos+1:]
+		s = s[:pos]
+		if v.Pre == "" {
+			return v, ErrInvalidVersion
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, ErrInvalidVersion
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, e := strconv.Atoi(part)
+		if e != nil || n < 0 {
+			return v, ErrInvalidVersion
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// String formats the version with leading "v"
+func (v SemVer) String() string {
+	s := "v" + strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." + strconv.Itoa(v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0, 1 if v is less than, equal to or greater than o
+func (v SemVer) Compare(o SemVer) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if c := compareInt(an, bn); c != 0 {
+				return c
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+// VersionConstraint is a set of alternatives ("||") each containing
+// comparators which must all match, e.g. ">=1.2 <2.0 || ^3.1"
+type VersionConstraint [][]versionComparator
+
+type versionComparator struct {
+	op string
+	v  SemVer
+}
+
+// ParseConstraint parses a version constraint, supported operators
+// are =, !=, >, >=, <, <=, ~ (same minor) and ^ (same major)
+func ParseConstraint(s string) (VersionConstraint, error) {
+	var c VersionConstraint
+	for _, alt := range strings.Split(s, "||") {
+		var comps []versionComparator
+		for _, field := range strings.Fields(alt) {
+			op := ""
+			for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "~", "^"} {
+				if strings.HasPrefix(field, prefix) {
+					op = prefix
+					break
+				}
+			}
+			v, err := ParseSemVer(field[len(op):])
+			if err != nil {
+				return nil, ErrInvalidConstraint
+			}
+			if op == "" {
+				op = "="
+			}
+			comps = append(comps, versionComparator{op: op, v: v})
+		}
+		if len(comps) == 0 {
+			return nil, ErrInvalidConstraint
+		}
+		c = append(c, comps)
+	}
+	return c, nil
+}
+
+// Match checks if v satisfies the constraint
+func (c VersionConstraint) Match(v SemVer) bool {
+	for _, comps := range c {
+		matched := true
+		for _, comp := range comps {
+			if !comp.match(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionComparator) match(v SemVer) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~":
+		return cmp >= 0 && v.Major == c.v.Major && v.Minor == c.v.Minor
+	case "^":
+		return cmp >= 0 && v.Major == c.v.Major
+	}
+	return false
+}
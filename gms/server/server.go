@@ -0,0 +1,328 @@
+// Package server serves content of a gms.RepoCache over HTTP
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// Server is an http.Handler exposing a RepoCache:
+//
+//	GET  /repos                          list cached repos
+//	GET  /repos/{name}/files/{path...}   download a file or list a directory
+//	GET  /repos/{name}/archive/{path...} archive of a subtree, ?format=tar|zip
+//	POST /repos/{name}/sync              sync the repo, requires Token
+//	POST /hooks/{provider}               push webhook, github|gitlab|bitbucket
+//	GET  /metrics                        served by Metrics if set
+type Server struct {
+	// Cache is the loaded cache to serve
+	Cache *gms.RepoCache
+	// Token authenticates POST requests as bearer token,
+	// POST requests are rejected if empty
+	Token string
+	// WebhookSecret verifies webhook requests, webhooks are rejected
+	// if empty
+	WebhookSecret string
+	// Metrics serves /metrics if not nil, e.g. prom.Registry
+	Metrics http.Handler
+
+	mux      *http.ServeMux
+	initOnce sync.Once
+
+	queueLock sync.Mutex
+	pending   []string
+	draining  bool
+}
+
+// RepoEntry is an element of repo listing
+type RepoEntry struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Version  string    `json:"version,omitempty"`
+	LastSync time.Time `json:"lastSync,omitempty"`
+}
+
+// FileEntry is an element of directory listing
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.initOnce.Do(func() {
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("GET /repos", s.listRepos)
+		s.mux.HandleFunc("GET /repos/{name}/files/{path...}", s.serveFile)
+		s.mux.HandleFunc("GET /repos/{name}/archive/{path...}", s.serveArchive)
+		s.mux.HandleFunc("POST /repos/{name}/sync", s.syncRepo)
+		s.mux.HandleFunc("POST /hooks/{provider}", s.handleWebhook)
+		if s.Metrics != nil {
+			s.mux.Handle("GET /metrics", s.Metrics)
+		}
+	})
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) listRepos(w http.ResponseWriter, r *http.Request) {
+	entries := []RepoEntry{}
+	for _, name := range s.Cache.RepoNames() {
+		repo := s.Cache.Find(name)
+		entry := RepoEntry{Name: name, Type: repo.Persist().Type}
+		if state, err := repo.State(); err == nil {
+			entry.Version = state.Version
+			entry.LastSync = state.LastSync
+		}
+		entries = append(entries, entry)
+	}
+	writeJSON(w, entries)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	repo, fn, lock, err := s.resolve(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer lock.Unlock()
+	f, err := os.Open(fn)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !fi.IsDir() {
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return
+	}
+	dirEntries, err := f.ReadDir(-1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rel := strings.Trim(r.PathValue("path"), "/")
+	entries := []FileEntry{}
+	for _, entry := range dirEntries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		if !repo.PathPolicy().Allows(path.Join(rel, entry.Name()), entry.IsDir()) ||
+			repo.CheckContent(r.Context(), filepath.FromSlash(path.Join(rel, entry.Name()))) != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, FileEntry{
+			Name:    entry.Name(),
+			Dir:     entry.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	writeJSON(w, entries)
+}
+
+func (s *Server) serveArchive(w http.ResponseWriter, r *http.Request) {
+	repo, dir, lock, err := s.resolve(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer lock.Unlock()
+	if fi, err := os.Stat(dir); err != nil {
+		writeError(w, err)
+		return
+	} else if !fi.IsDir() {
+		http.Error(w, "not a directory", http.StatusBadRequest)
+		return
+	}
+	format := gms.ArchiveFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = gms.ArchiveTar
+	}
+	var contentType string
+	switch format {
+	case gms.ArchiveTar:
+		contentType = "application/x-tar"
+	case gms.ArchiveZip:
+		contentType = "application/zip"
+	default:
+		http.Error(w, gms.ErrUnsupportedArchiveFormat.Error(), http.StatusBadRequest)
+		return
+	}
+	prefix := r.PathValue("name")
+	if sub := strings.Trim(r.PathValue("path"), "/"); sub != "" {
+		prefix = path.Base(sub)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+prefix+"."+string(format)+`"`)
+	opts := gms.ArchiveOptions{Prefix: prefix}
+	if filter := repo.PolicyFilter(r.PathValue("path")); filter != nil {
+		opts.Filters = append(opts.Filters, filter)
+	}
+	if filter := repo.ContentFilter(r.PathValue("path")); filter != nil {
+		opts.Filters = append(opts.Filters, filter)
+	}
+	// errors after streaming started can only abort the response
+	gms.ExportArchive(&gms.LocalRepo{BaseDir: dir}, w, format, opts)
+}
+
+func (s *Server) syncRepo(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	repo := s.Cache.Find(r.PathValue("name"))
+	if repo == nil {
+		writeError(w, gms.ErrRepoNotFound)
+		return
+	}
+	// content is locked by the sync, readers of other repos don't wait
+	if err := repo.SyncContext(r.Context()); err != nil {
+		s.logf("sync %s: %v", repo.Name, err)
+		http.Error(w, "sync failed", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Enqueue schedules a background sync of the repo, syncs are run one
+// at a time and a repo already pending is not queued again
+func (s *Server) Enqueue(name string) {
+	s.queueLock.Lock()
+	defer s.queueLock.Unlock()
+	for _, pending := range s.pending {
+		if pending == name {
+			return
+		}
+	}
+	s.pending = append(s.pending, name)
+	if !s.draining {
+		s.draining = true
+		go s.drain()
+	}
+}
+
+// drain syncs queued repos until the queue is empty, failures are
+// recorded in sync state of the repo
+func (s *Server) drain() {
+	for {
+		s.queueLock.Lock()
+		if len(s.pending) == 0 {
+			s.draining = false
+			s.queueLock.Unlock()
+			return
+		}
+		name := s.pending[0]
+		s.pending = s.pending[1:]
+		s.queueLock.Unlock()
+
+		if repo := s.Cache.Find(name); repo != nil {
+			if err := repo.Sync(); err != nil {
+				s.logf("sync %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// authorized checks the bearer token of the request
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// resolve maps name and path of the request to the repo and a path in
+// its local clone, the repo is looked up once as it may be removed.
+// Paths outside of the path policy are not found. The content is locked
+// shared until the returned lock is released
+func (s *Server) resolve(r *http.Request) (*gms.CachedRepo, string, *gms.RepoLock, error) {
+	repo := s.Cache.Find(r.PathValue("name"))
+	if repo == nil {
+		return nil, "", nil, gms.ErrRepoNotFound
+	}
+	rel := r.PathValue("path")
+	for _, seg := range strings.Split(rel, "/") {
+		if seg == ".git" {
+			return nil, "", nil, os.ErrNotExist
+		}
+	}
+	if err := repo.CheckContent(r.Context(), filepath.FromSlash(rel)); err != nil {
+		return nil, "", nil, err
+	}
+	lock, err := repo.RLock(r.Context())
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err = repo.Unseal(); err != nil {
+		lock.Unlock()
+		return nil, "", nil, err
+	}
+	fn, err := gms.SafeJoin(repo.BasePath(), filepath.FromSlash(rel))
+	if err != nil {
+		lock.Unlock()
+		return nil, "", nil, err
+	}
+	// content left out by the path policy may still be on disk
+	if rel = strings.Trim(rel, "/"); rel != "" {
+		fi, err := os.Stat(fn)
+		if err == nil && !repo.PathPolicy().Allows(rel, fi.IsDir()) {
+			err = os.ErrNotExist
+		}
+		if err != nil {
+			lock.Unlock()
+			return nil, "", nil, err
+		}
+	}
+	return repo, fn, lock, nil
+}
+
+// logf logs to Logger of the cache, credentials in URLs are redacted
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Cache.Logger != nil {
+		s.Cache.Logger.Printf("%s", gms.RedactURL(fmt.Sprintf(format, v...)))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError maps err to status, local paths are not revealed
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, gms.ErrRepoNotFound), os.IsNotExist(err):
+		status = http.StatusNotFound
+	case errors.Is(err, gms.ErrPathEscapes):
+		status = http.StatusBadRequest
+	case errors.Is(err, gms.ErrContentDenied):
+		status = http.StatusForbidden
+	}
+	http.Error(w, http.StatusText(status), status)
+}
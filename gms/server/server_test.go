@@ -0,0 +1,76 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+	"github.com/codingbrain/gms/gms/gmstest"
+)
+
+func TestPathPolicy(t *testing.T) {
+	c := gmstest.TempCache(t)
+	// the fake remote syncs everything regardless of the policy
+	remote := gmstest.NewFakeRemoteRepo(t.Name(), map[string]string{
+		"docs/a.txt":   "a",
+		"secret/b.txt": "b",
+		"c.txt":        "c",
+	})
+	repo, err := c.Add("r", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetPathPolicy("r", &gms.PathPolicy{Exclude: []string{"secret"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Cache: c}
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	for url, code := range map[string]int{
+		"/repos/r/files/docs/a.txt":   http.StatusOK,
+		"/repos/r/files/secret/b.txt": http.StatusNotFound,
+		"/repos/r/files/secret":       http.StatusNotFound,
+		"/repos/r/archive/secret":     http.StatusNotFound,
+	} {
+		if w := get(url); w.Code != code {
+			t.Errorf("%s: status %d, want %d", url, w.Code, code)
+		}
+	}
+
+	var entries []FileEntry
+	if err = json.NewDecoder(get("/repos/r/files/").Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name == "secret" {
+			t.Errorf("excluded directory listed")
+		}
+	}
+	if len(entries) != 2 {
+		t.Errorf("listed %d entries, want 2", len(entries))
+	}
+
+	tr := tar.NewReader(get("/repos/r/archive/").Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == "r/secret/" || hdr.Name == "r/secret/b.txt" {
+			t.Errorf("excluded %s archived", hdr.Name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+// MaxWebhookPayload is the maximum size of accepted webhook payload
+const MaxWebhookPayload = 5 << 20
+
+// pushEvent is the subset of push payloads of supported providers
+type pushEvent struct {
+	Repository struct {
+		// GitHub
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		GitURL   string `json:"git_url"`
+		HTMLURL  string `json:"html_url"`
+		// GitLab
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+		// Bitbucket
+		FullName string `json:"full_name"`
+		Links    struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+	// GitLab
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+		WebURL     string `json:"web_url"`
+	} `json:"project"`
+}
+
+// urls lists all repository URLs in the event
+func (e *pushEvent) urls() []string {
+	urls := []string{
+		e.Repository.CloneURL,
+		e.Repository.SSHURL,
+		e.Repository.GitURL,
+		e.Repository.HTMLURL,
+		e.Repository.GitHTTPURL,
+		e.Repository.GitSSHURL,
+		e.Repository.Links.HTML.Href,
+		e.Project.GitHTTPURL,
+		e.Project.GitSSHURL,
+		e.Project.WebURL,
+	}
+	if e.Repository.FullName != "" && e.Repository.Links.HTML.Href == "" {
+		urls = append(urls, "https://bitbucket.org/"+e.Repository.FullName)
+	}
+	return urls
+}
+
+// handleWebhook receives push events from GitHub, GitLab and Bitbucket
+// and enqueues syncs of cached repos with matching remote
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	// an empty secret would match requests without token
+	if s.WebhookSecret == "" {
+		http.Error(w, "webhooks disabled", http.StatusUnauthorized)
+		return
+	}
+	payload, err := io.ReadAll(io.LimitReader(r.Body, MaxWebhookPayload))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	var push bool
+	switch r.PathValue("provider") {
+	case "github":
+		if !verifySignature(s.WebhookSecret, r.Header.Get("X-Hub-Signature-256"), payload) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		push = r.Header.Get("X-GitHub-Event") == "push"
+	case "gitlab":
+		token := r.Header.Get("X-Gitlab-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.WebhookSecret)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		event := r.Header.Get("X-Gitlab-Event")
+		push = event == "Push Hook" || event == "Tag Push Hook"
+	case "bitbucket":
+		if !verifySignature(s.WebhookSecret, r.Header.Get("X-Hub-Signature"), payload) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		push = r.Header.Get("X-Event-Key") == "repo:push"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if !push {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var event pushEvent
+	if err = json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	names := s.matchRepos(event.urls())
+	for _, name := range names {
+		s.Enqueue(name)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(names)
+}
+
+// verifySignature checks "sha256=<hex>" HMAC of payload
+func verifySignature(secret, signature string, payload []byte) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// matchRepos finds cached git repos whose remote matches any of urls
+func (s *Server) matchRepos(urls []string) []string {
+	keys := make(map[string]bool)
+	for _, u := range urls {
+		if key := repoURLKey(u); key != "" {
+			keys[key] = true
+		}
+	}
+	var names []string
+	for _, name := range s.Cache.RepoNames() {
+		git, ok := s.Cache.Find(name).Remote.(*gms.GitRepo)
+		if !ok {
+			continue
+		}
+		if keys[repoURLKey(git.Remote)] || keys[repoURLKey(git.URL)] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// repoURLKey normalizes https, ssh and scp-like git URLs to host/path
+func repoURLKey(u string) string {
+	parsed, err := gms.ParseRepoURL(strings.TrimSpace(u))
+	if err != nil {
+		return ""
+	}
+	return parsed.Key()
+}
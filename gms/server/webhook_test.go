@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codingbrain/gms/gms/gmstest"
+)
+
+const testPayload = `{"repository":{"clone_url":"https://github.com/org/repo.git"}}`
+
+func webhookRequest(provider string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/hooks/"+provider, strings.NewReader(testPayload))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req
+}
+
+func sign(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(testPayload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookRejectedWithoutSecret(t *testing.T) {
+	s := &Server{Cache: gmstest.TempCache(t)}
+	for _, req := range []*http.Request{
+		webhookRequest("gitlab", map[string]string{"X-Gitlab-Event": "Push Hook"}),
+		webhookRequest("gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": ""}),
+		webhookRequest("github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("")}),
+		webhookRequest("bitbucket", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": sign("")}),
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want %d", req.URL.Path, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestWebhookAuthentication(t *testing.T) {
+	s := &Server{Cache: gmstest.TempCache(t), WebhookSecret: "s3cret"}
+	cases := []struct {
+		req  *http.Request
+		code int
+	}{
+		{webhookRequest("gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "s3cret"}), http.StatusAccepted},
+		{webhookRequest("gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}), http.StatusUnauthorized},
+		{webhookRequest("gitlab", map[string]string{"X-Gitlab-Event": "Push Hook"}), http.StatusUnauthorized},
+		{webhookRequest("github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("s3cret")}), http.StatusAccepted},
+		{webhookRequest("github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign("wrong")}), http.StatusUnauthorized},
+		{webhookRequest("bitbucket", map[string]string{"X-Event-Key": "repo:push", "X-Hub-Signature": sign("s3cret")}), http.StatusAccepted},
+		{webhookRequest("bitbucket", map[string]string{"X-Event-Key": "repo:push"}), http.StatusUnauthorized},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, c.req)
+		if w.Code != c.code {
+			t.Errorf("case %d %s: status %d, want %d", i, c.req.URL.Path, w.Code, c.code)
+		}
+	}
+}
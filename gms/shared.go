@@ -0,0 +1,105 @@
+package gms
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codingbrain/clix.go/clix"
+)
+
+var (
+	// ErrNotWritable indicates the current user can't write a directory
+	// of a shared cache
+	ErrNotWritable = errors.New("not writable by current user")
+)
+
+// SharedGitConfig returns git config letting users of a group work on
+// clones created by each other in the shared cache at baseDir, set it in
+// GitCmd.Config of the git client used with the cache. Ownership checks
+// of git are only relaxed inside baseDir, which needs git 2.46 or later
+func SharedGitConfig(baseDir string) map[string]string {
+	if abs, err := filepath.Abs(baseDir); err == nil {
+		baseDir = abs
+	}
+	return map[string]string{
+		"safe.directory":        filepath.ToSlash(baseDir) + "/*",
+		"core.sharedRepository": "group",
+	}
+}
+
+// SharedCache configures a cache shared by users of a group, e.g. one
+// cache per build host. Directories are group-owned with the setgid bit
+// so new files inherit the group. Content created by Sync is granted the
+// permissions of the owner to the group explicitly, the umask of the
+// process is left alone. It has no effect on Windows. Content made
+// read-only by ReadOnly can only be synced by its owner
+type SharedCache struct {
+	// Group owns the cache, the group of BaseDir if empty
+	Group string
+}
+
+// Share grants the group of a shared cache the permissions the owner has
+// on existing content, creating BaseDir if absent. Only content owned by
+// the current user is changed, other users share theirs
+func (c *RepoCache) Share() error {
+	if c.Shared == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.BaseDir, 0755); err != nil {
+		return err
+	}
+	return shareTree(c.BaseDir, c.Shared.Group)
+}
+
+// share grants the group access to what Sync created: metadata, content
+// and directories up to the cache directory containing MetaDir, new
+// prefixes of CASDir and stores of ObjectsDir
+func (r *CachedRepo) share() error {
+	var errs clix.AggregatedError
+	trees := []string{r.MetaDir, r.localDir()}
+	if r.AtomicSync {
+		trees = append(trees, r.VersionsDir)
+	}
+	for _, dir := range trees {
+		if err := shareTree(dir, ""); !os.IsNotExist(err) {
+			errs.Add(err)
+		}
+	}
+	base := filepath.Dir(filepath.Dir(r.MetaDir))
+	for _, dir := range []string{r.LocalDir, r.MetaDir, r.VersionsDir} {
+		for parent := filepath.Dir(dir); parent != base && isUnder(base, parent); parent = filepath.Dir(parent) {
+			errs.Add(shareDirs(parent, 0))
+		}
+	}
+	if r.CASDir != "" {
+		errs.Add(shareDirs(r.CASDir, 1))
+	}
+	if r.ObjectsDir != "" {
+		errs.Add(shareDirs(r.ObjectsDir, 1))
+	}
+	return errs.Aggregate()
+}
+
+// checkWritable checks the current user can write the directories
+// changed by Sync
+func (r *CachedRepo) checkWritable() error {
+	dirs := []string{r.MetaDir, filepath.Dir(r.LocalDir)}
+	if r.AtomicSync {
+		dirs = append(dirs, r.VersionsDir)
+	} else {
+		dirs = append(dirs, filepath.Join(r.localDir(), ".git"))
+	}
+	for _, dir := range dirs {
+		f, err := os.CreateTemp(dir, ".write-check-")
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: %w: %v", dir, ErrNotWritable, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+//go:build !windows
+// +build !windows
+
+package gms
+
+import (
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// shareTree sets group, setgid bit of directories and group permissions
+// mirroring the owner's of entries owned by the current user in dir
+func shareTree(dir, group string) error {
+	gid := -1
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+	return filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return shareEntry(fn, d, gid)
+	})
+}
+
+// shareDirs shares directories of dir up to depth levels below it like
+// shareTree, files are left alone
+func shareDirs(dir string, depth int) error {
+	err := filepath.WalkDir(dir, func(fn string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if err = shareEntry(fn, d, -1); err != nil {
+			return err
+		}
+		if rel, _ := filepath.Rel(dir, fn); rel != "." && strings.Count(rel, string(filepath.Separator)) >= depth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// shareEntry shares fn if it's a directory or regular file owned by the
+// current user, the group is changed to gid if not negative
+func shareEntry(fn string, d fs.DirEntry, gid int) error {
+	if !d.IsDir() && !d.Type().IsRegular() {
+		return nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if uid := os.Getuid(); !ok || (uid != 0 && int(st.Uid) != uid) {
+		return nil
+	}
+	if gid >= 0 && int(st.Gid) != gid {
+		if err = os.Lchown(fn, -1, gid); err != nil {
+			return err
+		}
+	}
+	perm := info.Mode().Perm()
+	mode := perm | (perm&0700)>>3
+	if d.IsDir() {
+		if mode == perm && info.Mode()&os.ModeSetgid != 0 {
+			return nil
+		}
+		mode |= os.ModeSetgid
+	} else if mode == perm {
+		return nil
+	}
+	return os.Chmod(fn, mode)
+}
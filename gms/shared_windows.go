@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package gms
+
+// shareTree does nothing as permissions are inherited from ACLs
+func shareTree(dir, group string) error {
+	return nil
+}
+
+// shareDirs does nothing as permissions are inherited from ACLs
+func shareDirs(dir string, depth int) error {
+	return nil
+}
@@ -0,0 +1,350 @@
+package gms
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureExt is appended to the name of a signed file to name its
+	// detached signature
+	SignatureExt = ".sig"
+	// SignatureEd25519 is the algorithm of SigningKey
+	SignatureEd25519 = "ed25519"
+
+	signingKeyPrefix = "gms-sign-key:"
+	verifyKeyPrefix  = "gms-verify-key:"
+	// signatureContext separates signed attestations from other messages
+	// signed by the same key
+	signatureContext = "gms-attestation-v1\n"
+)
+
+var (
+	// ErrInvalidSigningKey indicates a signing or verify key is malformed
+	ErrInvalidSigningKey = errors.New("invalid signing key")
+	// ErrUntrustedKey indicates a signature by a key not trusted
+	ErrUntrustedKey = errors.New("signed by untrusted key")
+	// ErrInvalidSignature indicates a signature doesn't match its payload
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrDigestMismatch indicates signed content is modified
+	ErrDigestMismatch = errors.New("content doesn't match signed digest")
+)
+
+// Attestation is the provenance of exported content: what it is, who
+// produced it and from which repos
+type Attestation struct {
+	// Subject names the content, e.g. the exported file
+	Subject string `json:"subject"`
+	// Digest is sha256:HEX of the content
+	Digest string `json:"digest"`
+	// Producer is who exported the content, USER@HOST by default
+	Producer string `json:"producer,omitempty"`
+	// Created is when the content is attested
+	Created time.Time `json:"created"`
+	// Repos are the repos the content is exported from
+	Repos []AttestedRepo `json:"repos,omitempty"`
+}
+
+// AttestedRepo is a repo in provenance
+type AttestedRepo struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Signature is a detached signature of an Attestation
+type Signature struct {
+	// KeyID identifies the key of the signer
+	KeyID string `json:"keyId"`
+	// Algorithm is the signature algorithm of the signer
+	Algorithm string `json:"alg"`
+	// Payload is the signed Attestation in JSON
+	Payload []byte `json:"payload"`
+	// Sig is the signature of Payload
+	Sig []byte `json:"sig"`
+}
+
+// Signer signs attestations, SigningKey is a built-in one. Others, e.g.
+// backed by a KMS, come with a Verifier of their own
+type Signer interface {
+	KeyID() string
+	Algorithm() string
+	Sign(msg []byte) ([]byte, error)
+}
+
+// Verifier verifies signatures of a Signer, VerifyKey verifies those of
+// SigningKey
+type Verifier interface {
+	KeyID() string
+	Verify(algorithm string, msg, sig []byte) error
+}
+
+// SigningKey is an ed25519 private key signing attestations
+type SigningKey struct {
+	key ed25519.PrivateKey
+}
+
+// GenerateSigningKey creates a random signing key
+func GenerateSigningKey() (*SigningKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{key: key}, nil
+}
+
+// ParseSigningKey decodes a signing key encoded by String
+func ParseSigningKey(s string) (*SigningKey, error) {
+	seed, err := decodeKey(s, signingKeyPrefix, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{key: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// String encodes the key, it must be kept secret
+func (k *SigningKey) String() string {
+	return signingKeyPrefix + base64.StdEncoding.EncodeToString(k.key.Seed())
+}
+
+// Public returns the key verifying signatures of k
+func (k *SigningKey) Public() *VerifyKey {
+	return &VerifyKey{key: k.key.Public().(ed25519.PublicKey)}
+}
+
+// KeyID implements Signer
+func (k *SigningKey) KeyID() string {
+	return k.Public().KeyID()
+}
+
+// Algorithm implements Signer
+func (k *SigningKey) Algorithm() string {
+	return SignatureEd25519
+}
+
+// Sign implements Signer
+func (k *SigningKey) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(k.key, msg), nil
+}
+
+// VerifyKey is an ed25519 public key verifying signatures of SigningKey
+type VerifyKey struct {
+	key ed25519.PublicKey
+}
+
+// ParseVerifyKey decodes a verify key encoded by String
+func ParseVerifyKey(s string) (*VerifyKey, error) {
+	key, err := decodeKey(s, verifyKeyPrefix, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return &VerifyKey{key: key}, nil
+}
+
+// String encodes the key to be distributed to consumers
+func (k *VerifyKey) String() string {
+	return verifyKeyPrefix + base64.StdEncoding.EncodeToString(k.key)
+}
+
+// KeyID implements Verifier, it's the first 8 bytes of the sha256 of the
+// key in hex
+func (k *VerifyKey) KeyID() string {
+	sum := sha256.Sum256(k.key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Verify implements Verifier
+func (k *VerifyKey) Verify(algorithm string, msg, sig []byte) error {
+	if algorithm != SignatureEd25519 || !ed25519.Verify(k.key, msg, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// decodeKey decodes base64 of size bytes after prefix
+func decodeKey(s, prefix string, size int) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), prefix)
+	if !ok {
+		return nil, ErrInvalidSigningKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != size {
+		return nil, ErrInvalidSigningKey
+	}
+	return key, nil
+}
+
+// NewAttestation attests content read from r as subject, produced by
+// the current user
+func NewAttestation(subject string, r io.Reader) (*Attestation, error) {
+	digest, err := contentDigest(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{
+		Subject:  subject,
+		Digest:   digest,
+		Producer: defaultProducer(),
+		Created:  time.Now().UTC(),
+	}, nil
+}
+
+// contentDigest returns sha256:HEX of content read from r
+func contentDigest(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// defaultProducer returns USER@HOST of the current process
+func defaultProducer() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
+
+// Provenance returns the repo with its current version for attestations
+func (r *CachedRepo) Provenance() (AttestedRepo, error) {
+	version, err := r.Version()
+	if err != nil {
+		return AttestedRepo{}, err
+	}
+	return AttestedRepo{Name: r.Name, URL: RedactURL(remoteLocation(r.Remote)), Version: version}, nil
+}
+
+// Provenance returns the repos declared by the manifest for attestations
+func (m *CacheManifest) Provenance() []AttestedRepo {
+	repos := make([]AttestedRepo, 0, len(m.Repos))
+	for _, spec := range m.Repos {
+		if !spec.Absent {
+			repos = append(repos, AttestedRepo{Name: spec.Name, URL: RedactURL(spec.URL), Version: spec.Version})
+		}
+	}
+	return repos
+}
+
+// Sign signs the attestation
+func (a *Attestation) Sign(signer Signer) (*Signature, error) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(append([]byte(signatureContext), payload...))
+	if err != nil {
+		return nil, err
+	}
+	return &Signature{KeyID: signer.KeyID(), Algorithm: signer.Algorithm(), Payload: payload, Sig: sig}, nil
+}
+
+// ReadSignature parses a signature written by Encode
+func ReadSignature(r io.Reader) (*Signature, error) {
+	sig := &Signature{}
+	if err := json.NewDecoder(r).Decode(sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return sig, nil
+}
+
+// Encode writes the signature in JSON
+func (s *Signature) Encode(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// Verify checks the signature is made by a trusted key and content read
+// from r is the signed one, the attestation is returned
+func (s *Signature) Verify(r io.Reader, trusted ...Verifier) (*Attestation, error) {
+	var verifier Verifier
+	for _, v := range trusted {
+		if v.KeyID() == s.KeyID {
+			verifier = v
+			break
+		}
+	}
+	if verifier == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUntrustedKey, s.KeyID)
+	}
+	if err := verifier.Verify(s.Algorithm, append([]byte(signatureContext), s.Payload...), s.Sig); err != nil {
+		return nil, err
+	}
+	a := &Attestation{}
+	if err := json.Unmarshal(s.Payload, a); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	digest, err := contentDigest(r)
+	if err != nil {
+		return nil, err
+	}
+	if digest != a.Digest {
+		return nil, ErrDigestMismatch
+	}
+	return a, nil
+}
+
+// SignFile writes the detached signature of file attested with repos
+// into file+SignatureExt
+func SignFile(file string, signer Signer, repos []AttestedRepo) (*Attestation, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	a, err := NewAttestation(filepath.Base(file), f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	a.Repos = repos
+	sig, err := a.Sign(signer)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.Create(file + SignatureExt)
+	if err != nil {
+		return nil, err
+	}
+	if err = sig.Encode(out); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return a, out.Close()
+}
+
+// VerifyFile verifies file with its detached signature in
+// file+SignatureExt
+func VerifyFile(file string, trusted ...Verifier) (*Attestation, error) {
+	sf, err := os.Open(file + SignatureExt)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := ReadSignature(sf)
+	sf.Close()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sig.Verify(f, trusted...)
+}
@@ -0,0 +1,108 @@
+package gms_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+func signedFile(t *testing.T, key *gms.SigningKey) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "export.tar")
+	if err := os.WriteFile(file, []byte("exported content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repos := []gms.AttestedRepo{{Name: "r", URL: "https://example.com/r.git", Version: "v1"}}
+	if _, err := gms.SignFile(file, key, repos); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSignFile(t *testing.T) {
+	key, err := gms.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := signedFile(t, key)
+	a, err := gms.VerifyFile(file, key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Subject != "export.tar" || len(a.Repos) != 1 || a.Repos[0].Version != "v1" {
+		t.Errorf("unexpected attestation %+v", a)
+	}
+	// keys survive encoding
+	public, err := gms.ParseVerifyKey(key.Public().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = gms.VerifyFile(file, public); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyFileUntrusted(t *testing.T) {
+	key, err := gms.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := gms.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := signedFile(t, key)
+	if _, err = gms.VerifyFile(file); !errors.Is(err, gms.ErrUntrustedKey) {
+		t.Errorf("no keys: got %v, want %v", err, gms.ErrUntrustedKey)
+	}
+	if _, err = gms.VerifyFile(file, other.Public()); !errors.Is(err, gms.ErrUntrustedKey) {
+		t.Errorf("other key: got %v, want %v", err, gms.ErrUntrustedKey)
+	}
+}
+
+func TestVerifyFileModified(t *testing.T) {
+	key, err := gms.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := signedFile(t, key)
+	if err = os.WriteFile(file, []byte("modified content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = gms.VerifyFile(file, key.Public()); !errors.Is(err, gms.ErrDigestMismatch) {
+		t.Errorf("got %v, want %v", err, gms.ErrDigestMismatch)
+	}
+}
+
+func TestVerifyFileForgedPayload(t *testing.T) {
+	key, err := gms.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := signedFile(t, key)
+	f, err := os.Open(file + gms.SignatureExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := gms.ReadSignature(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// still a valid attestation but not the signed one
+	sig.Payload = append(sig.Payload, ' ')
+	out, err := os.Create(file + gms.SignatureExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sig.Encode(out); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	if _, err = gms.VerifyFile(file, key.Public()); !errors.Is(err, gms.ErrInvalidSignature) {
+		t.Errorf("got %v, want %v", err, gms.ErrInvalidSignature)
+	}
+}
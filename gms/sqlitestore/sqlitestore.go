@@ -0,0 +1,353 @@
+// Package sqlitestore keeps metadata of a gms cache in one SQLite
+// database: the repo registry, sync history and content manifests, so
+// caches of many repos can be queried without scanning JSON files. The
+// program must import a database/sql driver of SQLite, e.g.
+// github.com/mattn/go-sqlite3
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/codingbrain/gms/gms"
+)
+
+const (
+	// DefaultFile is the filename of database in cache dir
+	DefaultFile = "gms.db"
+)
+
+// DriverName is the database/sql driver used by Open
+var DriverName = "sqlite3"
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS repos (
+		name TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		opaque TEXT NOT NULL,
+		meta TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS syncs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		repo TEXT NOT NULL,
+		started INTEGER NOT NULL,
+		duration INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		old_version TEXT NOT NULL,
+		new_version TEXT NOT NULL,
+		bytes INTEGER NOT NULL,
+		files_changed INTEGER NOT NULL,
+		error TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS syncs_repo ON syncs (repo, started)`,
+	`CREATE TABLE IF NOT EXISTS manifests (
+		repo TEXT PRIMARY KEY,
+		version TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS files (
+		repo TEXT NOT NULL,
+		path TEXT NOT NULL,
+		digest TEXT NOT NULL,
+		PRIMARY KEY (repo, path)
+	)`,
+	`CREATE INDEX IF NOT EXISTS files_digest ON files (digest)`,
+}
+
+func init() {
+	gms.ConfigStoreFactories["sqlite"] = func(location string) (gms.ConfigStore, error) {
+		return Open(location)
+	}
+}
+
+// Store is a gms.ConfigStore in SQLite which also records sync history
+// and content manifests
+type Store struct {
+	// DB is the database
+	DB *sql.DB
+	// ExportDir is where the registry is mirrored into repos.conf after
+	// every change if not empty, for tools reading the file
+	ExportDir string
+}
+
+// Open opens or creates the database file with DriverName
+func Open(path string) (*Store, error) {
+	db, err := sql.Open(DriverName, path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New creates the store in db, tables are created if absent
+func New(db *sql.DB) (*Store, error) {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{DB: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.DB.Close()
+}
+
+// LoadConfig implements gms.ConfigStore
+func (s *Store) LoadConfig() (*gms.CacheConfig, error) {
+	rows, err := s.DB.Query(`SELECT name, type, opaque, meta FROM repos`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cfg := &gms.CacheConfig{
+		Repos: make(map[string]gms.PersistentHandle),
+		Meta:  make(map[string]*gms.RepoMeta),
+	}
+	for rows.Next() {
+		var (
+			name string
+			h    gms.PersistentHandle
+			meta sql.NullString
+		)
+		if err = rows.Scan(&name, &h.Type, &h.Opaque, &meta); err != nil {
+			return nil, err
+		}
+		cfg.Repos[name] = h
+		if meta.Valid {
+			m := &gms.RepoMeta{}
+			if err = json.Unmarshal([]byte(meta.String), m); err != nil {
+				return nil, err
+			}
+			cfg.Meta[name] = m
+		}
+	}
+	return cfg, rows.Err()
+}
+
+// SaveConfig implements gms.ConfigStore
+func (s *Store) SaveConfig(cfg *gms.CacheConfig) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM repos`); err != nil {
+			return err
+		}
+		for name, h := range cfg.Repos {
+			if err := putRepo(tx, name, &h, cfg.Meta[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.export()
+}
+
+// SaveRepo implements gms.ConfigStore
+func (s *Store) SaveRepo(name string, h *gms.PersistentHandle, meta *gms.RepoMeta) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		if h == nil {
+			_, err := tx.Exec(`DELETE FROM repos WHERE name = ?`, name)
+			return err
+		}
+		return putRepo(tx, name, h, meta)
+	})
+	if err != nil {
+		return err
+	}
+	return s.export()
+}
+
+// Import replaces the registry with the one in src, e.g. a
+// gms.FileConfigStore of an existing cache
+func (s *Store) Import(src gms.ConfigStore) error {
+	cfg, err := src.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return s.SaveConfig(cfg)
+}
+
+func putRepo(tx *sql.Tx, name string, h *gms.PersistentHandle, meta *gms.RepoMeta) error {
+	var encoded sql.NullString
+	if meta != nil {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		encoded = sql.NullString{String: string(data), Valid: true}
+	}
+	_, err := tx.Exec(`INSERT INTO repos (name, type, opaque, meta) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET type = excluded.type, opaque = excluded.opaque, meta = excluded.meta`,
+		name, h.Type, h.Opaque, encoded)
+	return err
+}
+
+// export mirrors the registry into ExportDir
+func (s *Store) export() error {
+	if s.ExportDir == "" {
+		return nil
+	}
+	cfg, err := s.LoadConfig()
+	if err != nil {
+		return err
+	}
+	return (&gms.FileConfigStore{Dir: s.ExportDir}).SaveConfig(cfg)
+}
+
+// RecordSyncs appends results to sync history
+func (s *Store) RecordSyncs(results []*gms.SyncResult) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		for _, res := range results {
+			_, err := tx.Exec(`INSERT INTO syncs (repo, started, duration, action, old_version,
+				new_version, bytes, files_changed, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				res.Repo, res.Started.UnixNano(), int64(res.Duration), string(res.Action), res.OldVersion,
+				res.NewVersion, res.BytesTransferred, res.FilesChanged, res.Error)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// History returns the latest syncs of repo, newest first, all repos if
+// repo is empty and all syncs if limit is not positive
+func (s *Store) History(repo string, limit int) ([]*gms.SyncResult, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.DB.Query(`SELECT repo, started, duration, action, old_version, new_version,
+		bytes, files_changed, error FROM syncs WHERE ? = '' OR repo = ?
+		ORDER BY started DESC, id DESC LIMIT ?`, repo, repo, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []*gms.SyncResult
+	for rows.Next() {
+		var (
+			res      gms.SyncResult
+			started  int64
+			duration int64
+			action   string
+		)
+		if err = rows.Scan(&res.Repo, &started, &duration, &action, &res.OldVersion, &res.NewVersion,
+			&res.BytesTransferred, &res.FilesChanged, &res.Error); err != nil {
+			return nil, err
+		}
+		res.Started = time.Unix(0, started)
+		res.Duration = time.Duration(duration)
+		res.Action = gms.SyncAction(action)
+		if res.Error != "" {
+			res.Err = errors.New(res.Error)
+		}
+		results = append(results, &res)
+	}
+	return results, rows.Err()
+}
+
+// RecordManifest replaces the content manifest of repo
+func (s *Store) RecordManifest(repo string, m *gms.ContentManifest) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM files WHERE repo = ?`, repo); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO manifests (repo, version) VALUES (?, ?)
+			ON CONFLICT (repo) DO UPDATE SET version = excluded.version`, repo, m.Version)
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(`INSERT INTO files (repo, path, digest) VALUES (?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for path, digest := range m.Files {
+			if _, err = stmt.Exec(repo, path, digest); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Manifest returns the content manifest of repo, nil if not recorded
+func (s *Store) Manifest(repo string) (*gms.ContentManifest, error) {
+	m := &gms.ContentManifest{Files: make(map[string]string)}
+	err := s.DB.QueryRow(`SELECT version FROM manifests WHERE repo = ?`, repo).Scan(&m.Version)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.Query(`SELECT path, digest FROM files WHERE repo = ?`, repo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path, digest string
+		if err = rows.Scan(&path, &digest); err != nil {
+			return nil, err
+		}
+		m.Files[path] = digest
+	}
+	return m, rows.Err()
+}
+
+// FileRef is a file in content of a cached repo
+type FileRef struct {
+	Repo string
+	Path string
+}
+
+// FindByDigest lists files with digest across all recorded manifests
+func (s *Store) FindByDigest(digest string) ([]FileRef, error) {
+	rows, err := s.DB.Query(`SELECT repo, path FROM files WHERE digest = ? ORDER BY repo, path`, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var refs []FileRef
+	for rows.Next() {
+		var ref FileRef
+		if err = rows.Scan(&ref.Repo, &ref.Path); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// PostSync is a gms.SyncHook recording the content manifest of synced
+// repos
+func (s *Store) PostSync(ctx context.Context, ev *gms.SyncEvent) error {
+	m, err := gms.BuildContentManifest(ev.Dir)
+	if err != nil {
+		return err
+	}
+	m.Version = ev.NewVersion
+	return s.RecordManifest(ev.Repo, m)
+}
+
+func (s *Store) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,175 @@
+package gms
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// KnownHostsFile is the filename of managed known_hosts in cache dir
+	KnownHostsFile = "known_hosts"
+)
+
+var (
+	// ErrInvalidHostKey indicates a pinned host key is malformed
+	ErrInvalidHostKey = errors.New("invalid host key")
+	// ErrKnownHostsRequired indicates host keys are pinned without
+	// a known_hosts file to write them into
+	ErrKnownHostsRequired = errors.New("known_hosts file required to pin host keys")
+)
+
+// HostKeyChecking is the StrictHostKeyChecking option of OpenSSH
+type HostKeyChecking string
+
+// Host key checking modes
+const (
+	// HostKeyCheckingDefault leaves it to ssh config, unknown hosts
+	// fail as ssh can't prompt
+	HostKeyCheckingDefault HostKeyChecking = ""
+	// HostKeyCheckingYes rejects hosts not in known_hosts
+	HostKeyCheckingYes HostKeyChecking = "yes"
+	// HostKeyCheckingAcceptNew trusts unknown hosts on first use and
+	// rejects changed keys
+	HostKeyCheckingAcceptNew HostKeyChecking = "accept-new"
+	// HostKeyCheckingNo trusts any host key
+	HostKeyCheckingNo HostKeyChecking = "no"
+)
+
+// ParseHostKeyChecking validates s as HostKeyChecking
+func ParseHostKeyChecking(s string) (HostKeyChecking, error) {
+	switch v := HostKeyChecking(s); v {
+	case HostKeyCheckingDefault, HostKeyCheckingYes, HostKeyCheckingAcceptNew, HostKeyCheckingNo:
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid host key checking %q", s)
+}
+
+// SSHOptions controls host key verification of ssh remotes used by
+// GitCmd. ssh never prompts, so it fails instead of hanging without a
+// terminal
+type SSHOptions struct {
+	// Command is the ssh program, "ssh" if empty
+	Command string
+	// KnownHostsFile replaces the user known_hosts if not empty
+	KnownHostsFile string
+	// StrictHostKeyChecking controls trusting hosts not in known_hosts
+	StrictHostKeyChecking HostKeyChecking
+	// HostKeys pins keys of hosts, e.g. "github.com" or "[git.corp]:2222"
+	// to "ssh-ed25519 AAAA...". They replace keys of the hosts in
+	// KnownHostsFile before the first command, keys of other hosts,
+	// e.g. accepted as new, are kept
+	HostKeys map[string][]string
+
+	once         sync.Once
+	provisionErr error
+}
+
+// SSHCommand returns the value of GIT_SSH_COMMAND
+func (o *SSHOptions) SSHCommand() string {
+	cmd := o.Command
+	if cmd == "" {
+		cmd = "ssh"
+	}
+	args := []string{cmd, "-o", "BatchMode=yes"}
+	if o.StrictHostKeyChecking != HostKeyCheckingDefault {
+		args = append(args, "-o", "StrictHostKeyChecking="+string(o.StrictHostKeyChecking))
+	}
+	if o.KnownHostsFile != "" {
+		args = append(args, "-o", shellQuote("UserKnownHostsFile="+filepath.ToSlash(o.KnownHostsFile)))
+	}
+	return strings.Join(args, " ")
+}
+
+// env provisions pinned host keys once and returns environment
+// variables for git
+func (o *SSHOptions) env() ([]string, error) {
+	if o == nil {
+		return nil, nil
+	}
+	o.once.Do(func() { o.provisionErr = o.Provision() })
+	if o.provisionErr != nil {
+		return nil, o.provisionErr
+	}
+	return []string{"GIT_SSH_COMMAND=" + o.SSHCommand()}, nil
+}
+
+// Provision writes HostKeys into KnownHostsFile, replacing existing keys
+// of the pinned hosts
+func (o *SSHOptions) Provision() error {
+	if len(o.HostKeys) == 0 {
+		return nil
+	}
+	if o.KnownHostsFile == "" {
+		return ErrKnownHostsRequired
+	}
+	hosts := make([]string, 0, len(o.HostKeys))
+	for host, keys := range o.HostKeys {
+		for _, key := range keys {
+			if err := validateHostKey(key); err != nil {
+				return fmt.Errorf("%s: %w", host, err)
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var lines []string
+	f, err := os.Open(o.KnownHostsFile)
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if fields := strings.Fields(line); len(fields) > 0 && o.pinned(fields[0]) {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		f.Close()
+		if err = scanner.Err(); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for _, host := range hosts {
+		for _, key := range o.HostKeys[host] {
+			lines = append(lines, host+" "+strings.TrimSpace(key))
+		}
+	}
+	return saveFile(o.KnownHostsFile, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// pinned tells if any of comma-separated hosts of a known_hosts line
+// is in HostKeys
+func (o *SSHOptions) pinned(hosts string) bool {
+	for _, host := range strings.Split(hosts, ",") {
+		if _, ok := o.HostKeys[host]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHostKey checks key is "type base64-key [comment]"
+func validateHostKey(key string) error {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return ErrInvalidHostKey
+	}
+	if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidHostKey, err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for sh which runs GIT_SSH_COMMAND
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
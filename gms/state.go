@@ -0,0 +1,88 @@
+package gms
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// StateFile is the filename of sync state in meta dir
+	StateFile = "state.json"
+)
+
+// SyncState is the persisted sync history of a cached repo
+type SyncState struct {
+	// Created is when the repo was added to cache
+	Created time.Time `json:"created"`
+	// LastAttempt is when the last sync started
+	LastAttempt time.Time `json:"last-attempt"`
+	// LastSync is when the last successful sync finished
+	LastSync time.Time `json:"last-sync"`
+	// Version is the content version after last successful sync
+	Version string `json:"version,omitempty"`
+	// RemoteVersion is the version of the remote at the last sync with
+	// change detection, see ChangeDetector
+	RemoteVersion string `json:"remote-version,omitempty"`
+	// LastError is the error message of last failed sync
+	LastError string `json:"last-error,omitempty"`
+	// Syncs is the number of successful syncs
+	Syncs int `json:"syncs"`
+	// Failures is the number of failed syncs
+	Failures int `json:"failures"`
+	// Unhealthy is the validation error of content which couldn't be
+	// rolled back
+	Unhealthy string `json:"unhealthy,omitempty"`
+	// Dirty is the first path modified outside of gms since the last
+	// successful sync, see WatchLocal
+	Dirty string `json:"dirty,omitempty"`
+	// Usage is the size of content measured when it last changed, nil
+	// if synced before usage was recorded
+	Usage *ContentUsage `json:"usage,omitempty"`
+}
+
+// ContentUsage is the size of the local clone
+type ContentUsage struct {
+	// DiskUsage is total size in bytes including VCS metadata
+	DiskUsage int64 `json:"disk-usage"`
+	// Files is the number of files excluding VCS metadata
+	Files int `json:"files"`
+}
+
+// State loads persisted sync state, empty state is returned if none
+func (r *CachedRepo) State() (*SyncState, error) {
+	state := &SyncState{}
+	err := loadJSON(filepath.Join(r.MetaDir, StateFile), state)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return state, nil
+}
+
+// updateState loads, modifies and saves sync state
+func (r *CachedRepo) updateState(fn func(*SyncState)) error {
+	state, err := r.State()
+	if err != nil {
+		return err
+	}
+	fn(state)
+	return saveJSON(filepath.Join(r.MetaDir, StateFile), state)
+}
+
+// recordSync updates sync state with the result of a sync
+func (r *CachedRepo) recordSync(started time.Time, syncErr error) error {
+	return r.updateState(func(s *SyncState) {
+		s.LastAttempt = started
+		if syncErr != nil {
+			s.LastError = syncErr.Error()
+			s.Failures++
+		} else {
+			if version, err := r.Version(); err == nil {
+				s.Version = version
+			}
+			s.LastError, s.Dirty = "", ""
+			s.LastSync = time.Now()
+			s.Syncs++
+		}
+	})
+}
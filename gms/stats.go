@@ -0,0 +1,114 @@
+package gms
+
+import (
+	"os"
+	"time"
+)
+
+// RepoStats are statistics of a single cached repo
+type RepoStats struct {
+	// Name of the cached repo
+	Name string
+	// DiskUsage is total size in bytes of the local clone
+	DiskUsage int64
+	// Files is the number of files excluding VCS metadata
+	Files int
+	// LastSync is when the last successful sync finished
+	LastSync time.Time
+	// Syncs is the number of successful syncs
+	Syncs int
+	// Failures is the number of failed syncs
+	Failures int
+	// Age is the duration since the repo was added
+	Age time.Duration
+}
+
+// CacheStats are statistics of a RepoCache
+type CacheStats struct {
+	// Repos are per-repo statistics ordered by name
+	Repos []RepoStats
+	// DiskUsage is total size in bytes of all local clones
+	DiskUsage int64
+	// Files is the total number of files
+	Files int
+	// Failures is the total number of failed syncs
+	Failures int
+	// NeverSynced is the number of repos not synced yet
+	NeverSynced int
+}
+
+// Stats collects statistics of the cached repo, the size of content is
+// the one recorded when it last changed
+func (r *CachedRepo) Stats() (*RepoStats, error) {
+	stats := &RepoStats{Name: r.Name}
+	state, err := r.State()
+	if err != nil {
+		return nil, err
+	}
+	stats.LastSync = state.LastSync
+	stats.Syncs = state.Syncs
+	stats.Failures = state.Failures
+	if !state.Created.IsZero() {
+		stats.Age = time.Since(state.Created)
+	}
+	usage := state.Usage
+	if usage == nil {
+		// synced before usage was recorded
+		if usage, err = r.measureUsage(); err != nil {
+			return nil, err
+		}
+	}
+	stats.DiskUsage, stats.Files = usage.DiskUsage, usage.Files
+	return stats, nil
+}
+
+// measureUsage walks the local clone to measure its size
+func (r *CachedRepo) measureUsage() (*ContentUsage, error) {
+	usage := &ContentUsage{}
+	localDir := r.localDir()
+	if _, err := os.Stat(localDir); os.IsNotExist(err) {
+		return usage, nil
+	}
+	w := &RepoWalker{
+		WalkerFn: func(item WalkingItem) error {
+			usage.DiskUsage += item.FileInfo.Size()
+			if !item.FileInfo.IsDir() && !isVCSPath(localDir, item.Path) {
+				usage.Files++
+			}
+			return nil
+		},
+	}
+	if err := w.Visit(r.Name, &LocalRepo{BaseDir: localDir}); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// recordUsage measures content after it changed for Stats
+func (r *CachedRepo) recordUsage() error {
+	usage, err := r.measureUsage()
+	if err != nil {
+		return err
+	}
+	return r.updateState(func(s *SyncState) { s.Usage = usage })
+}
+
+// Stats collects statistics of all cached repos
+func (c *RepoCache) Stats() (*CacheStats, error) {
+	stats := &CacheStats{}
+	for _, name := range c.RepoNames() {
+		repoStats, err := c.repos[name].Stats()
+		if err != nil {
+			return nil, err
+		}
+		stats.Repos = append(stats.Repos, *repoStats)
+		stats.DiskUsage += repoStats.DiskUsage
+		stats.Files += repoStats.Files
+		stats.Failures += repoStats.Failures
+		if repoStats.LastSync.IsZero() {
+			stats.NeverSynced++
+		}
+	}
+	setMetric(c.Metrics, MetricCacheBytes, float64(stats.DiskUsage), nil)
+	return stats, nil
+}
@@ -0,0 +1,43 @@
+package gms_test
+
+import (
+	"testing"
+
+	"github.com/codingbrain/gms/gms/gmstest"
+)
+
+func TestStats(t *testing.T) {
+	c := gmstest.TempCache(t)
+	remote := gmstest.NewFakeRemoteRepo(t.Name(), map[string]string{"a.txt": "aa", "dir/b.txt": "bbb"})
+	r, err := c.Add("r", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.NeverSynced != 1 || stats.Files != 0 {
+		t.Errorf("before sync: %+v", stats)
+	}
+	if err = r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	repoStats, err := r.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repoStats.Files != 2 || repoStats.DiskUsage < 5 || repoStats.Syncs != 1 {
+		t.Errorf("after sync: %+v", repoStats)
+	}
+	// usage is recorded by sync rather than measured by Stats
+	if err = gmstest.WriteFiles(r.BasePath(), map[string]string{"c.txt": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if repoStats, err = r.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if repoStats.Files != 2 {
+		t.Errorf("files %d, want 2", repoStats.Files)
+	}
+}
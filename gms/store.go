@@ -0,0 +1,47 @@
+package gms
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/codingbrain/clix.go/conf"
+)
+
+// saveJSON encodes v as JSON and writes to file fn
+func saveJSON(fn string, v interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return saveFile(fn, encoded)
+}
+
+// saveFile replaces file fn with data
+func saveFile(fn string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	fs := conf.NewFileStore(fn)
+	w, err := fs.Write()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	w.Commit(true)
+	return nil
+}
+
+// loadJSON reads file fn and decodes JSON into v
+func loadJSON(fn string, v interface{}) error {
+	fs := conf.NewFileStore(fn)
+	rd, err := fs.Read()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	return json.NewDecoder(rd).Decode(v)
+}
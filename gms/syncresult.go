@@ -0,0 +1,160 @@
+package gms
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncAction is what a sync did to the local content
+type SyncAction string
+
+// Sync actions
+const (
+	// SyncCloned indicates content is fetched for the first time
+	SyncCloned SyncAction = "clone"
+	// SyncPulled indicates content is updated to a new version
+	SyncPulled SyncAction = "pull"
+	// SyncUpToDate indicates content is at the latest version already
+	SyncUpToDate SyncAction = "up-to-date"
+	// SyncRecloned indicates content is fetched again as updating failed
+	SyncRecloned SyncAction = "reclone"
+)
+
+// SyncResult describes a sync of a cached repo
+type SyncResult struct {
+	// Repo is the name of cached repo
+	Repo string `json:"repo"`
+	// Action is what's done, empty if the sync failed or is planned
+	Action SyncAction `json:"action,omitempty"`
+	// OldVersion is the version before sync, empty if never synced
+	OldVersion string `json:"oldVersion,omitempty"`
+	// NewVersion is the version after sync
+	NewVersion string `json:"newVersion,omitempty"`
+	// Started is when the sync started
+	Started time.Time `json:"started"`
+	// Duration is how long the sync took
+	Duration time.Duration `json:"duration"`
+	// BytesTransferred is the size of data received, from git progress
+	// for git repos. git omits the size of quick transfers, they count 0
+	BytesTransferred int64 `json:"bytesTransferred"`
+	// FilesChanged is the number of paths changed from OldVersion to
+	// NewVersion, -1 if unknown
+	FilesChanged int `json:"filesChanged"`
+	// Err is the error of the sync
+	Err error `json:"-"`
+	// Error is the message of Err
+	Error string `json:"error,omitempty"`
+}
+
+// syncReport collects what remote repos do during a sync, it's safe
+// for concurrent use
+type syncReport struct {
+	lock       sync.Mutex
+	action     SyncAction
+	bytes      int64
+	oldVersion string
+	newVersion string
+}
+
+type syncReportKey struct{}
+
+func withSyncReport(ctx context.Context, rep *syncReport) context.Context {
+	return context.WithValue(ctx, syncReportKey{}, rep)
+}
+
+func syncReportFromContext(ctx context.Context) *syncReport {
+	rep, _ := ctx.Value(syncReportKey{}).(*syncReport)
+	return rep
+}
+
+// setAction records the action, a later one replaces the earlier
+func (rep *syncReport) setAction(action SyncAction) {
+	if rep == nil {
+		return
+	}
+	rep.lock.Lock()
+	rep.action = action
+	rep.lock.Unlock()
+}
+
+// setVersions records versions before and after updating content
+func (rep *syncReport) setVersions(oldVersion, newVersion string) {
+	if rep == nil {
+		return
+	}
+	rep.lock.Lock()
+	rep.oldVersion, rep.newVersion = oldVersion, newVersion
+	rep.lock.Unlock()
+}
+
+func (rep *syncReport) addBytes(n int64) {
+	if rep == nil || n <= 0 {
+		return
+	}
+	rep.lock.Lock()
+	rep.bytes += n
+	rep.lock.Unlock()
+}
+
+// transferred returns the bytes received so far
+func (rep *syncReport) transferred() int64 {
+	if rep == nil {
+		return 0
+	}
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+	return rep.bytes
+}
+
+// progressArgs returns --progress if ctx collects transfer statistics,
+// git only reports progress to terminals otherwise
+func progressArgs(ctx context.Context) []string {
+	if syncReportFromContext(ctx) != nil {
+		return []string{"--progress"}
+	}
+	return nil
+}
+
+// transferRe matches the final progress of git receiving objects, e.g.
+// "Receiving objects: 100% (10/10), 1.50 MiB | 2.00 MiB/s, done."
+var transferRe = regexp.MustCompile(`(?:Receiving|Unpacking) objects: 100% \(\d+/\d+\), ([\d.]+) (bytes|KiB|MiB|GiB)`)
+
+// parseTransferred sums bytes received in progress output of git
+func parseTransferred(progress string) int64 {
+	var total int64
+	for _, m := range transferRe.FindAllStringSubmatch(progress, -1) {
+		size, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			continue
+		}
+		switch m[2] {
+		case "KiB":
+			size *= 1 << 10
+		case "MiB":
+			size *= 1 << 20
+		case "GiB":
+			size *= 1 << 30
+		}
+		total += int64(size)
+	}
+	return total
+}
+
+// collapseProgress keeps only the last update of progress lines, which
+// are rewritten with carriage returns
+func collapseProgress(s string) string {
+	if !strings.Contains(s, "\r") {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if pos := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); pos >= 0 {
+			lines[i] = strings.TrimRight(line[pos+1:], "\r")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
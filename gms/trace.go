@@ -0,0 +1,45 @@
+package gms
+
+import (
+	"context"
+)
+
+// Tracer starts spans around syncs and walks
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx if any
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation
+type Span interface {
+	// SetAttribute annotates the span, value is string, int, int64 or bool
+	SetAttribute(key string, value interface{})
+	// End finishes the span, marking it failed if err is not nil
+	End(err error)
+}
+
+// DefaultTracer traces operations of the package, nothing is traced if nil
+var DefaultTracer Tracer
+
+// Names of spans
+const (
+	SpanArchiveSync = "gms.archive.sync"
+	SpanGitDetect   = "gms.git.detect"
+	SpanGitSync     = "gms.git.sync"
+	SpanSync        = "gms.sync"
+	SpanSyncAll     = "gms.sync_all"
+	SpanWalk        = "gms.walk"
+)
+
+// startSpan starts a span using DefaultTracer
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if DefaultTracer == nil {
+		return ctx, nopSpan{}
+	}
+	return DefaultTracer.Start(ctx, name)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) End(err error)                              {}
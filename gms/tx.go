@@ -0,0 +1,97 @@
+package gms
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTxDone indicates the transaction is already committed or rolled back
+	ErrTxDone = errors.New("transaction already done")
+)
+
+// CacheTx batches modifications to RepoCache which are applied atomically
+// with a single config write. A RepoCache must not be modified by other
+// means while a transaction is in progress
+type CacheTx struct {
+	cache *RepoCache
+	repos map[string]*CachedRepo
+	added []*CachedRepo
+	done  bool
+}
+
+// Begin starts a transaction
+func (c *RepoCache) Begin() *CacheTx {
+	repos := make(map[string]*CachedRepo, len(c.repos))
+	for name, repo := range c.repos {
+		repos[name] = repo
+	}
+	return &CacheTx{cache: c, repos: repos}
+}
+
+// Add adds a remote repo as a new cached repo in the transaction
+func (tx *CacheTx) Add(name string, repo RemoteRepo) (*CachedRepo, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if r, exists := tx.repos[name]; exists {
+		return r, ErrRepoAlreadyExists
+	}
+	cachedRepo := tx.cache.newRepo(name, repo)
+	tx.repos[name] = cachedRepo
+	tx.added = append(tx.added, cachedRepo)
+	return cachedRepo, nil
+}
+
+// Remove deletes a cached repo in the transaction
+func (tx *CacheTx) Remove(name string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if r, exists := tx.repos[name]; exists {
+		delete(tx.repos, name)
+		for i, added := range tx.added {
+			if added == r {
+				tx.added = append(tx.added[:i], tx.added[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Find returns a cached repo by name as seen in the transaction
+func (tx *CacheTx) Find(name string) *CachedRepo {
+	return tx.repos[name]
+}
+
+// Commit applies all modifications and saves the config once,
+// nothing is applied if saving fails
+func (tx *CacheTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	c := tx.cache
+	repos := c.repos
+	c.repos = tx.repos
+	if err := c.Save(); err != nil {
+		c.repos = repos
+		return err
+	}
+	now := time.Now()
+	for _, repo := range tx.added {
+		err := repo.updateState(func(s *SyncState) {
+			*s = SyncState{Created: now}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards all modifications in the transaction
+func (tx *CacheTx) Rollback() {
+	tx.done = true
+}
@@ -0,0 +1,127 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	// ErrValidationFailed indicates synced content is rejected by a validator
+	ErrValidationFailed = errors.New("validation failed")
+)
+
+// Validator checks content of a cached repo after Sync
+type Validator interface {
+	Validate(ctx context.Context, repo *CachedRepo) error
+}
+
+// ValidatorFunc adapts a function to Validator
+type ValidatorFunc func(ctx context.Context, repo *CachedRepo) error
+
+// Validate implements Validator
+func (f ValidatorFunc) Validate(ctx context.Context, repo *CachedRepo) error {
+	return f(ctx, repo)
+}
+
+// ValidationError is returned by Sync when synced content is rejected
+type ValidationError struct {
+	// Repo is the name of cached repo
+	Repo string
+	// Version is the rejected version
+	Version string
+	// RolledBack is true if the local clone is restored to previous version,
+	// otherwise the repo is marked unhealthy
+	RolledBack bool
+	Err        error
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Repo + ": " + ErrValidationFailed.Error() + ": " + e.Err.Error()
+	if e.RolledBack {
+		msg += " (rolled back)"
+	}
+	return msg
+}
+
+// Unwrap returns the validator error
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrValidationFailed
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidationFailed
+}
+
+// RequireFiles rejects content missing any of paths relative to BasePath
+func RequireFiles(paths ...string) Validator {
+	return ValidatorFunc(func(ctx context.Context, repo *CachedRepo) error {
+		for _, relpath := range paths {
+			fn, err := SafeJoin(repo.BasePath(), relpath)
+			if err != nil {
+				return err
+			}
+			if _, err = os.Stat(fn); err != nil {
+				return fmt.Errorf("required %s: %w", relpath, err)
+			}
+		}
+		return nil
+	})
+}
+
+// RejectLargeFiles rejects content having files larger than limit bytes
+func RejectLargeFiles(limit int64) Validator {
+	return ValidatorFunc(func(ctx context.Context, repo *CachedRepo) error {
+		w := &RepoWalker{
+			WalkerFn: func(item WalkingItem) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if !item.FileInfo.IsDir() && item.FileInfo.Size() > limit {
+					return fmt.Errorf("%s: size %d exceeds %d", item.RelPath, item.FileInfo.Size(), limit)
+				}
+				return nil
+			},
+		}
+		w.Use(FilterVCS())
+		return w.Visit("", repo)
+	})
+}
+
+// validate runs validators, on failure the local clone is checked out at
+// oldVersion if the remote is pinnable, otherwise the state is marked
+// unhealthy. With AtomicSync the staging copy is simply discarded
+func (r *CachedRepo) validate(ctx context.Context, oldVersion string) error {
+	var failure error
+	for _, v := range r.Validators {
+		if failure = v.Validate(ctx, r); failure != nil {
+			break
+		}
+	}
+	if failure == nil {
+		if state, err := r.State(); err != nil || state.Unhealthy == "" {
+			return err
+		}
+		return r.updateState(func(s *SyncState) { s.Unhealthy = "" })
+	}
+	verr := &ValidationError{Repo: r.Name, Err: failure}
+	verr.Version, _ = r.Version()
+	if r.AtomicSync {
+		verr.RolledBack = true
+	} else if pr, ok := r.Remote.(PinnableRepo); ok && oldVersion != "" {
+		if err := pr.Checkout(r.LocalDir, oldVersion); err == nil {
+			verr.RolledBack = true
+		} else {
+			logf(r.Logger, "sync %s: rollback to %s: %v", r.Name, oldVersion, err)
+		}
+	}
+	logf(r.Logger, "sync %s: %v", r.Name, verr)
+	if !verr.RolledBack {
+		if err := r.updateState(func(s *SyncState) { s.Unhealthy = failure.Error() }); err != nil {
+			logf(r.Logger, "sync %s: %v", r.Name, err)
+		}
+	}
+	return verr
+}
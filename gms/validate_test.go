@@ -0,0 +1,61 @@
+package gms_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/codingbrain/gms/gms"
+	"github.com/codingbrain/gms/gms/gmstest"
+)
+
+func TestValidationRollback(t *testing.T) {
+	src := gmstest.TempGitRepo(t, map[string]string{"required.txt": "v1"})
+	c := gmstest.TempCache(t)
+	c.IndexPaths = true
+	c.Validators = []gms.Validator{gms.RequireFiles("required.txt")}
+	remote := &gms.GitRepo{URL: src}
+	if err := remote.Detect(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Add("r", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	v1, err := r.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(gms.DefaultGitCmd, "-C", src, "rm", "-q", "required.txt")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	cmd = exec.Command(gms.DefaultGitCmd, "-C", src, "commit", "-qm", "remove")
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gmstest", "GIT_AUTHOR_EMAIL=gmstest@example.com",
+		"GIT_COMMITTER_NAME=gmstest", "GIT_COMMITTER_EMAIL=gmstest@example.com")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	err = r.Sync()
+	var verr *gms.ValidationError
+	if !errors.As(err, &verr) || !verr.RolledBack {
+		t.Fatalf("got %v, want rolled back ValidationError", err)
+	}
+	if version, _ := r.Version(); version != v1 {
+		t.Errorf("after rollback: version %s, want %s", version, v1)
+	}
+	x, err := r.PathIndex()
+	if err != nil {
+		t.Fatalf("path index after rollback: %v", err)
+	}
+	if _, ok := x.Lookup("required.txt"); !ok {
+		t.Errorf("rolled back content not indexed")
+	}
+}
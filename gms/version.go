@@ -0,0 +1,57 @@
+package gms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Version implements VersionedRepo, it is a digest of path, size and
+// modification time of all files
+func (r *LocalRepo) Version() (string, error) {
+	return mtimeVersion(r)
+}
+
+// Version implements VersionedRepo, it is reported by remote repo if
+// supported, otherwise derived from file modification times
+func (r *CachedRepo) Version() (string, error) {
+	if vr, ok := r.Remote.(VersionedRemoteRepo); ok {
+		return vr.VersionAt(r.LocalDir)
+	}
+	return mtimeVersion(r)
+}
+
+// LastChanged returns the commit which last changed relpath relative to
+// BasePath, ErrNoHistory is returned if the remote keeps no history
+func (r *CachedRepo) LastChanged(relpath string) (*CommitInfo, error) {
+	if hr, ok := r.Remote.(HistoryRepo); ok {
+		return hr.LastChangedAt(r.localDir(), relpath)
+	}
+	return nil, ErrNoHistory
+}
+
+func mtimeVersion(repo Repository) (string, error) {
+	var entries []string
+	w := &RepoWalker{
+		WalkerFn: func(item WalkingItem) error {
+			if item.FileInfo.IsDir() {
+				return nil
+			}
+			entries = append(entries, fmt.Sprintf("%s\x00%d\x00%d\n", item.RelPath,
+				item.FileInfo.Size(), item.FileInfo.ModTime().UnixNano()))
+			return nil
+		},
+	}
+	w.Use(FilterVCS())
+	if err := w.Visit("", repo); err != nil {
+		return "", err
+	}
+	sort.Strings(entries)
+	h := sha256.New()
+	for _, entry := range entries {
+		io.WriteString(h, entry)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
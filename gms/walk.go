@@ -1,11 +1,85 @@
 package gms
 
 import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingbrain/clix.go/clix"
+)
+
+var (
+	// ErrHashUnavailable indicates the hash implementation is not linked
+	ErrHashUnavailable = errors.New("hash function unavailable")
+
+	// SkipDir returned from WalkerFn or a filter on a directory prunes
+	// the directory, returned on a file skips remaining entries in the
+	// same directory
+	SkipDir = filepath.SkipDir
+	// SkipAll returned from WalkerFn or a filter stops the walk without
+	// reporting an error
+	SkipAll = filepath.SkipAll
 )
 
+// SymlinkMode determines how RepoWalker handles symbolic links
+type SymlinkMode int
+
+// Symbolic link modes
+const (
+	// SymlinkReport reports links with FileInfo of the link itself,
+	// directories are not descended
+	SymlinkReport SymlinkMode = iota
+	// SymlinkSkip ignores links completely
+	SymlinkSkip
+	// SymlinkFollow reports links with FileInfo of the target and
+	// descends into linked directories once, broken links are reported
+	// as links
+	SymlinkFollow
+)
+
+// ErrorPolicy determines how RepoWalker handles errors
+type ErrorPolicy int
+
+// Error policies
+const (
+	// ErrorFailFast aborts the walk on the first error
+	ErrorFailFast ErrorPolicy = iota
+	// ErrorSkip ignores the entry or directory failed
+	ErrorSkip
+	// ErrorCollect skips the entry or directory failed and returns
+	// all errors aggregated when the walk completes
+	ErrorCollect
+)
+
+// WalkError is an error of an item during walk
+type WalkError struct {
+	// RepoName is name of the repository being visited
+	RepoName string
+	// RelPath is slash-separated path relative to BasePath
+	RelPath string
+	// Err is the original error
+	Err error
+}
+
+func (e *WalkError) Error() string {
+	return e.RepoName + ":" + e.RelPath + ": " + e.Err.Error()
+}
+
+// Unwrap returns the original error
+func (e *WalkError) Unwrap() error {
+	return e.Err
+}
+
 // WalkingItem is the current item being visited
 type WalkingItem struct {
 	// RepoName is name of the repository being visited
@@ -14,10 +88,56 @@ type WalkingItem struct {
 	Repo Repository
 	// Path is the relative path inside repo without filename
 	Path string
+	// RelPath is slash-separated path of the item relative to BasePath
+	// of the repo, including Name
+	RelPath string
+	// Depth is 1 for items directly under BasePath
+	Depth int
 	// Name is the name of the item, file/dir name
 	Name string
 	// FileInfo is obtained using os.Lstat
 	FileInfo os.FileInfo
+	// Hash is hex digest of regular file content if RepoWalker.Hash is set
+	Hash string
+	// Change is the kind of change in VisitChanged, empty otherwise
+	Change ChangeKind
+
+	ctx    context.Context
+	fsys   fs.FS
+	prefix string
+}
+
+// Open opens the content of the item for reading
+func (item *WalkingItem) Open() (io.ReadCloser, error) {
+	if item.fsys != nil {
+		return item.fsys.Open(path.Join(item.Path, item.Name))
+	}
+	return os.Open(item.prefix + filepath.Join(item.Path, item.Name))
+}
+
+func (item *WalkingItem) digest(algo crypto.Hash) (string, error) {
+	if !algo.Available() {
+		return "", ErrHashUnavailable
+	}
+	f, err := item.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := algo.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Context returns the context of the walk, long running callbacks
+// should abort when it is done
+func (item *WalkingItem) Context() context.Context {
+	if item.ctx == nil {
+		return context.Background()
+	}
+	return item.ctx
 }
 
 // RepoWalkerFn is the function visits all objects inside the repository
@@ -36,64 +156,373 @@ type RepoWalker struct {
 	Filters []RepoWalkerFilter
 	// BreadthFirst visits in breadth first order, otherwise depth first
 	BreadthFirst bool
+	// Parallelism is the max number of directories read concurrently,
+	// WalkerFn and filters are never called concurrently but the order
+	// of items is not deterministic if greater than 1
+	Parallelism int
+	// Symlinks determines how symbolic links are handled
+	Symlinks SymlinkMode
+	// MaxDepth limits the depth of items visited, unlimited if 0
+	MaxDepth int
+	// Sorted reads whole directories and visits entries in lexical order
+	// of names, otherwise the order is determined by the OS
+	Sorted bool
+	// OnError determines how errors from reading directories, filters
+	// and WalkerFn are handled, cancellation always aborts the walk
+	OnError ErrorPolicy
+	// Hash computes WalkingItem.Hash of regular files accepted by filters,
+	// the hash implementation must be linked into the binary
+	Hash crypto.Hash
+	// NoStat skips per-entry Lstat when reading directories, FileInfo
+	// only answers Name and IsDir without stat, other methods stat the
+	// entry on first call
+	NoStat bool
+	// Logger receives diagnostic messages if not nil
+	Logger Logger
+	// Metrics receives walk durations if not nil
+	Metrics Metrics
+	// Checkpoint records progress and resumes walks from it, which forces
+	// sorted depth-first sequential walking for a reproducible order
+	Checkpoint *WalkCheckpoint
 }
 
 // Visit walks over every entry inside the repo
+// If the repo implements PolicyRepo, its path policy is applied first,
+// preceded by ContentPolicy of a CachedRepo
+// If the repo implements FSRepository, it is walked using its fs.FS
+// Content of a sealed repo is decrypted first
 func (w *RepoWalker) Visit(name string, repo Repository) error {
-	return w.visit(repo.BasePath(), name, repo)
+	return w.VisitContext(context.Background(), name, repo)
+}
+
+// VisitContext is Visit with cancellation checked between entries,
+// ctx error is returned when the walk is cancelled
+func (w *RepoWalker) VisitContext(ctx context.Context, name string, repo Repository) error {
+	if err := unsealRepo(repo); err != nil {
+		return err
+	}
+	if fr, ok := repo.(FSRepository); ok {
+		return w.VisitFS(ctx, name, repo, fr.FS())
+	}
+	return w.run(&walk{RepoWalker: w, ctx: ctx, name: name, repo: repo}, repo.BasePath())
+}
+
+// VisitFS walks over BasePath of repo inside fsys instead of local file
+// system. Item Path is slash-separated path inside fsys, PathPrefix is
+// ignored and SymlinkFollow behaves as SymlinkReport
+func (w *RepoWalker) VisitFS(ctx context.Context, name string, repo Repository, fsys fs.FS) error {
+	root := path.Clean("/" + filepath.ToSlash(repo.BasePath()))[1:]
+	if root == "" {
+		root = "."
+	}
+	return w.run(&walk{RepoWalker: w, ctx: ctx, name: name, repo: repo, fsys: fsys}, root)
+}
+
+func (w *RepoWalker) run(wk *walk, root string) (err error) {
+	started := time.Now()
+	var span Span
+	wk.ctx, span = startSpan(wk.ctx, SpanWalk)
+	span.SetAttribute("gms.repo", wk.name)
+	defer func() {
+		span.End(err)
+		logf(w.Logger, "walk %s: done in %v, error: %v", wk.name, time.Since(started), err)
+		observeMetric(w.Metrics, MetricWalkSeconds, time.Since(started).Seconds(), Labels{"repo": wk.name})
+	}()
+	if w.Checkpoint != nil {
+		if w.Checkpoint.IsDone(wk.name) {
+			return nil
+		}
+		walker := *w
+		walker.Sorted, walker.BreadthFirst, walker.Parallelism = true, false, 0
+		wk.RepoWalker = &walker
+		wk.resumeFrom = w.Checkpoint.Last(wk.name)
+		wk.resumePruned = w.Checkpoint.lastPruned(wk.name)
+	}
+	wk.setupFilters()
+	if w.Parallelism > 1 {
+		err = wk.visitParallel(root)
+	} else {
+		err = wk.visit(root, "")
+	}
+	if err == nil && w.Checkpoint != nil {
+		w.Checkpoint.markDone(wk.name)
+	}
+	if err == nil || err == SkipAll {
+		return wk.errs.Aggregate()
+	}
+	return err
+}
+
+// setupFilters prepends content policy and path policy filters of the repo
+func (w *walk) setupFilters() {
+	w.filters = w.Filters
+	if pr, ok := w.repo.(PolicyRepo); ok {
+		if policy := pr.PathPolicy(); !policy.IsEmpty() {
+			w.filters = append([]RepoWalkerFilter{policy.Filter()}, w.filters...)
+		}
+	}
+	if pr, ok := w.repo.(contentPolicyRepo); ok {
+		if policy, name := pr.contentPolicy(); policy != nil {
+			w.filters = append([]RepoWalkerFilter{contentPolicyFilter(policy, name)}, w.filters...)
+		}
+	}
+}
+
+// walk is the state of a single Visit
+type walk struct {
+	*RepoWalker
+	ctx     context.Context
+	name    string
+	repo    Repository
+	filters []RepoWalkerFilter
+	// fsys is used instead of local file system if not nil
+	fsys fs.FS
+
+	// the following are only used in parallel mode
+	cancel context.CancelFunc
+	mu     *sync.Mutex
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	errMu  sync.Mutex
+	err    error
+
+	// resumeFrom is RelPath of the last item visited before,
+	// resumePruned is set if it was pruned by SkipDir
+	resumeFrom   string
+	resumePruned bool
+
+	// errors collected in ErrorCollect mode
+	errsMu sync.Mutex
+	errs   clix.AggregatedError
+
+	// visited directories in SymlinkFollow mode
+	visitedMu sync.Mutex
+	visited   map[string]bool
+}
+
+// enterDir checks if a directory is visited for the first time,
+// only tracked in SymlinkFollow mode
+func (w *walk) enterDir(fullPath string) (bool, error) {
+	if w.Symlinks != SymlinkFollow || w.fsys != nil {
+		return true, nil
+	}
+	key, err := dirIdentity(fullPath)
+	if err != nil {
+		return false, err
+	}
+	w.visitedMu.Lock()
+	defer w.visitedMu.Unlock()
+	if w.visited == nil {
+		w.visited = make(map[string]bool)
+	}
+	if w.visited[key] {
+		return false, nil
+	}
+	w.visited[key] = true
+	return true, nil
+}
+
+func (w *walk) visitParallel(basePath string) error {
+	var cancel context.CancelFunc
+	w.ctx, cancel = context.WithCancel(w.ctx)
+	defer cancel()
+	w.cancel = cancel
+	w.mu = &sync.Mutex{}
+	w.sem = make(chan struct{}, w.Parallelism-1)
+	w.fail(w.visit(basePath, ""))
+	w.wg.Wait()
+	return w.err
+}
+
+// handle applies error policy, nil is returned if the walk should continue
+func (w *walk) handle(relPath string, err error) error {
+	if err == nil || err == SkipAll || err == SkipDir || w.ctx.Err() != nil {
+		return err
+	}
+	switch w.OnError {
+	case ErrorSkip:
+		logf(w.Logger, "walk %s: skipped %s: %v", w.name, relPath, err)
+		return nil
+	case ErrorCollect:
+		w.errsMu.Lock()
+		w.errs.Add(&WalkError{RepoName: w.name, RelPath: relPath, Err: err})
+		w.errsMu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// fail records the first error and stops the walk
+func (w *walk) fail(err error) {
+	if err == nil {
+		return
+	}
+	w.errMu.Lock()
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+	w.errMu.Unlock()
+}
+
+// descend visits a sub-directory, in a new goroutine if a worker is available
+func (w *walk) descend(dir, relDir string) error {
+	if w.sem != nil {
+		select {
+		case w.sem <- struct{}{}:
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				defer func() { <-w.sem }()
+				w.fail(w.visit(dir, relDir))
+			}()
+			return nil
+		default:
+		}
+	}
+	return w.visit(dir, relDir)
+}
+
+// accept runs filters and WalkerFn, serialized in parallel mode
+func (w *walk) accept(item *WalkingItem) (skip bool, err error) {
+	if skip, err = w.filter(item); err != nil || skip {
+		return
+	}
+	if w.Hash != 0 && fileType(item.FileInfo).IsRegular() && item.Change != ChangeDeleted {
+		if item.Hash, err = item.digest(w.Hash); err != nil {
+			return false, err
+		}
+	}
+	if w.mu != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+	err = w.WalkerFn(*item)
+	if w.Checkpoint != nil {
+		w.checkpoint(item, err)
+	}
+	return false, err
+}
+
+// checkpoint records item visited with err returned by WalkerFn. A
+// directory pruned by SkipDir is recorded to be pruned on resume, so is
+// the parent of a file skipping the rest of it
+func (w *walk) checkpoint(item *WalkingItem, err error) {
+	switch {
+	case err == nil:
+		w.Checkpoint.record(w.name, item.RelPath, false)
+	case err != SkipDir:
+	case item.FileInfo.IsDir():
+		w.Checkpoint.record(w.name, item.RelPath, true)
+	case path.Dir(item.RelPath) != ".":
+		w.Checkpoint.record(w.name, path.Dir(item.RelPath), true)
+	default:
+		// the rest of the walk is skipped
+		w.Checkpoint.record(w.name, item.RelPath, false)
+	}
+}
+
+func (w *walk) filter(item *WalkingItem) (skip bool, err error) {
+	if w.mu != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+	for _, filter := range w.filters {
+		accepted, e := filter(item)
+		if e != nil {
+			return false, e
+		}
+		if !accepted {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
-func (w *RepoWalker) visit(basePath, name string, repo Repository) error {
+// visit reads directory basePath whose path relative to BasePath
+// of repo is relDir
+func (w *walk) visit(basePath, relDir string) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
 	fullPath := basePath
-	if w.PathPrefix != "" {
+	if w.PathPrefix != "" && w.fsys == nil {
 		fullPath = w.PathPrefix + fullPath
 	}
-	f, err := os.Open(fullPath)
+	if first, err := w.enterDir(fullPath); err != nil || !first {
+		return w.handle(relDir, err)
+	}
+	entries, err := w.openDir(fullPath)
 	if err != nil {
-		return err
+		return w.handle(relDir, err)
+	}
+	defer entries.Close()
+	depth := 1
+	if relDir != "" {
+		depth += strings.Count(relDir, "/") + 1
 	}
-	defer f.Close()
 	var dirs []string
+	var skip bool
 	for {
-		var fi os.FileInfo
-		if fis, e := f.Readdir(1); e == io.EOF {
+		if err = w.ctx.Err(); err != nil {
+			return err
+		}
+		fi, e := entries.Next()
+		if e == io.EOF {
 			break
 		} else if e != nil {
-			return e
-		} else {
-			fi = fis[0]
+			if e = w.handle(relDir, e); e != nil {
+				return e
+			}
+			break
+		}
+		if fileType(fi)&os.ModeSymlink != 0 {
+			if w.Symlinks == SymlinkSkip {
+				continue
+			} else if w.Symlinks == SymlinkFollow && w.fsys == nil {
+				if target, e := os.Stat(filepath.Join(fullPath, fi.Name())); e == nil {
+					fi = &renamedFileInfo{FileInfo: target, name: fi.Name()}
+				}
+			}
 		}
 
 		item := &WalkingItem{
-			RepoName: name,
-			Repo:     repo,
+			RepoName: w.name,
+			Repo:     w.repo,
 			Path:     basePath,
+			RelPath:  path.Join(relDir, fi.Name()),
+			Depth:    depth,
 			Name:     fi.Name(),
 			FileInfo: fi,
+			ctx:      w.ctx,
+			fsys:     w.fsys,
+			prefix:   w.PathPrefix,
 		}
-		skip := false
-		for _, filter := range w.Filters {
-			accepted, e := filter(item)
-			if e != nil {
-				return e
-			}
-			if !accepted {
-				skip = true
-				break
-			}
-		}
-		if skip {
+		resume := w.resumeAction(item.RelPath, fi.IsDir())
+		if resume == resumePrune {
 			continue
+		} else if resume == resumeDescend {
+			skip, err = false, nil
+		} else {
+			skip, err = w.accept(item)
 		}
-
-		if err = w.WalkerFn(*item); err != nil {
-			return err
+		if err == SkipDir && !fi.IsDir() {
+			err = nil
+			break
+		} else if err == SkipDir || skip {
+			err = nil
+			continue
+		} else if err != nil {
+			if err = w.handle(item.RelPath, err); err != nil {
+				return err
+			}
+			continue
 		}
-		if fi.IsDir() {
+		if fi.IsDir() && (w.MaxDepth <= 0 || depth < w.MaxDepth) {
 			if w.BreadthFirst {
 				dirs = append(dirs, fi.Name())
 			} else {
-				err = w.visit(filepath.Join(basePath, fi.Name()), name, repo)
+				err = w.descend(w.join(basePath, fi.Name()), item.RelPath)
 				if err != nil {
 					return err
 				}
@@ -101,13 +530,157 @@ func (w *RepoWalker) visit(basePath, name string, repo Repository) error {
 		}
 	}
 	for _, dir := range dirs {
-		if err = w.visit(filepath.Join(basePath, dir), name, repo); err != nil {
+		if err = w.descend(w.join(basePath, dir), path.Join(relDir, dir)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// join joins directory and name using the separator of file system
+func (w *walk) join(dir, name string) string {
+	if w.fsys != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// dirIterator iterates entries of a directory, Next returns io.EOF at end
+type dirIterator interface {
+	Next() (os.FileInfo, error)
+	Close() error
+}
+
+func (w *walk) openDir(dir string) (dirIterator, error) {
+	if w.fsys != nil {
+		entries, err := fs.ReadDir(w.fsys, dir)
+		if err != nil {
+			return nil, err
+		}
+		return &fsDirIterator{entries: entries, lazy: w.NoStat}, nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	it := &osDirIterator{f: f, lazy: w.NoStat}
+	if w.Sorted {
+		if it.sorted, err = it.read(-1); err != nil {
+			f.Close()
+			return nil, err
+		}
+		sort.Slice(it.sorted, func(i, j int) bool { return it.sorted[i].Name() < it.sorted[j].Name() })
+		it.all = true
+	}
+	return it, nil
+}
+
+// osDirIterator reads entries one by one, or all at once if sorted
+type osDirIterator struct {
+	f      *os.File
+	lazy   bool
+	sorted []os.FileInfo
+	all    bool
+}
+
+func (it *osDirIterator) read(n int) ([]os.FileInfo, error) {
+	if !it.lazy {
+		return it.f.Readdir(n)
+	}
+	entries, err := it.f.ReadDir(n)
+	fis := make([]os.FileInfo, len(entries))
+	for i, entry := range entries {
+		fis[i] = &lazyFileInfo{entry: entry}
+	}
+	return fis, err
+}
+
+func (it *osDirIterator) Next() (os.FileInfo, error) {
+	if !it.all {
+		fis, err := it.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return fis[0], nil
+	}
+	if len(it.sorted) == 0 {
+		return nil, io.EOF
+	}
+	fi := it.sorted[0]
+	it.sorted = it.sorted[1:]
+	return fi, nil
+}
+
+func (it *osDirIterator) Close() error {
+	return it.f.Close()
+}
+
+// fsDirIterator iterates entries from fs.ReadDir which are always sorted
+type fsDirIterator struct {
+	entries []fs.DirEntry
+	lazy    bool
+}
+
+func (it *fsDirIterator) Next() (os.FileInfo, error) {
+	if len(it.entries) == 0 {
+		return nil, io.EOF
+	}
+	entry := it.entries[0]
+	it.entries = it.entries[1:]
+	if it.lazy {
+		return &lazyFileInfo{entry: entry}, nil
+	}
+	return entry.Info()
+}
+
+func (it *fsDirIterator) Close() error {
+	return nil
+}
+
+// lazyFileInfo answers Name and IsDir from directory entry and
+// retrieves full FileInfo on demand
+type lazyFileInfo struct {
+	entry fs.DirEntry
+	once  sync.Once
+	fi    os.FileInfo
+}
+
+func (fi *lazyFileInfo) info() os.FileInfo {
+	fi.once.Do(func() {
+		if info, err := fi.entry.Info(); err == nil {
+			fi.fi = info
+		} else {
+			fi.fi = &deletedFileInfo{name: fi.entry.Name()}
+		}
+	})
+	return fi.fi
+}
+
+func (fi *lazyFileInfo) Name() string       { return fi.entry.Name() }
+func (fi *lazyFileInfo) IsDir() bool        { return fi.entry.IsDir() }
+func (fi *lazyFileInfo) Size() int64        { return fi.info().Size() }
+func (fi *lazyFileInfo) Mode() os.FileMode  { return fi.info().Mode() }
+func (fi *lazyFileInfo) ModTime() time.Time { return fi.info().ModTime() }
+func (fi *lazyFileInfo) Sys() interface{}   { return fi.info().Sys() }
+
+// fileType returns type bits of the mode without stat if possible
+func fileType(fi os.FileInfo) os.FileMode {
+	if lazy, ok := fi.(*lazyFileInfo); ok {
+		return lazy.entry.Type()
+	}
+	return fi.Mode().Type()
+}
+
+// renamedFileInfo is FileInfo of link target with the name of link
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *renamedFileInfo) Name() string {
+	return fi.name
+}
+
 // Use registers walker filters
 func (w *RepoWalker) Use(filters ...RepoWalkerFilter) *RepoWalker {
 	w.Filters = append(w.Filters, filters...)
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package gms
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// dirIdentity identifies a directory by device and inode
+func dirIdentity(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return strconv.FormatUint(uint64(st.Dev), 10) + ":" + strconv.FormatUint(uint64(st.Ino), 10), nil
+	}
+	return realPath(path)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package gms
+
+// dirIdentity identifies a directory by its real path
+func dirIdentity(path string) (string, error) {
+	return realPath(path)
+}
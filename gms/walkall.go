@@ -0,0 +1,36 @@
+package gms
+
+import "context"
+
+// WalkAll walks all cached repos in resolution order using the walker.
+// If dedupe is true, an item whose RelPath was already visited in a repo
+// of higher precedence is not passed to WalkerFn, directories are still
+// descended so overlaid content is merged. SkipAll stops walking all repos
+func WalkAll(ctx context.Context, cache *RepoCache, w *RepoWalker, dedupe bool) error {
+	walker := *w
+	if dedupe {
+		seen := make(map[string]string)
+		walker.WalkerFn = func(item WalkingItem) error {
+			if repoName, exists := seen[item.RelPath]; exists && repoName != item.RepoName {
+				return nil
+			}
+			seen[item.RelPath] = item.RepoName
+			return w.WalkerFn(item)
+		}
+	}
+	stopped := false
+	fn := walker.WalkerFn
+	walker.WalkerFn = func(item WalkingItem) error {
+		err := fn(item)
+		if err == SkipAll {
+			stopped = true
+		}
+		return err
+	}
+	for _, repo := range cache.ReposOrdered() {
+		if err := walker.VisitContext(ctx, repo.Name, repo); err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package gms
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ChangeTracker is a repository able to list changes since a version
+type ChangeTracker interface {
+	Repository
+	Changes(sinceVersion string) ([]Change, error)
+}
+
+// VisitChanged visits only the paths changed in the repo since the
+// version, using filters and options of the walker. Deleted paths are
+// visited with a FileInfo of zero size and mode. Directories are not
+// descended, SymlinkFollow and Parallelism are not used
+func (w *RepoWalker) VisitChanged(ctx context.Context, name string, repo ChangeTracker, sinceVersion string) error {
+	if err := unsealRepo(repo); err != nil {
+		return err
+	}
+	changes, err := repo.Changes(sinceVersion)
+	if err != nil {
+		return err
+	}
+	wk := &walk{RepoWalker: w, ctx: ctx, name: name, repo: repo}
+	wk.setupFilters()
+	base := repo.BasePath()
+	for _, c := range changes {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		depth := strings.Count(c.Path, "/") + 1
+		if w.MaxDepth > 0 && depth > w.MaxDepth {
+			continue
+		}
+		fn := filepath.Join(base, filepath.FromSlash(c.Path))
+		item := &WalkingItem{
+			RepoName: name,
+			Repo:     repo,
+			Path:     filepath.Dir(fn),
+			RelPath:  c.Path,
+			Depth:    depth,
+			Name:     path.Base(c.Path),
+			Change:   c.Kind,
+			ctx:      ctx,
+			prefix:   w.PathPrefix,
+		}
+		if c.Kind == ChangeDeleted {
+			item.FileInfo = &deletedFileInfo{name: item.Name}
+		} else if item.FileInfo, err = os.Lstat(w.PathPrefix + fn); err != nil {
+			if err = wk.handle(c.Path, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err = wk.accept(item); err == SkipAll {
+			break
+		} else if err != nil && err != SkipDir {
+			if err = wk.handle(c.Path, err); err != nil {
+				return err
+			}
+		}
+	}
+	return wk.errs.Aggregate()
+}
+
+// deletedFileInfo is FileInfo of a deleted path
+type deletedFileInfo struct {
+	name string
+}
+
+func (fi *deletedFileInfo) Name() string       { return fi.name }
+func (fi *deletedFileInfo) Size() int64        { return 0 }
+func (fi *deletedFileInfo) Mode() os.FileMode  { return 0 }
+func (fi *deletedFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *deletedFileInfo) IsDir() bool        { return false }
+func (fi *deletedFileInfo) Sys() interface{}   { return nil }
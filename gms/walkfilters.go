@@ -0,0 +1,173 @@
+package gms
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// relPath returns slash-separated path of item relative to base
+func (item *WalkingItem) relPath(base string) (string, error) {
+	rel, err := filepath.Rel(base, filepath.Join(item.Path, item.Name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// FilterGlob accepts files whose repo-relative path matches any of the
+// patterns (see MatchGlob), a pattern without "/" matches base name.
+// Directories are always accepted
+func FilterGlob(patterns ...string) RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		if item.FileInfo.IsDir() {
+			return true, nil
+		}
+		for _, pattern := range patterns {
+			if !strings.Contains(pattern, "/") {
+				if matched, _ := filepath.Match(pattern, item.Name); matched {
+					return true, nil
+				}
+			} else if MatchGlob(pattern, item.RelPath) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// FilterVCS rejects VCS metadata, see isVCSName
+func FilterVCS() RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		return !isVCSName(item.Name), nil
+	}
+}
+
+// FilterHidden rejects files and directories whose name starts with "."
+func FilterHidden() RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		return !strings.HasPrefix(item.Name, "."), nil
+	}
+}
+
+// FilterMaxSize rejects files larger than size bytes
+func FilterMaxSize(size int64) RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		return item.FileInfo.IsDir() || item.FileInfo.Size() <= size, nil
+	}
+}
+
+// FilterExt accepts files with any of the extensions (including ".",
+// case-insensitive), directories are always accepted
+func FilterExt(exts ...string) RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		if item.FileInfo.IsDir() {
+			return true, nil
+		}
+		ext := filepath.Ext(item.Name)
+		for _, e := range exts {
+			if strings.EqualFold(e, ext) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// gitignoreRule is a single parsed line of gitignore file
+type gitignoreRule struct {
+	pattern []string
+	negate  bool
+	dirOnly bool
+}
+
+// GitignoreRules are rules parsed from a gitignore file
+type GitignoreRules struct {
+	// Dir is the directory the patterns are relative to
+	Dir   string
+	rules []gitignoreRule
+}
+
+// ParseGitignore parses gitignore file, patterns are relative to the
+// directory containing the file
+func ParseGitignore(fn string) (*GitignoreRules, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g := &GitignoreRules{Dir: filepath.Dir(fn)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		g.Add(scanner.Text())
+	}
+	return g, scanner.Err()
+}
+
+// Add parses and appends a gitignore line
+func (g *GitignoreRules) Add(line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	var rule gitignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return
+	}
+	anchored := strings.Contains(line, "/")
+	rule.pattern = splitSegments(line)
+	if !anchored {
+		rule.pattern = append([]string{"**"}, rule.pattern...)
+	}
+	g.rules = append(g.rules, rule)
+}
+
+// Ignored checks if slash-separated path relative to Dir is ignored,
+// the last matching rule wins
+func (g *GitignoreRules) Ignored(rel string, isDir bool) bool {
+	segs := splitSegments(rel)
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.pattern, segs) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Filter creates a walker filter rejecting ignored items
+func (g *GitignoreRules) Filter() RepoWalkerFilter {
+	return func(item *WalkingItem) (bool, error) {
+		rel, err := item.relPath(g.Dir)
+		if err != nil {
+			return false, err
+		}
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return true, nil
+		}
+		return !g.Ignored(rel, item.FileInfo.IsDir()), nil
+	}
+}
+
+// FilterGitignore creates a walker filter from gitignore file
+func FilterGitignore(fn string) (RepoWalkerFilter, error) {
+	g, err := ParseGitignore(fn)
+	if err != nil {
+		return nil, err
+	}
+	return g.Filter(), nil
+}
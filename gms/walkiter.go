@@ -0,0 +1,29 @@
+package gms
+
+import (
+	"context"
+	"iter"
+)
+
+// Items returns an iterator over items of the repo, using the options
+// and filters of the walker, WalkerFn is ignored. The walk runs as the
+// iterator is consumed and Parallelism is not used. An error ends the
+// iteration with a zero WalkingItem and the error. SkipDir can't be used,
+// prune subtrees using filters instead
+func (w *RepoWalker) Items(ctx context.Context, name string, repo Repository) iter.Seq2[WalkingItem, error] {
+	return func(yield func(WalkingItem, error) bool) {
+		walker := *w
+		walker.Parallelism = 0
+		stopped := false
+		walker.WalkerFn = func(item WalkingItem) error {
+			if !yield(item, nil) {
+				stopped = true
+				return SkipAll
+			}
+			return nil
+		}
+		if err := walker.VisitContext(ctx, name, repo); err != nil && !stopped {
+			yield(WalkingItem{}, err)
+		}
+	}
+}
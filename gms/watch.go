@@ -0,0 +1,177 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoFileWatcher indicates NewFileWatcher isn't set
+	ErrNoFileWatcher = errors.New("file watcher unavailable")
+)
+
+// FileEvent is a change of a file or directory reported by FileWatcher
+type FileEvent struct {
+	// Path is the local path of the changed file
+	Path string
+	// Op describes the change, e.g. "WRITE" or "CREATE|CHMOD"
+	Op string
+}
+
+// FileWatcher reports changes inside watched directories
+type FileWatcher interface {
+	// Add watches a directory, its subdirectories aren't watched
+	Add(dir string) error
+	// Events delivers changes until the watcher is closed
+	Events() <-chan FileEvent
+	// Errors delivers failures of watching
+	Errors() <-chan error
+	// Close stops watching and closes Events
+	Close() error
+}
+
+// NewFileWatcher creates watchers used by WatchLocal, see package
+// fsnotifygms for an implementation using fsnotify
+var NewFileWatcher func() (FileWatcher, error)
+
+// watchSettle is how long changes are attributed to a sync after its
+// content lock is seen held
+var watchSettle = time.Second
+
+// LocalEdit is a modification of content of a cached repo made outside of gms
+type LocalEdit struct {
+	// Repo is the name of the cached repo
+	Repo string
+	// Path is slash-separated path relative to the local clone
+	Path string
+	// Op describes the change, see FileEvent
+	Op string
+	// Time is when the change is seen
+	Time time.Time
+}
+
+// WatchLocal watches the local clone for modifications made outside of
+// gms, e.g. manual edits of cached content. The first one marks the repo
+// dirty until the next successful sync, see SyncState.Dirty. Changes made
+// while Sync holds the content lock and VCS metadata are ignored. With
+// AtomicSync the version at the time of the call is watched. The channel
+// is closed when ctx is done or the watcher is closed
+func (r *CachedRepo) WatchLocal(ctx context.Context) (<-chan LocalEdit, error) {
+	if NewFileWatcher == nil {
+		return nil, ErrNoFileWatcher
+	}
+	watcher, err := NewFileWatcher()
+	if err != nil {
+		return nil, err
+	}
+	root := r.localDir()
+	if err = watchDirs(watcher, root); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	edits := make(chan LocalEdit)
+	go func() {
+		defer close(edits)
+		defer watcher.Close()
+		lw := &localWatch{r: r, root: root, watcher: watcher}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err, ok := <-watcher.Errors():
+				if !ok {
+					return
+				}
+				logf(r.Logger, "watch %s: %v", r.Name, err)
+			case event, ok := <-watcher.Events():
+				if !ok {
+					return
+				}
+				edit, ok := lw.edit(event)
+				if !ok {
+					continue
+				}
+				select {
+				case edits <- *edit:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return edits, nil
+}
+
+// MarkDirty records rel as modified outside of gms, only the first path
+// is kept until the next successful sync
+func (r *CachedRepo) MarkDirty(rel string) error {
+	return r.updateState(func(s *SyncState) {
+		if s.Dirty == "" {
+			s.Dirty = rel
+		}
+	})
+}
+
+// localWatch filters events of a watched local clone
+type localWatch struct {
+	r       *CachedRepo
+	root    string
+	watcher FileWatcher
+	synced  time.Time
+	dirty   bool
+}
+
+// edit turns event into a LocalEdit unless it's caused by gms
+func (w *localWatch) edit(event FileEvent) (*LocalEdit, bool) {
+	rel, err := filepath.Rel(w.root, event.Path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") || isVCSPath(w.root, event.Path) {
+		return nil, false
+	}
+	if strings.Contains(event.Op, "CREATE") {
+		if info, err := os.Lstat(event.Path); err == nil && info.IsDir() {
+			if err = watchDirs(w.watcher, event.Path); err != nil {
+				logf(w.r.Logger, "watch %s: %v", w.r.Name, err)
+			}
+		}
+	}
+	now := time.Now()
+	if lock, err := tryLockContent(w.r.contentLockFile(), false); err == nil && lock == nil {
+		// a sync clears the dirty mark
+		w.synced, w.dirty = now, false
+		return nil, false
+	} else if lock != nil {
+		lock.Unlock()
+	}
+	if now.Sub(w.synced) < watchSettle {
+		return nil, false
+	}
+	rel = filepath.ToSlash(rel)
+	if !w.dirty {
+		if err = w.r.MarkDirty(rel); err != nil {
+			logf(w.r.Logger, "watch %s: %v", w.r.Name, err)
+		} else {
+			w.dirty = true
+		}
+	}
+	return &LocalEdit{Repo: w.r.Name, Path: rel, Op: event.Op, Time: now}, true
+}
+
+// watchDirs adds dir and its subdirectories except VCS metadata
+func watchDirs(watcher FileWatcher, dir string) error {
+	return filepath.Walk(dir, func(fn string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if isVCSPath(dir, fn) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(fn)
+	})
+}
@@ -0,0 +1,39 @@
+package gms
+
+import (
+	"strings"
+)
+
+// isWindowsPath checks if s is a Windows local path: drive letter path
+// (C:\repo, C:/repo), UNC path (\\server\share) or relative path using
+// backslash (.\repo, ..\repo). It's checked on all platforms as URLs
+// may be persisted on another platform
+func isWindowsPath(s string) bool {
+	switch {
+	case len(s) >= 2 && isDriveLetter(s[0]) && s[1] == ':':
+		return len(s) == 2 || s[2] == '\\' || s[2] == '/'
+	case strings.HasPrefix(s, `\\`):
+		return true
+	case strings.HasPrefix(s, `.\`), strings.HasPrefix(s, `..\`):
+		return true
+	}
+	return false
+}
+
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// windowsFileURL splits a Windows path into file URL prefix and
+// slash-separated path: C:\repo is file:///C:/repo, \\server\share is
+// file://server/share and .\repo is file://./repo
+func windowsFileURL(s string) (prefix, path string) {
+	path = strings.ReplaceAll(s, `\`, "/")
+	switch {
+	case strings.HasPrefix(path, "//"):
+		return "file:", path
+	case strings.HasPrefix(path, "."):
+		return "file://", path
+	}
+	return "file:///", path
+}
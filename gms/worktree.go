@@ -0,0 +1,148 @@
+package gms
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// CacheWorktreesDir is the name of sub-directory containing worktrees
+	// of cached repos
+	CacheWorktreesDir = "worktrees"
+)
+
+var (
+	// ErrWorktreeUnsupported indicates the cached repo can't have worktrees
+	ErrWorktreeUnsupported = errors.New("worktrees not supported")
+)
+
+// WorktreeRepo is a remote repository able to check out additional refs
+// of content synced into dir sharing the same storage
+type WorktreeRepo interface {
+	RemoteRepo
+	AddWorktreeAt(dir, worktree, ref string) error
+	RemoveWorktreeAt(dir, worktree string) error
+}
+
+// AddWorktree checks out ref into a new worktree dir detached, so the
+// same branch can be checked out by multiple worktrees
+func (g *GitWorkTree) AddWorktree(ref, dir string) error {
+	return g.execLocked("worktree", "add", "--detach", dir, ref)
+}
+
+// RemoveWorktree removes the worktree dir including local modifications
+func (g *GitWorkTree) RemoveWorktree(dir string) error {
+	return g.execLocked("worktree", "remove", "--force", dir)
+}
+
+// PruneWorktrees cleans up records of worktrees no longer on disk
+func (g *GitWorkTree) PruneWorktrees() error {
+	return g.execLocked("worktree", "prune")
+}
+
+// AddWorktreeAt implements WorktreeRepo, an existing worktree is moved
+// to ref, or recreated if broken, e.g. the clone is recloned
+func (r *GitRepo) AddWorktreeAt(dir, worktree, ref string) error {
+	defer lockWorkDir(dir)()
+	if _, err := os.Stat(worktree); err == nil {
+		wt := &GitWorkTree{Client: r.client(), WorkDir: worktree}
+		if wt.Checkout(ref, "--detach") == nil {
+			return nil
+		}
+		if err = os.RemoveAll(worktree); err != nil {
+			return err
+		}
+	}
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir, locked: true}
+	if err := git.PruneWorktrees(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(worktree), 0755); err != nil {
+		return err
+	}
+	return git.AddWorktree(ref, worktree)
+}
+
+// RemoveWorktreeAt implements WorktreeRepo
+func (r *GitRepo) RemoveWorktreeAt(dir, worktree string) error {
+	git := &GitWorkTree{Client: r.client(), WorkDir: dir}
+	if err := git.RemoveWorktree(worktree); err != nil {
+		if _, serr := os.Stat(worktree); serr == nil {
+			return err
+		}
+		// already deleted from disk
+		return git.PruneWorktrees()
+	}
+	return nil
+}
+
+// worktreeDir returns the directory of worktree of ref
+func (r *CachedRepo) worktreeDir(ref string) (string, error) {
+	if r.WorktreesDir == "" || r.AtomicSync {
+		return "", ErrWorktreeUnsupported
+	}
+	return SafeJoin(r.WorktreesDir, url.PathEscape(ref))
+}
+
+// Worktree checks out ref, e.g. a branch, tag or version from Versions
+// or Lock, into a worktree sharing storage with the local clone, and
+// returns the base path in it. The worktree is moved to the latest
+// content of ref on every call. Worktrees are not supported with
+// AtomicSync
+func (r *CachedRepo) Worktree(ref string) (string, error) {
+	wr, ok := r.Remote.(WorktreeRepo)
+	if !ok {
+		return "", ErrWorktreeUnsupported
+	}
+	dir, err := r.worktreeDir(ref)
+	if err != nil {
+		return "", err
+	}
+	if r.DryRun != nil {
+		r.DryRun.record(PlannedAction{Op: PlanCreate, Path: dir})
+	} else if err = wr.AddWorktreeAt(r.LocalDir, dir, ref); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, r.Remote.BasePath()), nil
+}
+
+// RemoveWorktree deletes the worktree of ref
+func (r *CachedRepo) RemoveWorktree(ref string) error {
+	wr, ok := r.Remote.(WorktreeRepo)
+	if !ok {
+		return ErrWorktreeUnsupported
+	}
+	dir, err := r.worktreeDir(ref)
+	if err != nil {
+		return err
+	}
+	if r.DryRun != nil {
+		r.DryRun.record(PlannedAction{Op: PlanDelete, Path: dir})
+		return nil
+	}
+	return wr.RemoveWorktreeAt(r.LocalDir, dir)
+}
+
+// Worktrees lists refs having worktrees
+func (r *CachedRepo) Worktrees() ([]string, error) {
+	if r.WorktreesDir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(r.WorktreesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, entry := range entries {
+		if ref, err := url.PathUnescape(entry.Name()); err == nil && entry.IsDir() {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs, nil
+}